@@ -21,18 +21,20 @@ import (
 )
 
 type options struct {
-	annotations  []string
-	bouncerURL   string
-	collectorURL string
-	inputs       []string
-	extraOptions []string
-	noBouncer    bool
-	noGeoIP      bool
-	noJSON       bool
-	noCollector  bool
-	proxy        string
-	reportfile   string
-	verbose      bool
+	annotations    []string
+	bouncerURL     string
+	capturePackets bool
+	collectorURL   string
+	inputs         []string
+	extraOptions   []string
+	noBouncer      bool
+	noGeoIP        bool
+	noJSON         bool
+	noCollector    bool
+	proxy          string
+	reportfile     string
+	saveHAR        bool
+	verbose        bool
 }
 
 const (
@@ -52,6 +54,10 @@ func init() {
 	getopt.FlagLong(
 		&globalOptions.bouncerURL, "bouncer", 'b', "Set bouncer base URL", "URL",
 	)
+	getopt.FlagLong(
+		&globalOptions.capturePackets, "capture-packets", 0,
+		"Write a pcapng file with the traffic of each measurement",
+	)
 	getopt.FlagLong(
 		&globalOptions.collectorURL, "collector", 'c',
 		"Set collector base URL", "URL",
@@ -83,6 +89,10 @@ func init() {
 		&globalOptions.reportfile, "reportfile", 'o',
 		"Set the report file path", "PATH",
 	)
+	getopt.FlagLong(
+		&globalOptions.saveHAR, "save-har", 0,
+		"Write a HAR file with the HTTP transactions of each measurement",
+	)
 	getopt.FlagLong(
 		&globalOptions.verbose, "verbose", 'v', "Increase verbosity",
 	)
@@ -283,6 +293,8 @@ func main() {
 			log.WithError(err).Fatal("cannot set string option")
 		}
 	}
+	builder.SetCapturePackets(globalOptions.capturePackets)
+	builder.SetSaveHAR(globalOptions.saveHAR)
 	experiment := builder.NewExperiment()
 
 	if !globalOptions.noCollector {
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
 	"time"
@@ -13,20 +14,29 @@ import (
 	"github.com/iancoleman/strcase"
 	"github.com/ooni/probe-engine/collector"
 	"github.com/ooni/probe-engine/experiment/dash"
+	"github.com/ooni/probe-engine/experiment/dnscheck"
+	"github.com/ooni/probe-engine/experiment/dnsconsistency"
 	"github.com/ooni/probe-engine/experiment/example"
 	"github.com/ooni/probe-engine/experiment/fbmessenger"
 	"github.com/ooni/probe-engine/experiment/handler"
 	"github.com/ooni/probe-engine/experiment/hhfm"
 	"github.com/ooni/probe-engine/experiment/hirl"
+	"github.com/ooni/probe-engine/experiment/meekfronted"
 	"github.com/ooni/probe-engine/experiment/ndt5"
 	"github.com/ooni/probe-engine/experiment/ndt7"
 	"github.com/ooni/probe-engine/experiment/psiphon"
+	"github.com/ooni/probe-engine/experiment/signal"
 	"github.com/ooni/probe-engine/experiment/sniblocking"
+	"github.com/ooni/probe-engine/experiment/stunreachability"
 	"github.com/ooni/probe-engine/experiment/telegram"
 	"github.com/ooni/probe-engine/experiment/tor"
+	"github.com/ooni/probe-engine/experiment/urlgetter"
 	"github.com/ooni/probe-engine/experiment/web_connectivity"
 	"github.com/ooni/probe-engine/experiment/whatsapp"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/har"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/pcapng"
 )
 
 const dateFormat = "2006-01-02 15:04:05"
@@ -37,11 +47,13 @@ func formatTimeNowUTC() string {
 
 // ExperimentBuilder is an experiment builder.
 type ExperimentBuilder struct {
-	build         func(interface{}) *Experiment
-	callbacks     model.ExperimentCallbacks
-	config        interface{}
-	interruptible bool
-	needsInput    bool
+	build          func(interface{}) *Experiment
+	callbacks      model.ExperimentCallbacks
+	capturePackets bool
+	config         interface{}
+	interruptible  bool
+	needsInput     bool
+	saveHAR        bool
 }
 
 // Interruptible tells you whether this is an interruptible experiment. This kind
@@ -126,6 +138,20 @@ func (b *ExperimentBuilder) SetCallbacks(callbacks model.ExperimentCallbacks) {
 	b.callbacks = callbacks
 }
 
+// SetCapturePackets controls whether the experiment writes a pcapng
+// file, in the session's TempDir, containing the traffic of each
+// measurement. See Experiment.SetCapturePackets for more info.
+func (b *ExperimentBuilder) SetCapturePackets(enabled bool) {
+	b.capturePackets = enabled
+}
+
+// SetSaveHAR controls whether the experiment writes a HAR file, in
+// the session's TempDir, containing the HTTP transactions of each
+// measurement. See Experiment.SetSaveHAR for more info.
+func (b *ExperimentBuilder) SetSaveHAR(enabled bool) {
+	b.saveHAR = enabled
+}
+
 func fieldbyname(v interface{}, key string) (reflect.Value, error) {
 	// See https://stackoverflow.com/a/6396678/4354461
 	ptrinfo := reflect.ValueOf(v)
@@ -147,6 +173,8 @@ func fieldbyname(v interface{}, key string) (reflect.Value, error) {
 func (b *ExperimentBuilder) NewExperiment() *Experiment {
 	experiment := b.build(b.config)
 	experiment.callbacks = b.callbacks
+	experiment.capturePackets = b.capturePackets
+	experiment.saveHAR = b.saveHAR
 	return experiment
 }
 
@@ -175,13 +203,15 @@ func newExperimentBuilder(session *Session, name string) (*ExperimentBuilder, er
 
 // Experiment is an experiment instance.
 type Experiment struct {
-	callbacks     model.ExperimentCallbacks
-	measurer      model.ExperimentMeasurer
-	report        *collector.Report
-	session       *Session
-	testName      string
-	testStartTime string
-	testVersion   string
+	callbacks      model.ExperimentCallbacks
+	capturePackets bool
+	measurer       model.ExperimentMeasurer
+	report         *collector.Report
+	saveHAR        bool
+	session        *Session
+	testName       string
+	testStartTime  string
+	testVersion    string
 }
 
 // NewExperiment creates a new experiment given a measurer. The preferred
@@ -210,6 +240,27 @@ func (e *Experiment) OpenReport() (err error) {
 	return e.openReport(context.Background())
 }
 
+// SetCapturePackets controls whether we capture the traffic of each
+// measurement. When enabled, we write the payloads sent and received by
+// the experiment's connections, including the UDP DNS ones, into a pcapng
+// file in the session's TempDir. The packet headers are synthesized and
+// the timestamps are relative to the MeasurementRoot.Beginning, which is
+// the measurement start time. The annotation named "packet_capture"
+// contains the path of the file. Note
+// that this only works for experiments using netx.
+func (e *Experiment) SetCapturePackets(enabled bool) {
+	e.capturePackets = enabled
+}
+
+// SetSaveHAR controls whether we save the HTTP transactions of each
+// measurement as an HTTP Archive (HAR 1.2) file in the session's TempDir,
+// including DNS, connect and TLS timings. The annotation named
+// "http_archive" contains the path of the file. Note that this only
+// works for experiments using netx.
+func (e *Experiment) SetSaveHAR(enabled bool) {
+	e.saveHAR = enabled
+}
+
 // ReportID returns the open reportID, if we have opened a report
 // successfully before, or an empty string, otherwise.
 func (e *Experiment) ReportID() string {
@@ -248,6 +299,16 @@ func (e *Experiment) MeasureWithContext(
 		return
 	}
 	measurement = e.newMeasurement(input)
+	if e.capturePackets {
+		var done func()
+		ctx, done = e.startCapture(ctx, measurement)
+		defer done()
+	}
+	if e.saveHAR {
+		var done func()
+		ctx, done = e.startHAR(ctx, measurement)
+		defer done()
+	}
 	start := time.Now()
 	err = e.measurer.Run(ctx, e.session, measurement, &sessionExperimentCallbacks{
 		inner: e.callbacks,
@@ -264,6 +325,63 @@ func (e *Experiment) MeasureWithContext(
 	return
 }
 
+// startCapture creates the pcapng file, adds the corresponding annotation
+// to the measurement and returns a context configured to capture packets
+// into such file, along with a function to close the file. Because the
+// capture is a debugging aid, we only log the errors.
+func (e *Experiment) startCapture(
+	ctx context.Context, measurement *model.Measurement,
+) (context.Context, func()) {
+	logger := e.session.Logger()
+	filep, err := ioutil.TempFile(e.session.TempDir(), e.testName+"-*.pcapng")
+	if err != nil {
+		logger.Warnf("experiment: cannot create pcapng file: %s", err.Error())
+		return ctx, func() {}
+	}
+	writer, err := pcapng.NewWriter(filep)
+	if err != nil {
+		logger.Warnf("experiment: cannot write pcapng file: %s", err.Error())
+		filep.Close()
+		return ctx, func() {}
+	}
+	logger.Infof("experiment: capturing packets into %s", filep.Name())
+	measurement.AddAnnotation("packet_capture", filep.Name())
+	return modelx.WithPacketCapturer(ctx, writer), func() {
+		if err := writer.Err(); err != nil {
+			logger.Warnf("experiment: cannot write pcapng file: %s", err.Error())
+		}
+		filep.Close()
+	}
+}
+
+// startHAR creates the HAR file, adds the corresponding annotation to
+// the measurement and returns a context configured to collect the HTTP
+// transactions, along with a function that writes them into the file.
+// Like for startCapture, we only log the errors.
+func (e *Experiment) startHAR(
+	ctx context.Context, measurement *model.Measurement,
+) (context.Context, func()) {
+	logger := e.session.Logger()
+	filep, err := ioutil.TempFile(e.session.TempDir(), e.testName+"-*.har")
+	if err != nil {
+		logger.Warnf("experiment: cannot create HAR file: %s", err.Error())
+		return ctx, func() {}
+	}
+	logger.Infof("experiment: saving HTTP transactions into %s", filep.Name())
+	measurement.AddAnnotation("http_archive", filep.Name())
+	handler := har.NewHandler(time.Now())
+	handler.Creator = har.Creator{
+		Name:    e.session.SoftwareName(),
+		Version: e.session.SoftwareVersion(),
+	}
+	return modelx.WithObserver(ctx, handler), func() {
+		defer filep.Close()
+		if err := json.NewEncoder(filep).Encode(handler.HAR()); err != nil {
+			logger.Warnf("experiment: cannot write HAR file: %s", err.Error())
+		}
+	}
+}
+
 type sessionExperimentCallbacks struct {
 	inner model.ExperimentCallbacks
 	sess  *Session
@@ -407,6 +525,30 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"dns_consistency": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, dnsconsistency.NewExperimentMeasurer(
+					*config.(*dnsconsistency.Config),
+				))
+			},
+			config:     &dnsconsistency.Config{},
+			needsInput: true,
+		}
+	},
+
+	"dnscheck": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, dnscheck.NewExperimentMeasurer(
+					*config.(*dnscheck.Config),
+				))
+			},
+			config:     &dnscheck.Config{},
+			needsInput: true,
+		}
+	},
+
 	"example": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -512,6 +654,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"meek_fronted_requests": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, meekfronted.NewExperimentMeasurer(
+					*config.(*meekfronted.Config),
+				))
+			},
+			config:     &meekfronted.Config{},
+			needsInput: true,
+		}
+	},
+
 	"ndt5": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -552,6 +706,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"signal": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, signal.NewExperimentMeasurer(
+					*config.(*signal.Config),
+				))
+			},
+			config:     &signal.Config{},
+			needsInput: false,
+		}
+	},
+
 	"sni_blocking": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -566,6 +732,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"stun_reachability": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, stunreachability.NewExperimentMeasurer(
+					*config.(*stunreachability.Config),
+				))
+			},
+			config:     &stunreachability.Config{},
+			needsInput: false,
+		}
+	},
+
 	"telegram": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -590,6 +768,18 @@ var experimentsByName = map[string]func(*Session) *ExperimentBuilder{
 		}
 	},
 
+	"urlgetter": func(session *Session) *ExperimentBuilder {
+		return &ExperimentBuilder{
+			build: func(config interface{}) *Experiment {
+				return NewExperiment(session, urlgetter.NewExperimentMeasurer(
+					*config.(*urlgetter.Config),
+				))
+			},
+			config:     &urlgetter.Config{},
+			needsInput: true,
+		}
+	},
+
 	"web_connectivity": func(session *Session) *ExperimentBuilder {
 		return &ExperimentBuilder{
 			build: func(config interface{}) *Experiment {
@@ -0,0 +1,223 @@
+// Package dnscheck contains the dnscheck network experiment. This
+// experiment checks whether a specific DNS resolver (e.g., a DoH or DoT
+// service) is working by using it to resolve a control domain.
+//
+// The input is an URL describing the resolver. We support the
+// following formats:
+//
+// - doh://https://dns.google/dns-query
+//
+// - dot://1.1.1.1:853
+//
+// - tcp://8.8.8.8:53
+//
+// - udp://8.8.8.8:53
+//
+// Before using the resolver, we separately resolve its hostname
+// using the system resolver (the bootstrap lookup) such that we can
+// tell whether the failure occurred at bootstrap, when connecting,
+// during the TLS handshake, or when waiting for the DNS response.
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+const (
+	testName    = "dnscheck"
+	testVersion = "0.1.0"
+)
+
+// DefaultDomain is the domain we resolve by default.
+const DefaultDomain = "example.org"
+
+// Operations that may fail. When the resolver fails with an error that
+// is not related to connecting or handshaking, we say that the failure
+// happened while waiting for the DNS response.
+const (
+	OperationBootstrap    = "bootstrap"
+	OperationConnect      = "connect"
+	OperationDNSResponse  = "dns_response"
+	OperationTLSHandshake = "tls_handshake"
+)
+
+var (
+	errNoInput           = errors.New("dnscheck: no input provided")
+	errInvalidResolver   = errors.New("dnscheck: invalid resolver URL")
+	errUnsupportedScheme = errors.New("dnscheck: unsupported resolver URL scheme")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	Domain string `ooni:"Domain to resolve using the resolver (default: example.org)"`
+}
+
+// TestKeys contains dnscheck test keys.
+type TestKeys struct {
+	Addresses          []string                        `json:"addresses"`
+	BootstrapAddresses []string                        `json:"bootstrap_addresses"`
+	BootstrapFailure   *string                         `json:"bootstrap_failure"`
+	Domain             string                          `json:"domain"`
+	FailedOperation    *string                         `json:"failed_operation"`
+	Failure            *string                         `json:"failure"`
+	Queries            oonidatamodel.DNSQueriesList    `json:"queries"`
+	Requests           oonidatamodel.RequestList       `json:"requests"`
+	ResolverAddress    string                          `json:"resolver_address"`
+	ResolverNetwork    string                          `json:"resolver_network"`
+	TCPConnect         oonidatamodel.TCPConnectList    `json:"tcp_connect"`
+	TLSHandshakes      oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
+}
+
+// resolverInfo describes the resolver we are going to use.
+type resolverInfo struct {
+	address  string // the address to pass to netx.NewResolver
+	hostname string // the hostname to bootstrap
+	network  string // the network to pass to netx.NewResolver
+}
+
+// parseInput parses the input resolver URL.
+func parseInput(input string) (resolverInfo, error) {
+	const dohPrefix = "doh://"
+	if strings.HasPrefix(input, dohPrefix) {
+		address := input[len(dohPrefix):]
+		URL, err := url.Parse(address)
+		if err != nil || URL.Scheme != "https" || URL.Host == "" {
+			return resolverInfo{}, errInvalidResolver
+		}
+		return resolverInfo{
+			address: address, hostname: URL.Hostname(), network: "doh",
+		}, nil
+	}
+	URL, err := url.Parse(input)
+	if err != nil || URL.Host == "" {
+		return resolverInfo{}, errInvalidResolver
+	}
+	ports := map[string]string{"dot": "853", "tcp": "53", "udp": "53"}
+	port, found := ports[URL.Scheme]
+	if !found {
+		return resolverInfo{}, errUnsupportedScheme
+	}
+	if URL.Port() != "" {
+		port = URL.Port()
+	}
+	return resolverInfo{
+		address:  net.JoinHostPort(URL.Hostname(), port),
+		hostname: URL.Hostname(),
+		network:  URL.Scheme,
+	}, nil
+}
+
+// failedOperation maps the error returned by the resolver
+// to the operation that has failed.
+func failedOperation(err error) string {
+	var wrapper *modelx.ErrWrapper
+	if errors.As(err, &wrapper) {
+		switch wrapper.Operation {
+		case OperationConnect, OperationTLSHandshake:
+			return wrapper.Operation
+		}
+	}
+	return OperationDNSResponse
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func (tk *TestKeys) setFailure(operation string, err error) {
+	s := err.Error()
+	tk.Failure = &s
+	tk.FailedOperation = &operation
+}
+
+func (tk *TestKeys) add(results oonitemplates.Results) {
+	tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(results)...)
+	tk.Requests = append(tk.Requests, oonidatamodel.NewRequestList(results)...)
+	tk.TCPConnect = append(tk.TCPConnect, oonidatamodel.NewTCPConnectList(results)...)
+	tk.TLSHandshakes = append(
+		tk.TLSHandshakes, oonidatamodel.NewTLSHandshakesList(results)...)
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := &TestKeys{Domain: m.config.Domain}
+	if tk.Domain == "" {
+		tk.Domain = DefaultDomain
+	}
+	measurement.TestKeys = tk
+	if measurement.Input == "" {
+		return errNoInput
+	}
+	info, err := parseInput(measurement.Input)
+	if err != nil {
+		return err
+	}
+	tk.ResolverAddress, tk.ResolverNetwork = info.address, info.network
+	var received, sent int64
+	defer func() {
+		callbacks.OnDataUsage(
+			float64(received)/1024.0, // downloaded
+			float64(sent)/1024.0,     // uploaded
+		)
+	}()
+	if net.ParseIP(info.hostname) != nil {
+		tk.BootstrapAddresses = []string{info.hostname}
+	} else {
+		bootstrap := oonitemplates.DNSLookup(ctx, oonitemplates.DNSLookupConfig{
+			Beginning:     measurement.MeasurementStartTimeSaved,
+			Handler:       netxlogger.NewHandler(sess.Logger()),
+			Hostname:      info.hostname,
+			ServerNetwork: "system",
+		})
+		tk.add(bootstrap.TestKeys)
+		received += bootstrap.TestKeys.ReceivedBytes
+		sent += bootstrap.TestKeys.SentBytes
+		tk.BootstrapAddresses = bootstrap.Addresses
+		if bootstrap.Error != nil {
+			s := bootstrap.Error.Error()
+			tk.BootstrapFailure = &s
+			tk.setFailure(OperationBootstrap, bootstrap.Error)
+			return nil
+		}
+	}
+	lookup := oonitemplates.DNSLookup(ctx, oonitemplates.DNSLookupConfig{
+		Beginning:     measurement.MeasurementStartTimeSaved,
+		Handler:       netxlogger.NewHandler(sess.Logger()),
+		Hostname:      tk.Domain,
+		ServerAddress: info.address,
+		ServerNetwork: info.network,
+	})
+	tk.add(lookup.TestKeys)
+	received += lookup.TestKeys.ReceivedBytes
+	sent += lookup.TestKeys.SentBytes
+	tk.Addresses = lookup.Addresses
+	if lookup.Error != nil {
+		tk.setFailure(failedOperation(lookup.Error), lookup.Error)
+	}
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
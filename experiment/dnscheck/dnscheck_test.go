@@ -0,0 +1,182 @@
+package dnscheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "dnscheck" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func run(config Config, input string) (*TestKeys, error) {
+	measurement := &model.Measurement{Input: input}
+	m := NewExperimentMeasurer(config)
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*TestKeys), err
+}
+
+func TestUnitRunNoInput(t *testing.T) {
+	_, err := run(Config{}, "")
+	if !errors.Is(err, errNoInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunUnsupportedScheme(t *testing.T) {
+	_, err := run(Config{}, "https://dns.google/dns-query")
+	if !errors.Is(err, errUnsupportedScheme) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunBootstrapFailure(t *testing.T) {
+	tk, err := run(Config{}, "dot://dns.antani.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Domain != DefaultDomain {
+		t.Fatal("unexpected domain")
+	}
+	if tk.BootstrapFailure == nil || tk.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if tk.FailedOperation == nil || *tk.FailedOperation != OperationBootstrap {
+		t.Fatal("unexpected failed operation")
+	}
+	if len(tk.Queries) <= 0 {
+		t.Fatal("expected the bootstrap queries here")
+	}
+}
+
+func TestUnitRunConnectFailure(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close() // so that connecting should fail
+	tk, err := run(Config{Domain: "example.com"}, "tcp://"+address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.BootstrapFailure != nil {
+		t.Fatal("unexpected bootstrap failure")
+	}
+	if len(tk.BootstrapAddresses) != 1 || tk.BootstrapAddresses[0] != "127.0.0.1" {
+		t.Fatal("unexpected bootstrap addresses")
+	}
+	if tk.Failure == nil || *tk.Failure != modelx.FailureConnectionRefused {
+		t.Fatal("not the failure we expected")
+	}
+	if tk.FailedOperation == nil || *tk.FailedOperation != OperationConnect {
+		t.Fatal("unexpected failed operation")
+	}
+}
+
+func TestIntegrationRun(t *testing.T) {
+	for _, input := range []string{
+		"doh://https://dns.google/dns-query",
+		"dot://dns.google",
+		"udp://8.8.8.8:53",
+	} {
+		tk, err := run(Config{}, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tk.Failure != nil {
+			t.Fatal(*tk.Failure)
+		}
+		if len(tk.Addresses) <= 0 {
+			t.Fatal("expected some addresses here")
+		}
+	}
+}
+
+func TestUnitParseInput(t *testing.T) {
+	var inputs = []struct {
+		input string
+		info  resolverInfo
+		err   error
+	}{{
+		input: "doh://https://dns.google/dns-query",
+		info: resolverInfo{
+			address:  "https://dns.google/dns-query",
+			hostname: "dns.google",
+			network:  "doh",
+		},
+	}, {
+		input: "dot://1.1.1.1",
+		info:  resolverInfo{address: "1.1.1.1:853", hostname: "1.1.1.1", network: "dot"},
+	}, {
+		input: "dot://dns.google:8853",
+		info:  resolverInfo{address: "dns.google:8853", hostname: "dns.google", network: "dot"},
+	}, {
+		input: "udp://[2001:4860:4860::8888]",
+		info: resolverInfo{
+			address:  "[2001:4860:4860::8888]:53",
+			hostname: "2001:4860:4860::8888",
+			network:  "udp",
+		},
+	}, {
+		input: "tcp://8.8.8.8:53",
+		info:  resolverInfo{address: "8.8.8.8:53", hostname: "8.8.8.8", network: "tcp"},
+	}, {
+		input: "doh://http://dns.google/dns-query",
+		err:   errInvalidResolver,
+	}, {
+		input: "dot://",
+		err:   errInvalidResolver,
+	}, {
+		input: "system://",
+		err:   errInvalidResolver,
+	}, {
+		input: "antani://8.8.8.8",
+		err:   errUnsupportedScheme,
+	}}
+	for _, input := range inputs {
+		info, err := parseInput(input.input)
+		if !errors.Is(err, input.err) {
+			t.Fatalf("%s: not the error we expected: %+v", input.input, err)
+		}
+		if info != input.info {
+			t.Fatalf("%s: unexpected info: %+v", input.input, info)
+		}
+	}
+}
+
+func TestUnitFailedOperation(t *testing.T) {
+	var inputs = []struct {
+		err    error
+		expect string
+	}{
+		{&modelx.ErrWrapper{Operation: "connect"}, OperationConnect},
+		{&modelx.ErrWrapper{Operation: "tls_handshake"}, OperationTLSHandshake},
+		{&modelx.ErrWrapper{Operation: "resolve"}, OperationDNSResponse},
+		{errors.New("mocked error"), OperationDNSResponse},
+	}
+	for _, input := range inputs {
+		if out := failedOperation(input.err); out != input.expect {
+			t.Fatalf("expected %s, got %s", input.expect, out)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// Package dnsconsistency contains the DNS consistency network experiment. This
+// file in particular is a pure-Go implementation of that.
+//
+// We resolve the input domain using the system resolver and a set of
+// control resolvers and we compare the results. Differently from the
+// original implementation, control resolvers may use any transport
+// supported by netx (i.e., system, udp, tcp, dot, and doh).
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-002-dns-consistency.md.
+package dnsconsistency
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+const (
+	testName    = "dns_consistency"
+	testVersion = "0.1.0"
+)
+
+// DefaultControlResolvers is the default list of control resolvers.
+const DefaultControlResolvers = "doh:https://dns.google/dns-query dot:1.1.1.1:853 udp:8.8.8.8:53"
+
+const (
+	// ClassConsistent means the system resolver agrees with the controls.
+	ClassConsistent = "consistent"
+
+	// ClassInconsistent means the system resolver disagrees with the controls.
+	ClassInconsistent = "inconsistent"
+
+	// ClassBogon means the system resolver returned bogon addresses.
+	ClassBogon = modelx.FailureDNSBogonError
+
+	// ClassNXDOMAINOnlyLocally means that only the system resolver
+	// told us that the input domain does not exist.
+	ClassNXDOMAINOnlyLocally = "nxdomain_only_locally"
+)
+
+var (
+	errNoInput                = errors.New("dns_consistency: no input provided")
+	errNoControlResolvers     = errors.New("dns_consistency: no control resolvers")
+	errInvalidControlResolver = errors.New("dns_consistency: invalid control resolver")
+	errAllControlsFailed      = errors.New("dns_consistency: all control resolvers failed")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	ControlResolvers string `ooni:"Space separated list of network:address control resolvers"`
+}
+
+// ResolverResult contains the result of resolving the
+// input domain using a specific resolver.
+type ResolverResult struct {
+	Addresses []string `json:"addresses"`
+	Address   string   `json:"resolver_address"`
+	Failure   *string  `json:"failure"`
+	Network   string   `json:"resolver_network"`
+}
+
+// TestKeys contains dns_consistency test keys.
+type TestKeys struct {
+	Control      []ResolverResult             `json:"control"`
+	Failure      *string                      `json:"failure"`
+	Inconsistent []string                     `json:"inconsistent"`
+	Queries      oonidatamodel.DNSQueriesList `json:"queries"`
+	Result       string                       `json:"result"`
+	System       ResolverResult               `json:"system"`
+}
+
+// resolverConfig is the network and address of a resolver.
+type resolverConfig struct {
+	network string
+	address string
+}
+
+func (rc resolverConfig) String() string {
+	if rc.address == "" {
+		return rc.network
+	}
+	return rc.network + ":" + rc.address
+}
+
+// parseControlResolvers parses the ControlResolvers config string.
+func parseControlResolvers(s string) ([]resolverConfig, error) {
+	if s == "" {
+		s = DefaultControlResolvers
+	}
+	var out []resolverConfig
+	for _, entry := range strings.Fields(s) {
+		v := strings.SplitN(entry, ":", 2)
+		switch v[0] {
+		case "system":
+			out = append(out, resolverConfig{network: v[0]})
+		case "doh", "dot", "tcp", "udp":
+			if len(v) != 2 || v[1] == "" {
+				return nil, errInvalidControlResolver
+			}
+			out = append(out, resolverConfig{network: v[0], address: v[1]})
+		default:
+			return nil, errInvalidControlResolver
+		}
+	}
+	if len(out) <= 0 {
+		return nil, errNoControlResolvers
+	}
+	return out, nil
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	if measurement.Input == "" {
+		return errNoInput
+	}
+	controls, err := parseControlResolvers(m.config.ControlResolvers)
+	if err != nil {
+		return err
+	}
+	// run all the lookups in parallel, the system one is the first
+	configs := append([]resolverConfig{{network: "system"}}, controls...)
+	results := make([]*oonitemplates.DNSLookupResults, len(configs))
+	var wg sync.WaitGroup
+	for idx, rc := range configs {
+		wg.Add(1)
+		go func(idx int, rc resolverConfig) {
+			defer wg.Done()
+			results[idx] = oonitemplates.DNSLookup(ctx, oonitemplates.DNSLookupConfig{
+				Beginning:     measurement.MeasurementStartTimeSaved,
+				Handler:       netxlogger.NewHandler(sess.Logger()),
+				Hostname:      measurement.Input,
+				ServerAddress: rc.address,
+				ServerNetwork: rc.network,
+			})
+		}(idx, rc)
+	}
+	wg.Wait()
+	var received, sent int64
+	for idx, r := range results {
+		tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(r.TestKeys)...)
+		received += r.TestKeys.ReceivedBytes
+		sent += r.TestKeys.SentBytes
+		entry := newResolverResult(configs[idx], r)
+		if idx == 0 {
+			tk.System = entry
+			continue
+		}
+		tk.Control = append(tk.Control, entry)
+	}
+	callbacks.OnDataUsage(
+		float64(received)/1024.0, // downloaded
+		float64(sent)/1024.0,     // uploaded
+	)
+	tk.classify(func(ip string) uint {
+		asn, _, _ := mmdblookup.LookupASN(sess.ASNDatabasePath(), ip, sess.Logger())
+		return asn
+	})
+	return nil
+}
+
+// newResolverResult creates a ResolverResult from the results of a
+// DNSLookup. If the lookup returned bogons, we record it as a failure.
+func newResolverResult(rc resolverConfig, r *oonitemplates.DNSLookupResults) ResolverResult {
+	entry := ResolverResult{
+		Addresses: r.Addresses,
+		Address:   rc.address,
+		Network:   rc.network,
+	}
+	if r.Error != nil {
+		s := r.Error.Error()
+		entry.Failure = &s
+	}
+	for _, resolve := range r.TestKeys.Resolves {
+		if resolve.ContainsBogons {
+			s := modelx.FailureDNSBogonError
+			entry.Failure = &s
+		}
+	}
+	return entry
+}
+
+func isNXDOMAIN(failure *string) bool {
+	return failure != nil && *failure == modelx.FailureDNSNXDOMAINError
+}
+
+// consistentWith returns whether the addresses returned by the system
+// resolver are consistent with the ones returned by a control resolver. We
+// consider them consistent if they share at least an address or an ASN. The
+// lookupASN function maps an IP address to its ASN.
+func (tk *TestKeys) consistentWith(ctrl ResolverResult, lookupASN func(ip string) uint) bool {
+	if tk.System.Failure != nil || ctrl.Failure != nil {
+		return isNXDOMAIN(tk.System.Failure) && isNXDOMAIN(ctrl.Failure)
+	}
+	addrs := make(map[string]bool)
+	asns := make(map[uint]bool)
+	for _, addr := range ctrl.Addresses {
+		addrs[addr] = true
+		if asn := lookupASN(addr); asn != 0 {
+			asns[asn] = true
+		}
+	}
+	for _, addr := range tk.System.Addresses {
+		if addrs[addr] {
+			return true
+		}
+		if asn := lookupASN(addr); asn != 0 && asns[asn] {
+			return true
+		}
+	}
+	return false
+}
+
+// classify fills the Inconsistent and Result keys. Control resolvers
+// that failed for reasons other than NXDOMAIN are not considered.
+func (tk *TestKeys) classify(lookupASN func(ip string) uint) {
+	tk.Inconsistent = []string{}
+	var working, nxdomain int
+	for _, ctrl := range tk.Control {
+		if ctrl.Failure != nil && !isNXDOMAIN(ctrl.Failure) {
+			continue
+		}
+		working++
+		if isNXDOMAIN(ctrl.Failure) {
+			nxdomain++
+		}
+		if !tk.consistentWith(ctrl, lookupASN) {
+			rc := resolverConfig{network: ctrl.Network, address: ctrl.Address}
+			tk.Inconsistent = append(tk.Inconsistent, rc.String())
+		}
+	}
+	if tk.System.Failure != nil && *tk.System.Failure == modelx.FailureDNSBogonError {
+		tk.Result = ClassBogon
+		return
+	}
+	if working <= 0 {
+		s := errAllControlsFailed.Error()
+		tk.Failure = &s
+		return
+	}
+	if isNXDOMAIN(tk.System.Failure) && nxdomain <= 0 {
+		tk.Result = ClassNXDOMAINOnlyLocally
+		return
+	}
+	if len(tk.Inconsistent) > 0 {
+		tk.Result = ClassInconsistent
+		return
+	}
+	tk.Result = ClassConsistent
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
@@ -0,0 +1,199 @@
+package dnsconsistency
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "dns_consistency" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func run(config Config, input string) (*TestKeys, error) {
+	measurement := &model.Measurement{Input: input}
+	m := NewExperimentMeasurer(config)
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*TestKeys), err
+}
+
+func TestUnitRunNoInput(t *testing.T) {
+	_, err := run(Config{}, "")
+	if !errors.Is(err, errNoInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunInvalidControlResolver(t *testing.T) {
+	_, err := run(Config{ControlResolvers: "antani:1.1.1.1"}, "example.com")
+	if !errors.Is(err, errInvalidControlResolver) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunLocalhost(t *testing.T) {
+	tk, err := run(Config{ControlResolvers: "system"}, "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Result != ClassBogon {
+		t.Fatal("unexpected result")
+	}
+	if len(tk.Control) != 1 || len(tk.Queries) != 4 {
+		t.Fatal("unexpected number of entries")
+	}
+}
+
+func TestIntegrationRun(t *testing.T) {
+	tk, err := run(Config{}, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Result != ClassConsistent {
+		t.Fatal("unexpected result")
+	}
+	if len(tk.Control) != 3 {
+		t.Fatal("unexpected number of controls")
+	}
+}
+
+func TestUnitParseControlResolvers(t *testing.T) {
+	out, err := parseControlResolvers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 3 || out[0].String() != "doh:https://dns.google/dns-query" {
+		t.Fatal("unexpected default control resolvers")
+	}
+	out, err = parseControlResolvers("system tcp:8.8.8.8:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].String() != "system" || out[1].address != "8.8.8.8:53" {
+		t.Fatal("unexpected control resolvers")
+	}
+	if _, err := parseControlResolvers("udp"); !errors.Is(err, errInvalidControlResolver) {
+		t.Fatal("not the error we expected")
+	}
+	if _, err := parseControlResolvers(" "); !errors.Is(err, errNoControlResolvers) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func failure(s string) *string {
+	return &s
+}
+
+func lookupASN(ip string) uint {
+	switch ip {
+	case "1.1.1.1", "1.0.0.1":
+		return 13335
+	case "8.8.8.8":
+		return 15169
+	}
+	return 0
+}
+
+func TestUnitClassify(t *testing.T) {
+	var inputs = []struct {
+		name         string
+		tk           TestKeys
+		result       string
+		inconsistent int
+		failure      bool
+	}{{
+		name: "same addresses",
+		tk: TestKeys{
+			System:  ResolverResult{Addresses: []string{"8.8.8.8"}},
+			Control: []ResolverResult{{Addresses: []string{"8.8.8.8"}}},
+		},
+		result: ClassConsistent,
+	}, {
+		name: "same ASN",
+		tk: TestKeys{
+			System:  ResolverResult{Addresses: []string{"1.1.1.1"}},
+			Control: []ResolverResult{{Addresses: []string{"1.0.0.1"}}},
+		},
+		result: ClassConsistent,
+	}, {
+		name: "different ASN",
+		tk: TestKeys{
+			System: ResolverResult{Addresses: []string{"1.1.1.1"}},
+			Control: []ResolverResult{
+				{Addresses: []string{"8.8.8.8"}},
+				{Failure: failure(modelx.FailureGenericTimeoutError)},
+			},
+		},
+		result:       ClassInconsistent,
+		inconsistent: 1,
+	}, {
+		name: "bogon",
+		tk: TestKeys{
+			System:  ResolverResult{Failure: failure(modelx.FailureDNSBogonError)},
+			Control: []ResolverResult{{Addresses: []string{"8.8.8.8"}}},
+		},
+		result:       ClassBogon,
+		inconsistent: 1,
+	}, {
+		name: "NXDOMAIN everywhere",
+		tk: TestKeys{
+			System:  ResolverResult{Failure: failure(modelx.FailureDNSNXDOMAINError)},
+			Control: []ResolverResult{{Failure: failure(modelx.FailureDNSNXDOMAINError)}},
+		},
+		result: ClassConsistent,
+	}, {
+		name: "NXDOMAIN only locally",
+		tk: TestKeys{
+			System:  ResolverResult{Failure: failure(modelx.FailureDNSNXDOMAINError)},
+			Control: []ResolverResult{{Addresses: []string{"8.8.8.8"}}},
+		},
+		result:       ClassNXDOMAINOnlyLocally,
+		inconsistent: 1,
+	}, {
+		name: "system failure",
+		tk: TestKeys{
+			System:  ResolverResult{Failure: failure(modelx.FailureGenericTimeoutError)},
+			Control: []ResolverResult{{Addresses: []string{"8.8.8.8"}}},
+		},
+		result:       ClassInconsistent,
+		inconsistent: 1,
+	}, {
+		name: "all controls failed",
+		tk: TestKeys{
+			System:  ResolverResult{Addresses: []string{"8.8.8.8"}},
+			Control: []ResolverResult{{Failure: failure(modelx.FailureGenericTimeoutError)}},
+		},
+		failure: true,
+	}}
+	for _, input := range inputs {
+		t.Run(input.name, func(t *testing.T) {
+			input.tk.classify(lookupASN)
+			if input.tk.Result != input.result {
+				t.Fatalf("expected %s, got %s", input.result, input.tk.Result)
+			}
+			if len(input.tk.Inconsistent) != input.inconsistent {
+				t.Fatal("unexpected number of inconsistent resolvers")
+			}
+			if (input.tk.Failure != nil) != input.failure {
+				t.Fatal("unexpected failure")
+			}
+		})
+	}
+}
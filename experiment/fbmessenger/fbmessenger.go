@@ -1,23 +1,198 @@
 // Package fbmessenger contains the Facebook Messenger network experiment.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-019-facebook-messenger.md
 package fbmessenger
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
 )
 
 const (
 	testName    = "facebook_messenger"
-	testVersion = "0.0.2"
+	testVersion = "0.1.0"
 )
 
+const (
+	// FacebookASN is Facebook's ASN
+	FacebookASN = 32934
+
+	// ServiceBAPI is the b-api service
+	ServiceBAPI = "b-api.facebook.com"
+
+	// ServiceBGraph is the b-graph service
+	ServiceBGraph = "b-graph.facebook.com"
+
+	// ServiceEdge is the edge service
+	ServiceEdge = "edge-mqtt.facebook.com"
+
+	// ServiceExternalCDN is the external CDN service
+	ServiceExternalCDN = "external.xx.fbcdn.net"
+
+	// ServiceScontentCDN is the scontent CDN service
+	ServiceScontentCDN = "scontent.xx.fbcdn.net"
+
+	// ServiceStar is the star service
+	ServiceStar = "star.c10r.facebook.com"
+)
+
+// services contains the services we measure.
+var services = []string{
+	ServiceBAPI,
+	ServiceBGraph,
+	ServiceEdge,
+	ServiceExternalCDN,
+	ServiceScontentCDN,
+	ServiceStar,
+}
+
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	Agent      string                       `json:"agent"`
+	Queries    oonidatamodel.DNSQueriesList `json:"queries"`
+	TCPConnect oonidatamodel.TCPConnectList `json:"tcp_connect"`
+
+	FacebookBAPIDNSConsistent        *bool `json:"facebook_b_api_dns_consistent"`
+	FacebookBAPIReachable            *bool `json:"facebook_b_api_reachable"`
+	FacebookBGraphDNSConsistent      *bool `json:"facebook_b_graph_dns_consistent"`
+	FacebookBGraphReachable          *bool `json:"facebook_b_graph_reachable"`
+	FacebookEdgeDNSConsistent        *bool `json:"facebook_edge_dns_consistent"`
+	FacebookEdgeReachable            *bool `json:"facebook_edge_reachable"`
+	FacebookExternalCDNDNSConsistent *bool `json:"facebook_external_cdn_dns_consistent"`
+	FacebookExternalCDNReachable     *bool `json:"facebook_external_cdn_reachable"`
+	FacebookScontentCDNDNSConsistent *bool `json:"facebook_scontent_cdn_dns_consistent"`
+	FacebookScontentCDNReachable     *bool `json:"facebook_scontent_cdn_reachable"`
+	FacebookStarDNSConsistent        *bool `json:"facebook_star_dns_consistent"`
+	FacebookStarReachable            *bool `json:"facebook_star_reachable"`
+	FacebookDNSBlocking              *bool `json:"facebook_dns_blocking"`
+	FacebookTCPBlocking              *bool `json:"facebook_tcp_blocking"`
+}
+
+// serviceKeys returns the keys that pertain to the specified service.
+func (tk *TestKeys) serviceKeys(service string) (dnsConsistent, reachable **bool) {
+	switch service {
+	case ServiceBAPI:
+		return &tk.FacebookBAPIDNSConsistent, &tk.FacebookBAPIReachable
+	case ServiceBGraph:
+		return &tk.FacebookBGraphDNSConsistent, &tk.FacebookBGraphReachable
+	case ServiceEdge:
+		return &tk.FacebookEdgeDNSConsistent, &tk.FacebookEdgeReachable
+	case ServiceExternalCDN:
+		return &tk.FacebookExternalCDNDNSConsistent, &tk.FacebookExternalCDNReachable
+	case ServiceScontentCDN:
+		return &tk.FacebookScontentCDNDNSConsistent, &tk.FacebookScontentCDNReachable
+	case ServiceStar:
+		return &tk.FacebookStarDNSConsistent, &tk.FacebookStarReachable
+	}
+	return nil, nil
+}
+
+// serviceResults contains the results of measuring a service.
+type serviceResults struct {
+	dns      *oonitemplates.DNSLookupResults
+	connects []*oonitemplates.TCPConnectResults
+}
+
+// processone updates the test keys using the results of measuring
+// the given service. The lookupASN function maps an IP to its ASN. If
+// we cannot map an IP to its ASN, we leave the keys of the service
+// unset, because we cannot say whether they are consistent.
+func (tk *TestKeys) processone(
+	service string, r *serviceResults, lookupASN func(ip string) (uint, error),
+) error {
+	dnsConsistentKey, reachableKey := tk.serviceKeys(service)
+	if dnsConsistentKey == nil || r == nil || r.dns == nil {
+		return nil
+	}
+	tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(r.dns.TestKeys)...)
+	dnsConsistent, err := isConsistent(r.dns, lookupASN)
+	if err != nil {
+		return err
+	}
+	*dnsConsistentKey = &dnsConsistent
+	tk.FacebookDNSBlocking = or(tk.FacebookDNSBlocking, !dnsConsistent)
+	if !dnsConsistent {
+		return nil // we don't attempt connecting in such case
+	}
+	reachable := len(r.connects) > 0
+	for _, connect := range r.connects {
+		tk.TCPConnect = append(
+			tk.TCPConnect, oonidatamodel.NewTCPConnectList(connect.TestKeys)...)
+		if connect.Error != nil {
+			reachable = false
+		}
+	}
+	*reachableKey = &reachable
+	tk.FacebookTCPBlocking = or(tk.FacebookTCPBlocking, !reachable)
+	return nil
+}
+
+// isConsistent returns whether the lookup succeeded and all the
+// returned addresses belong to Facebook's ASN. It returns an error
+// if it cannot map any of the returned addresses to its ASN.
+func isConsistent(
+	r *oonitemplates.DNSLookupResults, lookupASN func(ip string) (uint, error),
+) (bool, error) {
+	if r.Error != nil || len(r.Addresses) <= 0 {
+		return false, nil
+	}
+	for _, addr := range r.Addresses {
+		asn, err := lookupASN(addr)
+		if err != nil {
+			return false, err
+		}
+		if asn != FacebookASN {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// processall updates the test keys using all the results. If we cannot
+// tell whether some services are consistent, we leave the blocking keys
+// unset unless we have seen blocking with other services. In such case
+// we also return the first ASN lookup error that occurred.
+func (tk *TestKeys) processall(
+	results map[string]*serviceResults, lookupASN func(ip string) (uint, error),
+) (err error) {
+	tk.Agent = "redirect"
+	tk.FacebookDNSBlocking = or(nil, false)
+	tk.FacebookTCPBlocking = or(nil, false)
+	for _, service := range services {
+		if e := tk.processone(service, results[service], lookupASN); e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		tk.FacebookDNSBlocking = trueOrNil(tk.FacebookDNSBlocking)
+		tk.FacebookTCPBlocking = trueOrNil(tk.FacebookTCPBlocking)
+	}
+	return
+}
+
+func or(v *bool, b bool) *bool {
+	out := b || (v != nil && *v)
+	return &out
+}
+
+func trueOrNil(v *bool) *bool {
+	if v != nil && *v {
+		return v
+	}
+	return nil
 }
 
 type measurer struct {
@@ -33,21 +208,95 @@ func (m *measurer) ExperimentVersion() string {
 }
 
 func (m *measurer) Run(
-	ctx context.Context, sess model.ExperimentSession,
-	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+	ctx context.Context,
+	sess model.ExperimentSession,
+	measurement *model.Measurement,
+	callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"FacebookMessenger", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	var (
+		completed     = atomicx.NewInt64()
+		mu            sync.Mutex
+		receivedBytes = atomicx.NewInt64()
+		results       = make(map[string]*serviceResults)
+		sentBytes     = atomicx.NewInt64()
+		waitgroup     sync.WaitGroup
 	)
-	settings.Options.GeoIPASNPath = sess.ASNDatabasePath()
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	lookupASN := func(ip string) (uint, error) {
+		asn, _, err := mmdblookup.LookupASN(sess.ASNDatabasePath(), ip, sess.Logger())
+		return asn, err
+	}
+	waitgroup.Add(len(services))
+	for _, service := range services {
+		go func(service string) {
+			defer waitgroup.Done()
+			r := measureone(ctx, sess, measurement, service, lookupASN)
+			for _, connect := range r.connects {
+				receivedBytes.Add(connect.TestKeys.ReceivedBytes)
+				sentBytes.Add(connect.TestKeys.SentBytes)
+			}
+			mu.Lock()
+			results[service] = r
+			mu.Unlock()
+			sofar := completed.Add(1)
+			percentage := float64(sofar) / float64(len(services))
+			callbacks.OnProgress(percentage, fmt.Sprintf(
+				"fbmessenger: measure %s: %s", service, errString(r.dns.Error),
+			))
+		}(service)
+	}
+	waitgroup.Wait()
+	testkeys := new(TestKeys)
+	measurement.TestKeys = testkeys
+	if err := testkeys.processall(results, lookupASN); err != nil {
+		sess.Logger().Warnf("fbmessenger: cannot lookup ASN: %s", err)
+	}
+	callbacks.OnDataUsage(
+		float64(receivedBytes.Load())/1024.0, // downloaded
+		float64(sentBytes.Load())/1024.0,     // uploaded
 	)
+	return nil
+}
+
+// measureone resolves the service and, if the returned addresses
+// are consistent, connects to all of them.
+func measureone(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, service string,
+	lookupASN func(ip string) (uint, error),
+) *serviceResults {
+	handler := netxlogger.NewHandler(sess.Logger())
+	r := new(serviceResults)
+	r.dns = oonitemplates.DNSLookup(ctx, oonitemplates.DNSLookupConfig{
+		Beginning: measurement.MeasurementStartTimeSaved,
+		Handler:   handler,
+		Hostname:  service,
+	})
+	if consistent, err := isConsistent(r.dns, lookupASN); err != nil || !consistent {
+		return r
+	}
+	for _, addr := range r.dns.Addresses {
+		r.connects = append(r.connects, oonitemplates.TCPConnect(
+			ctx, oonitemplates.TCPConnectConfig{
+				Address:   net.JoinHostPort(addr, "443"),
+				Beginning: measurement.MeasurementStartTimeSaved,
+				Handler:   handler,
+			},
+		))
+	}
+	return r
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
@@ -1,18 +1,178 @@
-package fbmessenger_test
+package fbmessenger
 
 import (
+	"context"
+	"errors"
 	"testing"
 
-	"github.com/ooni/probe-engine/experiment/fbmessenger"
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return fbmessenger.NewExperimentMeasurer(fbmessenger.Config{})
-	})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "facebook_messenger" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestIntegrationMeasure(t *testing.T) {
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(Config{})
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableASNDatabasePath: "../../testdata/asn.mmdb",
+			MockableLogger:          log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.FacebookDNSBlocking == nil || tk.FacebookTCPBlocking == nil {
+		t.Fatal("expected the blocking keys to be set")
+	}
+}
+
+var errASNLookup = errors.New("mocked ASN lookup error")
+
+func facebookASN(ip string) (uint, error) {
+	switch ip {
+	case "10.0.0.1":
+		return 0, nil
+	case "10.0.0.2":
+		return 0, errASNLookup
+	}
+	return FacebookASN, nil
+}
+
+func successfulResults(addrs ...string) *serviceResults {
+	r := &serviceResults{dns: &oonitemplates.DNSLookupResults{Addresses: addrs}}
+	for range addrs {
+		r.connects = append(r.connects, &oonitemplates.TCPConnectResults{})
+	}
+	return r
+}
+
+func TestUnitProcessallSuccess(t *testing.T) {
+	results := make(map[string]*serviceResults)
+	for _, service := range services {
+		results[service] = successfulResults("157.240.1.1")
+	}
+	tk := new(TestKeys)
+	if err := tk.processall(results, facebookASN); err != nil {
+		t.Fatal(err)
+	}
+	if *tk.FacebookDNSBlocking || *tk.FacebookTCPBlocking {
+		t.Fatal("expected no blocking")
+	}
+	for _, service := range services {
+		consistent, reachable := tk.serviceKeys(service)
+		if *consistent == nil || !**consistent || *reachable == nil || !**reachable {
+			t.Fatal("expected consistent and reachable service")
+		}
+	}
+}
+
+func TestUnitProcessallDNSBlocking(t *testing.T) {
+	results := make(map[string]*serviceResults)
+	for _, service := range services {
+		results[service] = successfulResults("157.240.1.1")
+	}
+	results[ServiceEdge] = successfulResults("10.0.0.1")
+	results[ServiceStar] = &serviceResults{dns: &oonitemplates.DNSLookupResults{
+		Error: errors.New("mocked error"),
+	}}
+	tk := new(TestKeys)
+	if err := tk.processall(results, facebookASN); err != nil {
+		t.Fatal(err)
+	}
+	if !*tk.FacebookDNSBlocking || *tk.FacebookTCPBlocking {
+		t.Fatal("expected DNS blocking only")
+	}
+	if *tk.FacebookEdgeDNSConsistent || tk.FacebookEdgeReachable != nil {
+		t.Fatal("unexpected edge keys")
+	}
+	if *tk.FacebookStarDNSConsistent || tk.FacebookStarReachable != nil {
+		t.Fatal("unexpected star keys")
+	}
+}
+
+func TestUnitProcessallTCPBlocking(t *testing.T) {
+	results := make(map[string]*serviceResults)
+	for _, service := range services {
+		results[service] = successfulResults("157.240.1.1")
+	}
+	results[ServiceBAPI] = successfulResults("157.240.1.1", "157.240.1.2")
+	results[ServiceBAPI].connects[1].Error = errors.New("mocked error")
+	tk := new(TestKeys)
+	if err := tk.processall(results, facebookASN); err != nil {
+		t.Fatal(err)
+	}
+	if *tk.FacebookDNSBlocking || !*tk.FacebookTCPBlocking {
+		t.Fatal("expected TCP blocking only")
+	}
+	if !*tk.FacebookBAPIDNSConsistent || *tk.FacebookBAPIReachable {
+		t.Fatal("unexpected b-api keys")
+	}
+}
+
+func TestUnitProcessallMissingResults(t *testing.T) {
+	tk := new(TestKeys)
+	if err := tk.processall(make(map[string]*serviceResults), facebookASN); err != nil {
+		t.Fatal(err)
+	}
+	if *tk.FacebookDNSBlocking || *tk.FacebookTCPBlocking {
+		t.Fatal("expected no blocking")
+	}
+	if tk.FacebookBAPIDNSConsistent != nil {
+		t.Fatal("expected nil key")
+	}
+}
+
+func TestUnitProcessallASNLookupFailure(t *testing.T) {
+	results := make(map[string]*serviceResults)
+	for _, service := range services {
+		results[service] = successfulResults("10.0.0.2")
+	}
+	tk := new(TestKeys)
+	if err := tk.processall(results, facebookASN); !errors.Is(err, errASNLookup) {
+		t.Fatal("not the error we expected")
+	}
+	if tk.FacebookDNSBlocking != nil || tk.FacebookTCPBlocking != nil {
+		t.Fatal("expected unknown blocking")
+	}
+	for _, service := range services {
+		consistent, reachable := tk.serviceKeys(service)
+		if *consistent != nil || *reachable != nil {
+			t.Fatal("expected unknown service keys")
+		}
+	}
+}
+
+func TestUnitProcessallASNLookupFailureWithBlocking(t *testing.T) {
+	results := make(map[string]*serviceResults)
+	for _, service := range services {
+		results[service] = successfulResults("10.0.0.2")
+	}
+	results[ServiceEdge] = successfulResults("10.0.0.1")
+	tk := new(TestKeys)
+	if err := tk.processall(results, facebookASN); !errors.Is(err, errASNLookup) {
+		t.Fatal("not the error we expected")
+	}
+	if tk.FacebookDNSBlocking == nil || !*tk.FacebookDNSBlocking {
+		t.Fatal("expected DNS blocking")
+	}
+	if tk.FacebookTCPBlocking != nil {
+		t.Fatal("expected unknown TCP blocking")
+	}
 }
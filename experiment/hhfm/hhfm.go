@@ -1,24 +1,60 @@
 // Package hhfm contains the HTTP Header Field Manipulation network experiment.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-006-header-field-manipulation.md
 package hhfm
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkhelper"
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/experiment/httpheader"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
 )
 
 const (
 	testName    = "http_header_field_manipulation"
-	testVersion = "0.0.1"
+	testVersion = "0.1.0"
 )
 
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// Tampering describes the detected forms of tampering.
+type Tampering struct {
+	HeaderFieldName           bool     `json:"header_field_name"`
+	HeaderNameDiff            []string `json:"header_name_diff"`
+	RequestLineCapitalization bool     `json:"request_line_capitalization"`
+	Total                     bool     `json:"total"`
+}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	Agent     string                    `json:"agent"`
+	Failure   *string                   `json:"failure"`
+	Requests  oonidatamodel.RequestList `json:"requests"`
+	Tampering Tampering                 `json:"tampering"`
+}
+
+// JSONHeaders is the response body returned by the
+// legacy http-return-json-headers test helper.
+type JSONHeaders struct {
+	HeadersDict map[string][]string `json:"headers_dict"`
+	RequestLine string              `json:"request_line"`
 }
 
 type measurer struct {
@@ -33,25 +69,239 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+var (
+	// errNoAvailableTestHelpers is emitted when there are no test helpers.
+	errNoAvailableTestHelpers = errors.New("no available helpers")
+
+	// errInvalidHelperURL indicates that the helper URL is not valid.
+	errInvalidHelperURL = errors.New("invalid helper URL")
+)
+
+const (
+	requestMethod = "GET"
+	maxBodySize   = 1 << 20
+)
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"HttpHeaderFieldManipulation", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	err := mkhelper.Set(sess, "http-return-json-headers", "legacy", &settings)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	tk.Agent = "agent"
+	testhelper := findTestHelper(sess)
+	if testhelper == nil {
+		return errNoAvailableTestHelpers
+	}
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": testhelper,
+	}
+	URL, err := url.Parse(testhelper.Address)
+	if err != nil || URL.Scheme != "http" || URL.Host == "" {
+		return errInvalidHelperURL
+	}
+	address := URL.Host
+	if URL.Port() == "" {
+		address = net.JoinHostPort(URL.Hostname(), "80")
+	}
+	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	headers := newRandomHeaders(gen, URL.Host)
+	dialer := netx.NewDialer()
+	dialer.Beginning = measurement.MeasurementStartTimeSaved
+	dialer.Handler = netxlogger.NewHandler(sess.Logger())
+	entry := oonidatamodel.RequestEntry{
+		Request: oonidatamodel.HTTPRequest{
+			Headers:     make(oonidatamodel.HTTPHeaders),
+			HeadersList: oonidatamodel.HTTPHeadersList{},
+			Method:      requestMethod,
+			URL:         testhelper.Address,
+		},
+		Response: oonidatamodel.HTTPResponse{
+			Headers:     make(oonidatamodel.HTTPHeaders),
+			HeadersList: oonidatamodel.HTTPHeadersList{},
+		},
+	}
+	for _, h := range headers {
+		value := oonidatamodel.MaybeBinaryValue{Value: h.value}
+		entry.Request.Headers[h.key] = value
+		entry.Request.HeadersList = append(
+			entry.Request.HeadersList, oonidatamodel.HTTPHeader{Key: h.key, Value: value})
+	}
+	resp, data, err := transact(ctx, dialer, address, headers, callbacks)
 	if err != nil {
-		return err
+		tk.Failure = setFailure(err)
+		entry.Failure = tk.Failure
+		tk.Requests = append(tk.Requests, entry)
+		sess.Logger().Infof("hhfm: %s", err.Error())
+		return nil
 	}
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
-	)
+	entry.Response.Body = oonidatamodel.MaybeBinaryValue{Value: string(data)}
+	entry.Response.Code = int64(resp.StatusCode)
+	for key, values := range resp.Header {
+		for idx, value := range values {
+			value := oonidatamodel.MaybeBinaryValue{Value: value}
+			if idx == 0 {
+				entry.Response.Headers[key] = value
+			}
+			entry.Response.HeadersList = append(
+				entry.Response.HeadersList, oonidatamodel.HTTPHeader{Key: key, Value: value})
+		}
+	}
+	tk.Requests = append(tk.Requests, entry)
+	tk.Tampering = analyze(headers, data)
+	sess.Logger().Infof("hhfm: tampering: %+v", tk.Tampering.Total)
+	return nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func findTestHelper(sess model.ExperimentSession) *model.Service {
+	testhelpers, _ := sess.GetTestHelpersByName("http-return-json-headers")
+	for _, th := range testhelpers {
+		if th.Type == "legacy" {
+			return &th
+		}
+	}
+	return nil
+}
+
+// header is a header whose key we send exactly as is.
+type header struct {
+	key   string
+	value string
+}
+
+// newRandomHeaders returns the headers to send, where the case of
+// each key has been randomized using the specified generator.
+func newRandomHeaders(gen *rand.Rand, host string) []header {
+	headers := []header{
+		{key: "Host", value: host},
+		{key: "User-Agent", value: httpheader.RandomUserAgent()},
+		{key: "Accept", value: httpheader.RandomAccept()},
+		{key: "Accept-Language", value: httpheader.RandomAcceptLanguage()},
+		{key: "Accept-Encoding", value: "gzip,deflate,sdch"},
+		{key: "Accept-Charset", value: "ISO-8859-1,utf-8;q=0.7,*;q=0.3"},
+	}
+	for idx := range headers {
+		headers[idx].key = randomCapitalization(gen, headers[idx].key)
+	}
+	return headers
+}
+
+// randomCapitalization randomly changes the case of the letters in s.
+func randomCapitalization(gen *rand.Rand, s string) string {
+	var builder strings.Builder
+	for _, r := range s {
+		if gen.Intn(2) == 0 {
+			builder.WriteString(strings.ToUpper(string(r)))
+		} else {
+			builder.WriteString(strings.ToLower(string(r)))
+		}
+	}
+	return builder.String()
+}
+
+// transact sends the request with the specified headers to the helper
+// and returns the response along with the response body.
+func transact(
+	ctx context.Context, dialer *netx.Dialer, address string,
+	headers []header, callbacks model.ExperimentCallbacks,
+) (*http.Response, []byte, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("%s / HTTP/1.1\r\n", requestMethod))
+	for _, h := range headers {
+		builder.WriteString(fmt.Sprintf("%s: %s\r\n", h.key, h.value))
+	}
+	builder.WriteString("\r\n")
+	request := builder.String()
+	reader := &countingReader{reader: conn}
+	defer func() {
+		callbacks.OnDataUsage(
+			float64(reader.count)/1024.0, // downloaded
+			float64(len(request))/1024.0, // uploaded
+		)
+	}()
+	if _, err := conn.Write([]byte(request)); err != nil {
+		return nil, nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(reader), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, data, nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	count, err := r.reader.Read(p)
+	r.count += int64(count)
+	return count, err
+}
+
+// analyze compares the headers we sent with the ones that the
+// helper says it has received and returns the tampering.
+func analyze(headers []header, data []byte) (tampering Tampering) {
+	var jsonHeaders JSONHeaders
+	if err := json.Unmarshal(data, &jsonHeaders); err != nil {
+		tampering.Total = true
+		return
+	}
+	if jsonHeaders.HeadersDict == nil || jsonHeaders.RequestLine == "" {
+		tampering.Total = true
+		return
+	}
+	tampering.RequestLineCapitalization = (jsonHeaders.RequestLine !=
+		fmt.Sprintf("%s / HTTP/1.1", requestMethod))
+	sent, received := make(map[string]bool), make(map[string]bool)
+	for _, h := range headers {
+		sent[h.key] = true
+	}
+	for key := range jsonHeaders.HeadersDict {
+		received[key] = true
+	}
+	tampering.HeaderNameDiff = []string{}
+	for key := range sent {
+		if !received[key] {
+			tampering.HeaderNameDiff = append(tampering.HeaderNameDiff, key)
+		}
+	}
+	for key := range received {
+		if !sent[key] && strings.ToLower(key) != "connection" {
+			tampering.HeaderNameDiff = append(tampering.HeaderNameDiff, key)
+		}
+	}
+	sort.Strings(tampering.HeaderNameDiff)
+	tampering.HeaderFieldName = len(tampering.HeaderNameDiff) > 0
+	tampering.Total = tampering.HeaderFieldName || tampering.RequestLineCapitalization
+	return
+}
+
+func setFailure(err error) (s *string) {
+	if err != nil {
+		descr := err.Error()
+		s = &descr
+	}
+	return
+}
@@ -1,18 +1,214 @@
-package hhfm_test
+package hhfm
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"strings"
 	"testing"
 
-	"github.com/ooni/probe-engine/experiment/hhfm"
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return hhfm.NewExperimentMeasurer(hhfm.Config{})
-	})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "http_header_field_manipulation" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitMeasureWithNoAvailableTestHelpers(t *testing.T) {
+	err := runWithHelpers(nil, new(model.Measurement))
+	if err != errNoAvailableTestHelpers {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithInvalidHelperURL(t *testing.T) {
+	err := runWithHelpers([]model.Service{{
+		Address: "https://127.0.0.1",
+		Type:    "legacy",
+	}}, new(model.Measurement))
+	if err != errInvalidHelperURL {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithConnectFailure(t *testing.T) {
+	measurement := new(model.Measurement)
+	err := runWithHelpers([]model.Service{{
+		Address: "http://127.0.0.1:1",
+		Type:    "legacy",
+	}}, measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Failure == nil || *tk.Failure != "connection_refused" {
+		t.Fatal("expected connection_refused failure")
+	}
+	if len(tk.Requests) != 1 || tk.Requests[0].Failure == nil {
+		t.Fatal("expected a failed request entry")
+	}
+}
+
+func TestUnitMeasureWithEchoingHelper(t *testing.T) {
+	address := startEchoHelper(t, func(key string) string { return key })
+	measurement := new(model.Measurement)
+	err := runWithHelpers([]model.Service{{
+		Address: "http://" + address,
+		Type:    "legacy",
+	}}, measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Tampering.Total || tk.Tampering.HeaderFieldName ||
+		tk.Tampering.RequestLineCapitalization {
+		t.Fatal("expected no tampering")
+	}
+}
+
+func TestUnitMeasureWithNormalizingMiddlebox(t *testing.T) {
+	address := startEchoHelper(t, textproto.CanonicalMIMEHeaderKey)
+	measurement := new(model.Measurement)
+	err := runWithHelpers([]model.Service{{
+		Address: "http://" + address,
+		Type:    "legacy",
+	}}, measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	// Note: it is possible, albeit very unlikely, that all random
+	// keys are already canonical, hence check before failing.
+	var canonical int
+	for _, h := range tk.Requests[0].Request.HeadersList {
+		if h.Key == textproto.CanonicalMIMEHeaderKey(h.Key) {
+			canonical++
+		}
+	}
+	if canonical == len(tk.Requests[0].Request.HeadersList) {
+		t.Skip("all keys were already canonical")
+	}
+	if !tk.Tampering.Total || !tk.Tampering.HeaderFieldName {
+		t.Fatal("expected tampering")
+	}
+}
+
+func TestUnitRandomCapitalization(t *testing.T) {
+	gen := rand.New(rand.NewSource(0))
+	out := randomCapitalization(gen, "Accept-Language")
+	if strings.ToLower(out) != "accept-language" {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitAnalyzeInvalidJSON(t *testing.T) {
+	tampering := analyze(nil, []byte("{"))
+	if !tampering.Total {
+		t.Fatal("expected total tampering")
+	}
+}
+
+func TestUnitAnalyzeMissingFields(t *testing.T) {
+	tampering := analyze(nil, []byte("{}"))
+	if !tampering.Total {
+		t.Fatal("expected total tampering")
+	}
+}
+
+func TestUnitAnalyzeRequestLine(t *testing.T) {
+	tampering := analyze([]header{{key: "hOSt", value: "x"}}, []byte(
+		`{"request_line":"get / HTTP/1.1","headers_dict":{"hOSt":["x"]}}`))
+	if !tampering.RequestLineCapitalization || !tampering.Total {
+		t.Fatal("expected request line tampering")
+	}
+	if tampering.HeaderFieldName || len(tampering.HeaderNameDiff) != 0 {
+		t.Fatal("expected no header tampering")
+	}
+}
+
+func TestUnitAnalyzeHeaderNameDiff(t *testing.T) {
+	tampering := analyze([]header{{key: "hOSt", value: "x"}}, []byte(
+		`{"request_line":"GET / HTTP/1.1","headers_dict":{"Host":["x"],"Connection":["close"],"Via":["proxy"]}}`))
+	if !tampering.HeaderFieldName || !tampering.Total {
+		t.Fatal("expected header tampering")
+	}
+	expect := []string{"Host", "Via", "hOSt"}
+	if len(tampering.HeaderNameDiff) != len(expect) {
+		t.Fatal("unexpected diff length")
+	}
+	for idx := range expect {
+		if tampering.HeaderNameDiff[idx] != expect[idx] {
+			t.Fatal("unexpected diff")
+		}
+	}
+}
+
+func runWithHelpers(helpers []model.Service, measurement *model.Measurement) error {
+	m := NewExperimentMeasurer(Config{})
+	return m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"http-return-json-headers": helpers,
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+}
+
+// startEchoHelper starts a fake http-return-json-headers helper that
+// echoes back the received headers after passing each key to transform.
+func startEchoHelper(t *testing.T, transform func(string) string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		out := JSONHeaders{HeadersDict: make(map[string][]string)}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if line == "" {
+				break
+			}
+			if out.RequestLine == "" {
+				out.RequestLine = line
+				continue
+			}
+			v := strings.SplitN(line, ": ", 2)
+			key := transform(v[0])
+			out.HeadersDict[key] = append(out.HeadersDict[key], v[1])
+		}
+		data, _ := json.Marshal(out)
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(data), data)
+	}()
+	return listener.Addr().String()
 }
@@ -1,24 +1,41 @@
 // Package hirl contains the HTTP Invalid Request Line network experiment.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-007-http-invalid-request-line.md
 package hirl
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkhelper"
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 const (
 	testName    = "http_invalid_request_line"
-	testVersion = "0.0.3"
+	testVersion = "0.1.0"
 )
 
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains the experiment test keys.
+type TestKeys struct {
+	FailureList   []*string                        `json:"failure_list"`
+	NetworkEvents oonidatamodel.NetworkEventsList  `json:"network_events"`
+	Received      []oonidatamodel.MaybeBinaryValue `json:"received"`
+	Sent          []string                         `json:"sent"`
+	TamperingList []bool                           `json:"tampering_list"`
+	Tampering     bool                             `json:"tampering"`
 }
 
 type measurer struct {
@@ -33,25 +50,162 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+var (
+	// errNoAvailableTestHelpers is emitted when there are no test helpers.
+	errNoAvailableTestHelpers = errors.New("no available helpers")
+)
+
+// readTimeout is the maximum time we wait for the echo.
+const readTimeout = 5 * time.Second
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"HttpInvalidRequestLine", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	testhelper := findTestHelper(sess)
+	if testhelper == nil {
+		return errNoAvailableTestHelpers
+	}
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": testhelper,
+	}
+	address := testhelper.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "80")
+	}
+	saver := new(handlers.SavingHandler)
+	dialer := netx.NewDialer()
+	dialer.Beginning = measurement.MeasurementStartTimeSaved
+	dialer.Handler = saver
+	var (
+		receivedBytes int64
+		sentBytes     int64
 	)
-	err := mkhelper.Set(sess, "tcp-echo", "legacy", &settings)
-	if err != nil {
-		return err
+	defer func() {
+		callbacks.OnDataUsage(
+			float64(receivedBytes)/1024.0, // downloaded
+			float64(sentBytes)/1024.0,     // uploaded
+		)
+	}()
+	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	requests := newRequestLines(gen)
+	for idx, request := range requests {
+		received, err := echo(ctx, dialer, address, request.line)
+		sentBytes += int64(len(request.line))
+		receivedBytes += int64(len(received))
+		tampering := received != request.line
+		tk.FailureList = append(tk.FailureList, setFailure(err))
+		tk.Received = append(tk.Received, oonidatamodel.MaybeBinaryValue{Value: received})
+		tk.Sent = append(tk.Sent, request.line)
+		tk.TamperingList = append(tk.TamperingList, tampering)
+		tk.Tampering = tk.Tampering || tampering
+		callbacks.OnProgress(float64(idx+1)/float64(len(requests)), fmt.Sprintf(
+			"hirl: %s: tampering: %+v; %s", request.name, tampering, errString(err),
+		))
+	}
+	var events []*modelx.Measurement
+	for _, ev := range saver.Read() {
+		ev := ev
+		events = append(events, &ev)
 	}
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	tk.NetworkEvents = oonidatamodel.NewNetworkEventsList(
+		oonitemplates.Results{NetworkEvents: events},
 	)
+	sess.Logger().Infof("hirl: tampering: %+v", tk.Tampering)
+	return nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func findTestHelper(sess model.ExperimentSession) *model.Service {
+	testhelpers, _ := sess.GetTestHelpersByName("tcp-echo")
+	for _, th := range testhelpers {
+		if th.Type == "legacy" {
+			return &th
+		}
+	}
+	return nil
+}
+
+// requestLine is a malformed request line to send.
+type requestLine struct {
+	name string
+	line string
+}
+
+// newRequestLines returns the malformed request lines to send.
+func newRequestLines(gen *rand.Rand) []requestLine {
+	return []requestLine{{
+		name: "random_invalid_method",
+		line: randomString(gen, 4) + " / HTTP/1.1\n\r",
+	}, {
+		name: "random_invalid_field_count",
+		line: fmt.Sprintf(
+			"%s %s %s %s\r\n", randomString(gen, 5), randomString(gen, 5),
+			randomString(gen, 5), randomString(gen, 5),
+		),
+	}, {
+		name: "random_big_request_method",
+		line: randomString(gen, 1024) + " / HTTP/1.1\n\r",
+	}, {
+		name: "random_invalid_version_number",
+		line: fmt.Sprintf("GET / HTTP/%d.%d\n\r", gen.Int63(), gen.Int63()),
+	}, {
+		name: "squid_cache_manager",
+		line: "GET cache_object://localhost/ HTTP/1.0\n\r",
+	}}
+}
+
+const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// randomString returns a random uppercase string of the given length.
+func randomString(gen *rand.Rand, length int) string {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = letters[gen.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// echo sends data to the helper and returns what it echoed back.
+func echo(
+	ctx context.Context, dialer *netx.Dialer, address, data string,
+) (string, error) {
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(data)); err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(readTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	buffer := make([]byte, len(data))
+	count, err := io.ReadFull(conn, buffer)
+	return string(buffer[:count]), err
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
+
+func setFailure(err error) (s *string) {
+	if err != nil {
+		descr := err.Error()
+		s = &descr
+	}
+	return
+}
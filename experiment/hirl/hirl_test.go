@@ -1,18 +1,143 @@
-package hirl_test
+package hirl
 
 import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
 	"testing"
 
-	"github.com/ooni/probe-engine/experiment/hirl"
-	"github.com/ooni/probe-engine/experiment/mktesting"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return hirl.NewExperimentMeasurer(hirl.Config{})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "http_invalid_request_line" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitMeasureWithNoAvailableTestHelpers(t *testing.T) {
+	err := runWithHelpers(nil, new(model.Measurement))
+	if err != errNoAvailableTestHelpers {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithEchoServer(t *testing.T) {
+	address := startServer(t, func(conn net.Conn) {
+		io.Copy(conn, conn)
+	})
+	measurement := new(model.Measurement)
+	err := runWithHelpers([]model.Service{{
+		Address: address,
+		Type:    "legacy",
+	}}, measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Tampering {
+		t.Fatal("expected no tampering")
+	}
+	if len(tk.Sent) != 5 || len(tk.Received) != 5 || len(tk.TamperingList) != 5 {
+		t.Fatal("unexpected number of entries")
+	}
+	for _, failure := range tk.FailureList {
+		if failure != nil {
+			t.Fatal(*failure)
+		}
+	}
+	if len(tk.NetworkEvents) <= 0 {
+		t.Fatal("expected network events")
+	}
+}
+
+func TestUnitMeasureWithNormalizingServer(t *testing.T) {
+	address := startServer(t, func(conn net.Conn) {
+		buffer := make([]byte, 4096)
+		count, _ := conn.Read(buffer)
+		conn.Write([]byte(strings.ToLower(string(buffer[:count]))))
 	})
+	measurement := new(model.Measurement)
+	err := runWithHelpers([]model.Service{{
+		Address: address,
+		Type:    "legacy",
+	}}, measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if !tk.Tampering {
+		t.Fatal("expected tampering")
+	}
+}
+
+func TestUnitMeasureWithConnectFailure(t *testing.T) {
+	measurement := new(model.Measurement)
+	err := runWithHelpers([]model.Service{{
+		Address: "127.0.0.1:1",
+		Type:    "legacy",
+	}}, measurement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	for _, failure := range tk.FailureList {
+		if failure == nil || *failure != "connection_refused" {
+			t.Fatal("expected connection_refused")
+		}
+	}
+	if !tk.Tampering {
+		t.Fatal("expected tampering")
+	}
+}
+
+func TestUnitRandomString(t *testing.T) {
+	gen := rand.New(rand.NewSource(0))
+	out := randomString(gen, 32)
+	if len(out) != 32 || strings.ToUpper(out) != out {
+		t.Fatal("unexpected random string")
+	}
+}
+
+func runWithHelpers(helpers []model.Service, measurement *model.Measurement) error {
+	m := NewExperimentMeasurer(Config{})
+	return m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"tcp-echo": helpers,
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+}
+
+func startServer(t *testing.T, serve func(net.Conn)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
 	}
+	go func() {
+		defer listener.Close()
+		for i := 0; i < 5; i++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			serve(conn)
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
 }
@@ -0,0 +1,133 @@
+// Package meekfronted contains the meek fronted requests network experiment. This
+// file in particular is a pure-Go implementation of that.
+//
+// The input is a front:inside pair. We connect to the front domain using
+// the front domain as SNI, then we send a request whose Host header is the
+// inside domain. If domain fronting works, the front forwards the request
+// to the inside domain (i.e., the meek server), which replies with a
+// well known body.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-014-meek-fronted-requests.md.
+package meekfronted
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/ooni/probe-engine/experiment/httpheader"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	testName    = "meek_fronted_requests"
+	testVersion = "0.1.0"
+)
+
+// DefaultExpectedBody is the body returned by meek servers.
+const DefaultExpectedBody = "I’m just a happy little web server.\n"
+
+var (
+	errNoInput      = errors.New("meek_fronted_requests: no input provided")
+	errInvalidInput = errors.New("meek_fronted_requests: input is not front:inside")
+
+	// errUnexpectedBody is used as failure when the body is not the expected one.
+	errUnexpectedBody = errors.New("meek_fronted_requests_unexpected_body")
+)
+
+// Config contains the experiment config.
+type Config struct {
+	ExpectedBody string `ooni:"Body that we expect from the inside domain"`
+}
+
+// TestKeys contains meek_fronted_requests test keys.
+type TestKeys struct {
+	Agent         string                          `json:"agent"`
+	Failure       *string                         `json:"failure"`
+	Front         string                          `json:"front"`
+	Inside        string                          `json:"inside"`
+	Queries       oonidatamodel.DNSQueriesList    `json:"queries"`
+	Requests      oonidatamodel.RequestList       `json:"requests"`
+	Success       bool                            `json:"success"`
+	TCPConnect    oonidatamodel.TCPConnectList    `json:"tcp_connect"`
+	TLSHandshakes oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
+}
+
+// parseInput splits the input into the front and the inside domains.
+func parseInput(input string) (front, inside string, err error) {
+	v := strings.SplitN(input, ":", 2)
+	if len(v) != 2 || v[0] == "" || v[1] == "" {
+		return "", "", errInvalidInput
+	}
+	return v[0], v[1], nil
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := &TestKeys{Agent: "agent"}
+	measurement.TestKeys = tk
+	if measurement.Input == "" {
+		return errNoInput
+	}
+	front, inside, err := parseInput(measurement.Input)
+	if err != nil {
+		return err
+	}
+	tk.Front, tk.Inside = front, inside
+	expectedBody := m.config.ExpectedBody
+	if expectedBody == "" {
+		expectedBody = DefaultExpectedBody
+	}
+	results := oonitemplates.HTTPDo(ctx, oonitemplates.HTTPDoConfig{
+		Accept:         httpheader.RandomAccept(),
+		AcceptLanguage: httpheader.RandomAcceptLanguage(),
+		Beginning:      measurement.MeasurementStartTimeSaved,
+		Handler:        netxlogger.NewHandler(sess.Logger()),
+		Host:           inside,
+		Method:         "GET",
+		SNI:            front,
+		URL:            "https://" + front + "/",
+		UserAgent:      httpheader.RandomUserAgent(),
+	})
+	tk.Queries = oonidatamodel.NewDNSQueriesList(results.TestKeys)
+	tk.Requests = oonidatamodel.NewRequestList(results.TestKeys)
+	tk.TCPConnect = oonidatamodel.NewTCPConnectList(results.TestKeys)
+	tk.TLSHandshakes = oonidatamodel.NewTLSHandshakesList(results.TestKeys)
+	callbacks.OnDataUsage(
+		float64(results.TestKeys.ReceivedBytes)/1024.0, // downloaded
+		float64(results.TestKeys.SentBytes)/1024.0,     // uploaded
+	)
+	err = results.Error
+	if err == nil && (results.StatusCode != 200 || string(results.BodySnap) != expectedBody) {
+		err = errUnexpectedBody
+	}
+	if err != nil {
+		s := err.Error()
+		tk.Failure = &s
+		return nil
+	}
+	tk.Success = true
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
@@ -0,0 +1,107 @@
+package meekfronted
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "meek_fronted_requests" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func run(config Config, input string) (*TestKeys, error) {
+	measurement := &model.Measurement{Input: input}
+	m := NewExperimentMeasurer(config)
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*TestKeys), err
+}
+
+func TestUnitRunNoInput(t *testing.T) {
+	_, err := run(Config{}, "")
+	if !errors.Is(err, errNoInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunInvalidInput(t *testing.T) {
+	_, err := run(Config{}, "ajax.aspnetcdn.com")
+	if !errors.Is(err, errInvalidInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestIntegrationRunDNSFailure(t *testing.T) {
+	tk, err := run(Config{}, "front.antani.invalid:inside.antani.invalid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Front != "front.antani.invalid" || tk.Inside != "inside.antani.invalid" {
+		t.Fatal("unexpected front or inside")
+	}
+	if tk.Success {
+		t.Fatal("unexpected success")
+	}
+	if tk.Failure == nil || *tk.Failure != modelx.FailureDNSNXDOMAINError {
+		t.Fatal("not the failure we expected")
+	}
+	if len(tk.Queries) <= 0 {
+		t.Fatal("expected some queries here")
+	}
+}
+
+func TestIntegrationRunUnexpectedBody(t *testing.T) {
+	tk, err := run(Config{}, "www.google.com:www.google.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Success {
+		t.Fatal("unexpected success")
+	}
+	if tk.Failure == nil || *tk.Failure != errUnexpectedBody.Error() {
+		t.Fatal("not the failure we expected")
+	}
+	if len(tk.Requests) != 1 || len(tk.TLSHandshakes) != 1 {
+		t.Fatal("unexpected number of requests or handshakes")
+	}
+}
+
+func TestUnitParseInput(t *testing.T) {
+	var inputs = []struct {
+		input  string
+		front  string
+		inside string
+		err    error
+	}{
+		{"ajax.aspnetcdn.com:az668014.vo.msecnd.net", "ajax.aspnetcdn.com", "az668014.vo.msecnd.net", nil},
+		{"ajax.aspnetcdn.com", "", "", errInvalidInput},
+		{":az668014.vo.msecnd.net", "", "", errInvalidInput},
+		{"ajax.aspnetcdn.com:", "", "", errInvalidInput},
+	}
+	for _, input := range inputs {
+		front, inside, err := parseInput(input.input)
+		if !errors.Is(err, input.err) {
+			t.Fatalf("%s: not the error we expected", input.input)
+		}
+		if front != input.front || inside != input.inside {
+			t.Fatalf("%s: unexpected front or inside", input.input)
+		}
+	}
+}
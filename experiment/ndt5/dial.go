@@ -0,0 +1,130 @@
+package ndt5
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Modes in which we can run ndt5.
+const (
+	modeRaw = "raw"
+	modeWS  = "ws"
+	modeWSS = "wss"
+)
+
+// errInvalidMode indicates that the configured mode is not valid.
+var errInvalidMode = errors.New("ndt5: invalid mode")
+
+// stream is a connection used either for control or for a subtest.
+type stream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+}
+
+type dialManager struct {
+	hostname  string
+	mode      string
+	port      string
+	tlsConfig *tls.Config
+}
+
+func newDialManager(hostname, mode string) (dialManager, error) {
+	switch mode {
+	case "":
+		mode = modeWSS
+	case modeRaw, modeWS, modeWSS:
+	default:
+		return dialManager{}, errInvalidMode
+	}
+	ports := map[string]string{modeRaw: "3001", modeWS: "3002", modeWSS: "3010"}
+	return dialManager{hostname: hostname, mode: mode, port: ports[mode]}, nil
+}
+
+// dialControl establishes the control connection.
+func (mgr dialManager) dialControl(ctx context.Context) (stream, error) {
+	return mgr.dial(ctx, mgr.port, "ndt")
+}
+
+// dialTest establishes a connection for the specified subtest.
+func (mgr dialManager) dialTest(ctx context.Context, port, subprotocol string) (stream, error) {
+	return mgr.dial(ctx, port, subprotocol)
+}
+
+func (mgr dialManager) dial(ctx context.Context, port, subprotocol string) (stream, error) {
+	address := net.JoinHostPort(mgr.hostname, port)
+	if mgr.mode == modeRaw {
+		return new(net.Dialer).DialContext(ctx, "tcp", address)
+	}
+	dialer := websocket.Dialer{
+		ReadBufferSize:  1 << 17,
+		TLSClientConfig: mgr.tlsConfig,
+		WriteBufferSize: 1 << 17,
+	}
+	URL := url.URL{Scheme: mgr.mode, Host: address, Path: "/ndt_protocol"}
+	headers := http.Header{}
+	headers.Add("Sec-WebSocket-Protocol", subprotocol)
+	conn, _, err := dialer.DialContext(ctx, URL.String(), headers)
+	if err != nil {
+		return nil, err
+	}
+	return &wsStream{conn: conn}, nil
+}
+
+// wsStream adapts a websocket connection to the stream interface
+// such that we can use the same code for all modes. Each Write
+// becomes a binary message, while Read reads the content of the
+// messages sequentially as if they were part of a stream.
+type wsStream struct {
+	conn   *websocket.Conn
+	reader io.Reader
+}
+
+func (ws *wsStream) Read(p []byte) (int, error) {
+	for {
+		if ws.reader == nil {
+			_, reader, err := ws.conn.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return 0, io.EOF
+			}
+			if err != nil {
+				return 0, err
+			}
+			ws.reader = reader
+		}
+		count, err := ws.reader.Read(p)
+		if err == io.EOF {
+			ws.reader = nil
+			if count <= 0 {
+				continue
+			}
+			err = nil
+		}
+		return count, err
+	}
+}
+
+func (ws *wsStream) Write(p []byte) (int, error) {
+	if err := ws.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ws *wsStream) Close() error {
+	return ws.conn.Close()
+}
+
+func (ws *wsStream) SetDeadline(t time.Time) error {
+	if err := ws.conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return ws.conn.SetWriteDeadline(t)
+}
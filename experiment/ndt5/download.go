@@ -0,0 +1,124 @@
+package ndt5
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestS2C contains the results of the download subtest.
+type TestS2C struct {
+	ClientSpeed float64           `json:"client_speed"` // [kbit/s]
+	ServerSpeed float64           `json:"server_speed"` // [kbit/s]
+	Web100      map[string]string `json:"web100_data"`
+}
+
+// s2cResults is the message with which the server tells us
+// how it measured the download subtest.
+type s2cResults struct {
+	Msg              string `json:"msg"`
+	ThroughputValue  string `json:"ThroughputValue"`
+	UnsentDataAmount string `json:"UnsentDataAmount"`
+	TotalSentByte    string `json:"TotalSentByte"`
+}
+
+// serverSpeed returns the speed measured by the server in kbit/s. Old
+// servers use the msg field to send "speed unsent total".
+func (r s2cResults) serverSpeed() (float64, error) {
+	value := r.ThroughputValue
+	if value == "" {
+		value = strings.SplitN(r.Msg, " ", 2)[0]
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// runS2C runs the download subtest.
+func (c *client) runS2C(ctx context.Context) (*TestS2C, error) {
+	port, err := c.proto.expect(msgTestPrepare)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dialer.dialTest(ctx, port, "s2c")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := c.proto.expect(msgTestStart); err != nil {
+		return nil, err
+	}
+	result := &TestS2C{Web100: make(map[string]string)}
+	elapsed, count, err := c.download(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	c.received += count
+	if elapsed > 0 {
+		result.ClientSpeed = float64(count) * 8.0 / elapsed.Seconds() / 1e03
+	}
+	kind, body, err := c.proto.readRaw()
+	if err != nil {
+		return nil, err
+	}
+	if kind != msgTestMsg {
+		return nil, fmt.Errorf("%w: expected %d, got %d", errUnexpectedMessage, msgTestMsg, kind)
+	}
+	var results s2cResults
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, err
+	}
+	if result.ServerSpeed, err = results.serverSpeed(); err != nil {
+		return nil, err
+	}
+	err = c.proto.write(msgTestMsg, strconv.FormatFloat(result.ClientSpeed, 'f', -1, 64))
+	if err != nil {
+		return nil, err
+	}
+	for {
+		kind, msg, err := c.proto.read()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case msgTestMsg:
+			parseWeb100(msg, result.Web100)
+		case msgTestFinalize:
+			return result, nil
+		default:
+			return nil, fmt.Errorf("%w: %d during s2c", errUnexpectedMessage, kind)
+		}
+	}
+}
+
+// download reads from conn until EOF, returning the elapsed
+// time and the number of bytes that we have received.
+func (c *client) download(ctx context.Context, conn stream) (time.Duration, int64, error) {
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(c.downloadRuntime)); err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	buffer := make([]byte, paramBufferSize)
+	ticker := time.NewTicker(paramMeasureInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		count, err := conn.Read(buffer)
+		total += int64(count)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		select {
+		case now := <-ticker.C:
+			c.onPerformance("download", now.Sub(start), total)
+		default:
+			// NOTHING
+		}
+	}
+	return time.Since(start), total, nil
+}
@@ -1,29 +1,72 @@
-// Package ndt contains the ndt network experiment.
+// Package ndt5 contains the ndt5 network experiment. This file
+// in particular is a pure-Go implementation of that.
+//
+// See https://github.com/ndt-project/ndt/wiki/NDTProtocol.
 package ndt5
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/dustin/go-humanize"
+	"github.com/ooni/probe-engine/experiment/ndt7"
+	"github.com/ooni/probe-engine/internal/mlablocate"
 	"github.com/ooni/probe-engine/model"
 )
 
 const (
 	testName    = "ndt"
-	testVersion = "0.1.0"
+	testVersion = "0.2.0"
 )
 
 // Config contains the experiment config.
 type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+	Mode string `ooni:"Protocol variant to use: raw, ws or wss (default: wss)"`
+}
+
+// Summary is the measurement summary. It is the same data structure
+// used by ndt7, so that apps can show both results in the same way.
+type Summary = ndt7.Summary
+
+// TestKeys contains the test keys
+type TestKeys struct {
+	// Failure is the failure string
+	Failure *string `json:"failure"`
+
+	// Mode is the protocol variant we have used
+	Mode string `json:"mode"`
+
+	// Server is the server we have used
+	Server string `json:"server"`
+
+	// Summary contains the measurement summary
+	Summary Summary `json:"summary"`
+
+	// TestC2S contains the upload results
+	TestC2S []*TestC2S `json:"test_c2s"`
+
+	// TestS2C contains the download results
+	TestS2C []*TestS2C `json:"test_s2c"`
+
+	// Web100 contains the variables sent by the server at the end
+	Web100 map[string]string `json:"web100_data"`
 }
 
 type measurer struct {
 	config Config
 }
 
+func (m *measurer) discover(ctx context.Context, sess model.ExperimentSession) (string, error) {
+	client := mlablocate.NewClient(sess.DefaultHTTPClient(), sess.Logger(), sess.UserAgent())
+	if sess.ExplicitProxy() {
+		client.NewRequest = mlablocate.NewRequestWithProxy(sess.ProbeIP())
+	}
+	return client.Query(ctx, "ndt")
+}
+
 func (m *measurer) ExperimentName() string {
 	return testName
 }
@@ -32,21 +75,158 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+// client runs a single ndt5 session.
+type client struct {
+	callbacks       model.ExperimentCallbacks
+	dialer          dialManager
+	downloadRuntime time.Duration
+	phase           int
+	phases          int
+	proto           protocol
+	received        int64
+	sent            int64
+	uploadRuntime   time.Duration
+}
+
+func newClient(dialer dialManager, callbacks model.ExperimentCallbacks) *client {
+	return &client{
+		callbacks:       callbacks,
+		dialer:          dialer,
+		downloadRuntime: paramDownloadRuntime,
+		uploadRuntime:   paramUploadRuntime,
+	}
+}
+
+// onPerformance emits the progress during a subtest.
+func (c *client) onPerformance(test string, elapsed time.Duration, count int64) {
+	seconds := elapsed.Seconds()
+	percentage := (float64(c.phase) + seconds/paramMaxRuntimeUpperBound) / float64(c.phases)
+	speed := float64(count) * 8.0 / seconds
+	c.callbacks.OnProgress(percentage, fmt.Sprintf(
+		"%s-speed %s", test, humanize.SI(float64(speed), "bit/s"),
+	))
+}
+
+// run runs the ndt5 protocol filling the test keys.
+func (c *client) run(ctx context.Context, tk *TestKeys) error {
+	conn, err := c.dialer.dialControl(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan interface{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close() // interrupt pending I/O
+		case <-done:
+		}
+	}()
+	if err := conn.SetDeadline(time.Now().Add(paramControlRuntime)); err != nil {
+		return err
+	}
+	c.proto = protocol{stream: conn}
+	if err := c.proto.login(testC2S | testS2C | testStatus); err != nil {
+		return err
+	}
+	if c.dialer.mode == modeRaw {
+		if err := c.proto.kickoff(); err != nil {
+			return err
+		}
+	}
+	if err := c.proto.waitInQueue(); err != nil {
+		return err
+	}
+	if _, err := c.proto.expect(msgLogin); err != nil { // server version
+		return err
+	}
+	tests, err := c.proto.expect(msgLogin)
+	if err != nil {
+		return err
+	}
+	ids := strings.Fields(tests)
+	c.phases = len(ids)
+	for idx, id := range ids {
+		c.phase = idx
+		switch id {
+		case strconv.Itoa(testC2S):
+			result, err := c.runC2S(ctx)
+			if err != nil {
+				return err
+			}
+			tk.TestC2S = append(tk.TestC2S, result)
+			tk.Summary.Upload = result.ServerSpeed
+		case strconv.Itoa(testS2C):
+			result, err := c.runS2C(ctx)
+			if err != nil {
+				return err
+			}
+			tk.TestS2C = append(tk.TestS2C, result)
+			tk.Summary.Download = result.ClientSpeed
+			summarizeWeb100(result.Web100, &tk.Summary)
+		default:
+			return fmt.Errorf("ndt5: unsupported test: %s", id)
+		}
+	}
+	for {
+		kind, msg, err := c.proto.read()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case msgResults:
+			parseWeb100(msg, tk.Web100)
+		case msgLogout:
+			return nil
+		default:
+			return fmt.Errorf("%w: %d while reading results", errUnexpectedMessage, kind)
+		}
+	}
+}
+
 func (m *measurer) Run(
 	ctx context.Context, sess model.ExperimentSession,
 	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"Ndt", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	tk := &TestKeys{Web100: make(map[string]string)}
+	measurement.TestKeys = tk
+	dialer, err := newDialManager("", m.config.Mode)
+	if err != nil {
+		tk.Failure = failureFromError(err)
+		return err
+	}
+	tk.Mode = dialer.mode
+	dialer.hostname, err = m.discover(ctx, sess)
+	if err != nil {
+		tk.Failure = failureFromError(err)
+		return err
+	}
+	tk.Server = dialer.hostname
+	callbacks.OnProgress(0, fmt.Sprintf("server: %s (%s)", dialer.hostname, dialer.mode))
+	c := newClient(dialer, callbacks)
+	err = c.run(ctx, tk)
+	callbacks.OnDataUsage(
+		float64(c.received)/1024.0, // downloaded
+		float64(c.sent)/1024.0,     // uploaded
 	)
+	if err != nil {
+		tk.Failure = failureFromError(err)
+		return err
+	}
+	callbacks.OnProgress(1, "done")
+	return nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func failureFromError(err error) (failure *string) {
+	if err != nil {
+		s := err.Error()
+		failure = &s
+	}
+	return
+}
@@ -1,18 +1,240 @@
-package ndt5_test
+package ndt5
 
 import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mktesting"
-	"github.com/ooni/probe-engine/experiment/ndt5"
+	"github.com/apex/log"
+	"github.com/gorilla/websocket"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return ndt5.NewExperimentMeasurer(ndt5.Config{})
-	})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "ndt" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.2.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitRunWithInvalidMode(t *testing.T) {
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(Config{Mode: "antani"})
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != errInvalidMode {
+		t.Fatal("not the error we expected")
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Failure == nil || *tk.Failure != errInvalidMode.Error() {
+		t.Fatal("expected failure to be set")
+	}
+}
+
+func TestUnitRunWithCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // fail immediately
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(Config{})
+	err := m.Run(
+		ctx,
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestIntegrationRun(t *testing.T) {
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(Config{})
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnitClientRaw(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		serveFake(t, conn, true, func() (string, func() (stream, error)) {
+			testListener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				return "", func() (stream, error) { return nil, err }
+			}
+			_, port, _ := net.SplitHostPort(testListener.Addr().String())
+			return port, func() (stream, error) {
+				defer testListener.Close()
+				return testListener.Accept()
+			}
+		})
+	}()
+	checkClient(t, modeRaw, listener.Addr().String())
+}
+
+func TestUnitClientWebSocket(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: []string{"ndt", "c2s", "s2c"}}
+	tests := make(chan *wsStream)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		ws := &wsStream{conn: conn}
+		if websocket.Subprotocols(r)[0] != "ndt" {
+			tests <- ws
+			return
+		}
+		defer conn.Close()
+		URL, _ := url.Parse(server.URL)
+		serveFake(t, ws, false, func() (string, func() (stream, error)) {
+			return URL.Port(), func() (stream, error) {
+				return <-tests, nil
+			}
+		})
+	}))
+	defer server.Close()
+	URL, _ := url.Parse(server.URL)
+	checkClient(t, modeWS, URL.Host)
+}
+
+func checkClient(t *testing.T, mode, address string) {
+	host, port, _ := net.SplitHostPort(address)
+	dialer, err := newDialManager(host, mode)
 	if err != nil {
 		t.Fatal(err)
 	}
+	dialer.port = port
+	c := newClient(dialer, handler.NewPrinterCallbacks(log.Log))
+	c.uploadRuntime = 500 * time.Millisecond
+	tk := &TestKeys{Web100: make(map[string]string)}
+	if err := c.run(context.Background(), tk); err != nil {
+		t.Fatal(err)
+	}
+	if len(tk.TestC2S) != 1 || len(tk.TestS2C) != 1 {
+		t.Fatal("unexpected number of subtests")
+	}
+	if tk.Summary.Upload != 1234.5 || tk.TestS2C[0].ServerSpeed != 5678.9 {
+		t.Fatal("unexpected server speed")
+	}
+	if tk.Summary.Download <= 0 || tk.Summary.Download != tk.TestS2C[0].ClientSpeed {
+		t.Fatal("unexpected download speed")
+	}
+	if tk.Summary.AvgRTT != 20 || tk.Summary.MinRTT != 10 || tk.Summary.MaxRTT != 30 {
+		t.Fatal("unexpected RTT")
+	}
+	if tk.Summary.MSS != 1448 || tk.Summary.RetransmitRate != 0.02 {
+		t.Fatal("unexpected MSS or retransmit rate")
+	}
+	if tk.Web100["Foo"] != "bar" {
+		t.Fatal("unexpected web100 results")
+	}
+	if c.sent <= 0 || c.received != 1<<20 {
+		t.Fatal("unexpected data usage")
+	}
+}
+
+// serveFake implements the server side of a successful ndt5 session.
+func serveFake(
+	t *testing.T, conn stream, raw bool, listen func() (string, func() (stream, error)),
+) {
+	proto := protocol{stream: conn}
+	kind, _, err := proto.readRaw()
+	if err != nil || kind != msgExtendedLogin {
+		t.Error("expected extended login")
+		return
+	}
+	if raw {
+		conn.Write([]byte(kickoffMessage))
+	}
+	proto.write(msgSrvQueue, "1")
+	proto.write(msgSrvQueue, srvQueueHeartbeat)
+	if _, err := proto.expect(msgWaiting); err != nil {
+		t.Error(err)
+		return
+	}
+	proto.write(msgSrvQueue, srvQueueGo)
+	proto.write(msgLogin, "v3.7.0-fake")
+	proto.write(msgLogin, "2 4")
+	// c2s
+	port, accept := listen()
+	proto.write(msgTestPrepare, port)
+	c2s, err := accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	proto.write(msgTestStart, "")
+	io.Copy(ioutil.Discard, c2s)
+	c2s.Close()
+	proto.write(msgTestMsg, "1234.5")
+	proto.write(msgTestFinalize, "")
+	// s2c
+	port, accept = listen()
+	proto.write(msgTestPrepare, port)
+	s2c, err := accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	proto.write(msgTestStart, "")
+	data := make([]byte, 1<<16)
+	for i := 0; i < 16; i++ {
+		s2c.Write(data)
+	}
+	if ws, ok := s2c.(*wsStream); ok {
+		ws.conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}
+	s2c.Close()
+	proto.writeRaw(msgTestMsg, []byte(
+		`{"ThroughputValue":"5678.9","UnsentDataAmount":"0","TotalSentByte":"1048576"}`))
+	if _, err := proto.expect(msgTestMsg); err != nil {
+		t.Error(err)
+		return
+	}
+	proto.write(msgTestMsg, "MinRTT: 10\nMaxRTT: 30\n")
+	proto.write(msgTestMsg, "SumRTT: 200\nCountRTT: 10\nCurMSS: 1448\n")
+	proto.write(msgTestMsg, "DataBytesOut: 100000\nBytesRetrans: 2000\n")
+	proto.write(msgTestFinalize, "")
+	proto.write(msgResults, "Foo: bar\n")
+	proto.write(msgLogout, "")
 }
@@ -0,0 +1,12 @@
+package ndt5
+
+import "time"
+
+const (
+	paramBufferSize           = 1 << 13
+	paramDownloadRuntime      = 15 * time.Second
+	paramMeasureInterval      = 250 * time.Millisecond
+	paramUploadRuntime        = 10 * time.Second
+	paramControlRuntime       = 60 * time.Second
+	paramMaxRuntimeUpperBound = 30.0 // seconds
+)
@@ -0,0 +1,174 @@
+package ndt5
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Message types defined by the NDT protocol.
+const (
+	msgCommFailure   byte = 0
+	msgSrvQueue      byte = 1
+	msgLogin         byte = 2
+	msgTestPrepare   byte = 3
+	msgTestStart     byte = 4
+	msgTestMsg       byte = 5
+	msgTestFinalize  byte = 6
+	msgError         byte = 7
+	msgResults       byte = 8
+	msgLogout        byte = 9
+	msgWaiting       byte = 10
+	msgExtendedLogin byte = 11
+)
+
+// Test IDs defined by the NDT protocol.
+const (
+	testC2S    = 2
+	testS2C    = 4
+	testStatus = 16
+)
+
+// Special SRV_QUEUE values defined by the NDT protocol.
+const (
+	srvQueueGo         = "0"
+	srvQueueHeartbeat  = "9990"
+	srvQueueServerBusy = "9988"
+	srvQueueServerFail = "9977"
+)
+
+// kickoffMessage is sent by the server in raw mode after login.
+const kickoffMessage = "123456 654321"
+
+// clientVersion is the version we claim to speak.
+const clientVersion = "v3.7.0"
+
+var (
+	// errUnexpectedMessage indicates we received a message
+	// with a type different from the one we expected.
+	errUnexpectedMessage = errors.New("ndt5: unexpected message type")
+
+	// errInvalidKickoff indicates that the kickoff message is wrong.
+	errInvalidKickoff = errors.New("ndt5: invalid kickoff message")
+
+	// errServerBusy indicates that the server is busy.
+	errServerBusy = errors.New("ndt5: server busy")
+)
+
+// protocol speaks the NDT control protocol over a stream.
+type protocol struct {
+	stream io.ReadWriter
+}
+
+// readRaw reads a single message and returns its type and body.
+func (p protocol) readRaw() (byte, []byte, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(p.stream, header); err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(p.stream, body); err != nil {
+		return 0, nil, err
+	}
+	return header[0], body, nil
+}
+
+// writeRaw writes a single message with the given type and body.
+func (p protocol) writeRaw(kind byte, body []byte) error {
+	if len(body) > 0xffff {
+		return errors.New("ndt5: message body too large")
+	}
+	message := make([]byte, 3, 3+len(body))
+	message[0] = kind
+	binary.BigEndian.PutUint16(message[1:], uint16(len(body)))
+	message = append(message, body...)
+	_, err := p.stream.Write(message)
+	return err
+}
+
+type jsonMessage struct {
+	Msg   string `json:"msg"`
+	Tests string `json:"tests,omitempty"`
+}
+
+// read reads a JSON message and returns its type and msg field.
+func (p protocol) read() (byte, string, error) {
+	kind, body, err := p.readRaw()
+	if err != nil {
+		return 0, "", err
+	}
+	var m jsonMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return 0, "", err
+	}
+	return kind, m.Msg, nil
+}
+
+// expect reads a JSON message of the specified type and returns its msg field.
+func (p protocol) expect(kind byte) (string, error) {
+	got, msg, err := p.read()
+	if err != nil {
+		return "", err
+	}
+	if got != kind {
+		return "", fmt.Errorf("%w: expected %d, got %d", errUnexpectedMessage, kind, got)
+	}
+	return msg, nil
+}
+
+// write writes a JSON message of the specified type.
+func (p protocol) write(kind byte, msg string) error {
+	data, err := json.Marshal(jsonMessage{Msg: msg})
+	if err != nil {
+		return err
+	}
+	return p.writeRaw(kind, data)
+}
+
+// login sends the extended login requesting the specified tests.
+func (p protocol) login(tests int) error {
+	data, err := json.Marshal(jsonMessage{
+		Msg:   clientVersion,
+		Tests: fmt.Sprintf("%d", tests),
+	})
+	if err != nil {
+		return err
+	}
+	return p.writeRaw(msgExtendedLogin, data)
+}
+
+// kickoff reads the kickoff message sent by the server in raw mode.
+func (p protocol) kickoff() error {
+	data := make([]byte, len(kickoffMessage))
+	if _, err := io.ReadFull(p.stream, data); err != nil {
+		return err
+	}
+	if string(data) != kickoffMessage {
+		return errInvalidKickoff
+	}
+	return nil
+}
+
+// waitInQueue waits until the server allows us to proceed.
+func (p protocol) waitInQueue() error {
+	for {
+		msg, err := p.expect(msgSrvQueue)
+		if err != nil {
+			return err
+		}
+		switch msg {
+		case srvQueueGo:
+			return nil
+		case srvQueueHeartbeat:
+			if err := p.write(msgWaiting, ""); err != nil {
+				return err
+			}
+		case srvQueueServerBusy, srvQueueServerFail:
+			return errServerBusy
+		}
+		// Otherwise the message is the number of minutes we should
+		// expect to wait in queue and we just continue waiting.
+	}
+}
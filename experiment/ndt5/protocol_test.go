@@ -0,0 +1,59 @@
+package ndt5
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestUnitProtocolRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	proto := protocol{stream: &buffer}
+	if err := proto.write(msgTestMsg, "antani"); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := proto.expect(msgTestMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg != "antani" {
+		t.Fatal("unexpected msg")
+	}
+}
+
+func TestUnitProtocolUnexpectedMessage(t *testing.T) {
+	var buffer bytes.Buffer
+	proto := protocol{stream: &buffer}
+	if err := proto.write(msgTestMsg, "antani"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := proto.expect(msgTestStart)
+	if !errors.Is(err, errUnexpectedMessage) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitProtocolBodyTooLarge(t *testing.T) {
+	var buffer bytes.Buffer
+	proto := protocol{stream: &buffer}
+	if err := proto.writeRaw(msgTestMsg, make([]byte, 1<<16)); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitProtocolInvalidKickoff(t *testing.T) {
+	proto := protocol{stream: bytes.NewBufferString("654321 123456")}
+	if err := proto.kickoff(); err != errInvalidKickoff {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitProtocolServerBusy(t *testing.T) {
+	var buffer bytes.Buffer
+	proto := protocol{stream: &buffer}
+	proto.write(msgSrvQueue, "3")
+	proto.write(msgSrvQueue, srvQueueServerBusy)
+	if err := proto.waitInQueue(); err != errServerBusy {
+		t.Fatal("not the error we expected")
+	}
+}
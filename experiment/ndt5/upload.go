@@ -0,0 +1,77 @@
+package ndt5
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// TestC2S contains the results of the upload subtest.
+type TestC2S struct {
+	ClientSpeed float64 `json:"client_speed"` // [kbit/s]
+	ServerSpeed float64 `json:"server_speed"` // [kbit/s]
+}
+
+// runC2S runs the upload subtest.
+func (c *client) runC2S(ctx context.Context) (*TestC2S, error) {
+	port, err := c.proto.expect(msgTestPrepare)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dialer.dialTest(ctx, port, "c2s")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err := c.proto.expect(msgTestStart); err != nil {
+		return nil, err
+	}
+	result := new(TestC2S)
+	elapsed, count, err := c.upload(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close() // tell the server we're done
+	c.sent += count
+	if elapsed > 0 {
+		result.ClientSpeed = float64(count) * 8.0 / elapsed.Seconds() / 1e03
+	}
+	msg, err := c.proto.expect(msgTestMsg)
+	if err != nil {
+		return nil, err
+	}
+	if result.ServerSpeed, err = strconv.ParseFloat(msg, 64); err != nil {
+		return nil, err
+	}
+	if _, err := c.proto.expect(msgTestFinalize); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// upload writes to conn for c.uploadRuntime, returning the
+// elapsed time and the number of bytes that we have sent.
+func (c *client) upload(ctx context.Context, conn stream) (time.Duration, int64, error) {
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(c.uploadRuntime + time.Second)); err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	buffer := make([]byte, paramBufferSize)
+	ticker := time.NewTicker(paramMeasureInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil && time.Since(start) < c.uploadRuntime {
+		count, err := conn.Write(buffer)
+		total += int64(count)
+		if err != nil {
+			return 0, 0, err
+		}
+		select {
+		case now := <-ticker.C:
+			c.onPerformance("upload", now.Sub(start), total)
+		default:
+			// NOTHING
+		}
+	}
+	return time.Since(start), total, nil
+}
@@ -0,0 +1,60 @@
+package ndt5
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseWeb100 parses the "Name: value" lines contained in msg and
+// adds them to the out map. Malformed lines are ignored.
+func parseWeb100(msg string, out map[string]string) {
+	for _, line := range strings.Split(msg, "\n") {
+		v := strings.SplitN(line, ":", 2)
+		if len(v) != 2 {
+			continue
+		}
+		name, value := strings.TrimSpace(v[0]), strings.TrimSpace(v[1])
+		if name == "" {
+			continue
+		}
+		out[name] = value
+	}
+}
+
+// web100Float returns the named variable as a float64 and
+// whether such variable exists and is a valid number.
+func web100Float(vars map[string]string, name string) (float64, bool) {
+	value, found := vars[name]
+	if !found {
+		return 0, false
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	return number, err == nil
+}
+
+// summarizeWeb100 fills the RTT, MSS and retransmission related fields
+// of the summary using the web100 variables collected by the server
+// while it was sending data to us. RTTs in web100 are in milliseconds. Like
+// ndt7, the retransmit rate is computed as bytes retransmitted over bytes sent.
+func summarizeWeb100(vars map[string]string, summary *Summary) {
+	if sumRTT, ok := web100Float(vars, "SumRTT"); ok {
+		if countRTT, ok := web100Float(vars, "CountRTT"); ok && countRTT > 0 {
+			summary.AvgRTT = sumRTT / countRTT
+		}
+	}
+	if maxRTT, ok := web100Float(vars, "MaxRTT"); ok {
+		summary.MaxRTT = maxRTT
+	}
+	if minRTT, ok := web100Float(vars, "MinRTT"); ok {
+		summary.MinRTT = minRTT
+		summary.Ping = minRTT
+	}
+	if mss, ok := web100Float(vars, "CurMSS"); ok {
+		summary.MSS = int64(mss)
+	}
+	if retrans, ok := web100Float(vars, "BytesRetrans"); ok {
+		if bytesOut, ok := web100Float(vars, "DataBytesOut"); ok && bytesOut > 0 {
+			summary.RetransmitRate = retrans / bytesOut
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package ndt5
+
+import "testing"
+
+func TestUnitParseWeb100(t *testing.T) {
+	vars := make(map[string]string)
+	parseWeb100("MinRTT: 10\ninvalid\n: nothing\nCurMSS:1448\n", vars)
+	if len(vars) != 2 || vars["MinRTT"] != "10" || vars["CurMSS"] != "1448" {
+		t.Fatal("unexpected parse result")
+	}
+}
+
+func TestUnitSummarizeWeb100(t *testing.T) {
+	var summary Summary
+	summarizeWeb100(map[string]string{
+		"BytesRetrans": "5000",
+		"CountRTT":     "0",
+		"DataBytesOut": "50000",
+		"MinRTT":       "antani",
+		"PktsOut":      "1",
+		"PktsRetrans":  "1",
+		"SumRTT":       "100",
+	}, &summary)
+	if summary.AvgRTT != 0 || summary.MinRTT != 0 {
+		t.Fatal("unexpected RTT")
+	}
+	if summary.RetransmitRate != 0.1 {
+		t.Fatal("unexpected retransmit rate")
+	}
+}
@@ -0,0 +1,234 @@
+// Package signal contains the Signal network experiment. We check whether
+// we can reach the Signal service, CDN and storage endpoints.
+//
+// Signal endpoints use certificates signed by Signal's own root CA,
+// therefore this experiment requires the CABundlePath option to point
+// to a PEM file containing such CA. We do not fallback to the system
+// CA bundle, because that would cause every TLS handshake to fail with
+// ssl_unknown_authority, which we would then mistake for blocking. For
+// the same reason, failing to load the CA bundle and getting back
+// ssl_unknown_authority are experiment errors rather than measurements.
+package signal
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/experiment/httpheader"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+const (
+	testName    = "signal"
+	testVersion = "0.1.0"
+)
+
+// Endpoints contains the Signal URLs that we measure. Each of them
+// is required for Signal to work properly. We consider an endpoint
+// reachable if we can get an HTTP response, regardless of its status
+// code, since we do not send valid API requests.
+var Endpoints = []string{
+	"https://cdn.signal.org/",
+	"https://cdn2.signal.org/",
+	"https://storage.signal.org/",
+	"https://textsecure-service.whispersystems.org/",
+}
+
+var (
+	errMissingCABundle   = errors.New("signal: CABundlePath option not set")
+	errInvalidCABundle   = errors.New("signal: cannot load the CA bundle")
+	errUntrustedCABundle = errors.New("signal: the CA bundle does not trust Signal")
+)
+
+// loadCABundle checks whether we can use the CA bundle at path, so
+// that we do not mistake a local configuration error for blocking.
+func loadCABundle(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidCABundle, err.Error())
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("%w: no valid certificates", errInvalidCABundle)
+	}
+	return nil
+}
+
+// Config contains the experiment config.
+type Config struct {
+	CABundlePath string `ooni:"Path to the PEM file containing Signal's root CA"`
+}
+
+// TestKeys contains signal test keys.
+type TestKeys struct {
+	Agent                string                          `json:"agent"`
+	Failures             map[string]*string              `json:"failures"`
+	Queries              oonidatamodel.DNSQueriesList    `json:"queries"`
+	Requests             oonidatamodel.RequestList       `json:"requests"`
+	SignalBackendFailure *string                         `json:"signal_backend_failure"`
+	SignalBackendStatus  string                          `json:"signal_backend_status"`
+	TCPConnect           oonidatamodel.TCPConnectList    `json:"tcp_connect"`
+	TLSHandshakes        oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
+
+	untrusted bool
+}
+
+func isUnknownAuthority(err error) bool {
+	var wrapper *modelx.ErrWrapper
+	return errors.As(err, &wrapper) &&
+		wrapper.Failure == modelx.FailureSSLUnknownAuthority
+}
+
+func newTestKeys() *TestKeys {
+	return &TestKeys{
+		Agent:               "redirect",
+		Failures:            make(map[string]*string),
+		SignalBackendStatus: "ok",
+	}
+}
+
+func (tk *TestKeys) processone(URL string, r *oonitemplates.HTTPDoResults) {
+	if r == nil {
+		// This happens when the context is done before we start
+		// measuring: we don't know, hence we don't say anything
+		return
+	}
+	tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(r.TestKeys)...)
+	tk.Requests = append(tk.Requests, oonidatamodel.NewRequestList(r.TestKeys)...)
+	tk.TCPConnect = append(tk.TCPConnect, oonidatamodel.NewTCPConnectList(r.TestKeys)...)
+	tk.TLSHandshakes = append(
+		tk.TLSHandshakes, oonidatamodel.NewTLSHandshakesList(r.TestKeys)...)
+	var failure *string
+	if r.Error != nil {
+		s := r.Error.Error()
+		failure = &s
+	}
+	tk.Failures[URL] = failure
+	if isUnknownAuthority(r.Error) {
+		// The CA bundle does not trust the certificate, which most
+		// likely means that CABundlePath is wrong. Run handles this.
+		tk.untrusted = true
+		return
+	}
+	if failure != nil && tk.SignalBackendStatus == "ok" {
+		tk.SignalBackendStatus = "blocked"
+		tk.SignalBackendFailure = failure
+	}
+}
+
+// processall processes the results in a stable order such that the
+// SignalBackendFailure is always the one of the first failed URL.
+func (tk *TestKeys) processall(results map[string]*oonitemplates.HTTPDoResults) {
+	var keys []string
+	for URL := range results {
+		keys = append(keys, URL)
+	}
+	sort.Strings(keys)
+	for _, URL := range keys {
+		tk.processone(URL, results[URL])
+	}
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	tk := newTestKeys()
+	measurement.TestKeys = tk
+	if m.config.CABundlePath == "" {
+		return errMissingCABundle
+	}
+	if err := loadCABundle(m.config.CABundlePath); err != nil {
+		return err
+	}
+	var (
+		completed     = atomicx.NewInt64()
+		mu            sync.Mutex
+		receivedBytes = atomicx.NewInt64()
+		results       = make(map[string]*oonitemplates.HTTPDoResults)
+		sentBytes     = atomicx.NewInt64()
+		waitgroup     sync.WaitGroup
+	)
+	waitgroup.Add(len(Endpoints))
+	for _, URL := range Endpoints {
+		go func(URL string) {
+			defer waitgroup.Done()
+			// Avoid making all requests concurrently
+			gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+			sleeptime := time.Duration(gen.Intn(5000)) * time.Millisecond
+			select {
+			case <-time.After(sleeptime):
+			case <-ctx.Done():
+				return
+			}
+			r := oonitemplates.HTTPDo(ctx, oonitemplates.HTTPDoConfig{
+				Accept:         httpheader.RandomAccept(),
+				AcceptLanguage: httpheader.RandomAcceptLanguage(),
+				Beginning:      measurement.MeasurementStartTimeSaved,
+				CABundlePath:   m.config.CABundlePath,
+				Handler:        netxlogger.NewHandler(sess.Logger()),
+				Method:         "GET",
+				URL:            URL,
+				UserAgent:      httpheader.RandomUserAgent(),
+			})
+			sentBytes.Add(r.TestKeys.SentBytes)
+			receivedBytes.Add(r.TestKeys.ReceivedBytes)
+			mu.Lock()
+			results[URL] = r
+			mu.Unlock()
+			sofar := completed.Add(1)
+			percentage := float64(sofar) / float64(len(Endpoints))
+			callbacks.OnProgress(percentage, fmt.Sprintf(
+				"signal: access %s: %s", URL, errString(r.Error),
+			))
+		}(URL)
+	}
+	waitgroup.Wait()
+	tk.processall(results)
+	callbacks.OnDataUsage(
+		float64(receivedBytes.Load())/1024.0, // downloaded
+		float64(sentBytes.Load())/1024.0,     // uploaded
+	)
+	if tk.untrusted {
+		return errUntrustedCABundle
+	}
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
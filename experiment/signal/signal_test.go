@@ -0,0 +1,151 @@
+package signal
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "signal" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func run(config Config) (*TestKeys, error) {
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(config)
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*TestKeys), err
+}
+
+func TestUnitRunMissingCABundle(t *testing.T) {
+	_, err := run(Config{})
+	if !errors.Is(err, errMissingCABundle) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunNonexistentCABundle(t *testing.T) {
+	tk, err := run(Config{CABundlePath: "testdata/nonexistent.pem"})
+	if !errors.Is(err, errInvalidCABundle) {
+		t.Fatal("not the error we expected")
+	}
+	if tk.SignalBackendStatus == "blocked" || tk.SignalBackendFailure != nil {
+		t.Fatal("unexpected backend status")
+	}
+	if len(tk.Failures) != 0 || len(tk.Requests) != 0 {
+		t.Fatal("we should not have measured")
+	}
+}
+
+func TestUnitRunInvalidCABundle(t *testing.T) {
+	tk, err := run(Config{CABundlePath: "../../netx/testdata/cacert-invalid.pem"})
+	if !errors.Is(err, errInvalidCABundle) {
+		t.Fatal("not the error we expected")
+	}
+	if tk.SignalBackendStatus == "blocked" {
+		t.Fatal("unexpected backend status")
+	}
+}
+
+func TestIntegrationRun(t *testing.T) {
+	// We cannot run this test without Signal's CA, so we just check that
+	// using the wrong CA bundle is an error rather than blocking.
+	tk, err := run(Config{CABundlePath: "../../netx/testdata/cacert.pem"})
+	if !errors.Is(err, errUntrustedCABundle) {
+		t.Fatal("not the error we expected")
+	}
+	if tk.SignalBackendStatus == "blocked" {
+		t.Fatal("unexpected backend status")
+	}
+	if len(tk.TLSHandshakes) <= 0 {
+		t.Fatal("expected some TLS handshakes")
+	}
+}
+
+func TestUnitProcessallSuccess(t *testing.T) {
+	tk := newTestKeys()
+	results := make(map[string]*oonitemplates.HTTPDoResults)
+	for _, URL := range Endpoints {
+		results[URL] = &oonitemplates.HTTPDoResults{StatusCode: 404}
+	}
+	tk.processall(results)
+	if tk.SignalBackendStatus != "ok" || tk.SignalBackendFailure != nil {
+		t.Fatal("unexpected backend status")
+	}
+	for _, URL := range Endpoints {
+		failure, found := tk.Failures[URL]
+		if !found || failure != nil {
+			t.Fatal("unexpected failure entry")
+		}
+	}
+}
+
+func TestUnitProcessallFailure(t *testing.T) {
+	tk := newTestKeys()
+	results := make(map[string]*oonitemplates.HTTPDoResults)
+	for _, URL := range Endpoints {
+		results[URL] = &oonitemplates.HTTPDoResults{StatusCode: 200}
+	}
+	results[Endpoints[1]] = &oonitemplates.HTTPDoResults{
+		Error: errors.New("connection_reset"),
+	}
+	results[Endpoints[3]] = &oonitemplates.HTTPDoResults{
+		Error: errors.New("generic_timeout_error"),
+	}
+	tk.processall(results)
+	if tk.SignalBackendStatus != "blocked" {
+		t.Fatal("unexpected backend status")
+	}
+	if tk.SignalBackendFailure == nil || *tk.SignalBackendFailure != "connection_reset" {
+		t.Fatal("unexpected backend failure")
+	}
+	if tk.Failures[Endpoints[0]] != nil || tk.Failures[Endpoints[3]] == nil {
+		t.Fatal("unexpected failures")
+	}
+}
+
+func TestUnitProcessallMissingResults(t *testing.T) {
+	tk := newTestKeys()
+	tk.processall(map[string]*oonitemplates.HTTPDoResults{Endpoints[0]: nil})
+	if tk.SignalBackendStatus != "ok" || len(tk.Failures) != 0 {
+		t.Fatal("unexpected test keys")
+	}
+}
+
+func TestUnitProcessallUnknownAuthority(t *testing.T) {
+	tk := newTestKeys()
+	results := make(map[string]*oonitemplates.HTTPDoResults)
+	for _, URL := range Endpoints {
+		results[URL] = &oonitemplates.HTTPDoResults{Error: &url.Error{
+			Op:  "Get",
+			URL: URL,
+			Err: &modelx.ErrWrapper{Failure: modelx.FailureSSLUnknownAuthority},
+		}}
+	}
+	tk.processall(results)
+	if tk.SignalBackendStatus != "ok" || tk.SignalBackendFailure != nil {
+		t.Fatal("unexpected backend status")
+	}
+	if !tk.untrusted || len(tk.Failures) != len(Endpoints) {
+		t.Fatal("unexpected test keys")
+	}
+}
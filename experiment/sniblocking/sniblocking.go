@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"net"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,7 +23,7 @@ import (
 
 const (
 	testName    = "sni_blocking"
-	testVersion = "0.0.5"
+	testVersion = "0.0.6"
 )
 
 // Config contains the experiment config.
@@ -60,8 +61,10 @@ type TestKeys struct {
 const (
 	classAnomalyTestHelperUnreachable   = "anomaly.test_helper_unreachable"
 	classAnomalyTimeout                 = "anomaly.timeout"
+	classAnomalyTLSAlert                = "anomaly.tls_alert"
 	classAnomalyUnexpectedFailure       = "anomaly.unexpected_failure"
 	classInterferenceClosed             = "interference.closed"
+	classInterferenceFailedHandshake    = "interference.failed_handshake"
 	classInterferenceInvalidCertificate = "interference.invalid_certificate"
 	classInterferenceReset              = "interference.reset"
 	classInterferenceUnknownAuthority   = "interference.unknown_authority"
@@ -72,6 +75,12 @@ func (tk *TestKeys) classify() string {
 	if tk.Target.Failure == nil {
 		return classSuccessGotServerHello
 	}
+	if strings.HasPrefix(*tk.Target.Failure, modelx.FailureSSLAlertPrefix) {
+		// The test helper may legitimately reject an SNI that it does not
+		// serve using an alert, such as unrecognized_name, therefore we
+		// cannot tell whether someone in the middle sent the alert.
+		return classAnomalyTLSAlert
+	}
 	switch *tk.Target.Failure {
 	case modelx.FailureConnectionRefused:
 		return classAnomalyTestHelperUnreachable
@@ -79,6 +88,10 @@ func (tk *TestKeys) classify() string {
 		return classInterferenceReset
 	case modelx.FailureDNSNXDOMAINError:
 		return classAnomalyTestHelperUnreachable
+	case modelx.FailureDNSRefused:
+		return classAnomalyTestHelperUnreachable
+	case modelx.FailureDNSServfail:
+		return classAnomalyTestHelperUnreachable
 	case modelx.FailureEOFError:
 		return classInterferenceClosed
 	case modelx.FailureGenericTimeoutError:
@@ -86,6 +99,18 @@ func (tk *TestKeys) classify() string {
 			return classAnomalyTestHelperUnreachable
 		}
 		return classAnomalyTimeout
+	case modelx.FailureHostUnreachable:
+		return classAnomalyTestHelperUnreachable
+	case modelx.FailureNetworkUnreachable:
+		return classAnomalyTestHelperUnreachable
+	case modelx.FailureSSLFailedHandshake:
+		// We received a record that is not TLS, which means that someone
+		// injected a response, unless the control also failed, in which
+		// case the test helper itself is not speaking TLS.
+		if tk.Control.Failure != nil {
+			return classAnomalyTestHelperUnreachable
+		}
+		return classInterferenceFailedHandshake
 	case modelx.FailureSSLInvalidCertificate:
 		return classInterferenceInvalidCertificate
 	case modelx.FailureSSLInvalidHostname:
@@ -10,7 +10,9 @@ import (
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/internal/netxlogger"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netxtest"
 )
 
 const (
@@ -42,6 +44,56 @@ func TestUnitTestKeysClassify(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("with tk.Target.Failure == dns_server_failure", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureDNSServfail)
+		if tk.classify() != classAnomalyTestHelperUnreachable {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("with tk.Target.Failure == dns_refused_error", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureDNSRefused)
+		if tk.classify() != classAnomalyTestHelperUnreachable {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("with tk.Target.Failure == host_unreachable", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureHostUnreachable)
+		if tk.classify() != classAnomalyTestHelperUnreachable {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("with tk.Target.Failure == network_unreachable", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureNetworkUnreachable)
+		if tk.classify() != classAnomalyTestHelperUnreachable {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("with tk.Target.Failure == ssl_failed_handshake #1", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureSSLFailedHandshake)
+		if tk.classify() != classInterferenceFailedHandshake {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("with tk.Target.Failure == ssl_failed_handshake #2", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureSSLFailedHandshake)
+		tk.Control.Failure = asStringPtr(modelx.FailureSSLFailedHandshake)
+		if tk.classify() != classAnomalyTestHelperUnreachable {
+			t.Fatal("unexpected result")
+		}
+	})
+	t.Run("with tk.Target.Failure == ssl_alert_unrecognized_name", func(t *testing.T) {
+		tk := new(TestKeys)
+		tk.Target.Failure = asStringPtr(modelx.FailureSSLAlertPrefix + "unrecognized_name")
+		if tk.classify() != classAnomalyTLSAlert {
+			t.Fatal("unexpected result")
+		}
+	})
 	t.Run("with tk.Target.Failure == connection_reset", func(t *testing.T) {
 		tk := new(TestKeys)
 		tk.Target.Failure = asStringPtr(modelx.FailureConnectionReset)
@@ -106,7 +158,7 @@ func TestUnitNewExperimentMeasurer(t *testing.T) {
 	if measurer.ExperimentName() != "sni_blocking" {
 		t.Fatal("unexpected name")
 	}
-	if measurer.ExperimentVersion() != "0.0.5" {
+	if measurer.ExperimentVersion() != "0.0.6" {
 		t.Fatal("unexpected version")
 	}
 }
@@ -225,6 +277,54 @@ func TestUnitMeasureoneSuccess(t *testing.T) {
 	}
 }
 
+func TestUnitMeasureoneWithSimulatedNetwork(t *testing.T) {
+	hosts := map[string][]string{"example.com": {"93.184.216.34"}}
+	for _, tc := range []struct {
+		network *netxtest.Network
+		failure string
+		class   string
+	}{{
+		network: &netxtest.Network{Hosts: hosts, Rules: []netxtest.Rule{{
+			Action: netxtest.ActionReset, Host: "93.184.216.34", ResetAfter: 1,
+		}}},
+		failure: modelx.FailureConnectionReset,
+		class:   classInterferenceReset,
+	}, {
+		network: &netxtest.Network{Hosts: hosts, Rules: []netxtest.Rule{{
+			Action: netxtest.ActionForgedCertificate, Host: "93.184.216.34",
+		}}},
+		failure: modelx.FailureSSLUnknownAuthority,
+		class:   classInterferenceUnknownAuthority,
+	}, {
+		network: &netxtest.Network{},
+		failure: modelx.FailureDNSNXDOMAINError,
+		class:   classAnomalyTestHelperUnreachable,
+	}} {
+		t.Run(tc.failure, func(t *testing.T) {
+			root := &modelx.MeasurementRoot{
+				Beginning: time.Now(),
+				Handler:   handlers.NoHandler,
+			}
+			tc.network.Configure(root)
+			ctx := modelx.WithMeasurementRoot(context.Background(), root)
+			tk := new(TestKeys)
+			tk.Target = new(measurer).measureone(
+				ctx,
+				netxlogger.NewHandler(log.Log),
+				time.Now(),
+				"kernel.org",
+				"example.com:443",
+			)
+			if tk.Target.Failure == nil || *tk.Target.Failure != tc.failure {
+				t.Fatal("unexpected failure")
+			}
+			if tk.classify() != tc.class {
+				t.Fatal("unexpected result")
+			}
+		})
+	}
+}
+
 func TestUnitMeasureonewithcacheWorks(t *testing.T) {
 	measurer := &measurer{cache: make(map[string]Subresult)}
 	output := make(chan Subresult, 2)
@@ -0,0 +1,149 @@
+package stunreachability
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// This file contains a minimal implementation of the subset of
+// RFC5389 (STUN) that we need to send a binding request and to
+// extract the mapped address from the binding response.
+
+const (
+	stunHeaderSize       = 20
+	stunMagicCookie      = 0x2112A442
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunBindingError     = 0x0111
+	stunMappedAddress    = 0x0001
+	stunXORMappedAddress = 0x0020
+	stunFamilyIPv4       = 0x01
+	stunFamilyIPv6       = 0x02
+)
+
+var (
+	errSTUNShortMessage       = errors.New("stun: message too short")
+	errSTUNNotAResponse       = errors.New("stun: not a binding response")
+	errSTUNErrorResponse      = errors.New("stun: binding error response")
+	errSTUNTransactionID      = errors.New("stun: unexpected transaction ID")
+	errSTUNInvalidAttribute   = errors.New("stun: invalid attribute")
+	errSTUNNoMappedAddress    = errors.New("stun: no mapped address")
+	errSTUNInvalidMagicCookie = errors.New("stun: invalid magic cookie")
+)
+
+// stunTransactionID is a STUN transaction ID.
+type stunTransactionID [12]byte
+
+// newSTUNTransactionID generates a new random transaction ID.
+func newSTUNTransactionID() (txid stunTransactionID, err error) {
+	_, err = rand.Read(txid[:])
+	return
+}
+
+// newSTUNBindingRequest returns a serialized binding request.
+func newSTUNBindingRequest(txid stunTransactionID) []byte {
+	out := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(out[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(out[2:4], 0) // no attributes
+	binary.BigEndian.PutUint32(out[4:8], stunMagicCookie)
+	copy(out[8:20], txid[:])
+	return out
+}
+
+// parseSTUNBindingResponse parses a binding response and returns
+// the mapped address, i.e., our address as seen by the server.
+func parseSTUNBindingResponse(txid stunTransactionID, data []byte) (string, error) {
+	if len(data) < stunHeaderSize {
+		return "", errSTUNShortMessage
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != stunMagicCookie {
+		return "", errSTUNInvalidMagicCookie
+	}
+	if !bytes.Equal(data[8:20], txid[:]) {
+		return "", errSTUNTransactionID
+	}
+	switch binary.BigEndian.Uint16(data[0:2]) {
+	case stunBindingSuccess:
+	case stunBindingError:
+		return "", errSTUNErrorResponse
+	default:
+		return "", errSTUNNotAResponse
+	}
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if len(data) < stunHeaderSize+length {
+		return "", errSTUNShortMessage
+	}
+	var mapped string
+	attrs := data[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) > 0 {
+		if len(attrs) < 4 {
+			return "", errSTUNInvalidAttribute
+		}
+		kind := binary.BigEndian.Uint16(attrs[0:2])
+		size := int(binary.BigEndian.Uint16(attrs[2:4]))
+		padded := (size + 3) &^ 3
+		if len(attrs) < 4+size {
+			return "", errSTUNInvalidAttribute
+		}
+		value := attrs[4 : 4+size]
+		switch kind {
+		case stunXORMappedAddress:
+			// XOR-MAPPED-ADDRESS wins over MAPPED-ADDRESS
+			address, err := parseSTUNAddress(value, txid, true)
+			if err != nil {
+				return "", err
+			}
+			return address, nil
+		case stunMappedAddress:
+			address, err := parseSTUNAddress(value, txid, false)
+			if err != nil {
+				return "", err
+			}
+			mapped = address
+		}
+		if len(attrs) < 4+padded {
+			break
+		}
+		attrs = attrs[4+padded:]
+	}
+	if mapped == "" {
+		return "", errSTUNNoMappedAddress
+	}
+	return mapped, nil
+}
+
+// parseSTUNAddress parses a (XOR-)MAPPED-ADDRESS attribute value.
+func parseSTUNAddress(value []byte, txid stunTransactionID, xored bool) (string, error) {
+	if len(value) < 4 {
+		return "", errSTUNInvalidAttribute
+	}
+	var size int
+	switch value[1] {
+	case stunFamilyIPv4:
+		size = net.IPv4len
+	case stunFamilyIPv6:
+		size = net.IPv6len
+	default:
+		return "", errSTUNInvalidAttribute
+	}
+	if len(value) != 4+size {
+		return "", errSTUNInvalidAttribute
+	}
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := make(net.IP, size)
+	copy(ip, value[4:])
+	if xored {
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+		copy(key[4:], txid[:])
+		port ^= uint16(stunMagicCookie >> 16)
+		for idx := range ip {
+			ip[idx] ^= key[idx]
+		}
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
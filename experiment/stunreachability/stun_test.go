@@ -0,0 +1,122 @@
+package stunreachability
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+// newSTUNBindingResponse is the server side of the binding
+// request. We use it to test our parser and in fake servers.
+func newSTUNBindingResponse(
+	txid stunTransactionID, kind uint16, addr *net.UDPAddr, xored bool,
+) []byte {
+	family, ip := byte(stunFamilyIPv4), addr.IP.To4()
+	if ip == nil {
+		family, ip = stunFamilyIPv6, addr.IP.To16()
+	}
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(addr.Port))
+	copy(value[4:], ip)
+	attr := uint16(stunMappedAddress)
+	if xored {
+		attr = stunXORMappedAddress
+		key := make([]byte, 16)
+		binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+		copy(key[4:], txid[:])
+		value[2] ^= key[0]
+		value[3] ^= key[1]
+		for idx := range ip {
+			value[4+idx] ^= key[idx]
+		}
+	}
+	out := newSTUNBindingRequest(txid)
+	binary.BigEndian.PutUint16(out[0:2], kind)
+	binary.BigEndian.PutUint16(out[2:4], uint16(4+len(value)))
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint16(header[0:2], attr)
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(value)))
+	return append(append(out, header...), value...)
+}
+
+func TestUnitSTUNBindingRequest(t *testing.T) {
+	txid, err := newSTUNTransactionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := newSTUNBindingRequest(txid)
+	if len(data) != stunHeaderSize {
+		t.Fatal("unexpected length")
+	}
+	if binary.BigEndian.Uint16(data[0:2]) != stunBindingRequest {
+		t.Fatal("unexpected message type")
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != stunMagicCookie {
+		t.Fatal("unexpected magic cookie")
+	}
+}
+
+func TestUnitParseSTUNBindingResponse(t *testing.T) {
+	txid := stunTransactionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	v4 := &net.UDPAddr{IP: net.IPv4(130, 192, 91, 211), Port: 54321}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	var inputs = []struct {
+		name   string
+		data   []byte
+		expect string
+		err    error
+	}{{
+		name:   "XOR-MAPPED-ADDRESS with IPv4",
+		data:   newSTUNBindingResponse(txid, stunBindingSuccess, v4, true),
+		expect: "130.192.91.211:54321",
+	}, {
+		name:   "XOR-MAPPED-ADDRESS with IPv6",
+		data:   newSTUNBindingResponse(txid, stunBindingSuccess, v6, true),
+		expect: "[2001:db8::1]:443",
+	}, {
+		name:   "MAPPED-ADDRESS with IPv4",
+		data:   newSTUNBindingResponse(txid, stunBindingSuccess, v4, false),
+		expect: "130.192.91.211:54321",
+	}, {
+		name: "short message",
+		data: []byte{0x01, 0x01},
+		err:  errSTUNShortMessage,
+	}, {
+		name: "error response",
+		data: newSTUNBindingResponse(txid, stunBindingError, v4, true),
+		err:  errSTUNErrorResponse,
+	}, {
+		name: "not a response",
+		data: newSTUNBindingRequest(txid),
+		err:  errSTUNNotAResponse,
+	}, {
+		name: "different transaction ID",
+		data: newSTUNBindingResponse(stunTransactionID{}, stunBindingSuccess, v4, true),
+		err:  errSTUNTransactionID,
+	}, {
+		name: "no attributes",
+		data: func() []byte {
+			data := newSTUNBindingRequest(txid)
+			binary.BigEndian.PutUint16(data[0:2], stunBindingSuccess)
+			return data
+		}(),
+		err: errSTUNNoMappedAddress,
+	}, {
+		name: "truncated attributes",
+		data: newSTUNBindingResponse(txid, stunBindingSuccess, v4, true)[:22],
+		err:  errSTUNShortMessage,
+	}}
+	for _, input := range inputs {
+		t.Run(input.name, func(t *testing.T) {
+			out, err := parseSTUNBindingResponse(txid, input.data)
+			if !errors.Is(err, input.err) {
+				t.Fatalf("not the error we expected: %+v", err)
+			}
+			if out != input.expect {
+				t.Fatalf("expected %s, got %s", input.expect, out)
+			}
+		})
+	}
+}
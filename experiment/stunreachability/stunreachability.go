@@ -0,0 +1,196 @@
+// Package stunreachability contains the STUN reachability experiment. We
+// send STUN binding requests to a list of STUN servers and we check
+// whether we receive a response. This helps to detect UDP blocking that
+// affects apps based on WebRTC.
+//
+// See https://tools.ietf.org/html/rfc5389.
+package stunreachability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/handlers"
+)
+
+const (
+	testName    = "stun_reachability"
+	testVersion = "0.1.0"
+)
+
+// DefaultServers is the default list of STUN servers.
+const DefaultServers = "stun.l.google.com:19302 stun.ekiga.net:3478 stun.stunprotocol.org:3478"
+
+const (
+	// maxAttempts is the number of binding requests we send
+	// to each server before declaring it unreachable.
+	maxAttempts = 3
+
+	// attemptTimeout is the time we wait for each response.
+	attemptTimeout = 2 * time.Second
+)
+
+// Config contains the experiment config.
+type Config struct {
+	Servers string `ooni:"Space separated list of STUN servers (host:port)"`
+}
+
+// Endpoint contains the results of querying a specific STUN server.
+type Endpoint struct {
+	Endpoint      string  `json:"endpoint"`
+	Failure       *string `json:"failure"`
+	MappedAddress string  `json:"mapped_address"`
+	RTT           float64 `json:"rtt"` // [s]
+}
+
+// TestKeys contains stun_reachability test keys.
+type TestKeys struct {
+	Endpoints     []Endpoint                      `json:"endpoints"`
+	NetworkEvents oonidatamodel.NetworkEventsList `json:"network_events"`
+	Queries       oonidatamodel.DNSQueriesList    `json:"queries"`
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m *measurer) ExperimentName() string {
+	return testName
+}
+
+func (m *measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+func (m *measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	servers := strings.Fields(m.config.Servers)
+	if len(servers) <= 0 {
+		servers = strings.Fields(DefaultServers)
+	}
+	saver := new(handlers.SavingHandler)
+	dialer := netx.NewDialer()
+	dialer.Beginning = measurement.MeasurementStartTimeSaved
+	dialer.Handler = saver
+	for idx, server := range servers {
+		entry := Endpoint{Endpoint: server}
+		mapped, rtt, err := bindingRequest(ctx, dialer, server)
+		if err != nil {
+			s := err.Error()
+			entry.Failure = &s
+		}
+		entry.MappedAddress, entry.RTT = mapped, rtt.Seconds()
+		tk.Endpoints = append(tk.Endpoints, entry)
+		callbacks.OnProgress(float64(idx+1)/float64(len(servers)), fmt.Sprintf(
+			"stun_reachability: %s: %s", server, errString(err),
+		))
+	}
+	var results oonitemplates.Results
+	for _, ev := range saver.Read() {
+		ev := ev
+		if ev.ResolveDone != nil {
+			results.Resolves = append(results.Resolves, ev.ResolveDone)
+		}
+		if ev.Read != nil {
+			results.ReceivedBytes += ev.Read.NumBytes
+		}
+		if ev.Write != nil {
+			results.SentBytes += ev.Write.NumBytes
+		}
+		results.NetworkEvents = append(results.NetworkEvents, &ev)
+	}
+	tk.NetworkEvents = oonidatamodel.NewNetworkEventsList(results)
+	tk.Queries = oonidatamodel.NewDNSQueriesList(results)
+	callbacks.OnDataUsage(
+		float64(results.ReceivedBytes)/1024.0, // downloaded
+		float64(results.SentBytes)/1024.0,     // uploaded
+	)
+	return nil
+}
+
+// bindingRequest sends a binding request to the server, retransmitting
+// it if needed, and returns the mapped address and the RTT.
+func bindingRequest(
+	ctx context.Context, dialer *netx.Dialer, server string,
+) (string, time.Duration, error) {
+	txid, err := newSTUNTransactionID()
+	if err != nil {
+		return "", 0, err
+	}
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return "", 0, err
+	}
+	defer conn.Close()
+	request := newSTUNBindingRequest(txid)
+	buffer := make([]byte, 1<<11)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		if _, err := conn.Write(request); err != nil {
+			return "", 0, err
+		}
+		mapped, err := readBindingResponse(ctx, conn, txid, buffer)
+		if err == nil {
+			return mapped, time.Since(start), nil
+		}
+		if !isTimeout(err) || attempt >= maxAttempts || ctx.Err() != nil {
+			return "", 0, err
+		}
+	}
+}
+
+// readBindingResponse reads the response to the binding request with
+// the specified txid. Retransmissions use the same txid, therefore we
+// ignore messages only when they carry a different txid.
+func readBindingResponse(
+	ctx context.Context, conn net.Conn, txid stunTransactionID, buffer []byte,
+) (string, error) {
+	deadline := time.Now().Add(attemptTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return "", err
+	}
+	for {
+		count, err := conn.Read(buffer)
+		if err != nil {
+			return "", err
+		}
+		mapped, err := parseSTUNBindingResponse(txid, buffer[:count])
+		if errors.Is(err, errSTUNTransactionID) {
+			continue // late response or garbage
+		}
+		return mapped, err
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return &measurer{config: config}
+}
@@ -0,0 +1,141 @@
+package stunreachability
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "stun_reachability" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func run(ctx context.Context, config Config) *TestKeys {
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(config)
+	err := m.Run(
+		ctx,
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		panic(err) // Run never fails
+	}
+	return measurement.TestKeys.(*TestKeys)
+}
+
+// listen creates a fake STUN server. When respond is false, the
+// server reads the requests but never answers them.
+func listen(t *testing.T, respond bool) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buffer := make([]byte, 1<<11)
+		for {
+			count, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			if !respond || count < stunHeaderSize {
+				continue
+			}
+			var txid stunTransactionID
+			copy(txid[:], buffer[8:20])
+			conn.WriteTo(newSTUNBindingResponse(
+				txid, stunBindingSuccess, addr.(*net.UDPAddr), true,
+			), addr)
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func TestUnitRunSuccess(t *testing.T) {
+	address, stop := listen(t, true)
+	defer stop()
+	tk := run(context.Background(), Config{Servers: address})
+	if len(tk.Endpoints) != 1 {
+		t.Fatal("unexpected number of endpoints")
+	}
+	entry := tk.Endpoints[0]
+	if entry.Failure != nil {
+		t.Fatal(*entry.Failure)
+	}
+	host, _, err := net.SplitHostPort(entry.MappedAddress)
+	if err != nil || host != "127.0.0.1" {
+		t.Fatal("unexpected mapped address")
+	}
+	if entry.RTT <= 0 {
+		t.Fatal("unexpected RTT")
+	}
+	var reads, writes int
+	for _, ev := range tk.NetworkEvents {
+		if ev.Proto != "udp" {
+			t.Fatal("unexpected protocol")
+		}
+		switch ev.Operation {
+		case "read":
+			reads++
+		case "write":
+			writes++
+		}
+	}
+	if reads != 1 || writes != 1 {
+		t.Fatal("unexpected number of reads and writes")
+	}
+}
+
+func TestUnitRunTimeout(t *testing.T) {
+	address, stop := listen(t, false)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	tk := run(ctx, Config{Servers: address})
+	if len(tk.Endpoints) != 1 {
+		t.Fatal("unexpected number of endpoints")
+	}
+	entry := tk.Endpoints[0]
+	if entry.Failure == nil || *entry.Failure != "generic_timeout_error" {
+		t.Fatal("not the failure we expected")
+	}
+	if entry.MappedAddress != "" || entry.RTT != 0 {
+		t.Fatal("unexpected results")
+	}
+}
+
+func TestUnitRunResolveFailure(t *testing.T) {
+	tk := run(context.Background(), Config{Servers: "stun.antani.invalid:3478"})
+	if len(tk.Endpoints) != 1 || tk.Endpoints[0].Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if len(tk.Queries) <= 0 {
+		t.Fatal("expected some queries here")
+	}
+}
+
+func TestIntegrationRun(t *testing.T) {
+	tk := run(context.Background(), Config{})
+	if len(tk.Endpoints) != 3 {
+		t.Fatal("unexpected number of endpoints")
+	}
+	for _, entry := range tk.Endpoints {
+		if entry.Failure == nil {
+			return // we're good if at least one server worked
+		}
+	}
+	t.Fatal("all STUN servers failed")
+}
@@ -4,14 +4,18 @@ import (
 	"context"
 	"errors"
 	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/ooni/probe-engine/experiment/handler"
 	"github.com/ooni/probe-engine/internal/mockable"
 	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/ooni/probe-engine/netx/netxtest"
 )
 
 func TestUnitNewExperimentMeasurer(t *testing.T) {
@@ -44,6 +48,44 @@ func TestUnitMeasureWithCancelledContext(t *testing.T) {
 	}
 }
 
+func TestUnitMeasureWithSimulatedNetwork(t *testing.T) {
+	// The access points accept connections but reset them as soon
+	// as we send the request, while web.telegram.org does not resolve
+	network := &netxtest.Network{Rules: []netxtest.Rule{{
+		Action: netxtest.ActionReset, Host: "*", ResetAfter: 1,
+	}}}
+	root := &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   handlers.NoHandler,
+	}
+	network.Configure(root)
+	measurement := new(model.Measurement)
+	err := new(measurer).Run(
+		modelx.WithMeasurementRoot(context.Background(), root),
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := *measurement.TestKeys.(**TestKeys)
+	if tk.TelegramTCPBlocking {
+		t.Fatal("unexpected TCP blocking")
+	}
+	if !tk.TelegramHTTPBlocking {
+		t.Fatal("expected HTTP blocking")
+	}
+	if tk.TelegramWebStatus != "blocked" {
+		t.Fatal("expected web blocking")
+	}
+	if !strings.HasSuffix(*tk.TelegramWebFailure, modelx.FailureDNSNXDOMAINError) {
+		t.Fatal("unexpected web failure")
+	}
+}
+
 func TestIntegrationMeasure(t *testing.T) {
 	m := new(measurer)
 	err := m.Run(
@@ -0,0 +1,359 @@
+package tor
+
+// This file implements the bootstrap mode, where we launch a tor binary
+// and follow its bootstrap using the control port.
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// bootstrapTimeout is the maximum time we allow tor to bootstrap.
+const bootstrapTimeout = 300 * time.Second
+
+var (
+	errControlPortFile  = errors.New("tor: cannot parse control port file")
+	errControlReply     = errors.New("tor: unexpected control port reply")
+	errBootstrapTimeout = errors.New(modelx.FailureGenericTimeoutError)
+)
+
+// BootstrapProgress is a bootstrap progress event emitted by tor.
+type BootstrapProgress struct {
+	Percentage int64   `json:"percentage"`
+	Summary    string  `json:"summary"`
+	T          float64 `json:"t"`
+	Tag        string  `json:"tag"`
+	Warning    string  `json:"warning,omitempty"`
+}
+
+// BootstrapResults contains the results of bootstrapping tor.
+type BootstrapResults struct {
+	BootstrapTime float64             `json:"bootstrap_time"`
+	Failure       *string             `json:"failure"`
+	Progress      []BootstrapProgress `json:"progress"`
+	TorVersion    string              `json:"tor_version"`
+}
+
+// parseKeywords parses the space separated KEY=VALUE pairs used by
+// bootstrap status events. Values may be quoted strings. Words that are
+// not in the KEY=VALUE form are stored with an empty value.
+func parseKeywords(s string) map[string]string {
+	out := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		idx := strings.IndexAny(s, " =")
+		if idx < 0 || s[idx] == ' ' {
+			if idx < 0 {
+				idx = len(s)
+			}
+			out[s[:idx]] = ""
+			s = s[idx:]
+			continue
+		}
+		key, rest := s[:idx], s[idx+1:]
+		if !strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			out[key], s = rest[:end], rest[end:]
+			continue
+		}
+		var value strings.Builder
+		idx = 1
+		for ; idx < len(rest) && rest[idx] != '"'; idx++ {
+			if rest[idx] == '\\' && idx+1 < len(rest) {
+				idx++
+			}
+			value.WriteByte(rest[idx])
+		}
+		out[key] = value.String()
+		if idx < len(rest) {
+			idx++ // skip the closing quote
+		}
+		s = rest[idx:]
+	}
+	return out
+}
+
+// parseBootstrapStatus parses a bootstrap status such as `NOTICE BOOTSTRAP
+// PROGRESS=10 TAG=conn_done SUMMARY="Connected to a relay"`. It returns
+// false if the status is not a bootstrap status.
+func parseBootstrapStatus(s string) (BootstrapProgress, bool) {
+	var progress BootstrapProgress
+	keywords := parseKeywords(s)
+	if _, found := keywords["BOOTSTRAP"]; !found {
+		return progress, false
+	}
+	percentage, err := strconv.ParseInt(keywords["PROGRESS"], 10, 64)
+	if err != nil {
+		return progress, false
+	}
+	progress.Percentage = percentage
+	progress.Summary = keywords["SUMMARY"]
+	progress.Tag = keywords["TAG"]
+	progress.Warning = keywords["WARNING"]
+	return progress, true
+}
+
+// parseControlPortFile parses the file written by tor because of the
+// ControlPortWriteToFile option, i.e., `PORT=127.0.0.1:9051`.
+func parseControlPortFile(data []byte) (string, error) {
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "PORT=") {
+		return "", errControlPortFile
+	}
+	return strings.TrimPrefix(line, "PORT="), nil
+}
+
+// controlConn is a minimal tor control protocol client.
+type controlConn struct {
+	conn   net.Conn
+	events []string
+	reader *bufio.Reader
+}
+
+func newControlConn(conn net.Conn) *controlConn {
+	return &controlConn{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *controlConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command sends a command and returns the lines of the reply without
+// the status code. Asynchronous events received in the meanwhile
+// are queued and returned by nextEvent.
+func (c *controlConn) command(cmd string) ([]string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", cmd); err != nil {
+		return nil, err
+	}
+	var reply []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, errControlReply
+		}
+		if strings.HasPrefix(line, "650") {
+			c.events = append(c.events, line[4:])
+			continue
+		}
+		if !strings.HasPrefix(line, "250") {
+			return nil, fmt.Errorf("%w: %s", errControlReply, line)
+		}
+		reply = append(reply, line[4:])
+		if line[3] == ' ' {
+			return reply, nil
+		}
+	}
+}
+
+// nextEvent returns the next asynchronous event.
+func (c *controlConn) nextEvent() (string, error) {
+	if len(c.events) > 0 {
+		event := c.events[0]
+		c.events = c.events[1:]
+		return event, nil
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", err
+		}
+		if strings.HasPrefix(line, "650 ") {
+			return line[4:], nil
+		}
+	}
+}
+
+// bootstrapper runs tor and follows its bootstrap.
+type bootstrapper struct {
+	beginning     time.Time
+	binary        string
+	callbacks     model.ExperimentCallbacks
+	dial          func(ctx context.Context, network, address string) (net.Conn, error)
+	execCommand   func(ctx context.Context, name string, arg ...string) *exec.Cmd
+	execLookPath  func(file string) (string, error)
+	ioutilTempDir func(dir, prefix string) (string, error)
+	logger        model.Logger
+	results       *BootstrapResults
+	tempDir       string
+}
+
+func newBootstrapper(
+	sess model.ExperimentSession, measurement *model.Measurement,
+	callbacks model.ExperimentCallbacks, binary string,
+) *bootstrapper {
+	if binary == "" {
+		binary = "tor"
+	}
+	return &bootstrapper{
+		beginning:     measurement.MeasurementStartTimeSaved,
+		binary:        binary,
+		callbacks:     callbacks,
+		dial:          new(net.Dialer).DialContext,
+		execCommand:   exec.CommandContext,
+		execLookPath:  exec.LookPath,
+		ioutilTempDir: ioutil.TempDir,
+		logger:        sess.Logger(),
+		results:       new(BootstrapResults),
+		tempDir:       sess.TempDir(),
+	}
+}
+
+// run bootstraps tor and fills b.results.
+func (b *bootstrapper) run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+	if err := b.do(ctx); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errBootstrapTimeout
+		}
+		b.results.Failure = setFailure(err)
+	}
+}
+
+func (b *bootstrapper) do(ctx context.Context) error {
+	path, err := b.execLookPath(b.binary)
+	if err != nil {
+		return err
+	}
+	datadir, err := b.ioutilTempDir(b.tempDir, "tor")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(datadir)
+	portfile := filepath.Join(datadir, "control-port")
+	cmd := b.execCommand(
+		ctx, path,
+		"DataDirectory", datadir,
+		"ControlPort", "auto",
+		"ControlPortWriteToFile", portfile,
+		"CookieAuthentication", "1",
+		"SocksPort", "auto",
+		"__OwningControllerProcess", strconv.Itoa(os.Getpid()),
+	)
+	start := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	b.logger.Infof("tor: started %s with pid %d", path, cmd.Process.Pid)
+	address, err := b.waitForControlPort(ctx, portfile)
+	if err != nil {
+		return err
+	}
+	conn, err := b.dial(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close() // unblock any pending read
+	}()
+	cookie, err := ioutil.ReadFile(filepath.Join(datadir, "control_auth_cookie"))
+	if err != nil {
+		return err
+	}
+	return b.follow(ctx, newControlConn(conn), cookie, start)
+}
+
+// waitForControlPort waits for tor to write the control port file.
+func (b *bootstrapper) waitForControlPort(
+	ctx context.Context, portfile string,
+) (string, error) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if data, err := ioutil.ReadFile(portfile); err == nil {
+			if address, err := parseControlPortFile(data); err == nil {
+				return address, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// follow authenticates and then follows bootstrap events until tor has
+// finished bootstrapping or the connection fails.
+func (b *bootstrapper) follow(
+	ctx context.Context, c *controlConn, cookie []byte, start time.Time,
+) error {
+	if _, err := c.command("AUTHENTICATE " + hex.EncodeToString(cookie)); err != nil {
+		return err
+	}
+	if _, err := c.command("TAKEOWNERSHIP"); err != nil {
+		return err
+	}
+	reply, err := c.command("GETINFO version")
+	if err != nil {
+		return err
+	}
+	b.results.TorVersion = strings.TrimPrefix(reply[0], "version=")
+	if _, err := c.command("SETEVENTS STATUS_CLIENT"); err != nil {
+		return err
+	}
+	reply, err = c.command("GETINFO status/bootstrap-phase")
+	if err != nil {
+		return err
+	}
+	if b.record(strings.TrimPrefix(reply[0], "status/bootstrap-phase=")) {
+		b.results.BootstrapTime = time.Since(start).Seconds()
+		return nil
+	}
+	for {
+		event, err := c.nextEvent()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(event, "STATUS_CLIENT ") {
+			continue
+		}
+		if b.record(strings.TrimPrefix(event, "STATUS_CLIENT ")) {
+			b.results.BootstrapTime = time.Since(start).Seconds()
+			return nil
+		}
+	}
+}
+
+// record records the bootstrap status, if any, and returns whether
+// the bootstrap is complete.
+func (b *bootstrapper) record(status string) bool {
+	progress, ok := parseBootstrapStatus(status)
+	if !ok {
+		return false
+	}
+	progress.T = time.Since(b.beginning).Seconds()
+	b.results.Progress = append(b.results.Progress, progress)
+	b.logger.Infof("tor: bootstrap: %d%% %s", progress.Percentage, progress.Summary)
+	b.callbacks.OnProgress(float64(progress.Percentage)/100.0, fmt.Sprintf(
+		"tor: bootstrap: %s", progress.Summary,
+	))
+	return progress.Percentage >= 100
+}
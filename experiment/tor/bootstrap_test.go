@@ -0,0 +1,247 @@
+package tor
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitParseBootstrapStatus(t *testing.T) {
+	var inputs = []struct {
+		input    string
+		ok       bool
+		expected BootstrapProgress
+	}{{
+		input: `NOTICE BOOTSTRAP PROGRESS=10 TAG=conn_done SUMMARY="Connected to a relay"`,
+		ok:    true,
+		expected: BootstrapProgress{
+			Percentage: 10, Summary: "Connected to a relay", Tag: "conn_done",
+		},
+	}, {
+		input: `WARN BOOTSTRAP PROGRESS=5 TAG=conn SUMMARY="Connecting to a relay" WARNING="Connection refused \"x\"" REASON=CONNECTREFUSED COUNT=1`,
+		ok:    true,
+		expected: BootstrapProgress{
+			Percentage: 5, Summary: "Connecting to a relay", Tag: "conn",
+			Warning: `Connection refused "x"`,
+		},
+	}, {
+		input: `NOTICE CIRCUIT_ESTABLISHED`,
+	}, {
+		input: `NOTICE BOOTSTRAP PROGRESS=antani`,
+	}}
+	for _, input := range inputs {
+		progress, ok := parseBootstrapStatus(input.input)
+		if ok != input.ok {
+			t.Fatalf("%s: unexpected ok value", input.input)
+		}
+		if progress != input.expected {
+			t.Fatalf("%s: unexpected progress: %+v", input.input, progress)
+		}
+	}
+}
+
+func TestUnitParseControlPortFile(t *testing.T) {
+	address, err := parseControlPortFile([]byte("PORT=127.0.0.1:9051\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if address != "127.0.0.1:9051" {
+		t.Fatal("unexpected address")
+	}
+	if _, err := parseControlPortFile([]byte("antani")); !errors.Is(err, errControlPortFile) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+// fakeControlPort emulates tor's control port.
+func fakeControlPort(conn net.Conn, authReply string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		var reply string
+		switch cmd := strings.TrimSpace(line); {
+		case strings.HasPrefix(cmd, "AUTHENTICATE "):
+			reply = authReply
+		case cmd == "TAKEOWNERSHIP":
+			reply = "250 OK\r\n"
+		case cmd == "GETINFO version":
+			reply = "250-version=0.4.2.7\r\n250 OK\r\n"
+		case cmd == "SETEVENTS STATUS_CLIENT":
+			reply = "250 OK\r\n" +
+				"650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=5 TAG=conn SUMMARY=\"Connecting to a relay\"\r\n"
+		case cmd == "GETINFO status/bootstrap-phase":
+			reply = "250-status/bootstrap-phase=NOTICE BOOTSTRAP PROGRESS=0 TAG=starting SUMMARY=\"Starting\"\r\n" +
+				"250 OK\r\n" +
+				"650 STATUS_CLIENT NOTICE CIRCUIT_ESTABLISHED\r\n" +
+				"650 STREAM 1 NEW 0 example.org:80\r\n" +
+				"650 STATUS_CLIENT NOTICE BOOTSTRAP PROGRESS=100 TAG=done SUMMARY=\"Done\"\r\n"
+		default:
+			reply = "510 Unrecognized command\r\n"
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+func newTestingBootstrapper() *bootstrapper {
+	return newBootstrapper(
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+		"",
+	)
+}
+
+func TestUnitBootstrapperFollowGood(t *testing.T) {
+	client, server := net.Pipe()
+	go fakeControlPort(server, "250 OK\r\n")
+	defer client.Close()
+	b := newTestingBootstrapper()
+	err := b.follow(context.Background(), newControlConn(client), []byte{1, 2}, b.beginning)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.results.TorVersion != "0.4.2.7" {
+		t.Fatal("unexpected tor version")
+	}
+	if len(b.results.Progress) != 3 {
+		t.Fatalf("unexpected number of progress entries: %+v", b.results.Progress)
+	}
+	for idx, percentage := range []int64{0, 5, 100} {
+		if b.results.Progress[idx].Percentage != percentage {
+			t.Fatal("unexpected percentage")
+		}
+	}
+	if b.results.BootstrapTime <= 0 {
+		t.Fatal("unexpected bootstrap time")
+	}
+}
+
+func TestUnitBootstrapperFollowAuthFailure(t *testing.T) {
+	client, server := net.Pipe()
+	go fakeControlPort(server, "515 Authentication failed\r\n")
+	defer client.Close()
+	b := newTestingBootstrapper()
+	err := b.follow(context.Background(), newControlConn(client), nil, b.beginning)
+	if !errors.Is(err, errControlReply) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitBootstrapperLookPathError(t *testing.T) {
+	b := newTestingBootstrapper()
+	b.binary = "antani-tor-binary-that-does-not-exist"
+	b.run(context.Background())
+	if b.results.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if !strings.Contains(*b.results.Failure, "executable file not found") {
+		t.Fatal("not the failure we expected")
+	}
+}
+
+func TestUnitBootstrapperTempDirError(t *testing.T) {
+	b := newTestingBootstrapper()
+	expected := errors.New("mocked error")
+	b.execLookPath = func(file string) (string, error) {
+		return file, nil
+	}
+	b.ioutilTempDir = func(dir, prefix string) (string, error) {
+		return "", expected
+	}
+	b.run(context.Background())
+	if b.results.Failure == nil || *b.results.Failure != expected.Error() {
+		t.Fatal("not the failure we expected")
+	}
+}
+
+func TestUnitBootstrapperGood(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			fakeControlPort(conn, "250 OK\r\n")
+		}
+	}()
+	b := newTestingBootstrapper()
+	b.execLookPath = func(file string) (string, error) {
+		return os.Args[0], nil
+	}
+	b.execCommand = func(ctx context.Context, name string, arg ...string) *exec.Cmd {
+		// Run the test binary without any test, so it exits immediately
+		return exec.CommandContext(ctx, name, "-test.run=^$")
+	}
+	b.ioutilTempDir = func(dir, prefix string) (string, error) {
+		// Pretend tor already wrote the control port and cookie files
+		datadir, err := ioutil.TempDir(dir, prefix)
+		if err != nil {
+			return "", err
+		}
+		data := []byte("PORT=" + listener.Addr().String() + "\n")
+		err = ioutil.WriteFile(filepath.Join(datadir, "control-port"), data, 0600)
+		if err != nil {
+			return "", err
+		}
+		cookie := filepath.Join(datadir, "control_auth_cookie")
+		return datadir, ioutil.WriteFile(cookie, make([]byte, 32), 0600)
+	}
+	b.run(context.Background())
+	if b.results.Failure != nil {
+		t.Fatal(*b.results.Failure)
+	}
+	if len(b.results.Progress) != 3 || b.results.TorVersion != "0.4.2.7" {
+		t.Fatal("unexpected results")
+	}
+}
+
+func TestUnitBootstrapperControlPortCanceled(t *testing.T) {
+	b := newTestingBootstrapper()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := b.waitForControlPort(ctx, "testdata/nonexistent-control-port")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasurerBootstrap(t *testing.T) {
+	measurer := newMeasurer(Config{Bootstrap: true, TorBinaryPath: "antani"})
+	measurement := new(model.Measurement)
+	err := measurer.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.Bootstrap == nil || tk.Bootstrap.Failure == nil {
+		t.Fatal("expected a bootstrap failure here")
+	}
+	if len(tk.Targets) != 0 {
+		t.Fatal("expected no targets here")
+	}
+}
@@ -1,5 +1,9 @@
 // Package tor contains the tor experiment.
 //
+// By default we measure the reachability of the targets provided by
+// the orchestra. When the Bootstrap option is set, we instead launch a
+// tor binary and record how its bootstrap progresses.
+//
 // Spec: https://github.com/ooni/spec/blob/master/nettests/ts-023-tor.md
 package tor
 
@@ -26,11 +30,14 @@ const (
 	testName = "tor"
 
 	// testVersion is th version of this experiment
-	testVersion = "0.1.0"
+	testVersion = "0.2.0"
 )
 
 // Config contains the experiment config.
-type Config struct{}
+type Config struct {
+	Bootstrap     bool   `ooni:"Bootstrap tor rather than measuring targets"`
+	TorBinaryPath string `ooni:"Path to the tor binary (by default we search tor in PATH)"`
+}
 
 // Summary contains a summary of what happened.
 type Summary struct {
@@ -65,9 +72,10 @@ func (tr *TargetResults) fillSummary() {
 	case "dir_port":
 		// The UI currently doesn't care about this protocol
 		// as long as drawing a table is concerned.
-	case "obfs4":
-		// We currently only perform an OBFS4 handshake, hence
-		// the final Failure is the handshake result
+	case "obfs4", "meek", "snowflake":
+		// We currently only perform an OBFS4 handshake, a meek poll
+		// or a snowflake rendezvous, hence the final Failure is
+		// the handshake result
 		tr.Summary["handshake"] = Summary{
 			Failure: tr.Failure,
 		}
@@ -83,14 +91,19 @@ func (tr *TargetResults) fillSummary() {
 
 // TestKeys contains tor test keys.
 type TestKeys struct {
+	Bootstrap               *BootstrapResults        `json:"bootstrap,omitempty"`
 	DirPortTotal            int64                    `json:"dir_port_total"`
 	DirPortAccessible       int64                    `json:"dir_port_accessible"`
+	MeekTotal               int64                    `json:"meek_total"`
+	MeekAccessible          int64                    `json:"meek_accessible"`
 	OBFS4Total              int64                    `json:"obfs4_total"`
 	OBFS4Accessible         int64                    `json:"obfs4_accessible"`
 	ORPortDirauthTotal      int64                    `json:"or_port_dirauth_total"`
 	ORPortDirauthAccessible int64                    `json:"or_port_dirauth_accessible"`
 	ORPortTotal             int64                    `json:"or_port_total"`
 	ORPortAccessible        int64                    `json:"or_port_accessible"`
+	SnowflakeTotal          int64                    `json:"snowflake_total"`
+	SnowflakeAccessible     int64                    `json:"snowflake_accessible"`
 	Targets                 map[string]TargetResults `json:"targets"`
 }
 
@@ -102,6 +115,11 @@ func (tk *TestKeys) fillToplevelKeys() {
 			if value.Failure == nil {
 				tk.DirPortAccessible++
 			}
+		case "meek":
+			tk.MeekTotal++
+			if value.Failure == nil {
+				tk.MeekAccessible++
+			}
 		case "obfs4":
 			tk.OBFS4Total++
 			if value.Failure == nil {
@@ -117,6 +135,11 @@ func (tk *TestKeys) fillToplevelKeys() {
 			if value.Failure == nil {
 				tk.ORPortAccessible++
 			}
+		case "snowflake":
+			tk.SnowflakeTotal++
+			if value.Failure == nil {
+				tk.SnowflakeAccessible++
+			}
 		}
 	}
 }
@@ -153,6 +176,10 @@ func (m *measurer) Run(
 	measurement *model.Measurement,
 	callbacks model.ExperimentCallbacks,
 ) error {
+	if m.config.Bootstrap {
+		m.bootstrap(ctx, sess, measurement, callbacks)
+		return nil
+	}
 	targets, err := m.gimmeTargets(ctx, sess)
 	if err != nil {
 		return err
@@ -165,6 +192,20 @@ func (m *measurer) Run(
 	return nil
 }
 
+func (m *measurer) bootstrap(
+	ctx context.Context,
+	sess model.ExperimentSession,
+	measurement *model.Measurement,
+	callbacks model.ExperimentCallbacks,
+) {
+	b := newBootstrapper(sess, measurement, callbacks, m.config.TorBinaryPath)
+	b.run(ctx)
+	measurement.TestKeys = &TestKeys{
+		Bootstrap: b.results,
+		Targets:   make(map[string]TargetResults),
+	}
+}
+
 func (m *measurer) gimmeTargets(
 	ctx context.Context, sess model.ExperimentSession,
 ) (map[string]model.TorTarget, error) {
@@ -312,6 +353,14 @@ func (rc *resultsCollector) defaultFlexibleConnect(
 			Handler:            netxlogger.NewHandler(rc.sess.Logger()),
 		})
 		tk, err = r.TestKeys, r.Error
+	case "meek":
+		r := oonitemplates.MeekConnect(ctx, oonitemplates.MeekConnectConfig{
+			Beginning: rc.measurement.MeasurementStartTimeSaved,
+			Handler:   netxlogger.NewHandler(rc.sess.Logger()),
+			Params:    target.Params,
+			UserAgent: httpheader.RandomUserAgent(),
+		})
+		tk, err = r.TestKeys, r.Error
 	case "obfs4":
 		r := oonitemplates.OBFS4Connect(ctx, oonitemplates.OBFS4ConnectConfig{
 			Address:      target.Address,
@@ -321,6 +370,14 @@ func (rc *resultsCollector) defaultFlexibleConnect(
 			StateBaseDir: rc.sess.TempDir(),
 		})
 		tk, err = r.TestKeys, r.Error
+	case "snowflake":
+		r := oonitemplates.SnowflakeRendezvous(ctx, oonitemplates.SnowflakeRendezvousConfig{
+			Beginning: rc.measurement.MeasurementStartTimeSaved,
+			Handler:   netxlogger.NewHandler(rc.sess.Logger()),
+			Params:    target.Params,
+			UserAgent: httpheader.RandomUserAgent(),
+		})
+		tk, err = r.TestKeys, r.Error
 	default:
 		r := oonitemplates.TCPConnect(ctx, oonitemplates.TCPConnectConfig{
 			Address:   target.Address,
@@ -20,7 +20,7 @@ func TestUnitNewExperimentMeasurer(t *testing.T) {
 	if measurer.ExperimentName() != "tor" {
 		t.Fatal("unexpected name")
 	}
-	if measurer.ExperimentVersion() != "0.1.0" {
+	if measurer.ExperimentVersion() != "0.2.0" {
 		t.Fatal("unexpected version")
 	}
 }
@@ -150,6 +150,22 @@ var staticTestingTargets = []model.TorTarget{
 		Address:  "1.1.1.1:80",
 		Protocol: "tcp",
 	},
+	model.TorTarget{
+		Address: "192.0.2.2:2",
+		Params: map[string][]string{
+			"url":   []string{"https://meek.azureedge.net/"},
+			"front": []string{"ajax.aspnetcdn.com"},
+		},
+		Protocol: "meek",
+	},
+	model.TorTarget{
+		Address: "192.0.2.3:1",
+		Params: map[string][]string{
+			"url":   []string{"https://snowflake-broker.azureedge.net/"},
+			"front": []string{"ajax.aspnetcdn.com"},
+		},
+		Protocol: "snowflake",
+	},
 }
 
 func TestUnitMeasurerMeasureTargetsNoInput(t *testing.T) {
@@ -382,6 +398,52 @@ func TestUnitDefautFlexibleConnectDefault(t *testing.T) {
 	}
 }
 
+func TestUnitDefautFlexibleConnectMeek(t *testing.T) {
+	rc := newResultsCollector(
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tk, err := rc.defaultFlexibleConnect(ctx, staticTestingTargets[4])
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if !strings.HasSuffix(err.Error(), "operation was canceled") &&
+		!strings.HasSuffix(err.Error(), "context canceled") {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if tk.HTTPRequests == nil {
+		t.Fatal("expected HTTP data here")
+	}
+}
+
+func TestUnitDefautFlexibleConnectSnowflake(t *testing.T) {
+	rc := newResultsCollector(
+		&mockable.ExperimentSession{
+			MockableLogger: log.Log,
+		},
+		new(model.Measurement),
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tk, err := rc.defaultFlexibleConnect(ctx, staticTestingTargets[5])
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if !strings.HasSuffix(err.Error(), "operation was canceled") &&
+		!strings.HasSuffix(err.Error(), "context canceled") {
+		t.Fatalf("not the error we expected: %+v", err)
+	}
+	if tk.HTTPRequests == nil {
+		t.Fatal("expected HTTP data here")
+	}
+}
+
 func TestUnitErrString(t *testing.T) {
 	if errString(nil) != "success" {
 		t.Fatal("not working with nil")
@@ -418,25 +480,27 @@ func TestUnitSummary(t *testing.T) {
 		}
 	})
 
-	t.Run("for OBFS4", func(t *testing.T) {
-		tr := new(TargetResults)
-		tr.TCPConnect = append(tr.TCPConnect, oonidatamodel.TCPConnectEntry{
-			Status: oonidatamodel.TCPConnectStatus{
-				Success: true,
-			},
-		})
-		failure := "mocked_error"
-		tr.TargetProtocol = "obfs4"
-		tr.Failure = &failure
-		tr.fillSummary()
-		if len(tr.Summary) != 2 {
-			t.Fatal("cannot find expected entry")
-		}
-		if tr.Summary["connect"].Failure != nil {
-			t.Fatal("invalid failure")
-		}
-		if *tr.Summary["handshake"].Failure != failure {
-			t.Fatal("invalid failure")
+	t.Run("for OBFS4, meek and snowflake", func(t *testing.T) {
+		for _, targetProtocol := range []string{"obfs4", "meek", "snowflake"} {
+			tr := new(TargetResults)
+			tr.TCPConnect = append(tr.TCPConnect, oonidatamodel.TCPConnectEntry{
+				Status: oonidatamodel.TCPConnectStatus{
+					Success: true,
+				},
+			})
+			failure := "mocked_error"
+			tr.TargetProtocol = targetProtocol
+			tr.Failure = &failure
+			tr.fillSummary()
+			if len(tr.Summary) != 2 {
+				t.Fatal("cannot find expected entry")
+			}
+			if tr.Summary["connect"].Failure != nil {
+				t.Fatal("invalid failure")
+			}
+			if *tr.Summary["handshake"].Failure != failure {
+				t.Fatal("invalid failure")
+			}
 		}
 	})
 
@@ -489,6 +553,24 @@ func TestUnitFillToplevelKeys(t *testing.T) {
 	}
 }
 
+func TestUnitFillToplevelKeysMeekAndSnowflake(t *testing.T) {
+	failure := "mocked_error"
+	tk := new(TestKeys)
+	tk.Targets = map[string]TargetResults{
+		"meek-ok":          TargetResults{TargetProtocol: "meek"},
+		"meek-failed":      TargetResults{TargetProtocol: "meek", Failure: &failure},
+		"snowflake-ok":     TargetResults{TargetProtocol: "snowflake"},
+		"snowflake-failed": TargetResults{TargetProtocol: "snowflake", Failure: &failure},
+	}
+	tk.fillToplevelKeys()
+	if tk.MeekTotal != 2 || tk.MeekAccessible != 1 {
+		t.Fatal("unexpected meek totals")
+	}
+	if tk.SnowflakeTotal != 2 || tk.SnowflakeAccessible != 1 {
+		t.Fatal("unexpected snowflake totals")
+	}
+}
+
 func newsession() model.ExperimentSession {
 	return &mockable.ExperimentSession{MockableLogger: log.Log}
 }
@@ -0,0 +1,173 @@
+// Package urlgetter implements a nettest that fetches a URL. This is not
+// an official OONI nettest, but rather a tool to perform measurements
+// that can be tuned at runtime using the ExperimentBuilder options.
+package urlgetter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
+	"github.com/ooni/probe-engine/model"
+)
+
+const (
+	testName    = "urlgetter"
+	testVersion = "0.0.1"
+)
+
+var (
+	errNoInput              = errors.New("urlgetter: no input provided")
+	errInputIsNotAnURL      = errors.New("urlgetter: input is not an URL")
+	errTLSOnlyAndTCPOnly    = errors.New("urlgetter: cannot use both TLSOnly and TCPOnly")
+	errUnsupportedURLScheme = errors.New("urlgetter: unsupported URL scheme")
+)
+
+// Config contains the experiment's configuration.
+type Config struct {
+	DNSAddress  string `ooni:"Address of the DNS resolver (depends on DNSNetwork)"`
+	DNSNetwork  string `ooni:"DNS resolver network: system, udp, tcp, dot or doh"`
+	Method      string `ooni:"HTTP method to use (default: GET)"`
+	NoTLSVerify bool   `ooni:"Whether to disable TLS certificate verification"`
+	SNI         string `ooni:"Force using the specified SNI"`
+	TCPOnly     bool   `ooni:"Only connect to the endpoint using TCP"`
+	TLSOnly     bool   `ooni:"Only perform the TLS handshake with the endpoint"`
+}
+
+// TestKeys contains the experiment's result.
+type TestKeys struct {
+	Agent         string                          `json:"agent"`
+	Failure       *string                         `json:"failure"`
+	NetworkEvents oonidatamodel.NetworkEventsList `json:"network_events"`
+	Queries       oonidatamodel.DNSQueriesList    `json:"queries"`
+	Requests      oonidatamodel.RequestList       `json:"requests"`
+	SNI           string                          `json:"sni,omitempty"`
+	TCPConnect    oonidatamodel.TCPConnectList    `json:"tcp_connect"`
+	TLSHandshakes oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
+}
+
+type measurer struct {
+	config Config
+}
+
+func (m measurer) ExperimentName() string {
+	return testName
+}
+
+func (m measurer) ExperimentVersion() string {
+	return testVersion
+}
+
+// endpoint returns the TCP endpoint corresponding to URL.
+func endpoint(URL *url.URL) (string, error) {
+	if port := URL.Port(); port != "" {
+		return net.JoinHostPort(URL.Hostname(), port), nil
+	}
+	switch URL.Scheme {
+	case "http":
+		return net.JoinHostPort(URL.Hostname(), "80"), nil
+	case "https":
+		return net.JoinHostPort(URL.Hostname(), "443"), nil
+	default:
+		return "", errUnsupportedURLScheme
+	}
+}
+
+// measure performs the measurement selected by the config and returns
+// the results of the operation as well as the error that occurred.
+func (m measurer) measure(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, URL *url.URL, tk *TestKeys,
+) (oonitemplates.Results, error) {
+	if m.config.TCPOnly || m.config.TLSOnly {
+		address, err := endpoint(URL)
+		if err != nil {
+			return oonitemplates.Results{}, err
+		}
+		tk.Agent = "agent"
+		if m.config.TCPOnly {
+			r := oonitemplates.TCPConnect(ctx, oonitemplates.TCPConnectConfig{
+				Address:          address,
+				Beginning:        measurement.MeasurementStartTimeSaved,
+				DNSServerAddress: m.config.DNSAddress,
+				DNSServerNetwork: m.config.DNSNetwork,
+				Handler:          netxlogger.NewHandler(sess.Logger()),
+			})
+			return r.TestKeys, r.Error
+		}
+		tk.SNI = m.config.SNI
+		if tk.SNI == "" {
+			tk.SNI = URL.Hostname()
+		}
+		r := oonitemplates.TLSConnect(ctx, oonitemplates.TLSConnectConfig{
+			Address:            address,
+			Beginning:          measurement.MeasurementStartTimeSaved,
+			DNSServerAddress:   m.config.DNSAddress,
+			DNSServerNetwork:   m.config.DNSNetwork,
+			Handler:            netxlogger.NewHandler(sess.Logger()),
+			InsecureSkipVerify: m.config.NoTLSVerify,
+			SNI:                tk.SNI,
+		})
+		return r.TestKeys, r.Error
+	}
+	tk.Agent = "redirect"
+	tk.SNI = m.config.SNI
+	method := m.config.Method
+	if method == "" {
+		method = "GET"
+	}
+	r := oonitemplates.HTTPDo(ctx, oonitemplates.HTTPDoConfig{
+		Beginning:          measurement.MeasurementStartTimeSaved,
+		DNSServerAddress:   m.config.DNSAddress,
+		DNSServerNetwork:   m.config.DNSNetwork,
+		Handler:            netxlogger.NewHandler(sess.Logger()),
+		InsecureSkipVerify: m.config.NoTLSVerify,
+		Method:             method,
+		SNI:                m.config.SNI,
+		URL:                URL.String(),
+		UserAgent:          sess.UserAgent(),
+	})
+	return r.TestKeys, r.Error
+}
+
+func (m measurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	if measurement.Input == "" {
+		return errNoInput
+	}
+	URL, err := url.Parse(measurement.Input)
+	if err != nil || URL.Scheme == "" || URL.Host == "" {
+		return errInputIsNotAnURL
+	}
+	if m.config.TCPOnly && m.config.TLSOnly {
+		return errTLSOnlyAndTCPOnly
+	}
+	results, err := m.measure(ctx, sess, measurement, URL, tk)
+	tk.NetworkEvents = oonidatamodel.NewNetworkEventsList(results)
+	tk.Queries = oonidatamodel.NewDNSQueriesList(results)
+	tk.Requests = oonidatamodel.NewRequestList(results)
+	tk.TCPConnect = oonidatamodel.NewTCPConnectList(results)
+	tk.TLSHandshakes = oonidatamodel.NewTLSHandshakesList(results)
+	callbacks.OnDataUsage(
+		float64(results.ReceivedBytes)/1024.0, // downloaded
+		float64(results.SentBytes)/1024.0,     // uploaded
+	)
+	if err != nil {
+		s := err.Error()
+		tk.Failure = &s
+	}
+	return nil
+}
+
+// NewExperimentMeasurer creates a new ExperimentMeasurer.
+func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
+	return measurer{config: config}
+}
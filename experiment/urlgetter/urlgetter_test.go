@@ -0,0 +1,181 @@
+package urlgetter
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/model"
+)
+
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "urlgetter" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.0.1" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func run(config Config, input string) (*TestKeys, error) {
+	measurement := &model.Measurement{Input: input}
+	m := NewExperimentMeasurer(config)
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
+	return measurement.TestKeys.(*TestKeys), err
+}
+
+func TestUnitRunNoInput(t *testing.T) {
+	_, err := run(Config{}, "")
+	if !errors.Is(err, errNoInput) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunInputIsNotAnURL(t *testing.T) {
+	_, err := run(Config{}, "\t")
+	if !errors.Is(err, errInputIsNotAnURL) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunTLSOnlyAndTCPOnly(t *testing.T) {
+	_, err := run(Config{TCPOnly: true, TLSOnly: true}, "https://x.org/")
+	if !errors.Is(err, errTLSOnlyAndTCPOnly) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitRunUnsupportedScheme(t *testing.T) {
+	tk, err := run(Config{TCPOnly: true}, "ftp://x.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure == nil || *tk.Failure != errUnsupportedURLScheme.Error() {
+		t.Fatal("not the failure we expected")
+	}
+}
+
+func TestUnitRunInvalidDNSNetwork(t *testing.T) {
+	tk, err := run(Config{DNSNetwork: "antani"}, "https://x.org/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+}
+
+func TestUnitRunHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "HEAD" {
+				w.WriteHeader(400)
+			}
+		},
+	))
+	defer server.Close()
+	tk, err := run(Config{Method: "HEAD"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Agent != "redirect" {
+		t.Fatal("unexpected agent")
+	}
+	if len(tk.Requests) != 1 || tk.Requests[0].Response.Code != 200 {
+		t.Fatal("unexpected requests")
+	}
+	if len(tk.TCPConnect) != 1 {
+		t.Fatal("unexpected tcp connect")
+	}
+}
+
+func TestUnitRunTCPOnly(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	tk, err := run(Config{TCPOnly: true}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.Agent != "agent" {
+		t.Fatal("unexpected agent")
+	}
+	if len(tk.Requests) != 0 || len(tk.TCPConnect) != 1 {
+		t.Fatal("unexpected results")
+	}
+}
+
+func TestUnitRunTLSOnly(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	tk, err := run(Config{NoTLSVerify: true, TLSOnly: true}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure != nil {
+		t.Fatal(*tk.Failure)
+	}
+	if tk.SNI != "127.0.0.1" {
+		t.Fatal("unexpected SNI")
+	}
+	if len(tk.TLSHandshakes) != 1 {
+		t.Fatal("unexpected TLS handshakes")
+	}
+}
+
+func TestUnitRunTLSOnlyVerifyFailure(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	tk, err := run(Config{SNI: "example.com", TLSOnly: true}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tk.Failure == nil {
+		t.Fatal("expected a failure here")
+	}
+	if tk.SNI != "example.com" {
+		t.Fatal("unexpected SNI")
+	}
+}
+
+func TestUnitEndpoint(t *testing.T) {
+	var inputs = []struct {
+		URL    string
+		expect string
+	}{
+		{"http://x.org/", "x.org:80"},
+		{"https://x.org/", "x.org:443"},
+		{"https://x.org:8443/", "x.org:8443"},
+		{"https://[::1]/", "[::1]:443"},
+	}
+	for _, input := range inputs {
+		URL, err := url.Parse(input.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		address, err := endpoint(URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address != input.expect {
+			t.Fatalf("expected %s, got %s", input.expect, address)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package web_connectivity
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ooni/probe-engine/geoiplookup/mmdblookup"
+	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+const (
+	// dnsConsistent means the control and the probe agree.
+	dnsConsistent = "consistent"
+
+	// dnsInconsistent means the control and the probe disagree.
+	dnsInconsistent = "inconsistent"
+
+	// controlNXDOMAIN is the failure string with which the control
+	// reports that the domain does not exist.
+	controlNXDOMAIN = "dns_name_error"
+)
+
+// blockingReason values for the blocking key.
+const (
+	blockingDNS         = "dns"
+	blockingTCPIP       = "tcp_ip"
+	blockingHTTPFailure = "http-failure"
+	blockingHTTPDiff    = "http-diff"
+)
+
+// analyze fills the top-level keys by comparing the measurement
+// performed by the probe with the control measurement.
+func (tk *TestKeys) analyze(sess model.ExperimentSession) {
+	if tk.ControlFailure != nil {
+		return // cannot say anything without a working control
+	}
+	tk.DNSConsistency = tk.dnsAnalysis(func(ip string) uint {
+		asn, _, _ := mmdblookup.LookupASN(sess.ASNDatabasePath(), ip, sess.Logger())
+		return asn
+	})
+	tk.tcpConnectAnalysis()
+	if tk.HTTPExperimentFailure == nil && tk.DNSExperimentFailure == nil {
+		tk.httpAnalysis()
+	}
+	tk.blockingAnalysis()
+}
+
+// isNXDOMAIN returns whether the failure means the domain does not exist.
+func isNXDOMAIN(failure *string) bool {
+	return failure != nil && (*failure == modelx.FailureDNSNXDOMAINError ||
+		*failure == controlNXDOMAIN)
+}
+
+// dnsAnalysis compares the addresses resolved by the probe with
+// the ones resolved by the control and returns the DNS consistency.
+// The lookupASN function maps an IP address to its ASN.
+func (tk *TestKeys) dnsAnalysis(lookupASN func(ip string) uint) string {
+	if tk.DNSExperimentFailure != nil || tk.Control.DNS.Failure != nil {
+		if isNXDOMAIN(tk.DNSExperimentFailure) && isNXDOMAIN(tk.Control.DNS.Failure) {
+			return dnsConsistent
+		}
+		return dnsInconsistent
+	}
+	ctrl := make(map[string]bool)
+	for _, addr := range tk.Control.DNS.Addrs {
+		ctrl[addr] = true
+	}
+	for _, addr := range tk.addresses {
+		if ctrl[addr] {
+			return dnsConsistent
+		}
+	}
+	for _, addr := range tk.addresses {
+		if isBogon(addr) {
+			return dnsInconsistent
+		}
+	}
+	ctrlASNs := make(map[uint]bool)
+	for _, addr := range tk.Control.DNS.Addrs {
+		if asn := lookupASN(addr); asn != 0 {
+			ctrlASNs[asn] = true
+		}
+	}
+	for _, addr := range tk.addresses {
+		if asn := lookupASN(addr); asn != 0 && ctrlASNs[asn] {
+			return dnsConsistent
+		}
+	}
+	return dnsInconsistent
+}
+
+var privateNetworks []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+		"::1/128", "fe80::/10", "fc00::/7",
+	} {
+		_, block, _ := net.ParseCIDR(cidr)
+		privateNetworks = append(privateNetworks, block)
+	}
+}
+
+func isBogon(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return true
+	}
+	for _, block := range privateNetworks {
+		if block.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// tcpConnectAnalysis marks as blocked the endpoints to which the probe
+// could not connect while the control could.
+func (tk *TestKeys) tcpConnectAnalysis() {
+	for idx, entry := range tk.TCPConnect {
+		endpoint := net.JoinHostPort(entry.IP, strconv.Itoa(entry.Port))
+		ctrl, found := tk.Control.TCPConnect[endpoint]
+		if !found {
+			continue
+		}
+		blocked := !entry.Status.Success && ctrl.Status
+		tk.TCPConnect[idx].Status.Blocked = &blocked
+	}
+}
+
+// commonHeaders are headers that are too common to be
+// useful to determine whether two responses match.
+var commonHeaders = map[string]bool{
+	"date":                      true,
+	"content-type":              true,
+	"server":                    true,
+	"cache-control":             true,
+	"vary":                      true,
+	"set-cookie":                true,
+	"location":                  true,
+	"expires":                   true,
+	"x-powered-by":              true,
+	"content-encoding":          true,
+	"last-modified":             true,
+	"accept-ranges":             true,
+	"pragma":                    true,
+	"x-frame-options":           true,
+	"etag":                      true,
+	"x-content-type-options":    true,
+	"age":                       true,
+	"via":                       true,
+	"p3p":                       true,
+	"x-xss-protection":          true,
+	"content-language":          true,
+	"cf-ray":                    true,
+	"strict-transport-security": true,
+	"link":                      true,
+	"x-varnish":                 true,
+}
+
+var titleRegexp = regexp.MustCompile(`(?i)<title>([^<]{1,128})</title>`)
+
+// extractTitle returns the title of a webpage or an empty string.
+func extractTitle(body []byte) string {
+	m := titleRegexp.FindSubmatch(body)
+	if len(m) < 2 {
+		return ""
+	}
+	return string(m[1])
+}
+
+// httpAnalysis compares the HTTP response received by the probe
+// with the one received by the control.
+func (tk *TestKeys) httpAnalysis() {
+	ctrl := tk.Control.HTTPRequest
+	if ctrl.Failure != nil {
+		return
+	}
+	// body length
+	explen, ctrllen := int64(len(tk.bodySnap)), ctrl.BodyLength
+	switch {
+	case explen == 0 && ctrllen == 0:
+		tk.BodyProportion = 1
+	case explen == 0 || ctrllen <= 0:
+		tk.BodyProportion = 0
+	case explen >= ctrllen:
+		tk.BodyProportion = float64(ctrllen) / float64(explen)
+	default:
+		tk.BodyProportion = float64(explen) / float64(ctrllen)
+	}
+	bodyLengthMatch := tk.BodyProportion > 0.7
+	tk.BodyLengthMatch = &bodyLengthMatch
+	// status code
+	statusCodeMatch := tk.status == ctrl.StatusCode
+	tk.StatusCodeMatch = &statusCodeMatch
+	// headers
+	exp, control := make(map[string]bool), make(map[string]bool)
+	for key := range tk.headers {
+		exp[strings.ToLower(key)] = true
+	}
+	for key := range ctrl.Headers {
+		control[strings.ToLower(key)] = true
+	}
+	headersMatch := true
+	for key := range control {
+		if !exp[key] && !commonHeaders[key] {
+			headersMatch = false
+			break
+		}
+	}
+	tk.HeadersMatch = &headersMatch
+	// title
+	expwords := strings.Fields(extractTitle(tk.bodySnap))
+	ctrlwords := strings.Fields(ctrl.Title)
+	if len(expwords) > 0 && len(ctrlwords) > 0 && len(expwords[0]) >= 5 {
+		titleMatch := expwords[0] == ctrlwords[0]
+		tk.TitleMatch = &titleMatch
+	}
+}
+
+// blockingAnalysis sets the Accessible and Blocking keys.
+func (tk *TestKeys) blockingAnalysis() {
+	var (
+		accessible   bool
+		inconsistent = tk.DNSConsistency == dnsInconsistent
+	)
+	// reason returns the reason but gives precedence to DNS inconsistency
+	// because that is likely to be the root cause of the issue.
+	reason := func(r string) interface{} {
+		if inconsistent {
+			return blockingDNS
+		}
+		return r
+	}
+	switch {
+	case tk.DNSExperimentFailure != nil:
+		tk.Accessible = &accessible
+		if tk.DNSConsistency == dnsConsistent {
+			tk.Blocking = false // the website is down
+			return
+		}
+		tk.Blocking = blockingDNS
+	case tk.tcpConnectBlocked():
+		tk.Accessible = &accessible
+		tk.Blocking = reason(blockingTCPIP)
+	case tk.HTTPExperimentFailure != nil:
+		tk.Accessible = &accessible
+		if tk.Control.HTTPRequest.Failure != nil {
+			tk.Blocking = false // the website is down
+			return
+		}
+		tk.Blocking = reason(blockingHTTPFailure)
+	case tk.StatusCodeMatch != nil && *tk.StatusCodeMatch && (isTrue(tk.BodyLengthMatch) ||
+		isTrue(tk.HeadersMatch) || isTrue(tk.TitleMatch)):
+		accessible = true
+		tk.Accessible = &accessible
+		tk.Blocking = false
+	default:
+		tk.Accessible = &accessible
+		tk.Blocking = reason(blockingHTTPDiff)
+	}
+}
+
+// tcpConnectBlocked returns true when we could not connect to
+// any endpoint while the control could connect to at least one.
+func (tk *TestKeys) tcpConnectBlocked() bool {
+	if len(tk.TCPConnect) <= 0 {
+		return false
+	}
+	var anyBlocked bool
+	for _, entry := range tk.TCPConnect {
+		if entry.Status.Success {
+			return false
+		}
+		anyBlocked = anyBlocked || isTrue(entry.Status.Blocked)
+	}
+	return anyBlocked
+}
+
+func isTrue(v *bool) bool {
+	return v != nil && *v
+}
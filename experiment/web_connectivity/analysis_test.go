@@ -0,0 +1,181 @@
+package web_connectivity
+
+import (
+	"testing"
+
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func fakeASN(ip string) uint {
+	return map[string]uint{
+		"93.184.216.34": 15133,
+		"93.184.216.35": 15133,
+	}[ip]
+}
+
+func TestUnitDNSAnalysisSameAddresses(t *testing.T) {
+	tk := &TestKeys{addresses: []string{"93.184.216.34"}}
+	tk.Control.DNS.Addrs = []string{"93.184.216.34"}
+	if tk.dnsAnalysis(fakeASN) != dnsConsistent {
+		t.Fatal("expected consistent")
+	}
+}
+
+func TestUnitDNSAnalysisBothNXDOMAIN(t *testing.T) {
+	failure, ctrlFailure := modelx.FailureDNSNXDOMAINError, controlNXDOMAIN
+	tk := &TestKeys{DNSExperimentFailure: &failure}
+	tk.Control.DNS.Failure = &ctrlFailure
+	if tk.dnsAnalysis(fakeASN) != dnsConsistent {
+		t.Fatal("expected consistent")
+	}
+}
+
+func TestUnitDNSAnalysisNXDOMAINOnlyLocally(t *testing.T) {
+	failure := modelx.FailureDNSNXDOMAINError
+	tk := &TestKeys{DNSExperimentFailure: &failure}
+	tk.Control.DNS.Addrs = []string{"93.184.216.34"}
+	if tk.dnsAnalysis(fakeASN) != dnsInconsistent {
+		t.Fatal("expected inconsistent")
+	}
+}
+
+func TestUnitDNSAnalysisBogon(t *testing.T) {
+	tk := &TestKeys{addresses: []string{"10.0.0.1"}}
+	tk.Control.DNS.Addrs = []string{"93.184.216.34"}
+	if tk.dnsAnalysis(fakeASN) != dnsInconsistent {
+		t.Fatal("expected inconsistent")
+	}
+}
+
+func TestUnitDNSAnalysisSameASN(t *testing.T) {
+	tk := &TestKeys{addresses: []string{"93.184.216.35"}}
+	tk.Control.DNS.Addrs = []string{"93.184.216.34"}
+	if tk.dnsAnalysis(fakeASN) != dnsConsistent {
+		t.Fatal("expected consistent")
+	}
+}
+
+func TestUnitDNSAnalysisDifferentASN(t *testing.T) {
+	tk := &TestKeys{addresses: []string{"130.192.91.211"}}
+	tk.Control.DNS.Addrs = []string{"93.184.216.34"}
+	if tk.dnsAnalysis(fakeASN) != dnsInconsistent {
+		t.Fatal("expected inconsistent")
+	}
+}
+
+func TestUnitExtractTitle(t *testing.T) {
+	title := extractTitle([]byte("<html><TITLE>Example Domain</TITLE></html>"))
+	if title != "Example Domain" {
+		t.Fatal("unexpected title")
+	}
+	if extractTitle([]byte("<html></html>")) != "" {
+		t.Fatal("expected empty title")
+	}
+}
+
+func TestUnitHTTPAnalysisMatch(t *testing.T) {
+	body := []byte("<html><title>Example Domain</title></html>")
+	tk := &TestKeys{
+		bodySnap: body,
+		headers:  map[string]string{"Date": "today", "X-Custom": "foo"},
+		status:   200,
+	}
+	tk.Control.HTTPRequest = ControlHTTPRequestResult{
+		BodyLength: int64(len(body)),
+		Headers:    map[string]string{"x-custom": "foo", "Server": "nginx"},
+		StatusCode: 200,
+		Title:      "Example Domain",
+	}
+	tk.httpAnalysis()
+	if tk.BodyProportion != 1 || !isTrue(tk.BodyLengthMatch) {
+		t.Fatal("expected body length match")
+	}
+	if !isTrue(tk.StatusCodeMatch) {
+		t.Fatal("expected status code match")
+	}
+	if !isTrue(tk.HeadersMatch) {
+		t.Fatal("expected headers match")
+	}
+	if !isTrue(tk.TitleMatch) {
+		t.Fatal("expected title match")
+	}
+	tk.blockingAnalysis()
+	if !isTrue(tk.Accessible) || tk.Blocking != false {
+		t.Fatal("expected accessible and not blocked")
+	}
+}
+
+func TestUnitHTTPAnalysisDiff(t *testing.T) {
+	tk := &TestKeys{
+		bodySnap: []byte("<html><title>Blocked</title></html>"),
+		headers:  map[string]string{"Server": "censor"},
+		status:   403,
+	}
+	tk.Control.HTTPRequest = ControlHTTPRequestResult{
+		BodyLength: 4096,
+		Headers:    map[string]string{"X-Custom": "foo"},
+		StatusCode: 200,
+		Title:      "Example Domain",
+	}
+	tk.httpAnalysis()
+	if isTrue(tk.BodyLengthMatch) || isTrue(tk.StatusCodeMatch) || isTrue(tk.HeadersMatch) {
+		t.Fatal("expected no match")
+	}
+	if tk.TitleMatch == nil || *tk.TitleMatch != false {
+		t.Fatal("expected title mismatch")
+	}
+	tk.blockingAnalysis()
+	if isTrue(tk.Accessible) || tk.Blocking != blockingHTTPDiff {
+		t.Fatal("expected http-diff blocking")
+	}
+}
+
+func TestUnitBlockingAnalysisTCPIP(t *testing.T) {
+	failure := modelx.FailureConnectionRefused
+	tk := &TestKeys{
+		DNSConsistency:        dnsConsistent,
+		HTTPExperimentFailure: &failure,
+		TCPConnect: oonidatamodel.TCPConnectList{{
+			IP:     "93.184.216.34",
+			Port:   80,
+			Status: oonidatamodel.TCPConnectStatus{Failure: &failure},
+		}},
+	}
+	tk.Control.TCPConnect = map[string]ControlTCPConnectResult{
+		"93.184.216.34:80": {Status: true},
+	}
+	tk.tcpConnectAnalysis()
+	if !isTrue(tk.TCPConnect[0].Status.Blocked) {
+		t.Fatal("expected endpoint to be blocked")
+	}
+	tk.blockingAnalysis()
+	if tk.Blocking != blockingTCPIP {
+		t.Fatal("expected tcp_ip blocking")
+	}
+}
+
+func TestUnitBlockingAnalysisWebsiteDown(t *testing.T) {
+	failure := modelx.FailureGenericTimeoutError
+	tk := &TestKeys{
+		DNSConsistency:        dnsConsistent,
+		HTTPExperimentFailure: &failure,
+	}
+	tk.Control.HTTPRequest.Failure = &failure
+	tk.blockingAnalysis()
+	if isTrue(tk.Accessible) || tk.Blocking != false {
+		t.Fatal("expected website to be down")
+	}
+}
+
+func TestUnitBlockingAnalysisDNS(t *testing.T) {
+	failure := modelx.FailureDNSNXDOMAINError
+	tk := &TestKeys{
+		DNSConsistency:       dnsInconsistent,
+		DNSExperimentFailure: &failure,
+	}
+	tk.blockingAnalysis()
+	if isTrue(tk.Accessible) || tk.Blocking != blockingDNS {
+		t.Fatal("expected dns blocking")
+	}
+}
@@ -0,0 +1,69 @@
+package web_connectivity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/internal/mockable"
+)
+
+func TestUnitControlSuccess(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var creq ControlRequest
+			if err := json.NewDecoder(r.Body).Decode(&creq); err != nil {
+				w.WriteHeader(400)
+				return
+			}
+			out := ControlResponse{
+				TCPConnect: make(map[string]ControlTCPConnectResult),
+				DNS:        ControlDNSResult{Addrs: []string{"93.184.216.34"}},
+			}
+			for _, endpoint := range creq.TCPConnect {
+				out.TCPConnect[endpoint] = ControlTCPConnectResult{Status: true}
+			}
+			out.HTTPRequest.StatusCode = 200
+			json.NewEncoder(w).Encode(out)
+		},
+	))
+	defer server.Close()
+	out, err := control(context.Background(), &mockable.ExperimentSession{
+		MockableHTTPClient: http.DefaultClient,
+		MockableLogger:     log.Log,
+	}, server.URL, ControlRequest{
+		HTTPRequest: "http://www.example.com",
+		TCPConnect:  []string{"93.184.216.34:80"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.TCPConnect["93.184.216.34:80"].Status != true {
+		t.Fatal("unexpected TCP connect result")
+	}
+	if out.HTTPRequest.StatusCode != 200 {
+		t.Fatal("unexpected status code")
+	}
+	if len(out.DNS.Addrs) != 1 {
+		t.Fatal("unexpected number of addresses")
+	}
+}
+
+func TestUnitControlFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		},
+	))
+	defer server.Close()
+	_, err := control(context.Background(), &mockable.ExperimentSession{
+		MockableHTTPClient: http.DefaultClient,
+		MockableLogger:     log.Log,
+	}, server.URL, ControlRequest{})
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+}
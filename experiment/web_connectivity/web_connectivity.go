@@ -1,25 +1,70 @@
 // Package web_connectivity contains the Web Connectivity network experiment.
+// This file in particular is a pure-Go implementation of that.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-017-web-connectivity.md.
 package web_connectivity
 
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkhelper"
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/experiment/httpheader"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 const (
 	testName    = "web_connectivity"
-	testVersion = "0.0.1"
+	testVersion = "0.1.0"
 )
 
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains webconnectivity test keys.
+type TestKeys struct {
+	Agent          string  `json:"agent"`
+	ClientResolver string  `json:"client_resolver"`
+	Retries        *int64  `json:"retries"`    // unused
+	SOCKSProxy     *string `json:"socksproxy"` // unused
+
+	// DNS experiment
+	Queries              oonidatamodel.DNSQueriesList `json:"queries"`
+	DNSExperimentFailure *string                      `json:"dns_experiment_failure"`
+	DNSConsistency       string                       `json:"dns_consistency"`
+
+	// Control experiment
+	ControlFailure *string         `json:"control_failure"`
+	Control        ControlResponse `json:"control"`
+
+	// TCP connect experiment
+	TCPConnect oonidatamodel.TCPConnectList `json:"tcp_connect"`
+
+	// HTTP experiment
+	Requests              oonidatamodel.RequestList `json:"requests"`
+	HTTPExperimentFailure *string                   `json:"http_experiment_failure"`
+	BodyLengthMatch       *bool                     `json:"body_length_match"`
+	BodyProportion        float64                   `json:"body_proportion"`
+	StatusCodeMatch       *bool                     `json:"status_code_match"`
+	HeadersMatch          *bool                     `json:"headers_match"`
+	TitleMatch            *bool                     `json:"title_match"`
+
+	// Top-level analysis
+	Accessible *bool       `json:"accessible"`
+	Blocking   interface{} `json:"blocking"`
+
+	// Internal state used during the analysis
+	addresses []string
+	bodySnap  []byte
+	headers   map[string]string
+	status    int64
 }
 
 type measurer struct {
@@ -34,30 +79,194 @@ func (m *measurer) ExperimentVersion() string {
 	return testVersion
 }
 
+var (
+	// errNoAvailableTestHelpers is emitted when there are no test helpers.
+	errNoAvailableTestHelpers = errors.New("no available helpers")
+
+	// errNoInput indicates that no input was provided
+	errNoInput = errors.New("no input provided")
+
+	// errInputIsNotAnURL indicates that the input is not an URL.
+	errInputIsNotAnURL = errors.New("input is not an URL")
+
+	// errUnsupportedInput indicates that the input URL scheme is unsupported.
+	errUnsupportedInput = errors.New("unsupported input scheme")
+)
+
 func (m *measurer) Run(
-	ctx context.Context, sess model.ExperimentSession,
-	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+	ctx context.Context,
+	sess model.ExperimentSession,
+	measurement *model.Measurement,
+	callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"WebConnectivity", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
-	)
-	settings.Options.GeoIPASNPath = sess.ASNDatabasePath()
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	tk := new(TestKeys)
+	measurement.TestKeys = tk
+	tk.Agent = "redirect"
+	tk.ClientResolver = sess.ResolverIP()
 	if measurement.Input == "" {
-		return errors.New("web_connectivity: passed an empty input")
+		return errNoInput
 	}
-	settings.Inputs = []string{measurement.Input}
-	err := mkhelper.Set(sess, "web-connectivity", "https", &settings)
+	URL, err := url.Parse(measurement.Input)
 	if err != nil {
-		return err
+		return errInputIsNotAnURL
+	}
+	if URL.Scheme != "http" && URL.Scheme != "https" {
+		return errUnsupportedInput
+	}
+	testhelper := findTestHelper(sess)
+	if testhelper == nil {
+		return errNoAvailableTestHelpers
 	}
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	measurement.TestHelpers = map[string]interface{}{
+		"backend": testhelper,
+	}
+	var (
+		receivedBytes int64
+		sentBytes     int64
 	)
+	defer func() {
+		callbacks.OnDataUsage(
+			float64(receivedBytes)/1024.0, // downloaded
+			float64(sentBytes)/1024.0,     // uploaded
+		)
+	}()
+	// 1. perform the DNS lookup
+	handler := netxlogger.NewHandler(sess.Logger())
+	dnsResults := oonitemplates.DNSLookup(ctx, oonitemplates.DNSLookupConfig{
+		Beginning: measurement.MeasurementStartTimeSaved,
+		Handler:   handler,
+		Hostname:  URL.Hostname(),
+	})
+	tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(dnsResults.TestKeys)...)
+	tk.DNSExperimentFailure = setFailure(dnsResults.Error)
+	tk.addresses = dnsResults.Addresses
+	callbacks.OnProgress(0.1, fmt.Sprintf(
+		"web_connectivity: resolve %s: %s", URL.Hostname(),
+		errString(dnsResults.Error),
+	))
+	// 2. build the list of endpoints to connect to
+	port := URL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[URL.Scheme]
+	}
+	var endpoints []string
+	for _, addr := range dnsResults.Addresses {
+		endpoints = append(endpoints, net.JoinHostPort(addr, port))
+	}
+	// 3. ask the control for its view of the world
+	headers := map[string][]string{
+		"Accept":          {httpheader.RandomAccept()},
+		"Accept-Language": {httpheader.RandomAcceptLanguage()},
+		"User-Agent":      {httpheader.RandomUserAgent()},
+	}
+	tk.Control, err = control(ctx, sess, testhelper.Address, ControlRequest{
+		HTTPRequest:        measurement.Input,
+		HTTPRequestHeaders: headers,
+		TCPConnect:         endpoints,
+	})
+	tk.ControlFailure = setFailure(err)
+	callbacks.OnProgress(0.3, fmt.Sprintf(
+		"web_connectivity: control: %s", errString(err),
+	))
+	// 4. connect to every endpoint we've discovered
+	for idx, endpoint := range endpoints {
+		r := oonitemplates.TCPConnect(ctx, oonitemplates.TCPConnectConfig{
+			Address:   endpoint,
+			Beginning: measurement.MeasurementStartTimeSaved,
+			Handler:   handler,
+		})
+		receivedBytes += r.TestKeys.ReceivedBytes
+		sentBytes += r.TestKeys.SentBytes
+		tk.TCPConnect = append(tk.TCPConnect, oonidatamodel.NewTCPConnectList(r.TestKeys)...)
+		callbacks.OnProgress(0.3+0.3*float64(idx+1)/float64(len(endpoints)), fmt.Sprintf(
+			"web_connectivity: connect %s: %s", endpoint, errString(r.Error),
+		))
+	}
+	// 5. fetch the webpage unless the DNS has failed. Note that we're
+	// using the same headers we've passed to the control.
+	if dnsResults.Error == nil {
+		r := oonitemplates.HTTPDo(ctx, newHTTPDoConfig(
+			measurement.Input, headers, measurement.MeasurementStartTimeSaved, handler,
+		))
+		receivedBytes += r.TestKeys.ReceivedBytes
+		sentBytes += r.TestKeys.SentBytes
+		tk.Requests = append(tk.Requests, oonidatamodel.NewRequestList(r.TestKeys)...)
+		tk.HTTPExperimentFailure = setFailure(r.Error)
+		tk.bodySnap = r.BodySnap
+		tk.status = r.StatusCode
+		tk.headers = make(map[string]string)
+		for key := range r.Headers {
+			tk.headers[key] = r.Headers.Get(key)
+		}
+		callbacks.OnProgress(0.9, fmt.Sprintf(
+			"web_connectivity: GET %s: %s", measurement.Input, errString(r.Error),
+		))
+	}
+	// 6. compare what we have seen with the control
+	tk.analyze(sess)
+	sess.Logger().Infof(
+		"web_connectivity: accessible: %+v; blocking: %+v",
+		asString(tk.Accessible), tk.Blocking,
+	)
+	callbacks.OnProgress(1, "web_connectivity: done")
+	return nil
+}
+
+// newHTTPDoConfig returns the config for fetching the webpage. We read
+// the whole body, like the control does, because the analysis compares
+// our body length with the one seen by the control.
+func newHTTPDoConfig(
+	URL string, headers map[string][]string, beginning time.Time,
+	handler modelx.Handler,
+) oonitemplates.HTTPDoConfig {
+	return oonitemplates.HTTPDoConfig{
+		Accept:                  headers["Accept"][0],
+		AcceptLanguage:          headers["Accept-Language"][0],
+		Beginning:               beginning,
+		Handler:                 handler,
+		MaxResponseBodySnapSize: -1,
+		Method:                  "GET",
+		URL:                     URL,
+		UserAgent:               headers["User-Agent"][0],
+	}
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func findTestHelper(sess model.ExperimentSession) *model.Service {
+	testhelpers, _ := sess.GetTestHelpersByName("web-connectivity")
+	for _, th := range testhelpers {
+		if th.Type == "https" {
+			return &th
+		}
+	}
+	return nil
+}
+
+func asString(v *bool) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%+v", *v)
+}
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
+
+func setFailure(err error) (s *string) {
+	if err != nil {
+		descr := err.Error()
+		s = &descr
+	}
+	return
+}
@@ -1,18 +1,124 @@
-package web_connectivity_test
+package web_connectivity
 
 import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mktesting"
-	"github.com/ooni/probe-engine/experiment/web_connectivity"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx/handlers"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("http://www.example.com", func() model.ExperimentMeasurer {
-		return web_connectivity.NewExperimentMeasurer(web_connectivity.Config{})
-	})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "web_connectivity" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.1.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestUnitMeasureWithNoInput(t *testing.T) {
+	err := runWithInput("")
+	if err != errNoInput {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithInputNotAnURL(t *testing.T) {
+	err := runWithInput("\t")
+	if err != errInputIsNotAnURL {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithUnsupportedInput(t *testing.T) {
+	err := runWithInput("dnslookup://example.com")
+	if err != errUnsupportedInput {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitMeasureWithNoAvailableTestHelpers(t *testing.T) {
+	err := runWithInput("http://www.example.com")
+	if err != errNoAvailableTestHelpers {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestIntegrationMeasure(t *testing.T) {
+	measurement := &model.Measurement{Input: "http://www.example.com"}
+	m := NewExperimentMeasurer(Config{})
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{
+			MockableHTTPClient: http.DefaultClient,
+			MockableLogger:     log.Log,
+			MockableTestHelpers: map[string][]model.Service{
+				"web-connectivity": []model.Service{{
+					Address: "https://wcth.ooni.io",
+					Type:    "https",
+				}},
+			},
+		},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.ControlFailure != nil {
+		t.Fatal(*tk.ControlFailure)
+	}
+	if tk.Accessible == nil || *tk.Accessible != true {
+		t.Fatal("expected the website to be accessible")
+	}
+}
+
+func runWithInput(input string) error {
+	m := NewExperimentMeasurer(Config{})
+	return m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		&model.Measurement{Input: input},
+		handler.NewPrinterCallbacks(log.Log),
+	)
+}
+
+func TestUnitHTTPDoConfigLargeBody(t *testing.T) {
+	body := bytes.Repeat([]byte("A"), 3<<20) // larger than the default snap size
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write(body)
+		}))
+	defer server.Close()
+	headers := map[string][]string{
+		"Accept":          {"*/*"},
+		"Accept-Language": {"en-US"},
+		"User-Agent":      {"miniooni/0.1.0-dev"},
+	}
+	r := oonitemplates.HTTPDo(context.Background(), newHTTPDoConfig(
+		server.URL, headers, time.Now(), handlers.NoHandler,
+	))
+	if r.Error != nil {
+		t.Fatal(r.Error)
+	}
+	tk := &TestKeys{bodySnap: r.BodySnap, status: r.StatusCode}
+	tk.Control.HTTPRequest = ControlHTTPRequestResult{
+		BodyLength: int64(len(body)),
+		StatusCode: 200,
+	}
+	tk.httpAnalysis()
+	if tk.BodyProportion != 1 || !isTrue(tk.BodyLengthMatch) {
+		t.Fatal("expected body length match")
+	}
 }
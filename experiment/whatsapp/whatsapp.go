@@ -1,23 +1,164 @@
-// Package whatsapp contains the WhatsApp network experiment.
+// Package whatsapp contains the WhatsApp network experiment. This file
+// in particular is a pure-Go implementation of that.
+//
+// See https://github.com/ooni/spec/blob/master/nettests/ts-018-whatsapp.md.
 package whatsapp
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/ooni/probe-engine/experiment/mkrunner"
-	"github.com/ooni/probe-engine/measurementkit"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/experiment/httpheader"
+	"github.com/ooni/probe-engine/internal/netxlogger"
+	"github.com/ooni/probe-engine/internal/oonidatamodel"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
 )
 
 const (
 	testName    = "whatsapp"
-	testVersion = "0.6.1"
+	testVersion = "0.7.0"
 )
 
+const (
+	// RegistrationServiceURL is the URL of the registration service
+	RegistrationServiceURL = "https://v.whatsapp.net/v2/register"
+
+	// WebHTTPURL is the cleartext URL of WhatsApp Web
+	WebHTTPURL = "http://web.whatsapp.com/"
+
+	// WebHTTPSURL is the secure URL of WhatsApp Web
+	WebHTTPSURL = "https://web.whatsapp.com/"
+)
+
+// endpoints returns the list of endpoints we connect to.
+func endpoints() (out []string) {
+	for idx := 1; idx <= 16; idx++ {
+		hostname := fmt.Sprintf("e%d.whatsapp.net", idx)
+		out = append(out, net.JoinHostPort(hostname, "443"))
+		out = append(out, net.JoinHostPort(hostname, "5222"))
+	}
+	return
+}
+
 // Config contains the experiment config.
-type Config struct {
-	// LogLevel is the MK log level. Empty implies "WARNING".
-	LogLevel string
+type Config struct{}
+
+// TestKeys contains whatsapp test keys.
+type TestKeys struct {
+	Agent                     string                          `json:"agent"`
+	Queries                   oonidatamodel.DNSQueriesList    `json:"queries"`
+	Requests                  oonidatamodel.RequestList       `json:"requests"`
+	TCPConnect                oonidatamodel.TCPConnectList    `json:"tcp_connect"`
+	TLSHandshakes             oonidatamodel.TLSHandshakesList `json:"tls_handshakes"`
+	RegistrationServerFailure *string                         `json:"registration_server_failure"`
+	RegistrationServerStatus  string                          `json:"registration_server_status"`
+	WhatsappEndpointsBlocked  []string                        `json:"whatsapp_endpoints_blocked"`
+	WhatsappEndpointsStatus   string                          `json:"whatsapp_endpoints_status"`
+	WhatsappWebFailure        *string                         `json:"whatsapp_web_failure"`
+	WhatsappWebStatus         string                          `json:"whatsapp_web_status"`
+}
+
+func newTestKeys() *TestKeys {
+	return &TestKeys{
+		Agent:                    "redirect",
+		RegistrationServerStatus: "ok",
+		WhatsappEndpointsBlocked: []string{},
+		WhatsappEndpointsStatus:  "ok",
+		WhatsappWebStatus:        "ok",
+	}
+}
+
+// results contains the results of all the measurements.
+type results struct {
+	connects map[string]*oonitemplates.TCPConnectResults
+	requests map[string]*oonitemplates.HTTPDoResults
+}
+
+func (tk *TestKeys) processConnects(m map[string]*oonitemplates.TCPConnectResults) {
+	var (
+		connected = make(map[string]bool)
+		hostnames = make(map[string]bool)
+		keys      []string
+	)
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // predictable output
+	for _, endpoint := range keys {
+		r := m[endpoint]
+		if r == nil {
+			continue
+		}
+		tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(r.TestKeys)...)
+		tk.TCPConnect = append(tk.TCPConnect, oonidatamodel.NewTCPConnectList(r.TestKeys)...)
+		hostname, _, _ := net.SplitHostPort(endpoint)
+		hostnames[hostname] = true
+		connected[hostname] = connected[hostname] || r.Error == nil
+	}
+	for hostname := range hostnames {
+		if !connected[hostname] {
+			tk.WhatsappEndpointsBlocked = append(tk.WhatsappEndpointsBlocked, hostname)
+		}
+	}
+	sort.Strings(tk.WhatsappEndpointsBlocked)
+	// We declare the endpoints blocked only if all of them are blocked
+	// consistently with what we do for Telegram access points.
+	if len(hostnames) <= 0 || len(tk.WhatsappEndpointsBlocked) == len(hostnames) {
+		tk.WhatsappEndpointsStatus = "blocked"
+	}
+}
+
+func (tk *TestKeys) processRequest(URL string, r *oonitemplates.HTTPDoResults) {
+	tk.Queries = append(tk.Queries, oonidatamodel.NewDNSQueriesList(r.TestKeys)...)
+	tk.Requests = append(tk.Requests, oonidatamodel.NewRequestList(r.TestKeys)...)
+	tk.TCPConnect = append(tk.TCPConnect, oonidatamodel.NewTCPConnectList(r.TestKeys)...)
+	tk.TLSHandshakes = append(
+		tk.TLSHandshakes, oonidatamodel.NewTLSHandshakesList(r.TestKeys)...)
+	var failure *string
+	if r.Error != nil {
+		failureString := r.Error.Error()
+		failure = &failureString
+	}
+	if URL == RegistrationServiceURL {
+		// Any response from the registration service is fine
+		if failure != nil {
+			tk.RegistrationServerFailure = failure
+			tk.RegistrationServerStatus = "blocked"
+		}
+		return
+	}
+	if tk.WhatsappWebStatus != "ok" {
+		return // we already flipped the state
+	}
+	if failure == nil && r.StatusCode != 200 {
+		failureString := "http_request_failed" // MK uses it
+		failure = &failureString
+	}
+	if failure != nil {
+		tk.WhatsappWebFailure = failure
+		tk.WhatsappWebStatus = "blocked"
+	}
+}
+
+func (tk *TestKeys) processall(r *results) {
+	tk.processConnects(r.connects)
+	var keys []string
+	for key := range r.requests {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // predictable output
+	for _, URL := range keys {
+		if r.requests[URL] != nil {
+			tk.processRequest(URL, r.requests[URL])
+		}
+	}
 }
 
 type measurer struct {
@@ -33,21 +174,108 @@ func (m *measurer) ExperimentVersion() string {
 }
 
 func (m *measurer) Run(
-	ctx context.Context, sess model.ExperimentSession,
-	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+	ctx context.Context,
+	sess model.ExperimentSession,
+	measurement *model.Measurement,
+	callbacks model.ExperimentCallbacks,
 ) error {
-	settings := measurementkit.NewSettings(
-		"Whatsapp", sess.SoftwareName(), sess.SoftwareVersion(),
-		sess.CABundlePath(), sess.ProbeASNString(), sess.ProbeCC(),
-		sess.ProbeIP(), sess.ProbeNetworkName(), m.config.LogLevel,
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+	var (
+		all       = endpoints()
+		urls      = []string{RegistrationServiceURL, WebHTTPURL, WebHTTPSURL}
+		completed = atomicx.NewInt64()
+		mu        sync.Mutex
+		r         = &results{
+			connects: make(map[string]*oonitemplates.TCPConnectResults),
+			requests: make(map[string]*oonitemplates.HTTPDoResults),
+		}
+		receivedBytes = atomicx.NewInt64()
+		sentBytes     = atomicx.NewInt64()
+		total         = len(all) + len(urls)
+		waitgroup     sync.WaitGroup
 	)
-	settings.Options.GeoIPASNPath = sess.ASNDatabasePath()
-	return mkrunner.Do(
-		settings, sess, measurement, callbacks, measurementkit.StartEx,
+	progress := func(what string, err error) {
+		sofar := completed.Add(1)
+		percentage := float64(sofar) / float64(total)
+		callbacks.OnProgress(percentage, fmt.Sprintf(
+			"whatsapp: access %s: %s", what, errString(err),
+		))
+	}
+	// Avoid making all requests concurrently
+	sleep := func() bool {
+		gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+		sleeptime := time.Duration(gen.Intn(5000)) * time.Millisecond
+		select {
+		case <-time.After(sleeptime):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	waitgroup.Add(total)
+	for _, endpoint := range all {
+		go func(endpoint string) {
+			defer waitgroup.Done()
+			if !sleep() {
+				return
+			}
+			results := oonitemplates.TCPConnect(ctx, oonitemplates.TCPConnectConfig{
+				Address:   endpoint,
+				Beginning: measurement.MeasurementStartTimeSaved,
+				Handler:   netxlogger.NewHandler(sess.Logger()),
+			})
+			sentBytes.Add(results.TestKeys.SentBytes)
+			receivedBytes.Add(results.TestKeys.ReceivedBytes)
+			mu.Lock()
+			r.connects[endpoint] = results
+			mu.Unlock()
+			progress(endpoint, results.Error)
+		}(endpoint)
+	}
+	for _, URL := range urls {
+		go func(URL string) {
+			defer waitgroup.Done()
+			if !sleep() {
+				return
+			}
+			results := oonitemplates.HTTPDo(ctx, oonitemplates.HTTPDoConfig{
+				Accept:         httpheader.RandomAccept(),
+				AcceptLanguage: httpheader.RandomAcceptLanguage(),
+				Beginning:      measurement.MeasurementStartTimeSaved,
+				Handler:        netxlogger.NewHandler(sess.Logger()),
+				Method:         "GET",
+				URL:            URL,
+				UserAgent:      httpheader.RandomUserAgent(),
+			})
+			sentBytes.Add(results.TestKeys.SentBytes)
+			receivedBytes.Add(results.TestKeys.ReceivedBytes)
+			mu.Lock()
+			r.requests[URL] = results
+			mu.Unlock()
+			progress(URL, results.Error)
+		}(URL)
+	}
+	waitgroup.Wait()
+	testkeys := newTestKeys()
+	measurement.TestKeys = testkeys
+	testkeys.processall(r)
+	callbacks.OnDataUsage(
+		float64(receivedBytes.Load())/1024.0, // downloaded
+		float64(sentBytes.Load())/1024.0,     // uploaded
 	)
+	return nil
 }
 
 // NewExperimentMeasurer creates a new ExperimentMeasurer.
 func NewExperimentMeasurer(config Config) model.ExperimentMeasurer {
 	return &measurer{config: config}
 }
+
+func errString(err error) (s string) {
+	s = "success"
+	if err != nil {
+		s = err.Error()
+	}
+	return
+}
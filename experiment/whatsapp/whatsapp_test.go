@@ -1,18 +1,137 @@
-package whatsapp_test
+package whatsapp
 
 import (
+	"context"
+	"errors"
 	"testing"
 
-	"github.com/ooni/probe-engine/experiment/mktesting"
-	"github.com/ooni/probe-engine/experiment/whatsapp"
+	"github.com/apex/log"
+	"github.com/ooni/probe-engine/experiment/handler"
+	"github.com/ooni/probe-engine/internal/mockable"
+	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/model"
 )
 
-func TestIntegration(t *testing.T) {
-	err := mktesting.Run("", func() model.ExperimentMeasurer {
-		return whatsapp.NewExperimentMeasurer(whatsapp.Config{})
-	})
+func TestUnitNewExperimentMeasurer(t *testing.T) {
+	measurer := NewExperimentMeasurer(Config{})
+	if measurer.ExperimentName() != "whatsapp" {
+		t.Fatal("unexpected name")
+	}
+	if measurer.ExperimentVersion() != "0.7.0" {
+		t.Fatal("unexpected version")
+	}
+}
+
+func TestIntegrationMeasure(t *testing.T) {
+	measurement := new(model.Measurement)
+	m := NewExperimentMeasurer(Config{})
+	err := m.Run(
+		context.Background(),
+		&mockable.ExperimentSession{MockableLogger: log.Log},
+		measurement,
+		handler.NewPrinterCallbacks(log.Log),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	tk := measurement.TestKeys.(*TestKeys)
+	if tk.WhatsappEndpointsStatus != "ok" {
+		t.Fatal("unexpected endpoints status")
+	}
+}
+
+func TestUnitEndpoints(t *testing.T) {
+	all := endpoints()
+	if len(all) != 32 {
+		t.Fatal("unexpected number of endpoints")
+	}
+	if all[0] != "e1.whatsapp.net:443" || all[31] != "e16.whatsapp.net:5222" {
+		t.Fatal("unexpected endpoints")
+	}
+}
+
+func newSuccessfulResults() *results {
+	r := &results{
+		connects: make(map[string]*oonitemplates.TCPConnectResults),
+		requests: make(map[string]*oonitemplates.HTTPDoResults),
+	}
+	for _, endpoint := range endpoints() {
+		r.connects[endpoint] = &oonitemplates.TCPConnectResults{}
+	}
+	for _, URL := range []string{RegistrationServiceURL, WebHTTPURL, WebHTTPSURL} {
+		r.requests[URL] = &oonitemplates.HTTPDoResults{StatusCode: 200}
+	}
+	return r
+}
+
+func TestUnitProcessallSuccess(t *testing.T) {
+	tk := newTestKeys()
+	tk.processall(newSuccessfulResults())
+	if tk.WhatsappEndpointsStatus != "ok" || len(tk.WhatsappEndpointsBlocked) != 0 {
+		t.Fatal("unexpected endpoints status")
+	}
+	if tk.RegistrationServerStatus != "ok" || tk.RegistrationServerFailure != nil {
+		t.Fatal("unexpected registration server status")
+	}
+	if tk.WhatsappWebStatus != "ok" || tk.WhatsappWebFailure != nil {
+		t.Fatal("unexpected web status")
+	}
+}
+
+func TestUnitProcessallSomeEndpointsBlocked(t *testing.T) {
+	r := newSuccessfulResults()
+	r.connects["e7.whatsapp.net:443"].Error = errors.New("mocked error")
+	r.connects["e7.whatsapp.net:5222"].Error = errors.New("mocked error")
+	r.connects["e8.whatsapp.net:5222"].Error = errors.New("mocked error")
+	tk := newTestKeys()
+	tk.processall(r)
+	if tk.WhatsappEndpointsStatus != "ok" {
+		t.Fatal("unexpected endpoints status")
+	}
+	if len(tk.WhatsappEndpointsBlocked) != 1 || tk.WhatsappEndpointsBlocked[0] != "e7.whatsapp.net" {
+		t.Fatal("unexpected list of blocked endpoints")
+	}
+}
+
+func TestUnitProcessallAllEndpointsBlocked(t *testing.T) {
+	r := newSuccessfulResults()
+	for _, v := range r.connects {
+		v.Error = errors.New("mocked error")
+	}
+	tk := newTestKeys()
+	tk.processall(r)
+	if tk.WhatsappEndpointsStatus != "blocked" || len(tk.WhatsappEndpointsBlocked) != 16 {
+		t.Fatal("unexpected endpoints status")
+	}
+}
+
+func TestUnitProcessallRegistrationServerBlocked(t *testing.T) {
+	r := newSuccessfulResults()
+	r.requests[RegistrationServiceURL] = &oonitemplates.HTTPDoResults{
+		Error: errors.New("mocked error"),
+	}
+	tk := newTestKeys()
+	tk.processall(r)
+	if tk.RegistrationServerStatus != "blocked" {
+		t.Fatal("unexpected registration server status")
+	}
+	if tk.RegistrationServerFailure == nil || *tk.RegistrationServerFailure != "mocked error" {
+		t.Fatal("unexpected registration server failure")
+	}
+	if tk.WhatsappWebStatus != "ok" {
+		t.Fatal("unexpected web status")
+	}
+}
+
+func TestUnitProcessallWebBlocked(t *testing.T) {
+	r := newSuccessfulResults()
+	r.requests[WebHTTPURL] = &oonitemplates.HTTPDoResults{StatusCode: 403}
+	tk := newTestKeys()
+	tk.processall(r)
+	if tk.WhatsappWebStatus != "blocked" {
+		t.Fatal("unexpected web status")
+	}
+	if tk.WhatsappWebFailure == nil || *tk.WhatsappWebFailure != "http_request_failed" {
+		t.Fatal("unexpected web failure")
+	}
 }
@@ -13,8 +13,10 @@ import (
 	"testing"
 
 	"github.com/ooni/probe-engine/experiment/example"
-	"github.com/ooni/probe-engine/measurementkit"
 	"github.com/ooni/probe-engine/model"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/har"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 func TestCreateAll(t *testing.T) {
@@ -52,6 +54,26 @@ func TestRunDASH(t *testing.T) {
 	runexperimentflow(t, builder.NewExperiment(), "")
 }
 
+func TestRunDNSConsistency(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("dns_consistency")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runexperimentflow(t, builder.NewExperiment(), "example.com")
+}
+
+func TestRunDNSCheck(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("dnscheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runexperimentflow(t, builder.NewExperiment(), "dot://1.1.1.1:853")
+}
+
 func TestRunExample(t *testing.T) {
 	sess := newSessionForTesting(t)
 	defer sess.Close()
@@ -95,6 +117,16 @@ func TestRunSNIBlocking(t *testing.T) {
 	runexperimentflow(t, builder.NewExperiment(), "kernel.org")
 }
 
+func TestRunSTUNReachability(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("stun_reachability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runexperimentflow(t, builder.NewExperiment(), "")
+}
+
 func TestRunTelegram(t *testing.T) {
 	sess := newSessionForTesting(t)
 	defer sess.Close()
@@ -276,10 +308,17 @@ func TestUseOptions(t *testing.T) {
 	}
 }
 
-func TestRunHHFM(t *testing.T) {
-	if !measurementkit.Available() {
-		t.Skip("Measurement Kit not available; skipping")
+func TestRunFacebookMessenger(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("facebook_messenger")
+	if err != nil {
+		t.Fatal(err)
 	}
+	runexperimentflow(t, builder.NewExperiment(), "")
+}
+
+func TestRunHHFM(t *testing.T) {
 	sess := newSessionForTesting(t)
 	defer sess.Close()
 	builder, err := sess.NewExperimentBuilder("http_header_field_manipulation")
@@ -289,6 +328,42 @@ func TestRunHHFM(t *testing.T) {
 	runexperimentflow(t, builder.NewExperiment(), "")
 }
 
+func TestRunHIRL(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("http_invalid_request_line")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runexperimentflow(t, builder.NewExperiment(), "")
+}
+
+func TestRunURLGetter(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("urlgetter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.SetOptionString("DNSNetwork", "dot"); err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.SetOptionString("DNSAddress", "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	runexperimentflow(t, builder.NewExperiment(), "https://www.google.com/")
+}
+
+func TestRunWhatsApp(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	builder, err := sess.NewExperimentBuilder("whatsapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runexperimentflow(t, builder.NewExperiment(), "")
+}
+
 func runexperimentflow(t *testing.T, experiment *Experiment, input string) {
 	err := experiment.OpenReport()
 	if err != nil {
@@ -636,3 +711,107 @@ func (am *antaniMeasurer) Run(
 ) error {
 	return nil
 }
+
+type capturingMeasurer struct {
+	capturer modelx.PacketCapturer
+}
+
+func (cm *capturingMeasurer) ExperimentName() string {
+	return "capturing"
+}
+
+func (cm *capturingMeasurer) ExperimentVersion() string {
+	return "0.1.0"
+}
+
+func (cm *capturingMeasurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	cm.capturer = modelx.ContextPacketCapturer(ctx)
+	return nil
+}
+
+func TestCapturePackets(t *testing.T) {
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	measurer := new(capturingMeasurer)
+	exp := NewExperiment(sess, measurer)
+	exp.SetCapturePackets(true)
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if measurer.capturer == nil {
+		t.Fatal("the context does not contain a packet capturer")
+	}
+	path := measurement.Annotations["packet_capture"]
+	if filepath.Dir(path) != sess.TempDir() {
+		t.Fatal("the pcapng file is not in the temporary directory")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) <= 0 {
+		t.Fatal("the pcapng file is empty")
+	}
+}
+
+type httpMeasurer struct {
+	URL string
+}
+
+func (hm *httpMeasurer) ExperimentName() string {
+	return "http"
+}
+
+func (hm *httpMeasurer) ExperimentVersion() string {
+	return "0.1.0"
+}
+
+func (hm *httpMeasurer) Run(
+	ctx context.Context, sess model.ExperimentSession,
+	measurement *model.Measurement, callbacks model.ExperimentCallbacks,
+) error {
+	client := netx.NewHTTPClient()
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, "GET", hm.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = ioutil.ReadAll(resp.Body)
+	return err
+}
+
+func TestSaveHAR(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	sess := newSessionForTesting(t)
+	defer sess.Close()
+	exp := NewExperiment(sess, &httpMeasurer{URL: server.URL})
+	exp.SetSaveHAR(true)
+	measurement, err := exp.Measure("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(measurement.Annotations["http_archive"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc har.HAR
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Log.Entries) != 1 || doc.Log.Entries[0].Response.Status != 404 {
+		t.Fatal("unexpected HAR entries")
+	}
+	if doc.Log.Creator.Name != sess.SoftwareName() {
+		t.Fatal("unexpected HAR creator")
+	}
+}
@@ -1,9 +1,29 @@
 module github.com/ooni/probe-engine
 
-go 1.14
+go 1.24
 
 require (
 	git.torproject.org/pluggable-transports/goptlib.git v1.1.0
+	github.com/Psiphon-Labs/psiphon-tunnel-core v2.0.11-0.20200225001008-1602125eff13+incompatible
+	github.com/apex/log v1.1.2
+	github.com/dustin/go-humanize v1.0.0
+	github.com/gorilla/websocket v1.4.1
+	github.com/iancoleman/strcase v0.0.0-20191112232945-16388991a334
+	github.com/m-lab/ndt7-client-go v0.2.0
+	github.com/miekg/dns v1.1.27
+	github.com/montanaflynn/stats v0.6.3
+	github.com/neubot/dash v0.4.1
+	github.com/oschwald/geoip2-golang v1.4.0
+	github.com/pborman/getopt v0.0.0-20190409184431-ee0cd42419d3
+	github.com/quic-go/quic-go v0.59.1
+	github.com/refraction-networking/utls v0.0.0-20190909200633-43c36d3c1f57
+	github.com/rogpeppe/go-internal v1.10.0
+	gitlab.com/yawning/obfs4.git v0.0.0-20190621002404-c357dd56dfa6
+	golang.org/x/net v0.43.0
+)
+
+require (
+	git.schwanenlied.me/yawning/bsaes.git v0.0.0-20190320102049-26d1add596b6 // indirect
 	github.com/AndreasBriese/bbloom v0.0.0-20170702084017-28f7e881ca57 // indirect
 	github.com/Psiphon-Inc/rotate-safe-writer v0.0.0-20170228160301-b276127301a9 // indirect
 	github.com/Psiphon-Labs/bolt v0.0.0-20200203172706-f3d58e369264 // indirect
@@ -12,50 +32,58 @@ require (
 	github.com/Psiphon-Labs/goarista v0.0.0-20160825065156-d002785f4c67 // indirect
 	github.com/Psiphon-Labs/goptlib v0.0.0-20180426172440-18963be5f9c5 // indirect
 	github.com/Psiphon-Labs/net v0.0.0-20191204183604-f5d60dada742 // indirect
-	github.com/Psiphon-Labs/psiphon-tunnel-core v2.0.11-0.20200225001008-1602125eff13+incompatible
 	github.com/Psiphon-Labs/quic-go v0.14.1-0.20200128193928-738e15bfe6c3 // indirect
 	github.com/Psiphon-Labs/tls-tris v0.0.0-20191206190901-78f52e6d6243 // indirect
-	github.com/apex/log v1.1.2
+	github.com/agl/ed25519 v0.0.0-20170116200512-5312a6153412 // indirect
 	github.com/aristanetworks/goarista v0.0.0-20200306225906-e0480e715858 // indirect
 	github.com/armon/go-proxyproto v0.0.0-20180202201750-5b7edb60ff5f // indirect
 	github.com/armon/go-socks5 v0.0.0-20160902184237-e75332964ef5 // indirect
 	github.com/bifurcation/mint v0.0.0-20180306135233-198357931e61 // indirect
 	github.com/boltdb/bolt v1.3.1 // indirect
+	github.com/cheekybits/genny v1.0.0 // indirect
 	github.com/cognusion/go-cache-lru v0.0.0-20170419142635-f73e2280ecea // indirect
 	github.com/creack/goselect v0.1.1 // indirect
+	github.com/dchest/siphash v1.2.1 // indirect
 	github.com/deckarep/golang-set v0.0.0-20171013212420-1d4478f51bed // indirect
 	github.com/dgraph-io/badger v1.5.4-0.20180815194500-3a87f6d9c273 // indirect
 	github.com/dgryski/go-farm v0.0.0-20180109070241-2de33835d102 // indirect
-	github.com/dustin/go-humanize v1.0.0
+	github.com/dsnet/compress v0.0.1 // indirect
 	github.com/elazarl/goproxy v0.0.0-20171101143503-a96fa3a31826 // indirect
 	github.com/gobwas/glob v0.2.4-0.20180402141543-f00a7392b439 // indirect
 	github.com/golang/mock v1.3.1 // indirect
+	github.com/golang/protobuf v1.3.3 // indirect
+	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/google/gxui v0.0.0-20151028112939-f85e0a97b3a4 // indirect
-	github.com/gorilla/websocket v1.4.1
 	github.com/grafov/m3u8 v0.0.0-20171211212457-6ab8f28ed427 // indirect
 	github.com/hashicorp/golang-lru v0.5.1 // indirect
-	github.com/iancoleman/strcase v0.0.0-20191112232945-16388991a334
 	github.com/juju/ratelimit v1.0.2-0.20191002062651-f60b32039441 // indirect
-	github.com/m-lab/ndt7-client-go v0.2.0
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/m-lab/ndt-server v0.13.4 // indirect
+	github.com/m-lab/tcp-info v1.3.0 // indirect
+	github.com/marten-seemann/qpack v0.1.0 // indirect
+	github.com/marten-seemann/qtls v0.4.1 // indirect
 	github.com/marusama/semaphore v0.0.0-20171214154724-565ffd8e868a // indirect
-	github.com/miekg/dns v1.1.27
-	github.com/montanaflynn/stats v0.6.3
-	github.com/neubot/dash v0.4.1
-	github.com/oschwald/geoip2-golang v1.4.0
+	github.com/onsi/ginkgo v1.10.1 // indirect
+	github.com/onsi/gomega v1.7.0 // indirect
+	github.com/oschwald/maxminddb-golang v1.6.0 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
-	github.com/pborman/getopt v0.0.0-20190409184431-ee0cd42419d3
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	github.com/redjack/marionette v0.0.0-20180818172807-360dd8f58226 // indirect
 	github.com/refraction-networking/gotapdance v0.0.0-20190909202946-3a6e1938ad70 // indirect
-	github.com/refraction-networking/utls v0.0.0-20190909200633-43c36d3c1f57 // indirect
-	github.com/rogpeppe/go-internal v1.5.2
 	github.com/ryanuber/go-glob v0.0.0-20170128012129-256dc444b735 // indirect
 	github.com/sergeyfrolov/bsbuffer v0.0.0-20180903213811-94e85abb8507 // indirect
+	github.com/sirupsen/logrus v1.4.2 // indirect
 	github.com/smartystreets/goconvey v1.6.4 // indirect
 	github.com/syndtr/gocapability v0.0.0-20170704070218-db04d3cc01c8 // indirect
 	github.com/zach-klippenstein/goregen v0.0.0-20160303162051-795b5e3961ea // indirect
-	gitlab.com/yawning/obfs4.git v0.0.0-20190621002404-c357dd56dfa6
+	gitlab.com/yawning/utls.git v0.0.11-1 // indirect
 	go.uber.org/atomic v1.3.3-0.20180806045314-ca680462431f // indirect
 	go.uber.org/multierr v1.1.1-0.20180122172545-ddea229ff1df // indirect
 	go.uber.org/zap v1.9.2-0.20180814183419-67bc79d13d15 // indirect
-	golang.org/x/net v0.0.0-20200301022130-244492dfa37a
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 )
+
@@ -27,6 +27,7 @@ type ExperimentSession struct {
 	MockableProbeCC              string
 	MockableProbeIP              string
 	MockableProbeNetworkName     string
+	MockableResolverIP           string
 	MockableSoftwareName         string
 	MockableSoftwareVersion      string
 	MockableTempDir              string
@@ -110,6 +111,11 @@ func (sess *ExperimentSession) ProbeNetworkName() string {
 	return sess.MockableProbeNetworkName
 }
 
+// ResolverIP implements ExperimentSession.ResolverIP
+func (sess *ExperimentSession) ResolverIP() string {
+	return sess.MockableResolverIP
+}
+
 // SoftwareName implements ExperimentSession.SoftwareName
 func (sess *ExperimentSession) SoftwareName() string {
 	return sess.MockableSoftwareName
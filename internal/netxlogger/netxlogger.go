@@ -76,6 +76,24 @@ func (h *Handler) OnMeasurement(m modelx.Measurement) {
 		)
 	}
 
+	// QUIC
+	if m.QUICHandshakeStart != nil {
+		h.logger.Debugf(
+			"[connID: %d] QUIC handshake: (SNI='%s')",
+			m.QUICHandshakeStart.ConnID,
+			m.QUICHandshakeStart.SNI,
+		)
+	}
+	if m.QUICHandshakeDone != nil {
+		h.logger.Debugf(
+			"[connID: %d] QUIC done: %s, %s (alpn='%s')",
+			m.QUICHandshakeDone.ConnID,
+			fmtError(m.QUICHandshakeDone.Error),
+			tlsx.VersionString(m.QUICHandshakeDone.ConnectionState.Version),
+			m.QUICHandshakeDone.ConnectionState.NegotiatedProtocol,
+		)
+	}
+
 	// HTTP round trip
 	if m.HTTPRequestHeadersDone != nil {
 		proto := "HTTP/1.1"
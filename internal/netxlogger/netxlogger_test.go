@@ -38,3 +38,14 @@ func TestIntegration(t *testing.T) {
 	}
 	client.HTTPClient.CloseIdleConnections()
 }
+
+func TestUnitQUICEvents(t *testing.T) {
+	log.SetHandler(discard.Default)
+	handler := NewHandler(log.Log)
+	handler.OnMeasurement(modelx.Measurement{
+		QUICHandshakeStart: &modelx.QUICHandshakeStartEvent{SNI: "www.google.com"},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		QUICHandshakeDone: &modelx.QUICHandshakeDoneEvent{},
+	})
+}
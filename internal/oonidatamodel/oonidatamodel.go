@@ -6,6 +6,8 @@ package oonidatamodel
 
 import (
 	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"net"
@@ -14,6 +16,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/internal/tlsx"
 	"github.com/ooni/probe-engine/netx/modelx"
@@ -21,6 +24,7 @@ import (
 
 // TCPConnectStatus contains the TCP connect status.
 type TCPConnectStatus struct {
+	Blocked *bool   `json:"blocked,omitempty"`
 	Failure *string `json:"failure"`
 	Success bool    `json:"success"`
 }
@@ -287,28 +291,50 @@ func NewRequestList(results oonitemplates.Results) RequestList {
 	return out
 }
 
-// DNSAnswerEntry is the answer to a DNS query
+// DNSAnswerEntry is the answer to a DNS query. Which fields are
+// set depends on the AnswerType. The SOA fields follow the names
+// used by OONI specs. RData contains the base64 encoded record
+// data for types not known by the DNS library (e.g. HTTPS).
 type DNSAnswerEntry struct {
-	AnswerType string  `json:"answer_type"`
-	Hostname   string  `json:"hostname,omitempty"`
-	IPv4       string  `json:"ipv4,omitempty"`
-	IPv6       string  `json:"ipv6,omitempty"`
-	TTL        *uint32 `json:"ttl"`
+	Algorithm       *uint8   `json:"algorithm,omitempty"`
+	AnswerType      string   `json:"answer_type"`
+	CAAFlag         *uint8   `json:"caa_flag,omitempty"`
+	CAATag          string   `json:"caa_tag,omitempty"`
+	CAAValue        string   `json:"caa_value,omitempty"`
+	ExpirationLimit *uint32  `json:"expiration_limit,omitempty"`
+	Flags           *uint16  `json:"flags,omitempty"`
+	Hostname        string   `json:"hostname,omitempty"`
+	IPv4            string   `json:"ipv4,omitempty"`
+	IPv6            string   `json:"ipv6,omitempty"`
+	MinimumTTL      *uint32  `json:"minimum_ttl,omitempty"`
+	Priority        *uint16  `json:"priority,omitempty"`
+	Protocol        *uint8   `json:"protocol,omitempty"`
+	PublicKey       string   `json:"public_key,omitempty"`
+	RData           string   `json:"rdata,omitempty"`
+	RefreshInterval *uint32  `json:"refresh_interval,omitempty"`
+	ResponsibleName string   `json:"responsible_name,omitempty"`
+	RetryInterval   *uint32  `json:"retry_interval,omitempty"`
+	SerialNumber    *uint32  `json:"serial_number,omitempty"`
+	TTL             *uint32  `json:"ttl"`
+	TXT             []string `json:"txt,omitempty"`
 }
 
 // DNSQueryEntry is a DNS query with possibly an answer
 type DNSQueryEntry struct {
-	Answers          []DNSAnswerEntry `json:"answers"`
-	DialID           int64            `json:"dial_id,omitempty"`
-	Engine           string           `json:"engine"`
-	Failure          *string          `json:"failure"`
-	Hostname         string           `json:"hostname"`
-	QueryType        string           `json:"query_type"`
-	ResolverHostname *string          `json:"resolver_hostname"`
-	ResolverPort     *string          `json:"resolver_port"`
-	ResolverAddress  string           `json:"resolver_address"`
-	T                float64          `json:"t"`
-	TransactionID    int64            `json:"transaction_id,omitempty"`
+	Answers           []DNSAnswerEntry `json:"answers"`
+	AuthenticatedData *bool            `json:"authenticated_data,omitempty"`
+	Cached            bool             `json:"cached,omitempty"`
+	DialID            int64            `json:"dial_id,omitempty"`
+	DNSSECStatus      string           `json:"dnssec_status,omitempty"`
+	Engine            string           `json:"engine"`
+	Failure           *string          `json:"failure"`
+	Hostname          string           `json:"hostname"`
+	QueryType         string           `json:"query_type"`
+	ResolverHostname  *string          `json:"resolver_hostname"`
+	ResolverPort      *string          `json:"resolver_port"`
+	ResolverAddress   string           `json:"resolver_address"`
+	T                 float64          `json:"t"`
+	TransactionID     int64            `json:"transaction_id,omitempty"`
 }
 
 type (
@@ -322,8 +348,13 @@ func NewDNSQueriesList(results oonitemplates.Results) DNSQueriesList {
 	// TODO(bassosimone): add support for CNAME lookups.
 	var out DNSQueriesList
 	for _, resolve := range results.Resolves {
+		if resolve.QueryType != 0 {
+			out = append(out, newDNSRawQueryEntry(resolve))
+			continue
+		}
 		for _, qtype := range []dnsQueryType{"A", "AAAA"} {
 			entry := qtype.makequeryentry(resolve)
+			entry.setDNSSEC(resolve.DNSSEC[dns.StringToType[string(qtype)]])
 			for _, addr := range resolve.Addresses {
 				if qtype.ipoftype(addr) {
 					entry.Answers = append(entry.Answers, qtype.makeanswerentry(addr))
@@ -358,6 +389,7 @@ func (qtype dnsQueryType) makeanswerentry(addr string) DNSAnswerEntry {
 
 func (qtype dnsQueryType) makequeryentry(resolve *modelx.ResolveDoneEvent) DNSQueryEntry {
 	return DNSQueryEntry{
+		Cached:          resolve.Cached,
 		DialID:          resolve.DialID,
 		Engine:          resolve.TransportNetwork,
 		Failure:         makeFailure(resolve.Error),
@@ -369,6 +401,99 @@ func (qtype dnsQueryType) makequeryentry(resolve *modelx.ResolveDoneEvent) DNSQu
 	}
 }
 
+func (entry *DNSQueryEntry) setDNSSEC(validation *modelx.DNSSECValidation) {
+	if validation != nil {
+		ad := validation.AuthenticatedData
+		entry.AuthenticatedData = &ad
+		entry.DNSSECStatus = validation.Status
+	}
+}
+
+// newDNSRawQueryEntry creates the entry for a LookupRaw query.
+func newDNSRawQueryEntry(resolve *modelx.ResolveDoneEvent) DNSQueryEntry {
+	entry := dnsQueryType(dnsTypeName(resolve.QueryType)).makequeryentry(resolve)
+	entry.setDNSSEC(resolve.DNSSEC[resolve.QueryType])
+	for _, rr := range resolve.Answers {
+		entry.Answers = append(entry.Answers, makeanswerentryfromrr(rr))
+	}
+	return entry
+}
+
+func dnsTypeName(qtype uint16) string {
+	switch qtype {
+	case modelx.DNSTypeSVCB:
+		return "SVCB"
+	case modelx.DNSTypeHTTPS:
+		return "HTTPS"
+	}
+	return dns.Type(qtype).String()
+}
+
+func makeanswerentryfromrr(rr dns.RR) DNSAnswerEntry {
+	ttl := rr.Header().Ttl
+	answer := DNSAnswerEntry{
+		AnswerType: dnsTypeName(rr.Header().Rrtype),
+		TTL:        &ttl,
+	}
+	switch v := rr.(type) {
+	case *dns.A:
+		answer.IPv4 = v.A.String()
+	case *dns.AAAA:
+		answer.IPv6 = v.AAAA.String()
+	case *dns.CAA:
+		answer.CAAFlag = &v.Flag
+		answer.CAATag = v.Tag
+		answer.CAAValue = v.Value
+	case *dns.CNAME:
+		answer.Hostname = v.Target
+	case *dns.DNSKEY:
+		answer.Algorithm = &v.Algorithm
+		answer.Flags = &v.Flags
+		answer.Protocol = &v.Protocol
+		answer.PublicKey = v.PublicKey
+	case *dns.MX:
+		answer.Hostname = v.Mx
+	case *dns.NS:
+		answer.Hostname = v.Ns
+	case *dns.PTR:
+		answer.Hostname = v.Ptr
+	case *dns.SOA:
+		answer.ExpirationLimit = &v.Expire
+		answer.Hostname = v.Ns
+		answer.MinimumTTL = &v.Minttl
+		answer.RefreshInterval = &v.Refresh
+		answer.ResponsibleName = v.Mbox
+		answer.RetryInterval = &v.Retry
+		answer.SerialNumber = &v.Serial
+	case *dns.TXT:
+		answer.TXT = v.Txt
+	case *dns.RFC3597:
+		data, err := hex.DecodeString(v.Rdata)
+		if err != nil {
+			break // the DNS library should always give us valid hex
+		}
+		answer.RData = base64.StdEncoding.EncodeToString(data)
+		if v.Hdr.Rrtype == modelx.DNSTypeSVCB || v.Hdr.Rrtype == modelx.DNSTypeHTTPS {
+			parsesvcb(data, &answer)
+		}
+	}
+	return answer
+}
+
+// parsesvcb extracts SvcPriority and TargetName from the record data
+// of a SVCB or HTTPS record. The SvcParams are only available in the
+// RData field. See draft-ietf-dnsop-svcb-https.
+func parsesvcb(data []byte, answer *DNSAnswerEntry) {
+	if len(data) < 2 {
+		return
+	}
+	priority := binary.BigEndian.Uint16(data)
+	answer.Priority = &priority
+	if target, _, err := dns.UnpackDomainName(data, 2); err == nil {
+		answer.Hostname = target
+	}
+}
+
 // NetworkEvent is a network event.
 type NetworkEvent struct {
 	Address       string  `json:"address,omitempty"`
@@ -438,6 +563,10 @@ type TLSHandshake struct {
 	CipherSuite        string             `json:"cipher_suite"`
 	ConnID             int64              `json:"conn_id,omitempty"`
 	Failure            *string            `json:"failure"`
+	JA3                string             `json:"ja3,omitempty"`
+	JA3Hash            string             `json:"ja3_hash,omitempty"`
+	JA3S               string             `json:"ja3s,omitempty"`
+	JA3SHash           string             `json:"ja3s_hash,omitempty"`
 	NegotiatedProtocol string             `json:"negotiated_protocol"`
 	PeerCertificates   []MaybeBinaryValue `json:"peer_certificates"`
 	T                  float64            `json:"t"`
@@ -456,6 +585,10 @@ func NewTLSHandshakesList(results oonitemplates.Results) TLSHandshakesList {
 			CipherSuite:        tlsx.CipherSuiteString(in.ConnectionState.CipherSuite),
 			ConnID:             in.ConnID,
 			Failure:            makeFailure(in.Error),
+			JA3:                in.ConnectionState.JA3,
+			JA3Hash:            in.ConnectionState.JA3Hash,
+			JA3S:               in.ConnectionState.JA3S,
+			JA3SHash:           in.ConnectionState.JA3SHash,
 			NegotiatedProtocol: in.ConnectionState.NegotiatedProtocol,
 			PeerCertificates:   makePeerCerts(in.ConnectionState.PeerCertificates),
 			T:                  in.DurationSinceBeginning.Seconds(),
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/internal/oonitemplates"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
@@ -790,6 +791,121 @@ func dnscheckbad(e DNSQueryEntry) error {
 	return nil
 }
 
+func TestUnitNewDNSQueriesListRaw(t *testing.T) {
+	hdr := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{Name: "ooni.io.", Rrtype: rrtype, Ttl: 300}
+	}
+	out := NewDNSQueriesList(oonitemplates.Results{
+		Resolves: []*modelx.ResolveDoneEvent{
+			&modelx.ResolveDoneEvent{
+				Answers: []dns.RR{
+					&dns.TXT{Hdr: hdr(dns.TypeTXT), Txt: []string{"v=spf1 -all"}},
+					&dns.CAA{Hdr: hdr(dns.TypeCAA), Tag: "issue", Value: "letsencrypt.org"},
+					&dns.SOA{
+						Hdr: hdr(dns.TypeSOA), Ns: "ns1.ooni.io.", Mbox: "admin.ooni.io.",
+						Serial: 17, Refresh: 7200, Retry: 3600, Expire: 1209600, Minttl: 60,
+					},
+					&dns.DNSKEY{
+						Hdr: hdr(dns.TypeDNSKEY), Flags: 257, Protocol: 3,
+						Algorithm: 13, PublicKey: "AAAA",
+					},
+					&dns.CNAME{Hdr: hdr(dns.TypeCNAME), Target: "www.ooni.io."},
+					&dns.RFC3597{Hdr: hdr(modelx.DNSTypeHTTPS), Rdata: "00010000010003026832"},
+				},
+				Hostname:         "ooni.io",
+				QueryType:        dns.TypeANY,
+				TransportNetwork: "udp",
+				TransportAddress: "1.1.1.1:53",
+			},
+		},
+	})
+	if len(out) != 1 {
+		t.Fatal("unexpected output length")
+	}
+	if out[0].QueryType != "ANY" || out[0].Engine != "udp" || out[0].Failure != nil {
+		t.Fatal("unexpected query entry")
+	}
+	data, err := json.Marshal(out[0].Answers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"answer_type":"TXT","ttl":300,"txt":["v=spf1 -all"]},` +
+		`{"answer_type":"CAA","caa_flag":0,"caa_tag":"issue","caa_value":"letsencrypt.org","ttl":300},` +
+		`{"answer_type":"SOA","expiration_limit":1209600,"hostname":"ns1.ooni.io.",` +
+		`"minimum_ttl":60,"refresh_interval":7200,"responsible_name":"admin.ooni.io.",` +
+		`"retry_interval":3600,"serial_number":17,"ttl":300},` +
+		`{"algorithm":13,"answer_type":"DNSKEY","flags":257,"protocol":3,"public_key":"AAAA","ttl":300},` +
+		`{"answer_type":"CNAME","hostname":"www.ooni.io.","ttl":300},` +
+		`{"answer_type":"HTTPS","hostname":".","priority":1,"rdata":"AAEAAAEAAwJoMg==","ttl":300}]`
+	if string(data) != expected {
+		t.Fatal(string(data))
+	}
+}
+
+func TestUnitNewDNSQueriesListDNSSEC(t *testing.T) {
+	out := NewDNSQueriesList(oonitemplates.Results{
+		Resolves: []*modelx.ResolveDoneEvent{
+			&modelx.ResolveDoneEvent{
+				Addresses: []string{"8.8.4.4"},
+				DNSSEC: map[uint16]*modelx.DNSSECValidation{
+					dns.TypeA: &modelx.DNSSECValidation{
+						AuthenticatedData: true,
+						Status:            modelx.DNSSECStatusSecure,
+					},
+				},
+				Hostname:         "dns.google",
+				TransportNetwork: "udp",
+			},
+		},
+	})
+	if len(out) != 2 {
+		t.Fatal("unexpected output length")
+	}
+	if out[0].QueryType != "A" || out[0].DNSSECStatus != modelx.DNSSECStatusSecure {
+		t.Fatal("unexpected A entry")
+	}
+	if out[0].AuthenticatedData == nil || *out[0].AuthenticatedData != true {
+		t.Fatal("unexpected AD bit for A entry")
+	}
+	if out[1].QueryType != "AAAA" || out[1].DNSSECStatus != "" {
+		t.Fatal("unexpected AAAA entry")
+	}
+	if out[1].AuthenticatedData != nil {
+		t.Fatal("unexpected AD bit for AAAA entry")
+	}
+}
+
+func TestUnitNewDNSQueriesListCached(t *testing.T) {
+	out := NewDNSQueriesList(oonitemplates.Results{
+		Resolves: []*modelx.ResolveDoneEvent{
+			&modelx.ResolveDoneEvent{
+				Addresses:        []string{"8.8.4.4"},
+				Cached:           true,
+				Hostname:         "dns.google",
+				TransportNetwork: "system",
+			},
+		},
+	})
+	if len(out) != 2 {
+		t.Fatal("unexpected output length")
+	}
+	if !out[0].Cached || !out[1].Cached {
+		t.Fatal("entries not marked as cached")
+	}
+}
+
+func TestUnitDNSTypeName(t *testing.T) {
+	if dnsTypeName(modelx.DNSTypeSVCB) != "SVCB" {
+		t.Fatal("unexpected SVCB name")
+	}
+	if dnsTypeName(dns.TypeCAA) != "CAA" {
+		t.Fatal("unexpected CAA name")
+	}
+	if dnsTypeName(4444) != "TYPE4444" {
+		t.Fatal("unexpected unknown type name")
+	}
+}
+
 func TestUnitDNSQueryTypeIPOfType(t *testing.T) {
 	qtype := dnsQueryType("ANTANI")
 	if qtype.ipoftype("8.8.8.8") == true {
@@ -1057,6 +1173,10 @@ func TestUnitNewTLSHandshakesListSuccess(t *testing.T) {
 			&modelx.TLSHandshakeDoneEvent{
 				ConnectionState: modelx.TLSConnectionState{
 					CipherSuite:        tls.TLS_AES_128_GCM_SHA256,
+					JA3:                "771,4865,0-10-11,29,0",
+					JA3Hash:            "c3bcd2fd7b2a6e0a5c9e6d6f8b1e3a4d",
+					JA3S:               "771,4865,43-51",
+					JA3SHash:           "0e8d3c2b6f1a7e9d4c5b2a1f3e6d8c7b",
 					NegotiatedProtocol: "h2",
 					PeerCertificates: []modelx.X509Certificate{
 						modelx.X509Certificate{
@@ -1085,6 +1205,12 @@ func TestUnitNewTLSHandshakesListSuccess(t *testing.T) {
 	if out[0].Failure != nil {
 		t.Fatal("invalid out[0].Failure")
 	}
+	if out[0].JA3 != "" || out[0].JA3Hash != "" {
+		t.Fatal("invalid out[0].JA3")
+	}
+	if out[0].JA3S != "" || out[0].JA3SHash != "" {
+		t.Fatal("invalid out[0].JA3S")
+	}
 	if out[0].NegotiatedProtocol != "" {
 		t.Fatal("invalid out[0].NegotiatedProtocol")
 	}
@@ -1129,6 +1255,18 @@ func TestUnitNewTLSHandshakesListSuccess(t *testing.T) {
 	if out[2].Failure != nil {
 		t.Fatal("invalid out[2].Failure")
 	}
+	if out[2].JA3 != "771,4865,0-10-11,29,0" {
+		t.Fatal("invalid out[2].JA3")
+	}
+	if out[2].JA3Hash != "c3bcd2fd7b2a6e0a5c9e6d6f8b1e3a4d" {
+		t.Fatal("invalid out[2].JA3Hash")
+	}
+	if out[2].JA3S != "771,4865,43-51" {
+		t.Fatal("invalid out[2].JA3S")
+	}
+	if out[2].JA3SHash != "0e8d3c2b6f1a7e9d4c5b2a1f3e6d8c7b" {
+		t.Fatal("invalid out[2].JA3SHash")
+	}
 	if out[2].NegotiatedProtocol != "h2" {
 		t.Fatal("invalid out[2].NegotiatedProtocol")
 	}
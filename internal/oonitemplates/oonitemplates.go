@@ -9,17 +9,22 @@
 package oonitemplates
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	goptlib "git.torproject.org/pluggable-transports/goptlib.git"
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx"
@@ -127,6 +132,16 @@ func (r *Results) onMeasurement(m modelx.Measurement, lowLevel bool) {
 		m.TLSHandshakeDone.ConnID = cm.scramble(m.TLSHandshakeDone.ConnID)
 		r.TLSHandshakes = append(r.TLSHandshakes, m.TLSHandshakeDone)
 	}
+	if m.QUICHandshakeDone != nil {
+		// The QUIC handshake is a TLS handshake where QUIC replaces the
+		// record layer, hence we also account for it as a TLS handshake.
+		r.TLSHandshakes = append(r.TLSHandshakes, &modelx.TLSHandshakeDoneEvent{
+			ConnectionState:        m.QUICHandshakeDone.ConnectionState,
+			ConnID:                 cm.scramble(m.QUICHandshakeDone.ConnID),
+			DurationSinceBeginning: m.QUICHandshakeDone.DurationSinceBeginning,
+			Error:                  m.QUICHandshakeDone.Error,
+		})
+	}
 	if m.Read != nil {
 		m.Read.ConnID = cm.scramble(m.Read.ConnID)
 		r.ReceivedBytes += m.Read.NumBytes // overflow unlikely
@@ -224,16 +239,21 @@ func configureDNS(seed int64, network, address string) (modelx.DNSResolver, erro
 // DNSLookupConfig contains DNSLookup settings.
 type DNSLookupConfig struct {
 	Beginning     time.Time
+	DNSSEC        bool // validate replies (not with the system resolver)
 	Handler       modelx.Handler
 	Hostname      string
+	QueryType     uint16 // zero means A and AAAA (i.e. LookupHost)
 	ServerAddress string
 	ServerNetwork string
 }
 
-// DNSLookupResults contains the results of a DNSLookup
+// DNSLookupResults contains the results of a DNSLookup. When
+// a QueryType was specified, the reply is in Reply and Addresses
+// is empty, otherwise Addresses is filled and Reply is nil.
 type DNSLookupResults struct {
 	TestKeys  Results
 	Addresses []string
+	Reply     *dns.Msg
 	Error     error
 }
 
@@ -250,8 +270,9 @@ func DNSLookup(
 	}
 	channel := make(chan modelx.Measurement)
 	root := &modelx.MeasurementRoot{
-		Beginning: config.Beginning,
-		Handler:   newChannelHandler(channel),
+		Beginning:        config.Beginning,
+		DNSSECValidation: config.DNSSEC,
+		Handler:          newChannelHandler(channel),
 	}
 	ctx = modelx.WithMeasurementRoot(ctx, root)
 	resolver, err := netx.NewResolver(config.ServerNetwork, config.ServerAddress)
@@ -260,6 +281,19 @@ func DNSLookup(
 		return results
 	}
 	results.TestKeys.collect(channel, config.Handler, func() {
+		if config.QueryType != 0 {
+			var (
+				reply *dns.Msg
+				err   = modelx.ErrDNSRawNotSupported
+			)
+			if reso, okay := resolver.(modelx.DNSRawResolver); okay {
+				reply, err = reso.LookupRaw(ctx, config.Hostname, config.QueryType)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results.Reply, results.Error = reply, err
+			return
+		}
 		addrs, err := resolver.LookupHost(ctx, config.Hostname)
 		mu.Lock()
 		defer mu.Unlock()
@@ -274,12 +308,16 @@ type HTTPDoConfig struct {
 	AcceptLanguage     string
 	Beginning          time.Time
 	Body               []byte
+	CABundlePath       string
 	DNSServerAddress   string
 	DNSServerNetwork   string
+	HTTP3              bool
 	Handler            modelx.Handler
+	Host               string
 	InsecureSkipVerify bool
 	Method             string
 	ProxyFunc          func(*http.Request) (*url.URL, error)
+	SNI                string
 	URL                string
 	UserAgent          string
 
@@ -294,6 +332,10 @@ type HTTPDoConfig struct {
 	//
 	// Same rules as modelx.MeasurementRoot.MaxBodySnapSize.
 	MaxResponseBodySnapSize int64
+
+	// headers contains extra headers to add to the request. We use
+	// it to implement templates based on HTTPDo.
+	headers http.Header
 }
 
 // HTTPDoResults contains the results of a HTTPDo
@@ -317,7 +359,6 @@ func HTTPDo(
 		config.Beginning = time.Now()
 	}
 	channel := make(chan modelx.Measurement)
-	// TODO(bassosimone): tell client to use specific CA bundle?
 	root := &modelx.MeasurementRoot{
 		Beginning:       config.Beginning,
 		Handler:         newChannelHandler(channel),
@@ -335,21 +376,44 @@ func HTTPDo(
 		return results
 	}
 	client.SetResolver(resolver)
+	if config.CABundlePath != "" {
+		if err := client.SetCABundle(config.CABundlePath); err != nil {
+			results.Error = err
+			return results
+		}
+	}
 	if config.InsecureSkipVerify {
 		client.ForceSkipVerify()
 	}
-	// TODO(bassosimone): implement sending body
-	req, err := http.NewRequest(config.Method, config.URL, nil)
+	if config.SNI != "" {
+		client.ForceSpecificSNI(config.SNI)
+	}
+	if config.HTTP3 {
+		client.ForceHTTP3()
+	}
+	var body io.Reader
+	if config.Body != nil {
+		body = bytes.NewReader(config.Body)
+	}
+	req, err := http.NewRequest(config.Method, config.URL, body)
 	if err != nil {
 		results.Error = err
 		return results
 	}
+	if config.Host != "" {
+		req.Host = config.Host
+	}
 	if config.Accept != "" {
 		req.Header.Set("Accept", config.Accept)
 	}
 	if config.AcceptLanguage != "" {
 		req.Header.Set("Accept-Language", config.AcceptLanguage)
 	}
+	for key, values := range config.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
 	req.Header.Set("User-Agent", config.UserAgent)
 	req = req.WithContext(ctx)
 	results.TestKeys.collect(channel, config.Handler, func() {
@@ -383,6 +447,7 @@ func HTTPDo(
 type TLSConnectConfig struct {
 	Address            string
 	Beginning          time.Time
+	ClientHello        string
 	DNSServerAddress   string
 	DNSServerNetwork   string
 	Handler            modelx.Handler
@@ -430,6 +495,12 @@ func TLSConnect(
 	}
 	// TODO(bassosimone): can this call really fail?
 	dialer.ForceSpecificSNI(config.SNI)
+	if config.ClientHello != "" {
+		if err := dialer.ForceClientHello(config.ClientHello); err != nil {
+			results.Error = err
+			return results
+		}
+	}
 	results.TestKeys.collect(channel, config.Handler, func() {
 		conn, err := dialer.DialTLSContext(ctx, "tcp", config.Address)
 		if conn != nil {
@@ -610,3 +681,151 @@ func OBFS4Connect(
 	}, true)
 	return results
 }
+
+// newFrontedHTTPDoConfig returns the HTTPDoConfig for sending a request
+// to URL using front, if not empty, for domain fronting. That is, we
+// replace the URL host with front, which we also use as SNI, and we
+// set the Host header to the original URL host.
+func newFrontedHTTPDoConfig(URL, front string) (HTTPDoConfig, error) {
+	parsed, err := url.Parse(URL)
+	if err != nil {
+		return HTTPDoConfig{}, err
+	}
+	if parsed.Host == "" {
+		return HTTPDoConfig{}, ErrMissingURLHost
+	}
+	config := HTTPDoConfig{URL: parsed.String()}
+	if front != "" {
+		config.Host = parsed.Host
+		parsed.Host = front
+		config.SNI = parsed.Hostname()
+		config.URL = parsed.String()
+	}
+	return config, nil
+}
+
+var (
+	// ErrMissingURLHost indicates that the URL has no host.
+	ErrMissingURLHost = errors.New("oonitemplates: URL has no host")
+
+	// ErrMeekUnexpectedStatusCode indicates that the meek server
+	// did not accept our poll request.
+	ErrMeekUnexpectedStatusCode = errors.New("meek_unexpected_status_code")
+
+	// ErrSnowflakeNoProxies indicates that the snowflake broker
+	// could not match us with any snowflake proxy.
+	ErrSnowflakeNoProxies = errors.New("snowflake_no_proxies_available")
+
+	// ErrSnowflakeUnexpectedStatusCode indicates that the snowflake
+	// broker returned an unexpected status code.
+	ErrSnowflakeUnexpectedStatusCode = errors.New("snowflake_unexpected_status_code")
+)
+
+// MeekConnectConfig contains MeekConnect settings.
+type MeekConnectConfig struct {
+	Beginning        time.Time
+	DNSServerAddress string
+	DNSServerNetwork string
+	Handler          modelx.Handler
+	Params           goptlib.Args
+	UserAgent        string
+}
+
+// MeekConnectResults contains the results of a MeekConnect
+type MeekConnectResults struct {
+	TestKeys Results
+	Error    error
+}
+
+// MeekConnect performs a meek handshake. The Params must contain the
+// url of the meek server and may contain the front domain. We send an
+// empty poll request, which the meek server should accept with 200,
+// as it would happen when a client opens a new meek session.
+func MeekConnect(
+	ctx context.Context, config MeekConnectConfig,
+) *MeekConnectResults {
+	results := new(MeekConnectResults)
+	URL, _ := config.Params.Get("url")
+	front, _ := config.Params.Get("front")
+	httpConfig, err := newFrontedHTTPDoConfig(URL, front)
+	if err != nil {
+		results.Error = err
+		return results
+	}
+	gen := rand.New(rand.NewSource(time.Now().UnixNano()))
+	httpConfig.Beginning = config.Beginning
+	httpConfig.Body = []byte{}
+	httpConfig.DNSServerAddress = config.DNSServerAddress
+	httpConfig.DNSServerNetwork = config.DNSServerNetwork
+	httpConfig.Handler = config.Handler
+	httpConfig.Method = "POST"
+	httpConfig.UserAgent = config.UserAgent
+	httpConfig.headers = http.Header{
+		"X-Session-Id": []string{fmt.Sprintf("%016x", gen.Uint64())},
+	}
+	r := HTTPDo(ctx, httpConfig)
+	results.TestKeys, results.Error = r.TestKeys, r.Error
+	if results.Error == nil && r.StatusCode != 200 {
+		results.Error = ErrMeekUnexpectedStatusCode
+	}
+	return results
+}
+
+// snowflakeOffer is the SDP offer we send to the broker. We are only
+// interested in the rendezvous, so a minimal offer is enough.
+const snowflakeOffer = `{"type":"offer","sdp":"v=0\r\no=- 0 0 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n"}`
+
+// SnowflakeRendezvousConfig contains SnowflakeRendezvous settings.
+type SnowflakeRendezvousConfig struct {
+	Beginning        time.Time
+	DNSServerAddress string
+	DNSServerNetwork string
+	Handler          modelx.Handler
+	Params           goptlib.Args
+	UserAgent        string
+}
+
+// SnowflakeRendezvousResults contains the results of a SnowflakeRendezvous
+type SnowflakeRendezvousResults struct {
+	TestKeys Results
+	Answer   []byte
+	Error    error
+}
+
+// SnowflakeRendezvous performs the rendezvous with the snowflake broker
+// using the domain fronting method. The Params must contain the url of
+// the broker and may contain the front domain. The rendezvous succeeds
+// when the broker returns the answer of a snowflake proxy.
+func SnowflakeRendezvous(
+	ctx context.Context, config SnowflakeRendezvousConfig,
+) *SnowflakeRendezvousResults {
+	results := new(SnowflakeRendezvousResults)
+	URL, _ := config.Params.Get("url")
+	front, _ := config.Params.Get("front")
+	httpConfig, err := newFrontedHTTPDoConfig(strings.TrimSuffix(URL, "/")+"/client", front)
+	if err != nil {
+		results.Error = err
+		return results
+	}
+	httpConfig.Beginning = config.Beginning
+	httpConfig.Body = []byte(snowflakeOffer)
+	httpConfig.DNSServerAddress = config.DNSServerAddress
+	httpConfig.DNSServerNetwork = config.DNSServerNetwork
+	httpConfig.Handler = config.Handler
+	httpConfig.Method = "POST"
+	httpConfig.UserAgent = config.UserAgent
+	r := HTTPDo(ctx, httpConfig)
+	results.TestKeys, results.Error = r.TestKeys, r.Error
+	if results.Error != nil {
+		return results
+	}
+	switch r.StatusCode {
+	case 200:
+		results.Answer = r.BodySnap
+	case 503, 504:
+		results.Error = ErrSnowflakeNoProxies
+	default:
+		results.Error = ErrSnowflakeUnexpectedStatusCode
+	}
+	return results
+}
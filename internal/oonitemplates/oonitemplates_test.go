@@ -3,13 +3,17 @@ package oonitemplates
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	goptlib "git.torproject.org/pluggable-transports/goptlib.git"
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/modelx"
 	"gitlab.com/yawning/obfs4.git/transports"
 	obfs4base "gitlab.com/yawning/obfs4.git/transports/base"
@@ -73,6 +77,65 @@ func TestIntegrationDNSLookupUnknownDNS(t *testing.T) {
 	}
 }
 
+func TestIntegrationDNSLookupRaw(t *testing.T) {
+	ctx := context.Background()
+	results := DNSLookup(ctx, DNSLookupConfig{
+		Hostname:      "ooni.io",
+		QueryType:     dns.TypeTXT,
+		ServerAddress: "1.1.1.1:53",
+		ServerNetwork: "udp",
+	})
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+	if results.Reply == nil || len(results.Reply.Answer) < 1 {
+		t.Fatal("no answers returned?!")
+	}
+	if len(results.Addresses) > 0 {
+		t.Fatal("addresses returned?!")
+	}
+	if len(results.TestKeys.Resolves) != 1 {
+		t.Fatal("unexpected number of resolves")
+	}
+	if results.TestKeys.Resolves[0].QueryType != dns.TypeTXT {
+		t.Fatal("unexpected query type")
+	}
+}
+
+func TestIntegrationDNSLookupDNSSEC(t *testing.T) {
+	ctx := context.Background()
+	results := DNSLookup(ctx, DNSLookupConfig{
+		DNSSEC:        true,
+		Hostname:      "dns.google",
+		ServerAddress: "1.1.1.1:53",
+		ServerNetwork: "udp",
+	})
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+	if len(results.TestKeys.Resolves) != 1 {
+		t.Fatal("unexpected number of resolves")
+	}
+	validation := results.TestKeys.Resolves[0].DNSSEC[dns.TypeA]
+	if validation == nil || validation.Status != modelx.DNSSECStatusSecure {
+		t.Fatal("expected a secure reply")
+	}
+}
+
+func TestUnitDNSLookupRawSystemResolver(t *testing.T) {
+	ctx := context.Background()
+	results := DNSLookup(ctx, DNSLookupConfig{
+		Hostname:  "ooni.io",
+		QueryType: dns.TypeTXT,
+	})
+	if !errors.Is(results.Error, modelx.ErrDNSRawNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if results.Reply != nil {
+		t.Fatal("expected a nil reply here")
+	}
+}
+
 func TestIntegrationHTTPDoGood(t *testing.T) {
 	ctx := context.Background()
 	results := HTTPDo(ctx, HTTPDoConfig{
@@ -105,6 +168,34 @@ func TestIntegrationHTTPDoUnknownDNS(t *testing.T) {
 	}
 }
 
+func TestIntegrationHTTPDoCABundle(t *testing.T) {
+	ctx := context.Background()
+	results := HTTPDo(ctx, HTTPDoConfig{
+		CABundlePath: "../../netx/testdata/cacert.pem",
+		URL:          "https://www.example.com/",
+	})
+	if results.Error == nil {
+		t.Fatal("expected an error here")
+	}
+	if results.Error.Error() != modelx.FailureSSLUnknownAuthority {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitHTTPDoCABundleNonexisting(t *testing.T) {
+	ctx := context.Background()
+	results := HTTPDo(ctx, HTTPDoConfig{
+		CABundlePath: "testdata/cacert-nonexistent.pem",
+		URL:          "https://www.example.com/",
+	})
+	if results.Error == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(results.TestKeys.Connects) != 0 {
+		t.Fatal("expected no connects here")
+	}
+}
+
 func TestIntegrationHTTPDoForceSkipVerify(t *testing.T) {
 	ctx := context.Background()
 	results := HTTPDo(ctx, HTTPDoConfig{
@@ -116,6 +207,39 @@ func TestIntegrationHTTPDoForceSkipVerify(t *testing.T) {
 	}
 }
 
+func TestIntegrationHTTPDoHost(t *testing.T) {
+	ctx := context.Background()
+	results := HTTPDo(ctx, HTTPDoConfig{
+		Host: "www.google.com",
+		URL:  "https://www.example.com/",
+	})
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+	if len(results.TestKeys.HTTPRequests) != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+	headers := results.TestKeys.HTTPRequests[0].RequestHeaders
+	host := headers.Get("Host")
+	if host == "" {
+		host = headers.Get(":authority") // HTTP/2
+	}
+	if host != "www.google.com" {
+		t.Fatal("unexpected Host")
+	}
+}
+
+func TestIntegrationHTTPDoForceSpecificSNI(t *testing.T) {
+	ctx := context.Background()
+	results := HTTPDo(ctx, HTTPDoConfig{
+		URL: "https://www.example.com/",
+		SNI: "ooni.io",
+	})
+	if results.Error == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
 func TestIntegrationHTTPDoRoundTripError(t *testing.T) {
 	ctx := context.Background()
 	results := HTTPDo(ctx, HTTPDoConfig{
@@ -196,6 +320,31 @@ func TestIntegrationTLSConnectForceSkipVerify(t *testing.T) {
 	}
 }
 
+func TestIntegrationTLSConnectClientHello(t *testing.T) {
+	ctx := context.Background()
+	results := TLSConnect(ctx, TLSConnectConfig{
+		Address:     "www.google.com:443",
+		ClientHello: "chrome",
+	})
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+}
+
+func TestUnitTLSConnectClientHelloUnknown(t *testing.T) {
+	ctx := context.Background()
+	results := TLSConnect(ctx, TLSConnectConfig{
+		Address:     "www.google.com:443",
+		ClientHello: "antani",
+	})
+	if results.Error == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(results.TestKeys.Connects) != 0 {
+		t.Fatal("expected no connects here")
+	}
+}
+
 func TestIntegrationBodySnapSizes(t *testing.T) {
 	const (
 		maxEventsBodySnapSize   = 1 << 7
@@ -387,6 +536,198 @@ func (txp *faketransport) ServerFactory(stateDir string, args *goptlib.Args) (ob
 	return txp.ServerFactory(stateDir, args)
 }
 
+func TestUnitHTTPDoBodyAndHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			data, _ := ioutil.ReadAll(r.Body)
+			if string(data) != "antani" || r.Header.Get("X-Antani") != "mascetti" {
+				w.WriteHeader(400)
+				return
+			}
+			w.WriteHeader(200)
+		}))
+	defer server.Close()
+	results := HTTPDo(context.Background(), HTTPDoConfig{
+		Body:    []byte("antani"),
+		Method:  "POST",
+		URL:     server.URL,
+		headers: http.Header{"X-Antani": []string{"mascetti"}},
+	})
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+	if results.StatusCode != 200 {
+		t.Fatal("the server did not see our body and headers")
+	}
+}
+
+func TestUnitNewFrontedHTTPDoConfig(t *testing.T) {
+	t.Run("with front", func(t *testing.T) {
+		config, err := newFrontedHTTPDoConfig(
+			"https://meek.azureedge.net/", "ajax.aspnetcdn.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.URL != "https://ajax.aspnetcdn.com/" {
+			t.Fatal("unexpected URL")
+		}
+		if config.Host != "meek.azureedge.net" || config.SNI != "ajax.aspnetcdn.com" {
+			t.Fatal("unexpected Host or SNI")
+		}
+	})
+	t.Run("without front", func(t *testing.T) {
+		config, err := newFrontedHTTPDoConfig("https://meek.azureedge.net/", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.URL != "https://meek.azureedge.net/" || config.Host != "" || config.SNI != "" {
+			t.Fatal("unexpected config")
+		}
+	})
+	t.Run("with invalid URL", func(t *testing.T) {
+		_, err := newFrontedHTTPDoConfig("\t", "")
+		if err == nil {
+			t.Fatal("expected an error here")
+		}
+	})
+	t.Run("with missing host", func(t *testing.T) {
+		_, err := newFrontedHTTPDoConfig("/antani", "")
+		if !errors.Is(err, ErrMissingURLHost) {
+			t.Fatal("not the error we expected")
+		}
+	})
+}
+
+func newMeekServer(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.Header.Get("X-Session-Id") == "" {
+				w.WriteHeader(400)
+				return
+			}
+			w.WriteHeader(status)
+		}))
+}
+
+func TestUnitMeekConnectGood(t *testing.T) {
+	server := newMeekServer(200)
+	defer server.Close()
+	results := MeekConnect(context.Background(), MeekConnectConfig{
+		Params: goptlib.Args{"url": []string{server.URL}},
+	})
+	if results.Error != nil {
+		t.Fatal(results.Error)
+	}
+	if len(results.TestKeys.HTTPRequests) != 1 {
+		t.Fatal("unexpected number of requests")
+	}
+}
+
+func TestUnitMeekConnectUnexpectedStatusCode(t *testing.T) {
+	server := newMeekServer(404)
+	defer server.Close()
+	results := MeekConnect(context.Background(), MeekConnectConfig{
+		Params: goptlib.Args{"url": []string{server.URL}},
+	})
+	if !errors.Is(results.Error, ErrMeekUnexpectedStatusCode) {
+		t.Fatal("not the error we expected")
+	}
+	if results.Error.Error() != "meek_unexpected_status_code" {
+		t.Fatal("not the failure string we expected")
+	}
+}
+
+func TestUnitMeekConnectMissingURL(t *testing.T) {
+	results := MeekConnect(context.Background(), MeekConnectConfig{})
+	if !errors.Is(results.Error, ErrMissingURLHost) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func newSnowflakeBroker(status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != "/client" {
+				w.WriteHeader(400)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			if !strings.Contains(string(data), `"type":"offer"`) {
+				w.WriteHeader(400)
+				return
+			}
+			w.WriteHeader(status)
+			if status == 200 {
+				w.Write([]byte(`{"type":"answer","sdp":""}`))
+			}
+		}))
+}
+
+func TestUnitSnowflakeRendezvous(t *testing.T) {
+	var inputs = []struct {
+		status int
+		err    error
+	}{
+		{200, nil},
+		{503, ErrSnowflakeNoProxies},
+		{504, ErrSnowflakeNoProxies},
+		{400, ErrSnowflakeUnexpectedStatusCode},
+	}
+	for _, input := range inputs {
+		server := newSnowflakeBroker(input.status)
+		results := SnowflakeRendezvous(context.Background(), SnowflakeRendezvousConfig{
+			Params: goptlib.Args{"url": []string{server.URL + "/"}},
+		})
+		server.Close()
+		if !errors.Is(results.Error, input.err) {
+			t.Fatalf("%d: not the error we expected: %+v", input.status, results.Error)
+		}
+		if input.err == nil && !strings.Contains(string(results.Answer), "answer") {
+			t.Fatalf("%d: unexpected answer", input.status)
+		}
+	}
+}
+
+func TestUnitSnowflakeRendezvousMissingURL(t *testing.T) {
+	results := SnowflakeRendezvous(context.Background(), SnowflakeRendezvousConfig{})
+	if !errors.Is(results.Error, ErrMissingURLHost) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitResultsQUICHandshakeDone(t *testing.T) {
+	var results Results
+	results.onMeasurement(modelx.Measurement{
+		QUICHandshakeDone: &modelx.QUICHandshakeDoneEvent{
+			ConnectionState: modelx.TLSConnectionState{NegotiatedProtocol: "h3-24"},
+			ConnID:          1234,
+		},
+	}, false)
+	if len(results.TLSHandshakes) != 1 {
+		t.Fatal("expected a TLS handshake here")
+	}
+	if results.TLSHandshakes[0].ConnectionState.NegotiatedProtocol != "h3-24" {
+		t.Fatal("unexpected negotiated protocol")
+	}
+	if results.TLSHandshakes[0].ConnID == 1234 {
+		t.Fatal("the ConnID has not been scrambled")
+	}
+}
+
+func TestIntegrationHTTPDoHTTP3(t *testing.T) {
+	ctx := context.Background()
+	results := HTTPDo(ctx, HTTPDoConfig{
+		HTTP3: true,
+		URL:   "http://www.google.com/", // cannot use HTTP/3 with cleartext
+	})
+	if results.Error == nil {
+		t.Fatal("expected an error here")
+	}
+	if len(results.TestKeys.Connects) != 0 {
+		t.Fatal("expected no connects here")
+	}
+}
+
 func TestUnitConnmapper(t *testing.T) {
 	var mapper connmapper
 	if mapper.scramble(-1) >= 0 {
@@ -347,6 +347,7 @@ type ExperimentSession interface {
 	ProbeCC() string
 	ProbeIP() string
 	ProbeNetworkName() string
+	ResolverIP() string
 	SoftwareName() string
 	SoftwareVersion() string
 	TempDir() string
@@ -12,6 +12,7 @@ import (
 
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/internal/dialer"
+	"github.com/ooni/probe-engine/netx/internal/dialer/tlsdialer"
 	"github.com/ooni/probe-engine/netx/internal/resolver"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
@@ -22,6 +23,9 @@ type Dialer struct {
 	Handler   modelx.Handler
 	Resolver  modelx.DNSResolver
 	TLSConfig *tls.Config
+
+	clientHello   tlsdialer.ClientHelloBuilder
+	happyEyeballs bool
 }
 
 func newDialer(beginning time.Time, handler modelx.Handler) *Dialer {
@@ -61,9 +65,13 @@ func (d *Dialer) DialContext(
 	ctx context.Context, network, address string,
 ) (conn net.Conn, err error) {
 	ctx = maybeWithMeasurementRoot(ctx, d.Beginning, d.Handler)
-	return dialer.New(
-		d.Resolver, new(net.Dialer),
-	).DialContext(ctx, network, address)
+	return d.newDNSDialer().DialContext(ctx, network, address)
+}
+
+func (d *Dialer) newDNSDialer() modelx.Dialer {
+	dnsDialer := dialer.New(d.Resolver, new(net.Dialer))
+	dnsDialer.HappyEyeballs = d.happyEyeballs
+	return dnsDialer
 }
 
 // DialTLS is like Dial, but creates TLS connections.
@@ -77,10 +85,9 @@ func (d *Dialer) DialTLSContext(
 	ctx context.Context, network, address string,
 ) (net.Conn, error) {
 	ctx = maybeWithMeasurementRoot(ctx, d.Beginning, d.Handler)
-	return dialer.NewTLS(
-		dialer.New(d.Resolver, new(net.Dialer)),
-		d.TLSConfig,
-	).DialTLSContext(ctx, network, address)
+	tlsDialer := dialer.NewTLS(d.newDNSDialer(), d.TLSConfig)
+	tlsDialer.ClientHello = d.clientHello
+	return tlsDialer.DialTLSContext(ctx, network, address)
 }
 
 // SetCABundle configures the dialer to use a specific CA bundle. This
@@ -105,6 +112,30 @@ func (d *Dialer) ForceSpecificSNI(sni string) error {
 	return nil
 }
 
+// ForceClientHello forces DialTLS to send the ClientHello of a well
+// known TLS client rather than the one of Go's crypto/tls. The name
+// of the client is one of "chrome", "firefox", "ios", "randomized". The
+// name is recorded in the TLSHandshakeStartEvent. Note that this has no
+// effect on HTTP code, because net/http performs its own handshakes.
+func (d *Dialer) ForceClientHello(name string) error {
+	builder, err := tlsdialer.NewClientHelloBuilder(name)
+	if err != nil {
+		return err
+	}
+	d.clientHello = builder
+	return nil
+}
+
+// ForceHappyEyeballs forces dialing using Happy Eyeballs v2 (RFC8305)
+// rather than trying the resolved addresses sequentially. We interleave
+// IPv6 and IPv4 addresses and we start a new connection attempt every
+// 250 millisecond until one of them succeeds. Each attempt emits its own
+// ConnectEvent, and we close the other connections that succeed.
+func (d *Dialer) ForceHappyEyeballs() error {
+	d.happyEyeballs = true
+	return nil
+}
+
 // ForceSkipVerify forces to skip certificate verification
 func (d *Dialer) ForceSkipVerify() error {
 	d.TLSConfig.InsecureSkipVerify = true
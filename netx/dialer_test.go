@@ -1,11 +1,15 @@
 package netx_test
 
 import (
+	"context"
 	"crypto/x509"
 	"errors"
+	"net"
 	"testing"
 
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 func TestIntegrationDialerDial(t *testing.T) {
@@ -113,3 +117,108 @@ func TestIntegrationDialerForceSpecificSNI(t *testing.T) {
 		t.Fatal("expected a nil connection here")
 	}
 }
+
+func TestUnitDialerForceClientHelloUnknown(t *testing.T) {
+	dialer := netx.NewDialer()
+	if err := dialer.ForceClientHello("antani"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestIntegrationDialerForceClientHello(t *testing.T) {
+	dialer := netx.NewDialer()
+	if err := dialer.ForceClientHello("firefox"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.DialTLS("tcp", "www.google.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+type staticresolver struct {
+	*net.Resolver
+	addrs []string
+}
+
+func (r *staticresolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	return r.addrs, nil
+}
+
+func TestUnitDialerForceHappyEyeballs(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saver := new(handlers.SavingHandler)
+	dialer := netx.NewDialer()
+	dialer.Handler = saver
+	// Nobody listens on the IPv6 address, which is tried first
+	dialer.SetResolver(&staticresolver{addrs: []string{"127.0.0.1", "::1"}})
+	if err := dialer.ForceHappyEyeballs(); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("tcp", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	var connects []*modelx.ConnectEvent
+	for _, ev := range saver.Read() {
+		if ev.Connect != nil {
+			connects = append(connects, ev.Connect)
+		}
+	}
+	if len(connects) != 2 {
+		t.Fatal("unexpected number of connect events")
+	}
+	if connects[0].DialID != connects[1].DialID {
+		t.Fatal("the connect events have different DialIDs")
+	}
+	if connects[0].Error == nil || connects[1].Error != nil {
+		t.Fatal("unexpected connect results")
+	}
+}
+
+type savingcapturer struct {
+	packets []modelx.CapturedPacket
+}
+
+func (c *savingcapturer) CapturePacket(packet modelx.CapturedPacket) {
+	packet.Data = append([]byte{}, packet.Data...)
+	c.packets = append(c.packets, packet)
+}
+
+func TestUnitDialerWithPacketCapturer(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	capturer := new(savingcapturer)
+	ctx := modelx.WithPacketCapturer(context.Background(), capturer)
+	conn, err := netx.NewDialer().DialContext(ctx, "udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if len(capturer.packets) != 1 {
+		t.Fatal("unexpected number of captured packets")
+	}
+	packet := capturer.packets[0]
+	if !packet.Sent || packet.Network != "udp" || string(packet.Data) != "abc" {
+		t.Fatal("unexpected captured packet")
+	}
+	if packet.RemoteAddr.String() != listener.LocalAddr().String() {
+		t.Fatal("unexpected remote address")
+	}
+}
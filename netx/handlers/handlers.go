@@ -4,6 +4,7 @@ package handlers
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 
 	"github.com/ooni/probe-engine/internal/runtimex"
 	"github.com/ooni/probe-engine/netx/modelx"
@@ -27,3 +28,25 @@ func (noHandler) OnMeasurement(m modelx.Measurement) {
 
 // NoHandler is a Handler that does not print anything
 var NoHandler noHandler
+
+// SavingHandler saves the events it receives.
+type SavingHandler struct {
+	mu sync.Mutex
+	v  []modelx.Measurement
+}
+
+// OnMeasurement implements modelx.Handler.OnMeasurement
+func (sh *SavingHandler) OnMeasurement(ev modelx.Measurement) {
+	sh.mu.Lock()
+	sh.v = append(sh.v, ev)
+	sh.mu.Unlock()
+}
+
+// Read returns the saved events and resets the internal state.
+func (sh *SavingHandler) Read() []modelx.Measurement {
+	sh.mu.Lock()
+	v := sh.v
+	sh.v = nil
+	sh.mu.Unlock()
+	return v
+}
@@ -11,3 +11,15 @@ func TestIntegration(t *testing.T) {
 	handlers.NoHandler.OnMeasurement(modelx.Measurement{})
 	handlers.StdoutHandler.OnMeasurement(modelx.Measurement{})
 }
+
+func TestUnitSavingHandler(t *testing.T) {
+	saver := new(handlers.SavingHandler)
+	saver.OnMeasurement(modelx.Measurement{})
+	saver.OnMeasurement(modelx.Measurement{})
+	if len(saver.Read()) != 2 {
+		t.Fatal("unexpected number of events")
+	}
+	if len(saver.Read()) != 0 {
+		t.Fatal("expected Read to reset the state")
+	}
+}
@@ -0,0 +1,455 @@
+// Package har assembles netx events into an HTTP Archive.
+//
+// The Handler in this package correlates the events emitted by netx using
+// their TransactionID and ConnID, and creates a HAR 1.2 document, including
+// the DNS, connect and TLS timing phases. See the specification at
+// <http://www.softwareishard.com/blog/har-12-spec/>.
+package har
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// HAR is the root of a HAR document.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log contains the HAR log.
+type Log struct {
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Version string  `json:"version"`
+}
+
+// Creator describes the application that created the log.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry describes an HTTP transaction.
+type Entry struct {
+	Cache           Cache    `json:"cache"`
+	Comment         string   `json:"comment,omitempty"`
+	Connection      string   `json:"connection,omitempty"`
+	Request         Request  `json:"request"`
+	Response        Response `json:"response"`
+	ServerIPAddress string   `json:"serverIPAddress,omitempty"`
+	StartedDateTime string   `json:"startedDateTime"`
+	Time            float64  `json:"time"`
+	Timings         Timings  `json:"timings"`
+}
+
+// Cache contains info about the cache. We never use a cache.
+type Cache struct{}
+
+// Request contains info about the request.
+type Request struct {
+	BodySize    int64       `json:"bodySize"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	HeadersSize int64       `json:"headersSize"`
+	HTTPVersion string      `json:"httpVersion"`
+	Method      string      `json:"method"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	QueryString []NameValue `json:"queryString"`
+	URL         string      `json:"url"`
+}
+
+// Response contains info about the response.
+type Response struct {
+	BodySize    int64       `json:"bodySize"`
+	Content     Content     `json:"content"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	HeadersSize int64       `json:"headersSize"`
+	HTTPVersion string      `json:"httpVersion"`
+	RedirectURL string      `json:"redirectURL"`
+	Status      int64       `json:"status"`
+	StatusText  string      `json:"statusText"`
+}
+
+// Cookie contains info about a cookie.
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NameValue is a header or a query string parameter.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// PostData contains the request body.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// Content contains the response body. Because netx only saves a
+// snapshot of the body, Text may be truncated. When the body is not
+// valid UTF-8, Text is base64 encoded and Encoding is "base64".
+type Content struct {
+	Encoding string `json:"encoding,omitempty"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	Text     string `json:"text"`
+}
+
+// Timings contains the duration in milliseconds of the phases of the
+// transaction. A negative value means that the phase does not apply,
+// e.g., the connect phase when reusing a connection.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	Connect float64 `json:"connect"`
+	DNS     float64 `json:"dns"`
+	Receive float64 `json:"receive"`
+	Send    float64 `json:"send"`
+	SSL     float64 `json:"ssl"`
+	Wait    float64 `json:"wait"`
+}
+
+// Handler is a modelx.Handler and a modelx.Observer that assembles the
+// events it receives into a HAR document. It is goroutine safe.
+type Handler struct {
+	// Creator is the creator of the HAR document.
+	Creator Creator
+
+	beginning    time.Time
+	conns        map[int64]*connection
+	mu           sync.Mutex
+	order        []int64
+	transactions map[int64]*transaction
+}
+
+// NewHandler creates a new Handler. The beginning argument is the
+// Beginning of the MeasurementRoot using the Handler. It is ignored
+// when the Handler is used as a modelx.Observer.
+func NewHandler(beginning time.Time) *Handler {
+	return &Handler{
+		Creator:      Creator{Name: "netx", Version: "unknown"},
+		beginning:    beginning,
+		conns:        make(map[int64]*connection),
+		transactions: make(map[int64]*transaction),
+	}
+}
+
+// connection contains the timing of a connection.
+type connection struct {
+	connectDone  time.Time
+	connectStart time.Time
+	serverIP     string
+	tlsDone      time.Time
+	tlsStart     time.Time
+}
+
+// transaction contains what we know about a transaction.
+type transaction struct {
+	connection
+	bodySize      int64
+	connID        int64
+	dnsDone       time.Time
+	dnsStart      time.Time
+	method        string
+	ready         time.Time
+	requestDone   time.Time
+	responseDone  time.Time
+	responseStart time.Time
+	roundTrip     *modelx.HTTPRoundTripDoneEvent
+	roundTripDone time.Time
+	sawBody       bool
+	start         time.Time
+	url           string
+}
+
+// OnMeasurement implements modelx.Handler.OnMeasurement.
+func (h *Handler) OnMeasurement(m modelx.Measurement) {
+	h.Observe(h.beginning, m)
+}
+
+// Observe implements modelx.Observer.Observe.
+func (h *Handler) Observe(beginning time.Time, m modelx.Measurement) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if ev := m.ResolveStart; ev != nil && ev.TransactionID != 0 {
+		tx := h.transaction(ev.TransactionID)
+		if tx.dnsStart.IsZero() {
+			tx.dnsStart = beginning.Add(ev.DurationSinceBeginning)
+		}
+	}
+	if ev := m.ResolveDone; ev != nil && ev.TransactionID != 0 {
+		h.transaction(ev.TransactionID).dnsDone = beginning.Add(ev.DurationSinceBeginning)
+	}
+	if ev := m.Connect; ev != nil && ev.Error == nil {
+		conn := h.connection(ev.ConnID)
+		conn.connectDone = beginning.Add(ev.DurationSinceBeginning)
+		conn.connectStart = conn.connectDone.Add(-ev.SyscallDuration)
+		conn.serverIP, _, _ = net.SplitHostPort(ev.RemoteAddress)
+		if ev.TransactionID != 0 {
+			h.transaction(ev.TransactionID).connection = *conn
+		}
+	}
+	if ev := m.TLSHandshakeStart; ev != nil {
+		t := beginning.Add(ev.DurationSinceBeginning)
+		if ev.TransactionID != 0 {
+			h.transaction(ev.TransactionID).tlsStart = t
+		} else {
+			h.connection(ev.ConnID).tlsStart = t
+		}
+	}
+	if ev := m.TLSHandshakeDone; ev != nil {
+		t := beginning.Add(ev.DurationSinceBeginning)
+		if ev.TransactionID != 0 {
+			h.transaction(ev.TransactionID).tlsDone = t
+		} else {
+			h.connection(ev.ConnID).tlsDone = t
+		}
+	}
+	if ev := m.HTTPRoundTripStart; ev != nil {
+		tx := h.transaction(ev.TransactionID)
+		tx.method = ev.Method
+		tx.start = beginning.Add(ev.DurationSinceBeginning)
+		tx.url = ev.URL
+	}
+	if ev := m.HTTPConnectionReady; ev != nil {
+		tx := h.transaction(ev.TransactionID)
+		tx.connID = ev.ConnID
+		tx.ready = beginning.Add(ev.DurationSinceBeginning)
+	}
+	if ev := m.HTTPRequestDone; ev != nil {
+		h.transaction(ev.TransactionID).requestDone = beginning.Add(ev.DurationSinceBeginning)
+	}
+	if ev := m.HTTPResponseStart; ev != nil {
+		h.transaction(ev.TransactionID).responseStart = beginning.Add(ev.DurationSinceBeginning)
+	}
+	if ev := m.HTTPRoundTripDone; ev != nil {
+		tx := h.transaction(ev.TransactionID)
+		roundTrip := *ev // make a copy
+		roundTrip.RequestBodySnap = append([]byte{}, ev.RequestBodySnap...)
+		roundTrip.RequestHeaders = ev.RequestHeaders.Clone()
+		roundTrip.ResponseBodySnap = append([]byte{}, ev.ResponseBodySnap...)
+		roundTrip.ResponseHeaders = ev.ResponseHeaders.Clone()
+		tx.roundTrip = &roundTrip
+		tx.roundTripDone = beginning.Add(ev.DurationSinceBeginning)
+	}
+	if ev := m.HTTPResponseBodyPart; ev != nil {
+		tx := h.transaction(ev.TransactionID)
+		tx.bodySize += int64(len(ev.Data))
+		tx.sawBody = true
+	}
+	if ev := m.HTTPResponseDone; ev != nil {
+		h.transaction(ev.TransactionID).responseDone = beginning.Add(ev.DurationSinceBeginning)
+	}
+}
+
+func (h *Handler) transaction(id int64) *transaction {
+	tx, found := h.transactions[id]
+	if !found {
+		tx = new(transaction)
+		h.transactions[id] = tx
+		h.order = append(h.order, id)
+	}
+	return tx
+}
+
+func (h *Handler) connection(id int64) *connection {
+	conn, found := h.conns[id]
+	if !found {
+		conn = new(connection)
+		h.conns[id] = conn
+	}
+	return conn
+}
+
+// HAR returns the HAR document containing all the transactions that
+// have completed their round trip so far.
+func (h *Handler) HAR() *HAR {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := []Entry{}
+	for _, id := range h.order {
+		tx := h.transactions[id]
+		if tx.roundTrip == nil || tx.start.IsZero() {
+			continue
+		}
+		entries = append(entries, h.newEntry(tx))
+	}
+	return &HAR{Log: Log{
+		Creator: h.Creator,
+		Entries: entries,
+		Version: "1.2",
+	}}
+}
+
+func (h *Handler) newEntry(tx *transaction) Entry {
+	conn := tx.connection
+	if c, found := h.conns[tx.connID]; found {
+		if conn.connectStart.IsZero() {
+			conn = *c
+		}
+		// When the TLS handshake is managed by netx, rather than by the
+		// HTTP transport, we only know the ConnID of the TLS events.
+		if conn.tlsStart.IsZero() {
+			conn.tlsDone, conn.tlsStart = c.tlsDone, c.tlsStart
+		}
+	}
+	if conn.connectStart.Before(tx.start) {
+		conn = connection{} // we're reusing an existing connection
+	}
+	rt := tx.roundTrip
+	entry := Entry{
+		Request: Request{
+			BodySize:    int64(len(rt.RequestBodySnap)),
+			Cookies:     newCookies((&http.Request{Header: rt.RequestHeaders}).Cookies()),
+			Headers:     newHeaders(rt.RequestHeaders),
+			HeadersSize: -1,
+			HTTPVersion: rt.ResponseProto,
+			Method:      tx.method,
+			QueryString: newQueryString(tx.url),
+			URL:         tx.url,
+		},
+		Response: Response{
+			BodySize:    -1,
+			Content:     newContent(rt),
+			Cookies:     newCookies((&http.Response{Header: rt.ResponseHeaders}).Cookies()),
+			Headers:     newHeaders(rt.ResponseHeaders),
+			HeadersSize: -1,
+			HTTPVersion: rt.ResponseProto,
+			RedirectURL: rt.ResponseHeaders.Get("Location"),
+			Status:      rt.ResponseStatusCode,
+			StatusText:  http.StatusText(int(rt.ResponseStatusCode)),
+		},
+		ServerIPAddress: conn.serverIP,
+		StartedDateTime: tx.start.Format(time.RFC3339Nano),
+		Timings:         newTimings(tx, conn),
+	}
+	if len(rt.RequestBodySnap) > 0 {
+		entry.Request.PostData = &PostData{
+			MimeType: rt.RequestHeaders.Get("Content-Type"),
+			Text:     string(rt.RequestBodySnap),
+		}
+	}
+	if tx.sawBody {
+		entry.Response.BodySize = tx.bodySize
+		entry.Response.Content.Size = tx.bodySize
+	}
+	if tx.connID != 0 {
+		entry.Connection = strconv.FormatInt(tx.connID, 10)
+	}
+	if rt.Error != nil {
+		entry.Comment = rt.Error.Error()
+	}
+	for _, value := range []float64{
+		entry.Timings.Blocked, entry.Timings.DNS, entry.Timings.Connect,
+		entry.Timings.Send, entry.Timings.Wait, entry.Timings.Receive,
+	} {
+		if value > 0 {
+			entry.Time += value
+		}
+	}
+	return entry
+}
+
+func newTimings(tx *transaction, conn connection) Timings {
+	timings := Timings{
+		Blocked: -1,
+		Connect: -1,
+		DNS:     -1,
+		SSL:     -1,
+	}
+	var dnsStart time.Time
+	if !tx.dnsStart.IsZero() && !tx.dnsDone.IsZero() && !tx.dnsStart.Before(tx.start) {
+		dnsStart = tx.dnsStart
+		timings.DNS = milliseconds(tx.dnsDone.Sub(tx.dnsStart))
+	}
+	if !conn.connectStart.IsZero() {
+		connectDone := conn.connectDone
+		if !conn.tlsStart.IsZero() && !conn.tlsDone.IsZero() {
+			timings.SSL = milliseconds(conn.tlsDone.Sub(conn.tlsStart))
+			connectDone = conn.tlsDone
+		}
+		timings.Connect = milliseconds(connectDone.Sub(conn.connectStart))
+	}
+	for _, t := range []time.Time{dnsStart, conn.connectStart, tx.ready} {
+		if !t.IsZero() {
+			timings.Blocked = milliseconds(t.Sub(tx.start))
+			break
+		}
+	}
+	if !tx.ready.IsZero() && !tx.requestDone.IsZero() {
+		timings.Send = milliseconds(tx.requestDone.Sub(tx.ready))
+	}
+	if !tx.requestDone.IsZero() && !tx.responseStart.IsZero() {
+		timings.Wait = milliseconds(tx.responseStart.Sub(tx.requestDone))
+	}
+	if !tx.responseStart.IsZero() {
+		end := tx.responseDone
+		if end.IsZero() {
+			end = tx.roundTripDone
+		}
+		timings.Receive = milliseconds(end.Sub(tx.responseStart))
+	}
+	return timings
+}
+
+func milliseconds(d time.Duration) float64 {
+	if d < 0 {
+		d = 0
+	}
+	return float64(d) / float64(time.Millisecond)
+}
+
+func newHeaders(header http.Header) []NameValue {
+	out := []NameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			out = append(out, NameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+func newCookies(cookies []*http.Cookie) []Cookie {
+	out := []Cookie{}
+	for _, cookie := range cookies {
+		out = append(out, Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	return out
+}
+
+func newQueryString(URL string) []NameValue {
+	out := []NameValue{}
+	parsed, err := url.Parse(URL)
+	if err != nil {
+		return out
+	}
+	return newHeaders(http.Header(parsed.Query()))
+}
+
+func newContent(rt *modelx.HTTPRoundTripDoneEvent) Content {
+	content := Content{
+		MimeType: rt.ResponseHeaders.Get("Content-Type"),
+		Size:     int64(len(rt.ResponseBodySnap)),
+		Text:     string(rt.ResponseBodySnap),
+	}
+	if !utf8.Valid(rt.ResponseBodySnap) {
+		content.Encoding = "base64"
+		content.Text = base64.StdEncoding.EncodeToString(rt.ResponseBodySnap)
+	}
+	return content
+}
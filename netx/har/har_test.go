@@ -0,0 +1,287 @@
+package har
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func emitTransaction(handler modelx.Handler, txid, connid int64, failed bool) {
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPRoundTripStart: &modelx.HTTPRoundTripStartEvent{
+			DurationSinceBeginning: ms(10),
+			Method:                 "POST",
+			TransactionID:          txid,
+			URL:                    "https://www.example.com/?q=antani",
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		ResolveStart: &modelx.ResolveStartEvent{
+			DurationSinceBeginning: ms(12),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		ResolveDone: &modelx.ResolveDoneEvent{
+			DurationSinceBeginning: ms(20),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		Connect: &modelx.ConnectEvent{
+			ConnID:                 connid,
+			DurationSinceBeginning: ms(30),
+			RemoteAddress:          "93.184.216.34:443",
+			SyscallDuration:        ms(10),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		TLSHandshakeStart: &modelx.TLSHandshakeStartEvent{
+			DurationSinceBeginning: ms(30),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		TLSHandshakeDone: &modelx.TLSHandshakeDoneEvent{
+			DurationSinceBeginning: ms(45),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPConnectionReady: &modelx.HTTPConnectionReadyEvent{
+			ConnID:                 connid,
+			DurationSinceBeginning: ms(46),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPRequestDone: &modelx.HTTPRequestDoneEvent{
+			DurationSinceBeginning: ms(48),
+			TransactionID:          txid,
+		},
+	})
+	if failed {
+		handler.OnMeasurement(modelx.Measurement{
+			HTTPRoundTripDone: &modelx.HTTPRoundTripDoneEvent{
+				DurationSinceBeginning: ms(60),
+				Error:                  errors.New("connection_reset"),
+				RequestMethod:          "POST",
+				TransactionID:          txid,
+			},
+		})
+		return
+	}
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPResponseStart: &modelx.HTTPResponseStartEvent{
+			DurationSinceBeginning: ms(58),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPRoundTripDone: &modelx.HTTPRoundTripDoneEvent{
+			DurationSinceBeginning: ms(60),
+			RequestBodySnap:        []byte("a=b"),
+			RequestHeaders: http.Header{
+				"Content-Type": {"application/x-www-form-urlencoded"},
+				"Cookie":       {"session=xyz"},
+			},
+			RequestMethod:    "POST",
+			ResponseBodySnap: []byte{0xff, 0xfe},
+			ResponseHeaders: http.Header{
+				"Content-Type": {"application/octet-stream"},
+				"Location":     {"https://www.example.org/"},
+				"Set-Cookie":   {"id=17"},
+			},
+			ResponseProto:      "HTTP/1.1",
+			ResponseStatusCode: 302,
+			TransactionID:      txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPResponseBodyPart: &modelx.HTTPResponseBodyPartEvent{
+			Data:                   make([]byte, 128),
+			DurationSinceBeginning: ms(65),
+			TransactionID:          txid,
+		},
+	})
+	handler.OnMeasurement(modelx.Measurement{
+		HTTPResponseDone: &modelx.HTTPResponseDoneEvent{
+			DurationSinceBeginning: ms(70),
+			TransactionID:          txid,
+		},
+	})
+}
+
+func TestUnitHandlerSuccess(t *testing.T) {
+	beginning := time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC)
+	handler := NewHandler(beginning)
+	emitTransaction(handler, 1, 17, false)
+	doc := handler.HAR()
+	if doc.Log.Version != "1.2" || len(doc.Log.Entries) != 1 {
+		t.Fatal("unexpected HAR log")
+	}
+	entry := doc.Log.Entries[0]
+	if entry.StartedDateTime != "2020-03-01T12:00:00.01Z" {
+		t.Fatal(entry.StartedDateTime)
+	}
+	expected := Timings{
+		Blocked: 2, DNS: 8, Connect: 25, SSL: 15, Send: 2, Wait: 10, Receive: 12,
+	}
+	if entry.Timings != expected {
+		t.Fatalf("%+v", entry.Timings)
+	}
+	if entry.Time != 2+8+25+2+10+12 {
+		t.Fatal(entry.Time)
+	}
+	if entry.ServerIPAddress != "93.184.216.34" || entry.Connection != "17" {
+		t.Fatal("unexpected connection info")
+	}
+	if entry.Request.Method != "POST" || entry.Request.PostData.Text != "a=b" {
+		t.Fatal("unexpected request")
+	}
+	if len(entry.Request.QueryString) != 1 || entry.Request.QueryString[0].Value != "antani" {
+		t.Fatal("unexpected query string")
+	}
+	if len(entry.Request.Cookies) != 1 || entry.Request.Cookies[0].Value != "xyz" {
+		t.Fatal("unexpected request cookies")
+	}
+	if entry.Response.Status != 302 || entry.Response.StatusText != "Found" {
+		t.Fatal("unexpected response status")
+	}
+	if entry.Response.RedirectURL != "https://www.example.org/" {
+		t.Fatal("unexpected redirect URL")
+	}
+	if len(entry.Response.Cookies) != 1 || entry.Response.Cookies[0].Name != "id" {
+		t.Fatal("unexpected response cookies")
+	}
+	if entry.Response.BodySize != 128 || entry.Response.Content.Encoding != "base64" {
+		t.Fatal("unexpected response content")
+	}
+	if entry.Response.Content.Text != "//4=" {
+		t.Fatal("unexpected response body")
+	}
+}
+
+func TestUnitHandlerFailure(t *testing.T) {
+	handler := NewHandler(time.Now())
+	emitTransaction(handler, 1, 17, true)
+	entry := handler.HAR().Log.Entries[0]
+	if entry.Comment != "connection_reset" || entry.Response.Status != 0 {
+		t.Fatal("unexpected failed entry")
+	}
+	if entry.Timings.Wait != 0 || entry.Timings.Receive != 0 {
+		t.Fatal("unexpected timings")
+	}
+}
+
+func TestUnitHandlerObserverAndReuse(t *testing.T) {
+	handler := NewHandler(time.Time{})
+	ctx := modelx.WithObserver(context.Background(), handler)
+	ctx = modelx.WithMeasurementRoot(ctx, &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   new(dummyhandler),
+	})
+	root := modelx.ContextMeasurementRoot(ctx)
+	emitTransaction(root.Handler, 1, 17, false)
+	// The second transaction reuses the same connection
+	root.Handler.OnMeasurement(modelx.Measurement{
+		HTTPRoundTripStart: &modelx.HTTPRoundTripStartEvent{
+			DurationSinceBeginning: ms(100),
+			Method:                 "GET",
+			TransactionID:          2,
+			URL:                    "https://www.example.com/",
+		},
+	})
+	root.Handler.OnMeasurement(modelx.Measurement{
+		HTTPConnectionReady: &modelx.HTTPConnectionReadyEvent{
+			ConnID:                 17,
+			DurationSinceBeginning: ms(101),
+			TransactionID:          2,
+		},
+	})
+	root.Handler.OnMeasurement(modelx.Measurement{
+		HTTPRoundTripDone: &modelx.HTTPRoundTripDoneEvent{
+			DurationSinceBeginning: ms(120),
+			ResponseStatusCode:     200,
+			TransactionID:          2,
+		},
+	})
+	// This transaction did not complete the round trip
+	root.Handler.OnMeasurement(modelx.Measurement{
+		HTTPRoundTripStart: &modelx.HTTPRoundTripStartEvent{
+			DurationSinceBeginning: ms(130),
+			TransactionID:          3,
+		},
+	})
+	entries := handler.HAR().Log.Entries
+	if len(entries) != 2 {
+		t.Fatal("unexpected number of entries")
+	}
+	if entries[1].Timings.Connect != -1 || entries[1].Timings.DNS != -1 {
+		t.Fatal("the reused connection has connect or DNS timings")
+	}
+	if entries[1].Timings.Blocked != 1 || entries[1].ServerIPAddress != "" {
+		t.Fatal("unexpected timings for reused connection")
+	}
+}
+
+type dummyhandler struct{}
+
+func (*dummyhandler) OnMeasurement(modelx.Measurement) {}
+
+func TestUnitHandlerWithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("hello, world"))
+		},
+	))
+	defer server.Close()
+	handler := NewHandler(time.Now())
+	client := netx.NewHTTPClient()
+	defer client.CloseIdleConnections()
+	client.Transport.Beginning = handler.beginning
+	client.Transport.Handler = handler
+	resp, err := client.HTTPClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	data, err := json.Marshal(handler.HAR())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc HAR
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Log.Entries) != 1 {
+		t.Fatal("unexpected number of entries")
+	}
+	entry := doc.Log.Entries[0]
+	if entry.Response.Status != 200 || entry.Response.Content.Text != "hello, world" {
+		t.Fatal("unexpected response")
+	}
+	if entry.Response.BodySize != int64(len("hello, world")) {
+		t.Fatal("unexpected body size")
+	}
+	if entry.Timings.Connect < 0 || entry.ServerIPAddress != "127.0.0.1" {
+		t.Fatal("missing connect timing")
+	}
+}
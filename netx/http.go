@@ -8,6 +8,7 @@ import (
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/internal/errwrapper"
 	"github.com/ooni/probe-engine/netx/internal/httptransport"
+	"github.com/ooni/probe-engine/netx/internal/httptransport/http3transport"
 	"github.com/ooni/probe-engine/netx/modelx"
 	"golang.org/x/net/http2"
 )
@@ -134,11 +135,28 @@ func (t *HTTPTransport) ForceSpecificSNI(sni string) error {
 	return t.Dialer.ForceSpecificSNI(sni)
 }
 
+// ForceHappyEyeballs internally calls netx.Dialer.ForceHappyEyeballs.
+func (t *HTTPTransport) ForceHappyEyeballs() error {
+	return t.Dialer.ForceHappyEyeballs()
+}
+
 // ForceSkipVerify forces to skip certificate verification
 func (t *HTTPTransport) ForceSkipVerify() error {
 	return t.Dialer.ForceSkipVerify()
 }
 
+// ForceHTTP3 forces using HTTP/3 over QUIC. The QUIC handshake and the
+// UDP datagrams will be reported by the measurement events. Because this
+// replaces the HTTP/1.1 and HTTP/2 transport, the proxy func is ignored
+// and you cannot access cleartext URLs. This function is not goroutine
+// safe. Make sure you call it before starting to use the transport.
+func (t *HTTPTransport) ForceHTTP3() error {
+	t.roundTripper = httptransport.New(
+		http3transport.New(t.Dialer, t.Dialer.TLSConfig),
+	)
+	return nil
+}
+
 // HTTPClient is a replacement for http.HTTPClient.
 type HTTPClient struct {
 	// HTTPClient is the underlying client. Pass this client to existing code
@@ -195,11 +213,21 @@ func (c *HTTPClient) ForceSpecificSNI(sni string) error {
 	return c.Transport.ForceSpecificSNI(sni)
 }
 
+// ForceHappyEyeballs internally calls netx.Dialer.ForceHappyEyeballs.
+func (c *HTTPClient) ForceHappyEyeballs() error {
+	return c.Transport.ForceHappyEyeballs()
+}
+
 // ForceSkipVerify forces to skip certificate verification
 func (c *HTTPClient) ForceSkipVerify() error {
 	return c.Transport.ForceSkipVerify()
 }
 
+// ForceHTTP3 forces using HTTP/3 over QUIC.
+func (c *HTTPClient) ForceHTTP3() error {
+	return c.Transport.ForceHTTP3()
+}
+
 // CloseIdleConnections closes the idle connections.
 func (c *HTTPClient) CloseIdleConnections() {
 	c.Transport.CloseIdleConnections()
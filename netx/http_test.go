@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
 
@@ -100,6 +101,44 @@ func TestIntegrationHTTPClientForceSkipVerify(t *testing.T) {
 	}
 }
 
+func TestUnitHTTPClientForceHTTP3Cleartext(t *testing.T) {
+	client := netx.NewHTTPClientWithoutProxy()
+	client.ForceHTTP3()
+	// HTTP/3 cannot be used with cleartext URLs
+	resp, err := client.HTTPClient.Get("http://www.example.com/")
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if resp != nil {
+		t.Fatal("expected nil response here")
+	}
+}
+
+func TestUnitHTTPClientJA3(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	client := netx.NewHTTPClientWithoutProxy()
+	defer client.CloseIdleConnections()
+	client.ForceSkipVerify()
+	saver := new(handlers.SavingHandler)
+	client.Transport.Handler = saver
+	resp, err := client.HTTPClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var found bool
+	for _, ev := range saver.Read() {
+		if ev.TLSHandshakeDone != nil {
+			state := ev.TLSHandshakeDone.ConnectionState
+			found = state.JA3Hash != "" && state.JA3SHash != ""
+		}
+	}
+	if !found {
+		t.Fatal("the JA3/JA3S fingerprints have not been recorded")
+	}
+}
+
 func TestHTTPNewClientProxy(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
@@ -9,12 +9,16 @@ import (
 	"github.com/ooni/probe-engine/netx/modelx"
 )
 
-// MeasuringConn is a net.Conn used to perform measurements
+// MeasuringConn is a net.Conn used to perform measurements. When
+// Capturer is not nil, we also pass it the payloads that we send and
+// receive, so that it could synthesize a packet capture.
 type MeasuringConn struct {
 	net.Conn
 	Beginning time.Time
+	Capturer  modelx.PacketCapturer
 	Handler   modelx.Handler
 	ID        int64
+	Network   string
 }
 
 // Read reads data from the connection.
@@ -27,6 +31,7 @@ func (c *MeasuringConn) Read(b []byte) (n int, err error) {
 		Operation: "read",
 	}.MaybeBuild()
 	stop := time.Now()
+	c.capture(b[:n], stop, false)
 	c.Handler.OnMeasurement(modelx.Measurement{
 		Read: &modelx.ReadEvent{
 			ConnID:                 c.ID,
@@ -49,6 +54,7 @@ func (c *MeasuringConn) Write(b []byte) (n int, err error) {
 		Operation: "write",
 	}.MaybeBuild()
 	stop := time.Now()
+	c.capture(b[:n], stop, true)
 	c.Handler.OnMeasurement(modelx.Measurement{
 		Write: &modelx.WriteEvent{
 			ConnID:                 c.ID,
@@ -61,6 +67,21 @@ func (c *MeasuringConn) Write(b []byte) (n int, err error) {
 	return
 }
 
+func (c *MeasuringConn) capture(data []byte, t time.Time, sent bool) {
+	if c.Capturer == nil || len(data) <= 0 {
+		return
+	}
+	c.Capturer.CapturePacket(modelx.CapturedPacket{
+		ConnID:                 c.ID,
+		Data:                   data,
+		DurationSinceBeginning: t.Sub(c.Beginning),
+		LocalAddr:              c.Conn.LocalAddr(),
+		Network:                c.Network,
+		RemoteAddr:             c.Conn.RemoteAddr(),
+		Sent:                   sent,
+	})
+}
+
 // Close closes the connection
 func (c *MeasuringConn) Close() (err error) {
 	start := time.Now()
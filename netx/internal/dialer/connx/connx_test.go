@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 func TestIntegrationMeasuringConn(t *testing.T) {
@@ -31,6 +32,48 @@ func TestIntegrationMeasuringConn(t *testing.T) {
 	}
 }
 
+type capturer struct {
+	packets []modelx.CapturedPacket
+}
+
+func (c *capturer) CapturePacket(packet modelx.CapturedPacket) {
+	c.packets = append(c.packets, packet)
+}
+
+func TestUnitMeasuringConnCapture(t *testing.T) {
+	capturer := new(capturer)
+	conn := net.Conn(&MeasuringConn{
+		Conn:     fakeconn{},
+		Capturer: capturer,
+		Handler:  handlers.NoHandler,
+		ID:       17,
+		Network:  "tcp",
+	})
+	defer conn.Close()
+	if _, err := conn.Write(make([]byte, 128)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(capturer.packets) != 2 {
+		t.Fatal("unexpected number of captured packets")
+	}
+	sent, recv := capturer.packets[0], capturer.packets[1]
+	if !sent.Sent || len(sent.Data) != 128 || sent.ConnID != 17 {
+		t.Fatal("unexpected sent packet")
+	}
+	if recv.Sent || len(recv.Data) != 64 || recv.Network != "tcp" {
+		t.Fatal("unexpected received packet")
+	}
+	if recv.LocalAddr == nil || recv.RemoteAddr == nil {
+		t.Fatal("missing addresses")
+	}
+}
+
 type fakeconn struct{}
 
 func (fakeconn) Read(b []byte) (n int, err error) {
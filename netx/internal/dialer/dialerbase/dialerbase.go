@@ -10,6 +10,7 @@ import (
 	"github.com/ooni/probe-engine/netx/internal/connid"
 	"github.com/ooni/probe-engine/netx/internal/dialer/connx"
 	"github.com/ooni/probe-engine/netx/internal/errwrapper"
+	"github.com/ooni/probe-engine/netx/internal/ja3"
 	"github.com/ooni/probe-engine/netx/internal/transactionid"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
@@ -50,8 +51,12 @@ func (d *Dialer) DialContext(
 	// this is the same timeout used by Go's net/http.DefaultTransport
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
+	dialContext := d.dialer.DialContext
+	if root := modelx.ContextMeasurementRoot(ctx); root != nil && root.DialContext != nil {
+		dialContext = root.DialContext
+	}
 	start := time.Now()
-	conn, err := d.dialer.DialContext(ctx, network, address)
+	conn, err := dialContext(ctx, network, address)
 	stop := time.Now()
 	err = errwrapper.SafeErrWrapperBuilder{
 		// ConnID does not make any sense if we've failed and the error
@@ -77,11 +82,17 @@ func (d *Dialer) DialContext(
 	if err != nil {
 		return nil, err
 	}
+	if slot := ja3.ContextSlot(ctx); slot != nil {
+		// The HTTP transport will perform the TLS handshake
+		conn = &ja3.Conn{Conn: conn, Recorder: slot.NewRecorder()}
+	}
 	return &connx.MeasuringConn{
 		Conn:      conn,
 		Beginning: d.beginning,
+		Capturer:  modelx.ContextPacketCapturer(ctx),
 		Handler:   d.handler,
 		ID:        connID,
+		Network:   network,
 	}, nil
 }
 
@@ -35,6 +35,35 @@ func TestIntegrationErrorNoConnect(t *testing.T) {
 	}
 }
 
+func TestUnitDialContextOverride(t *testing.T) {
+	saver := new(handlers.SavingHandler)
+	dialer := New(time.Now(), saver, new(net.Dialer), 17)
+	client, server := net.Pipe()
+	defer server.Close()
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return client, nil
+		},
+		Handler: handlers.NoHandler,
+	})
+	conn, err := dialer.DialContext(ctx, "tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr() != client.RemoteAddr() {
+		t.Fatal("the dialer did not use the override")
+	}
+	events := saver.Read()
+	if len(events) != 1 || events[0].Connect == nil {
+		t.Fatal("the dialer did not emit the ConnectEvent")
+	}
+	if events[0].Connect.RemoteAddress != "10.0.0.1:80" {
+		t.Fatal("unexpected remote address")
+	}
+}
+
 // see whether we implement the interface
 func newdialer() modelx.Dialer {
 	return New(
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/ooni/probe-engine/netx/internal/dialer/dialerbase"
 	"github.com/ooni/probe-engine/netx/internal/dialid"
@@ -15,15 +16,19 @@ import (
 // Dialer defines the dialer API. We implement the most basic form
 // of DNS, but more advanced resolutions are possible.
 type Dialer struct {
-	dialer   modelx.Dialer
-	resolver modelx.DNSResolver
+	AttemptDelay  time.Duration // default: 250 millisecond
+	HappyEyeballs bool          // default: false
+	dialer        modelx.Dialer
+	resolver      modelx.DNSResolver
 }
 
-// New creates a new Dialer.
+// New creates a new Dialer. By default, the dialer tries the resolved
+// addresses sequentially. Set HappyEyeballs to true to use RFC8305.
 func New(resolver modelx.DNSResolver, dialer modelx.Dialer) (d *Dialer) {
 	return &Dialer{
-		dialer:   dialer,
-		resolver: resolver,
+		AttemptDelay: 250 * time.Millisecond,
+		dialer:       dialer,
+		resolver:     resolver,
 	}
 }
 
@@ -49,6 +54,9 @@ func (d *Dialer) DialContext(
 	if err != nil {
 		return
 	}
+	if d.HappyEyeballs && len(addrs) > 1 {
+		return d.dialHappyEyeballs(ctx, network, onlyport, addrs, dialID)
+	}
 	var errorslist []error
 	for _, addr := range addrs {
 		dialer := dialerbase.New(
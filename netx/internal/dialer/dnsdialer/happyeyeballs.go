@@ -0,0 +1,104 @@
+package dnsdialer
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/internal/dialer/dialerbase"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+type attemptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialHappyEyeballs implements the connection attempts part of Happy
+// Eyeballs v2 (RFC8305 Sect. 5). We start a new attempt every AttemptDelay,
+// or as soon as the previous attempt fails, and we use the first connection
+// that is established. Each attempt emits its own ConnectEvent. When we
+// have a winner, we interrupt the other attempts and we close the
+// connections that succeeded anyway, which emits CloseEvents.
+//
+// We do not implement the asynchronous DNS part (RFC8305 Sect. 3),
+// since our resolvers return all the addresses at once.
+func (d *Dialer) dialHappyEyeballs(
+	ctx context.Context, network, port string, addrs []string, dialID int64,
+) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	addrs = interleaveAddresses(addrs)
+	results := make(chan attemptResult, len(addrs))
+	var next, pending int
+	attempt := func() {
+		dialer := dialerbase.New(root.Beginning, root.Handler, d.dialer, dialID)
+		target := net.JoinHostPort(addrs[next], port)
+		go func() {
+			conn, err := dialer.DialContext(ctx, network, target)
+			results <- attemptResult{conn: conn, err: err}
+		}()
+		next, pending = next+1, pending+1
+	}
+	timer := time.NewTimer(d.AttemptDelay)
+	defer timer.Stop()
+	attempt()
+	var errorslist []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				attempt()
+				timer.Reset(d.AttemptDelay)
+			}
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				cancel()
+				for ; pending > 0; pending-- {
+					if loser := <-results; loser.conn != nil {
+						loser.conn.Close()
+					}
+				}
+				return result.conn, nil
+			}
+			errorslist = append(errorslist, result.err)
+			if next < len(addrs) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				attempt()
+				timer.Reset(d.AttemptDelay)
+			}
+		}
+	}
+	return nil, reduceErrors(errorslist)
+}
+
+// interleaveAddresses alternates IPv6 and IPv4 addresses, starting
+// with IPv6, as recommended by RFC8305 Sect. 4.
+func interleaveAddresses(addrs []string) []string {
+	var ipv4, ipv6 []string
+	for _, addr := range addrs {
+		if strings.Contains(addr, ":") {
+			ipv6 = append(ipv6, addr)
+		} else {
+			ipv4 = append(ipv4, addr)
+		}
+	}
+	out := make([]string, 0, len(addrs))
+	for len(ipv4) > 0 || len(ipv6) > 0 {
+		if len(ipv6) > 0 {
+			out, ipv6 = append(out, ipv6[0]), ipv6[1:]
+		}
+		if len(ipv4) > 0 {
+			out, ipv4 = append(out, ipv4[0]), ipv4[1:]
+		}
+	}
+	return out
+}
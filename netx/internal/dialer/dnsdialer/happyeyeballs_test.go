@@ -0,0 +1,190 @@
+package dnsdialer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+type fakeconn struct {
+	net.Conn
+	closed bool
+	mu     sync.Mutex
+}
+
+func (c *fakeconn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+type fakeattempt struct {
+	delay      time.Duration
+	err        error
+	ignoresCtx bool
+}
+
+type fakedialer struct {
+	attempts map[string]fakeattempt
+	conns    map[string]*fakeconn
+	mu       sync.Mutex
+}
+
+func (d *fakedialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *fakedialer) DialContext(
+	ctx context.Context, network, address string,
+) (net.Conn, error) {
+	attempt := d.attempts[address]
+	if attempt.ignoresCtx {
+		time.Sleep(attempt.delay)
+	} else {
+		select {
+		case <-time.After(attempt.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if attempt.err != nil {
+		return nil, attempt.err
+	}
+	conn, _ := net.Pipe()
+	fconn := &fakeconn{Conn: conn}
+	d.mu.Lock()
+	d.conns[address] = fconn
+	d.mu.Unlock()
+	return fconn, nil
+}
+
+func dialhappyeyeballs(
+	delay time.Duration, attempts map[string]fakeattempt,
+) (net.Conn, *fakedialer, []modelx.Measurement, error) {
+	fd := &fakedialer{attempts: attempts, conns: make(map[string]*fakeconn)}
+	dialer := New(nil, fd)
+	dialer.AttemptDelay = delay
+	dialer.HappyEyeballs = true
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+		LookupHost: func(ctx context.Context, hostname string) ([]string, error) {
+			return []string{"10.0.0.1", "10.0.0.2", "fd00::1"}, nil
+		},
+	})
+	conn, err := dialer.DialContext(ctx, "tcp", "www.example.com:443")
+	return conn, fd, saver.Read(), err
+}
+
+func TestUnitHappyEyeballsStaggered(t *testing.T) {
+	begin := time.Now()
+	conn, _, events, err := dialhappyeyeballs(50*time.Millisecond, map[string]fakeattempt{
+		"[fd00::1]:443": fakeattempt{delay: time.Hour}, // broken IPv6
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if time.Now().Sub(begin) < 50*time.Millisecond {
+		t.Fatal("the second attempt did not wait for the attempt delay")
+	}
+	var connects []*modelx.ConnectEvent
+	for _, ev := range events {
+		if ev.Connect != nil {
+			connects = append(connects, ev.Connect)
+		}
+	}
+	if len(connects) != 2 {
+		t.Fatal("unexpected number of connect events")
+	}
+	if connects[0].DialID != connects[1].DialID {
+		t.Fatal("the connect events have different DialIDs")
+	}
+	if connects[0].RemoteAddress != "10.0.0.1:443" || connects[0].Error != nil {
+		t.Fatal("the IPv4 attempt should have succeeded first")
+	}
+	if connects[1].RemoteAddress != "[fd00::1]:443" || connects[1].Error == nil {
+		t.Fatal("the IPv6 attempt should have been interrupted")
+	}
+}
+
+func TestUnitHappyEyeballsFailureStartsNextAttempt(t *testing.T) {
+	conn, _, _, err := dialhappyeyeballs(time.Hour, map[string]fakeattempt{
+		"[fd00::1]:443": fakeattempt{err: errors.New("mocked error")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestUnitHappyEyeballsLosersAreClosed(t *testing.T) {
+	conn, fd, events, err := dialhappyeyeballs(10*time.Millisecond, map[string]fakeattempt{
+		"[fd00::1]:443": fakeattempt{delay: 100 * time.Millisecond, ignoresCtx: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	loser := fd.conns["[fd00::1]:443"]
+	if loser == nil {
+		t.Fatal("the losing attempt did not connect")
+	}
+	loser.mu.Lock()
+	defer loser.mu.Unlock()
+	if !loser.closed {
+		t.Fatal("the losing connection has not been closed")
+	}
+	var closed bool
+	for _, ev := range events {
+		closed = closed || ev.Close != nil
+	}
+	if !closed {
+		t.Fatal("the losing connection close has not been recorded")
+	}
+}
+
+func TestUnitHappyEyeballsAllFailed(t *testing.T) {
+	failure := errors.New("mocked error")
+	conn, _, events, err := dialhappyeyeballs(time.Hour, map[string]fakeattempt{
+		"[fd00::1]:443": fakeattempt{err: failure},
+		"10.0.0.1:443":  fakeattempt{err: failure},
+		"10.0.0.2:443":  fakeattempt{err: failure},
+	})
+	if !errors.Is(err, failure) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected a nil conn here")
+	}
+	var count int
+	for _, ev := range events {
+		if ev.Connect != nil {
+			count++
+		}
+	}
+	if count != 3 {
+		t.Fatal("unexpected number of connect events")
+	}
+}
+
+func TestUnitInterleaveAddresses(t *testing.T) {
+	out := interleaveAddresses([]string{
+		"10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::1", "fd00::2",
+	})
+	expected := []string{
+		"fd00::1", "10.0.0.1", "fd00::2", "10.0.0.2", "10.0.0.3",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Fatal(out)
+	}
+}
@@ -0,0 +1,29 @@
+// Package quicdialer contains the QUIC dialer.
+package quicdialer
+
+import "net"
+
+// PacketConn adapts a connected UDP net.Conn to the net.PacketConn
+// interface expected by QUIC. We dial UDP sockets using the ordinary
+// dialer, so we get DNS, connect, read and write events for free.
+type PacketConn struct {
+	net.Conn
+}
+
+// NewPacketConn creates a new PacketConn.
+func NewPacketConn(conn net.Conn) *PacketConn {
+	return &PacketConn{Conn: conn}
+}
+
+// ReadFrom reads a datagram, which always comes from the remote
+// address because the underlying socket is connected.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Conn.Read(p)
+	return n, c.Conn.RemoteAddr(), err
+}
+
+// WriteTo writes a datagram to the remote address. The addr
+// argument is ignored because the underlying socket is connected.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.Conn.Write(p)
+}
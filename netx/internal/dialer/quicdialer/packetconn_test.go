@@ -0,0 +1,42 @@
+package quicdialer
+
+import (
+	"net"
+	"testing"
+)
+
+func TestUnitPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		buffer := make([]byte, 128)
+		n, addr, err := server.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		server.WriteTo(buffer[:n], addr)
+	}()
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pconn := NewPacketConn(conn)
+	defer pconn.Close()
+	if _, err := pconn.WriteTo([]byte("antani"), nil); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 128)
+	n, addr, err := pconn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buffer[:n]) != "antani" {
+		t.Fatal("unexpected datagram")
+	}
+	if addr.String() != server.LocalAddr().String() {
+		t.Fatal("unexpected remote address")
+	}
+}
@@ -0,0 +1,99 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/internal/dialer/connx"
+	"github.com/ooni/probe-engine/netx/internal/errwrapper"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/quic-go/quic-go"
+)
+
+// QUICDialer is the QUIC dialer
+type QUICDialer struct {
+	HandshakeTimeout time.Duration // default: 10 second
+	dialer           modelx.Dialer
+	quicDialContext  func(
+		ctx context.Context, pconn net.PacketConn, remoteAddr net.Addr,
+		tlsConf *tls.Config, config *quic.Config,
+	) (*quic.Conn, error)
+}
+
+// New creates a new QUIC dialer. The dialer is used to create the
+// UDP socket, hence it should emit connect, read and write events.
+func New(dialer modelx.Dialer) *QUICDialer {
+	return &QUICDialer{
+		HandshakeTimeout: 10 * time.Second,
+		dialer:           dialer,
+		quicDialContext:  quic.Dial,
+	}
+}
+
+// DialContext dials a new QUIC connection. The network argument
+// is ignored, since QUIC always runs over UDP. The UDP socket is
+// closed when the returned QUIC connection is closed.
+func (d *QUICDialer) DialContext(
+	ctx context.Context, network, address string,
+	tlsConfig *tls.Config, quicConfig *quic.Config,
+) (*quic.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := d.dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	config := tlsConfig.Clone() // avoid polluting original config
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	var connID int64
+	if mconn, ok := conn.(*connx.MeasuringConn); ok {
+		connID = mconn.ID
+	}
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	root.Handler.OnMeasurement(modelx.Measurement{
+		QUICHandshakeStart: &modelx.QUICHandshakeStartEvent{
+			ConnID:                 connID,
+			DurationSinceBeginning: time.Now().Sub(root.Beginning),
+			SNI:                    config.ServerName,
+		},
+	})
+	hsctx, cancel := context.WithTimeout(ctx, d.HandshakeTimeout)
+	defer cancel()
+	sess, err := d.quicDialContext(
+		hsctx, NewPacketConn(conn), conn.RemoteAddr(), config, quicConfig,
+	)
+	err = errwrapper.SafeErrWrapperBuilder{
+		ConnID:    connID,
+		Error:     err,
+		Operation: "quic_handshake",
+	}.MaybeBuild()
+	var state tls.ConnectionState
+	if sess != nil {
+		state = sess.ConnectionState().TLS
+	}
+	root.Handler.OnMeasurement(modelx.Measurement{
+		QUICHandshakeDone: &modelx.QUICHandshakeDoneEvent{
+			ConnID:                 connID,
+			ConnectionState:        modelx.NewTLSConnectionState(state),
+			DurationSinceBeginning: time.Now().Sub(root.Beginning),
+			Error:                  err,
+		},
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// quic-go does not own sockets passed to Dial, so we close the
+	// UDP socket ourselves once the QUIC connection is done.
+	go func() {
+		<-sess.Context().Done()
+		conn.Close()
+	}()
+	return sess, nil
+}
@@ -0,0 +1,146 @@
+package quicdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/internal/dialer/dialerbase"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func newdialer() *QUICDialer {
+	return New(dialerbase.New(
+		time.Now(), handlers.NoHandler, new(net.Dialer), 17,
+	))
+}
+
+// newserver starts a local HTTP/3 server using the certificate of
+// an httptest server and returns its address and a pool trusting it.
+func newserver(t *testing.T) (string, *x509.CertPool, func()) {
+	tlsserver := httptest.NewTLSServer(http.NotFoundHandler())
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		Handler: http.NotFoundHandler(),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: tlsserver.TLS.Certificates,
+		}),
+	}
+	go server.Serve(pconn)
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsserver.Certificate())
+	return pconn.LocalAddr().String(), pool, func() {
+		server.Close()
+		pconn.Close()
+		tlsserver.Close()
+	}
+}
+
+func TestIntegrationSuccess(t *testing.T) {
+	dialer := newdialer()
+	sess, err := dialer.DialContext(
+		context.Background(), "udp", "www.google.com:443",
+		&tls.Config{NextProtos: []string{"h3"}},
+		&quic.Config{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.CloseWithError(0, "")
+}
+
+func TestUnitSuccessWithLocalServer(t *testing.T) {
+	address, pool, cleanup := newserver(t)
+	defer cleanup()
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	sess, err := newdialer().DialContext(ctx, "udp", address, &tls.Config{
+		NextProtos: []string{http3.NextProtoH3},
+		RootCAs:    pool,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sess.CloseWithError(0, "")
+	var done *modelx.QUICHandshakeDoneEvent
+	for _, ev := range saver.Read() {
+		if ev.QUICHandshakeDone != nil {
+			done = ev.QUICHandshakeDone
+		}
+	}
+	if done == nil || done.Error != nil {
+		t.Fatal("missing or failed QUIC handshake event")
+	}
+	if done.ConnectionState.NegotiatedProtocol != http3.NextProtoH3 {
+		t.Fatal("unexpected negotiated protocol")
+	}
+	if len(done.ConnectionState.PeerCertificates) != 1 {
+		t.Fatal("unexpected peer certificates")
+	}
+}
+
+func TestUnitInvalidAddress(t *testing.T) {
+	dialer := newdialer()
+	sess, err := dialer.DialContext(
+		context.Background(), "udp", "www.google.com", new(tls.Config), nil,
+	)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if sess != nil {
+		t.Fatal("expected a nil session here")
+	}
+}
+
+func TestUnitHandshakeFailure(t *testing.T) {
+	dialer := newdialer()
+	expected := errors.New("mocked error")
+	dialer.quicDialContext = func(
+		ctx context.Context, pconn net.PacketConn, remoteAddr net.Addr,
+		tlsConf *tls.Config, config *quic.Config,
+	) (*quic.Conn, error) {
+		if tlsConf.ServerName != "127.0.0.1" {
+			return nil, errors.New("unexpected host")
+		}
+		return nil, expected
+	}
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	sess, err := dialer.DialContext(ctx, "udp", "127.0.0.1:443", new(tls.Config), nil)
+	if !errors.Is(err, expected) {
+		t.Fatal("not the error we expected")
+	}
+	var wrapper *modelx.ErrWrapper
+	if !errors.As(err, &wrapper) || wrapper.Operation != "quic_handshake" {
+		t.Fatal("the error has not been wrapped")
+	}
+	if sess != nil {
+		t.Fatal("expected a nil session here")
+	}
+	var start, done bool
+	for _, ev := range saver.Read() {
+		start = start || ev.QUICHandshakeStart != nil
+		done = done || (ev.QUICHandshakeDone != nil && ev.QUICHandshakeDone.Error != nil)
+	}
+	if !start || !done {
+		t.Fatal("missing QUIC handshake events")
+	}
+}
@@ -0,0 +1,119 @@
+package tlsdialer
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sort"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// TLSConn is a TLS client connection.
+type TLSConn interface {
+	net.Conn
+	ConnectionState() tls.ConnectionState
+	Handshake() error
+}
+
+// ClientHelloBuilder creates TLS client connections that send a
+// specific ClientHello. We use it to parrot well known clients.
+type ClientHelloBuilder interface {
+	// Client creates a new TLS client connection.
+	Client(conn net.Conn, config *tls.Config) TLSConn
+
+	// Name returns the name of the ClientHello parrot.
+	Name() string
+}
+
+// ErrUnknownClientHello indicates that we don't know a parrot.
+var ErrUnknownClientHello = errors.New("tlsdialer: unknown ClientHello parrot")
+
+// clientHelloParrots maps parrot names to uTLS ClientHello IDs.
+var clientHelloParrots = map[string]utls.ClientHelloID{
+	"chrome":     utls.HelloChrome_Auto,
+	"firefox":    utls.HelloFirefox_Auto,
+	"ios":        utls.HelloIOS_Auto,
+	"randomized": utls.HelloRandomized,
+}
+
+// ClientHelloNames returns the sorted names of the available parrots.
+func ClientHelloNames() (names []string) {
+	for name := range clientHelloParrots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// NewClientHelloBuilder returns the ClientHelloBuilder for the parrot
+// with the specified name, or ErrUnknownClientHello.
+func NewClientHelloBuilder(name string) (ClientHelloBuilder, error) {
+	id, found := clientHelloParrots[name]
+	if !found {
+		return nil, ErrUnknownClientHello
+	}
+	return &utlsBuilder{id: id, name: name}, nil
+}
+
+type utlsBuilder struct {
+	id   utls.ClientHelloID
+	name string
+}
+
+func (b *utlsBuilder) Client(conn net.Conn, config *tls.Config) TLSConn {
+	uconn := utls.UClient(conn, &utls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+		NextProtos:         config.NextProtos,
+		RootCAs:            config.RootCAs,
+		ServerName:         config.ServerName,
+	}, b.id)
+	// If building fails here, Handshake will fail in the same way later.
+	if uconn.BuildHandshakeState() == nil {
+		dedupGREASEExtensions(uconn)
+	}
+	return &utlsConn{UConn: uconn}
+}
+
+// dedupGREASEExtensions works around a uTLS bug where the two GREASE
+// extensions sometimes get the same value (about once every 16 hellos),
+// which causes servers to reject the ClientHello because it contains
+// a duplicate extension.
+func dedupGREASEExtensions(uconn *utls.UConn) {
+	var greases []*utls.UtlsGREASEExtension
+	for _, ext := range uconn.Extensions {
+		if grease, ok := ext.(*utls.UtlsGREASEExtension); ok {
+			greases = append(greases, grease)
+		}
+	}
+	if len(greases) == 2 && greases[0].Value == greases[1].Value {
+		greases[1].Value ^= 0x1010
+		uconn.MarshalClientHello()
+	}
+}
+
+func (b *utlsBuilder) Name() string {
+	return b.name
+}
+
+type utlsConn struct {
+	*utls.UConn
+}
+
+func (c *utlsConn) ConnectionState() tls.ConnectionState {
+	s := c.UConn.ConnectionState()
+	return tls.ConnectionState{
+		Version:                     s.Version,
+		HandshakeComplete:           s.HandshakeComplete,
+		DidResume:                   s.DidResume,
+		CipherSuite:                 s.CipherSuite,
+		NegotiatedProtocol:          s.NegotiatedProtocol,
+		NegotiatedProtocolIsMutual:  s.NegotiatedProtocolIsMutual,
+		ServerName:                  s.ServerName,
+		PeerCertificates:            s.PeerCertificates,
+		VerifiedChains:              s.VerifiedChains,
+		SignedCertificateTimestamps: s.SignedCertificateTimestamps,
+		OCSPResponse:                s.OCSPResponse,
+		TLSUnique:                   s.TLSUnique,
+	}
+}
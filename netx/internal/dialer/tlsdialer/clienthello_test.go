@@ -0,0 +1,134 @@
+package tlsdialer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/internal/dialer/dialerbase"
+	"github.com/ooni/probe-engine/netx/modelx"
+	utls "github.com/refraction-networking/utls"
+)
+
+func TestUnitNewClientHelloBuilder(t *testing.T) {
+	for _, name := range ClientHelloNames() {
+		builder, err := NewClientHelloBuilder(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if builder.Name() != name {
+			t.Fatal("unexpected builder name")
+		}
+	}
+	builder, err := NewClientHelloBuilder("antani")
+	if !errors.Is(err, ErrUnknownClientHello) {
+		t.Fatal("not the error we expected")
+	}
+	if builder != nil {
+		t.Fatal("expected a nil builder here")
+	}
+}
+
+func TestUnitClientHelloNames(t *testing.T) {
+	names := strings.Join(ClientHelloNames(), " ")
+	if names != "chrome firefox ios randomized" {
+		t.Fatal("unexpected names")
+	}
+}
+
+func TestUnitDialTLSWithClientHello(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	builder, err := NewClientHelloBuilder("chrome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := New(dialerbase.New(
+		time.Now(), handlers.NoHandler, new(net.Dialer), 17,
+	), &tls.Config{InsecureSkipVerify: true})
+	dialer.ClientHello = builder
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	conn, err := dialer.DialTLSContext(ctx, "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.(TLSConn).ConnectionState().Version == 0 {
+		t.Fatal("unexpected TLS version")
+	}
+	var found bool
+	for _, ev := range saver.Read() {
+		if ev.TLSHandshakeStart != nil {
+			found = ev.TLSHandshakeStart.ClientHello == "chrome"
+		}
+	}
+	if !found {
+		t.Fatal("the ClientHello has not been recorded")
+	}
+}
+
+func TestUnitDedupGREASEExtensions(t *testing.T) {
+	conn, _ := net.Pipe()
+	defer conn.Close()
+	uconn := utls.UClient(conn, &utls.Config{ServerName: "example.com"}, utls.HelloChrome_Auto)
+	if err := uconn.BuildHandshakeState(); err != nil {
+		t.Fatal(err)
+	}
+	var greases []*utls.UtlsGREASEExtension
+	for _, ext := range uconn.Extensions {
+		if grease, ok := ext.(*utls.UtlsGREASEExtension); ok {
+			greases = append(greases, grease)
+		}
+	}
+	if len(greases) != 2 {
+		t.Fatal("expected two GREASE extensions")
+	}
+	greases[1].Value = greases[0].Value
+	dedupGREASEExtensions(uconn)
+	if greases[0].Value == greases[1].Value {
+		t.Fatal("the GREASE extensions are still duplicated")
+	}
+}
+
+func TestUnitDialTLSJA3(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dialer := New(dialerbase.New(
+		time.Now(), handlers.NoHandler, new(net.Dialer), 17,
+	), &tls.Config{InsecureSkipVerify: true})
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	conn, err := dialer.DialTLSContext(ctx, "tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var state modelx.TLSConnectionState
+	for _, ev := range saver.Read() {
+		if ev.TLSHandshakeDone != nil {
+			state = ev.TLSHandshakeDone.ConnectionState
+		}
+	}
+	if !strings.HasPrefix(state.JA3, "771,") || state.JA3Hash == "" {
+		t.Fatal("the JA3 fingerprint has not been recorded")
+	}
+	if !strings.HasPrefix(state.JA3S, "771,") || state.JA3SHash == "" {
+		t.Fatal("the JA3S fingerprint has not been recorded")
+	}
+}
@@ -9,13 +9,15 @@ import (
 
 	"github.com/ooni/probe-engine/netx/internal/dialer/connx"
 	"github.com/ooni/probe-engine/netx/internal/errwrapper"
+	"github.com/ooni/probe-engine/netx/internal/ja3"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 // TLSDialer is the TLS dialer
 type TLSDialer struct {
-	ConnectTimeout      time.Duration // default: 30 second
-	TLSHandshakeTimeout time.Duration // default: 10 second
+	ClientHello         ClientHelloBuilder // default: use crypto/tls
+	ConnectTimeout      time.Duration      // default: 30 second
+	TLSHandshakeTimeout time.Duration      // default: 10 second
 	config              *tls.Config
 	dialer              modelx.Dialer
 	setDeadline         func(net.Conn, time.Time) error
@@ -63,7 +65,18 @@ func (d *TLSDialer) DialTLSContext(
 		conn.Close()
 		return nil, err
 	}
-	tlsconn := tls.Client(conn, config)
+	var (
+		clientHello string
+		recorder    = new(ja3.Recorder)
+		recconn     = &ja3.Conn{Conn: conn, Recorder: recorder}
+		tlsconn     TLSConn
+	)
+	if d.ClientHello != nil {
+		clientHello = d.ClientHello.Name()
+		tlsconn = d.ClientHello.Client(recconn, config)
+	} else {
+		tlsconn = tls.Client(recconn, config)
+	}
 	var connID int64
 	if mconn, ok := conn.(*connx.MeasuringConn); ok {
 		connID = mconn.ID
@@ -75,6 +88,7 @@ func (d *TLSDialer) DialTLSContext(
 	// performing non-HTTP TLS-enabled dial operations.
 	root.Handler.OnMeasurement(modelx.Measurement{
 		TLSHandshakeStart: &modelx.TLSHandshakeStartEvent{
+			ClientHello:            clientHello,
 			ConnID:                 connID,
 			DurationSinceBeginning: time.Now().Sub(root.Beginning),
 			SNI:                    config.ServerName,
@@ -86,10 +100,12 @@ func (d *TLSDialer) DialTLSContext(
 		Error:     err,
 		Operation: "tls_handshake",
 	}.MaybeBuild()
+	state := modelx.NewTLSConnectionState(tlsconn.ConnectionState())
+	recorder.Fill(&state)
 	root.Handler.OnMeasurement(modelx.Measurement{
 		TLSHandshakeDone: &modelx.TLSHandshakeDoneEvent{
 			ConnID:                 connID,
-			ConnectionState:        modelx.NewTLSConnectionState(tlsconn.ConnectionState()),
+			ConnectionState:        state,
 			Error:                  err,
 			DurationSinceBeginning: time.Now().Sub(root.Beginning),
 		},
@@ -2,12 +2,17 @@
 package errwrapper
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
+	"syscall"
 
 	"github.com/ooni/probe-engine/netx/modelx"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
 )
 
 // SafeErrWrapperBuilder contains a builder for modelx.ErrWrapper that
@@ -75,6 +80,35 @@ func toFailureString(err error) string {
 		return modelx.FailureSSLInvalidCertificate
 	}
 
+	if failure := toTLSFailureString(err); failure != "" {
+		return failure
+	}
+	if failure := toDNSFailureString(err); failure != "" {
+		return failure
+	}
+	if failure := toHTTP2FailureString(err); failure != "" {
+		return failure
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.ECONNABORTED:
+			return modelx.FailureConnectionAborted
+		case syscall.ECONNREFUSED:
+			return modelx.FailureConnectionRefused
+		case syscall.ECONNRESET:
+			return modelx.FailureConnectionReset
+		case syscall.EHOSTUNREACH:
+			return modelx.FailureHostUnreachable
+		case syscall.ENETUNREACH:
+			return modelx.FailureNetworkUnreachable
+		case syscall.ETIMEDOUT:
+			return modelx.FailureGenericTimeoutError
+		}
+		// FALLTHROUGH
+	}
+
 	s := err.Error()
 	if strings.HasSuffix(s, "EOF") {
 		return modelx.FailureEOFError
@@ -104,6 +138,70 @@ func toFailureString(err error) string {
 	return fmt.Sprintf("unknown_failure: %s", s)
 }
 
+func toTLSFailureString(err error) string {
+	// Both crypto/tls and utls wrap the alerts sent by the peer
+	// into a *net.OpError whose Op is "remote error".
+	var opError *net.OpError
+	if errors.As(err, &opError) && opError.Op == "remote error" {
+		return toTLSAlertFailureString(opError.Err)
+	}
+	// We receive a record header error when the peer does not
+	// speak TLS, e.g., when a middlebox injects a response.
+	var recordHeaderError tls.RecordHeaderError
+	if errors.As(err, &recordHeaderError) {
+		return modelx.FailureSSLFailedHandshake
+	}
+	var utlsRecordHeaderError utls.RecordHeaderError
+	if errors.As(err, &utlsRecordHeaderError) {
+		return modelx.FailureSSLFailedHandshake
+	}
+	return ""
+}
+
+// toTLSAlertFailureString maps an alert such as "tls: unrecognized name"
+// to a failure string such as "ssl_alert_unrecognized_name".
+func toTLSAlertFailureString(err error) string {
+	name := strings.ToLower(strings.TrimPrefix(err.Error(), "tls: "))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}), "_")
+	return modelx.FailureSSLAlertPrefix + name
+}
+
+func toDNSFailureString(err error) string {
+	if errors.Is(err, modelx.ErrDNSServfail) {
+		return modelx.FailureDNSServfail // not in MK
+	}
+	if errors.Is(err, modelx.ErrDNSRefused) {
+		return modelx.FailureDNSRefused // not in MK
+	}
+	// The Go resolver says "server misbehaving" for all the rcodes it
+	// does not handle but only SERVFAIL is marked as temporary.
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) && dnsError.IsTemporary &&
+		dnsError.Err == "server misbehaving" {
+		return modelx.FailureDNSServfail // not in MK
+	}
+	return ""
+}
+
+func toHTTP2FailureString(err error) string {
+	var streamError http2.StreamError
+	if errors.As(err, &streamError) && streamError.Code == http2.ErrCodeProtocol {
+		return modelx.FailureHTTP2ProtocolError // not in MK
+	}
+	var connectionError http2.ConnectionError
+	if errors.As(err, &connectionError) &&
+		http2.ErrCode(connectionError) == http2.ErrCodeProtocol {
+		return modelx.FailureHTTP2ProtocolError // not in MK
+	}
+	var goAwayError http2.GoAwayError
+	if errors.As(err, &goAwayError) && goAwayError.ErrCode == http2.ErrCodeProtocol {
+		return modelx.FailureHTTP2ProtocolError // not in MK
+	}
+	return ""
+}
+
 func toOperationString(err error, operation string) string {
 	var errwrapper *modelx.ErrWrapper
 	if errors.As(err, &errwrapper) {
@@ -121,6 +219,9 @@ func toOperationString(err error, operation string) string {
 		if errwrapper.Operation == "tls_handshake" {
 			return errwrapper.Operation
 		}
+		if errwrapper.Operation == "quic_handshake" {
+			return errwrapper.Operation
+		}
 		// FALLTHROUGH
 	}
 	return operation
@@ -2,14 +2,19 @@ package errwrapper
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"io"
 	"net"
+	"net/url"
+	"os"
 	"syscall"
 	"testing"
 
 	"github.com/ooni/probe-engine/netx/modelx"
+	utls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
 )
 
 func TestMaybeBuildFactory(t *testing.T) {
@@ -85,6 +90,103 @@ func TestToFailureString(t *testing.T) {
 			t.Fatal("unexpected results")
 		}
 	})
+	t.Run("for connection_aborted", func(t *testing.T) {
+		if toFailureString(syscall.ECONNABORTED) != modelx.FailureConnectionAborted {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for host_unreachable", func(t *testing.T) {
+		err := &net.OpError{
+			Op:  "dial",
+			Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH),
+		}
+		if toFailureString(err) != modelx.FailureHostUnreachable {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for network_unreachable", func(t *testing.T) {
+		err := &net.OpError{
+			Op:  "dial",
+			Err: os.NewSyscallError("connect", syscall.ENETUNREACH),
+		}
+		if toFailureString(err) != modelx.FailureNetworkUnreachable {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for ETIMEDOUT", func(t *testing.T) {
+		if toFailureString(syscall.ETIMEDOUT) != modelx.FailureGenericTimeoutError {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for TLS alert", func(t *testing.T) {
+		err := &net.OpError{Op: "remote error", Err: errors.New("tls: handshake failure")}
+		if toFailureString(err) != "ssl_alert_handshake_failure" {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for unknown TLS alert", func(t *testing.T) {
+		err := &net.OpError{Op: "remote error", Err: errors.New("tls: alert(200)")}
+		if toFailureString(err) != "ssl_alert_alert_200" {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for tls.RecordHeaderError", func(t *testing.T) {
+		var err tls.RecordHeaderError
+		if toFailureString(err) != modelx.FailureSSLFailedHandshake {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for utls.RecordHeaderError", func(t *testing.T) {
+		var err utls.RecordHeaderError
+		if toFailureString(err) != modelx.FailureSSLFailedHandshake {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for modelx.ErrDNSServfail", func(t *testing.T) {
+		if toFailureString(modelx.ErrDNSServfail) != modelx.FailureDNSServfail {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for modelx.ErrDNSRefused", func(t *testing.T) {
+		if toFailureString(modelx.ErrDNSRefused) != modelx.FailureDNSRefused {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for server misbehaving", func(t *testing.T) {
+		err := &net.DNSError{Err: "server misbehaving", IsTemporary: true}
+		if toFailureString(err) != modelx.FailureDNSServfail {
+			t.Fatal("unexpected results")
+		}
+		err.IsTemporary = false
+		if toFailureString(err) == modelx.FailureDNSServfail {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for HTTP/2 stream error", func(t *testing.T) {
+		err := &url.Error{Op: "Get", URL: "https://x.org/", Err: http2.StreamError{
+			StreamID: 1,
+			Code:     http2.ErrCodeProtocol,
+		}}
+		if toFailureString(err) != modelx.FailureHTTP2ProtocolError {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for HTTP/2 connection error", func(t *testing.T) {
+		err := http2.ConnectionError(http2.ErrCodeProtocol)
+		if toFailureString(err) != modelx.FailureHTTP2ProtocolError {
+			t.Fatal("unexpected results")
+		}
+	})
+	t.Run("for HTTP/2 GOAWAY error", func(t *testing.T) {
+		err := http2.GoAwayError{ErrCode: http2.ErrCodeProtocol}
+		if toFailureString(err) != modelx.FailureHTTP2ProtocolError {
+			t.Fatal("unexpected results")
+		}
+		err.ErrCode = http2.ErrCodeNo
+		if toFailureString(err) == modelx.FailureHTTP2ProtocolError {
+			t.Fatal("unexpected results")
+		}
+	})
 	t.Run("for context deadline expired", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 1)
 		defer cancel()
@@ -155,6 +257,14 @@ func TestUnitToOperationString(t *testing.T) {
 			t.Fatal("unexpected result")
 		}
 	})
+	t.Run("for quic_handshake", func(t *testing.T) {
+		// You're doing HTTP/3 and the QUIC handshake fails. You want
+		// to know about a QUIC handshake error.
+		err := &modelx.ErrWrapper{Operation: "quic_handshake"}
+		if toOperationString(err, "http_round_trip") != "quic_handshake" {
+			t.Fatal("unexpected result")
+		}
+	})
 	t.Run("for minor operation", func(t *testing.T) {
 		// You just noticed that TLS handshake failed and you
 		// have a child error telling you that read failed. Here
@@ -0,0 +1,60 @@
+// Package http3transport contains the HTTP/3 round tripper.
+package http3transport
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+
+	"github.com/ooni/probe-engine/netx/internal/dialer/quicdialer"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Transport performs HTTP/3 round trips.
+type Transport struct {
+	config *tls.Config
+	dialer *quicdialer.QUICDialer
+}
+
+// New creates a new Transport. The dialer is used to create the
+// UDP sockets and the config is the TLS config to use.
+func New(dialer modelx.Dialer, config *tls.Config) *Transport {
+	return &Transport{config: config, dialer: quicdialer.New(dialer)}
+}
+
+// RoundTrip executes a single HTTP/3 transaction. We create a round
+// tripper for each request and we dispose of it when the response body
+// is closed. So, we never reuse QUIC connections, and each connection
+// is measured using the measurement root of the request that caused it.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	txp := &http3.Transport{
+		DisableCompression: true,
+		TLSClientConfig:    t.config,
+		Dial: func(
+			ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config,
+		) (*quic.Conn, error) {
+			return t.dialer.DialContext(ctx, "udp", addr, tlsCfg, cfg)
+		},
+	}
+	resp, err := txp.RoundTrip(req)
+	if err != nil {
+		txp.Close()
+		return nil, err
+	}
+	resp.Body = &bodyCloser{ReadCloser: resp.Body, closer: txp}
+	return resp, nil
+}
+
+type bodyCloser struct {
+	io.ReadCloser
+	closer io.Closer
+}
+
+func (b *bodyCloser) Close() error {
+	err := b.ReadCloser.Close()
+	b.closer.Close()
+	return err
+}
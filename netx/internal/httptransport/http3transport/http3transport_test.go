@@ -0,0 +1,120 @@
+package http3transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/internal/dialer"
+	"github.com/ooni/probe-engine/netx/internal/resolver"
+	"github.com/ooni/probe-engine/netx/modelx"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func newtransport(config *tls.Config) *Transport {
+	return New(
+		dialer.New(resolver.NewResolverSystem(), new(net.Dialer)),
+		config,
+	)
+}
+
+func TestIntegrationSuccess(t *testing.T) {
+	saver := new(handlers.SavingHandler)
+	req, err := http.NewRequest("GET", "https://www.google.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(modelx.WithMeasurementRoot(
+		req.Context(), &modelx.MeasurementRoot{Handler: saver},
+	))
+	resp, err := newtransport(new(tls.Config)).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	var handshakes int
+	for _, ev := range saver.Read() {
+		if ev.QUICHandshakeDone != nil {
+			handshakes++
+		}
+	}
+	if handshakes != 1 {
+		t.Fatal("unexpected number of QUIC handshakes")
+	}
+}
+
+func TestUnitSuccessWithLocalServer(t *testing.T) {
+	tlsserver := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsserver.Close()
+	pconn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pconn.Close()
+	server := &http3.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+			Certificates: tlsserver.TLS.Certificates,
+		}),
+	}
+	defer server.Close()
+	go server.Serve(pconn)
+	pool := x509.NewCertPool()
+	pool.AddCert(tlsserver.Certificate())
+	saver := new(handlers.SavingHandler)
+	req, err := http.NewRequest("GET", "https://"+pconn.LocalAddr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(modelx.WithMeasurementRoot(
+		req.Context(), &modelx.MeasurementRoot{Handler: saver},
+	))
+	resp, err := newtransport(&tls.Config{RootCAs: pool}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if string(data) != "HTTP/3.0" {
+		t.Fatal("unexpected protocol")
+	}
+	var handshakes, reads int
+	for _, ev := range saver.Read() {
+		if ev.QUICHandshakeDone != nil && ev.QUICHandshakeDone.Error == nil {
+			handshakes++
+		}
+		if ev.Read != nil {
+			reads++
+		}
+	}
+	if handshakes != 1 || reads == 0 {
+		t.Fatal("missing QUIC events")
+	}
+}
+
+func TestUnitCleartextURL(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://www.google.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := newtransport(new(tls.Config)).RoundTrip(req)
+	if err == nil {
+		t.Fatal("expected an error here")
+	}
+	if resp != nil {
+		t.Fatal("expected a nil response here")
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/ooni/probe-engine/netx/internal/connid"
 	"github.com/ooni/probe-engine/netx/internal/dialid"
 	"github.com/ooni/probe-engine/netx/internal/errwrapper"
+	"github.com/ooni/probe-engine/netx/internal/ja3"
 	"github.com/ooni/probe-engine/netx/internal/transactionid"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
@@ -105,6 +106,11 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	// Allow the dialer to record the TLS hello messages of the
+	// connections for which net/http performs the handshake
+	ctx, slot := ja3.WithSlot(req.Context())
+	req = req.WithContext(ctx)
+
 	// Prepare a tracer for delivering events
 	tracer := &httptrace.ClientTrace{
 		TLSHandshakeStart: func() {
@@ -129,11 +135,13 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 				TransactionID: tid,
 			}.MaybeBuild()
 			durationSinceBeginning := time.Now().Sub(root.Beginning)
+			connState := modelx.NewTLSConnectionState(state)
+			slot.Fill(&connState)
 			// Event emitted by net/http when DialTLS is not
 			// configured in the http.Transport
 			root.Handler.OnMeasurement(modelx.Measurement{
 				TLSHandshakeDone: &modelx.TLSHandshakeDoneEvent{
-					ConnectionState:        modelx.NewTLSConnectionState(state),
+					ConnectionState:        connState,
 					Error:                  err,
 					DurationSinceBeginning: durationSinceBeginning,
 					TransactionID:          tid,
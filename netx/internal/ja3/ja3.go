@@ -0,0 +1,345 @@
+// Package ja3 computes the JA3 and JA3S fingerprints of TLS handshakes.
+//
+// JA3 fingerprints the ClientHello and JA3S the ServerHello. Comparing
+// the JA3S fingerprint with the one observed from a clean network allows
+// to tell whether a middlebox has replaced the server handshake. See
+// <https://github.com/salesforce/ja3> for more information.
+//
+// We observe the bytes sent and received by a connection and we record
+// the first handshake message sent (i.e. the ClientHello) and the first
+// one received (i.e. the ServerHello), from which we compute the JA3
+// and JA3S strings, along with their MD5 hashes.
+package ja3
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+const (
+	recordTypeHandshake = 22
+
+	handshakeTypeClientHello = 1
+	handshakeTypeServerHello = 2
+
+	extensionSupportedGroups = 10
+	extensionECPointFormats  = 11
+
+	// maxStreamSize is the maximum number of bytes that we buffer
+	// while waiting for the first handshake message.
+	maxStreamSize = 1 << 17
+)
+
+// ErrInvalidHello indicates that we cannot parse a hello message.
+var ErrInvalidHello = errors.New("ja3: invalid hello message")
+
+// ClientHello returns the JA3 string of the ClientHello message msg, which
+// includes the four bytes handshake header. The string consists of the
+// version, the cipher suites, the extensions, the supported groups and the
+// EC point formats. We skip GREASE values (RFC8701).
+func ClientHello(msg []byte) (string, error) {
+	r := &reader{data: msg}
+	if r.uint8() != handshakeTypeClientHello {
+		return "", ErrInvalidHello
+	}
+	body := &reader{data: r.vector(3)}
+	version := body.uint16()
+	body.skip(32)  // random
+	body.vector(1) // session ID
+	ciphers := uint16List(body.vector(2))
+	body.vector(1) // compression methods
+	var extensions, groups, formats []uint16
+	exts := &reader{data: body.vector(2)}
+	for !exts.empty() {
+		extType, data := exts.uint16(), exts.vector(2)
+		extensions = append(extensions, extType)
+		switch extType {
+		case extensionSupportedGroups:
+			groups = uint16List((&reader{data: data}).vector(2))
+		case extensionECPointFormats:
+			for _, format := range (&reader{data: data}).vector(1) {
+				formats = append(formats, uint16(format))
+			}
+		}
+	}
+	if r.err || body.err || exts.err {
+		return "", ErrInvalidHello
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(version)), join(ciphers), join(extensions),
+		join(groups), join(formats),
+	}, ","), nil
+}
+
+// ServerHello returns the JA3S string of the ServerHello message msg, which
+// includes the four bytes handshake header. The string consists of the
+// version, the selected cipher suite and the extensions.
+func ServerHello(msg []byte) (string, error) {
+	r := &reader{data: msg}
+	if r.uint8() != handshakeTypeServerHello {
+		return "", ErrInvalidHello
+	}
+	body := &reader{data: r.vector(3)}
+	version := body.uint16()
+	body.skip(32)  // random
+	body.vector(1) // session ID
+	cipher := body.uint16()
+	body.skip(1) // compression method
+	var extensions []uint16
+	exts := new(reader)
+	if !body.empty() { // extensions are optional in TLS 1.2
+		exts.data = body.vector(2)
+	}
+	for !exts.empty() {
+		extensions = append(extensions, exts.uint16())
+		exts.vector(2)
+	}
+	if r.err || body.err || exts.err {
+		return "", ErrInvalidHello
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(version)), strconv.Itoa(int(cipher)), join(extensions),
+	}, ","), nil
+}
+
+// Hash returns the MD5 hash of a JA3 or JA3S string.
+func Hash(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func isGREASE(value uint16) bool {
+	return value&0x0f0f == 0x0a0a && value>>8 == value&0xff
+}
+
+func uint16List(data []byte) (out []uint16) {
+	for len(data) >= 2 {
+		out = append(out, binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	return
+}
+
+func join(values []uint16) string {
+	var out []string
+	for _, value := range values {
+		if !isGREASE(value) {
+			out = append(out, strconv.Itoa(int(value)))
+		}
+	}
+	return strings.Join(out, "-")
+}
+
+// reader reads big endian values. On failure, it sets err and from
+// then on returns zero values, so that we only check errors once.
+type reader struct {
+	data []byte
+	err  bool
+}
+
+func (r *reader) empty() bool {
+	return r.err || len(r.data) <= 0
+}
+
+func (r *reader) next(n int) []byte {
+	if r.err || n > len(r.data) {
+		r.err = true
+		return nil
+	}
+	out := r.data[:n]
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *reader) skip(n int) {
+	r.next(n)
+}
+
+func (r *reader) uint8() uint8 {
+	if data := r.next(1); data != nil {
+		return data[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if data := r.next(2); data != nil {
+		return binary.BigEndian.Uint16(data)
+	}
+	return 0
+}
+
+// vector reads a vector whose length is encoded using size bytes.
+func (r *reader) vector(size int) []byte {
+	var length int
+	for _, b := range r.next(size) {
+		length = length<<8 | int(b)
+	}
+	return r.next(length)
+}
+
+// stream extracts the first handshake message from the bytes sent
+// or received by a connection, which may span several records.
+type stream struct {
+	buffer    []byte
+	done      bool
+	handshake []byte
+	message   []byte
+}
+
+func (s *stream) feed(data []byte) {
+	if s.done {
+		return
+	}
+	s.buffer = append(s.buffer, data...)
+	for len(s.buffer) >= 5 && !s.done {
+		if s.buffer[0] != recordTypeHandshake {
+			s.stop() // not a TLS handshake
+			return
+		}
+		length := int(binary.BigEndian.Uint16(s.buffer[3:5]))
+		if len(s.buffer) < 5+length {
+			break
+		}
+		s.handshake = append(s.handshake, s.buffer[5:5+length]...)
+		s.buffer = s.buffer[5+length:]
+		s.parse()
+	}
+	if len(s.buffer)+len(s.handshake) > maxStreamSize {
+		s.stop()
+	}
+}
+
+// parse checks whether we have received the whole first handshake
+// message, in which case we save it and we stop.
+func (s *stream) parse() {
+	if len(s.handshake) >= 4 {
+		length := int(s.handshake[1])<<16 | int(s.handshake[2])<<8 | int(s.handshake[3])
+		if len(s.handshake) >= 4+length {
+			message := s.handshake[:4+length]
+			s.stop()
+			s.message = message
+		}
+	}
+}
+
+func (s *stream) stop() {
+	s.buffer, s.done, s.handshake = nil, true, nil
+}
+
+// Recorder records the first handshake message sent and received by
+// a connection. It is goroutine safe.
+type Recorder struct {
+	mu      sync.Mutex
+	read    stream
+	written stream
+}
+
+// OnRead feeds the recorder with bytes received by the connection.
+func (r *Recorder) OnRead(data []byte) {
+	r.mu.Lock()
+	r.read.feed(data)
+	r.mu.Unlock()
+}
+
+// OnWrite feeds the recorder with bytes sent by the connection.
+func (r *Recorder) OnWrite(data []byte) {
+	r.mu.Lock()
+	r.written.feed(data)
+	r.mu.Unlock()
+}
+
+func (r *Recorder) sawClientHello() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.written.message != nil
+}
+
+// Fill sets the JA3 and JA3S fields of state, if we have seen the
+// corresponding messages and we could parse them.
+func (r *Recorder) Fill(state *modelx.TLSConnectionState) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, err := ClientHello(r.written.message); err == nil {
+		state.JA3, state.JA3Hash = s, Hash(s)
+	}
+	if s, err := ServerHello(r.read.message); err == nil {
+		state.JA3S, state.JA3SHash = s, Hash(s)
+	}
+}
+
+// Conn is a net.Conn that feeds a Recorder.
+type Conn struct {
+	net.Conn
+	Recorder *Recorder
+}
+
+// Read reads data from the connection.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.Recorder.OnRead(b[:n])
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.Recorder.OnWrite(b[:n])
+	return n, err
+}
+
+// Slot collects the recorders of the connections dialed by an HTTP
+// transaction, for which the HTTP transport performs the handshake. This
+// is how the HTTP code gets the recorder of its TLS connection.
+type Slot struct {
+	mu        sync.Mutex
+	recorders []*Recorder
+}
+
+type slotContextKey struct{}
+
+// WithSlot returns a copy of ctx containing a new Slot, which is
+// also returned to the caller.
+func WithSlot(ctx context.Context) (context.Context, *Slot) {
+	slot := new(Slot)
+	return context.WithValue(ctx, slotContextKey{}, slot), slot
+}
+
+// ContextSlot returns the Slot configured in ctx or nil.
+func ContextSlot(ctx context.Context) *Slot {
+	slot, _ := ctx.Value(slotContextKey{}).(*Slot)
+	return slot
+}
+
+// NewRecorder creates a new Recorder and adds it to the Slot.
+func (s *Slot) NewRecorder() *Recorder {
+	recorder := new(Recorder)
+	s.mu.Lock()
+	s.recorders = append(s.recorders, recorder)
+	s.mu.Unlock()
+	return recorder
+}
+
+// Fill is like Recorder.Fill but uses the most recent recorder that
+// has seen a ClientHello. The other recorders may belong to connections
+// that have not been used, e.g., Happy Eyeballs attempts that did not
+// win the race, or to connections used to resolve domain names.
+func (s *Slot) Fill(state *modelx.TLSConnectionState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.recorders) - 1; i >= 0; i-- {
+		if s.recorders[i].sawClientHello() {
+			s.recorders[i].Fill(state)
+			return
+		}
+	}
+}
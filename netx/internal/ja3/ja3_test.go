@@ -0,0 +1,198 @@
+package ja3
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// newmessage creates a handshake message with the given type and body
+func newmessage(msgtype byte, body []byte) []byte {
+	length := len(body)
+	return append([]byte{
+		msgtype, byte(length >> 16), byte(length >> 8), byte(length),
+	}, body...)
+}
+
+func newclienthello() []byte {
+	body := []byte{0x03, 0x03}               // version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0x00)                // session ID
+	body = append(body, 0x00, 0x06, 0x0a, 0x0a, 0x13, 0x01, 0xc0, 0x2f)
+	body = append(body, 0x01, 0x00) // compression methods
+	extensions := []byte{
+		0x1a, 0x1a, 0x00, 0x00, // GREASE
+		0x00, 0x00, 0x00, 0x00, // server_name
+		0x00, 0x0a, 0x00, 0x06, 0x00, 0x04, 0x2a, 0x2a, 0x00, 0x1d, // groups
+		0x00, 0x0b, 0x00, 0x02, 0x01, 0x00, // point formats
+	}
+	body = append(body, 0x00, byte(len(extensions)))
+	body = append(body, extensions...)
+	return newmessage(handshakeTypeClientHello, body)
+}
+
+func newserverhello(extensions []byte) []byte {
+	body := []byte{0x03, 0x03}               // version
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, 0x00)                // session ID
+	body = append(body, 0xc0, 0x2f)          // cipher suite
+	body = append(body, 0x00)                // compression method
+	if extensions != nil {
+		body = append(body, 0x00, byte(len(extensions)))
+		body = append(body, extensions...)
+	}
+	return newmessage(handshakeTypeServerHello, body)
+}
+
+// newrecords splits msg into handshake records of at most size bytes
+func newrecords(msg []byte, size int) (out []byte) {
+	for len(msg) > 0 {
+		n := size
+		if n > len(msg) {
+			n = len(msg)
+		}
+		out = append(out, recordTypeHandshake, 0x03, 0x01, byte(n>>8), byte(n))
+		out = append(out, msg[:n]...)
+		msg = msg[n:]
+	}
+	return
+}
+
+func TestUnitClientHello(t *testing.T) {
+	s, err := ClientHello(newclienthello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "771,4865-49199,0-10-11,29,0" {
+		t.Fatal(s)
+	}
+}
+
+func TestUnitClientHelloInvalid(t *testing.T) {
+	if _, err := ClientHello(newserverhello(nil)); err != ErrInvalidHello {
+		t.Fatal("not the error we expected")
+	}
+	msg := newclienthello()
+	if _, err := ClientHello(msg[:len(msg)-1]); err != ErrInvalidHello {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitServerHello(t *testing.T) {
+	s, err := ServerHello(newserverhello([]byte{
+		0xff, 0x01, 0x00, 0x01, 0x00, 0x00, 0x0b, 0x00, 0x02, 0x01, 0x00,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "771,49199,65281-11" {
+		t.Fatal(s)
+	}
+	s, err = ServerHello(newserverhello(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != "771,49199," {
+		t.Fatal(s)
+	}
+	if _, err := ServerHello(nil); err != ErrInvalidHello {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitHash(t *testing.T) {
+	// See https://github.com/salesforce/ja3 for this example
+	s := "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"
+	if Hash(s) != "ada70206e40642a3e4461f35503241d5" {
+		t.Fatal(Hash(s))
+	}
+}
+
+func TestUnitIsGREASE(t *testing.T) {
+	if !isGREASE(0x0a0a) || !isGREASE(0xfafa) {
+		t.Fatal("GREASE value not recognized")
+	}
+	if isGREASE(0x0a1a) || isGREASE(0x1301) {
+		t.Fatal("not a GREASE value")
+	}
+}
+
+func TestUnitRecorderFragmented(t *testing.T) {
+	recorder := new(Recorder)
+	data := newrecords(newclienthello(), 16)
+	for i := range data {
+		recorder.OnWrite(data[i : i+1])
+	}
+	// The ServerHello and the ChangeCipherSpec are usually read together
+	recorder.OnRead(append(newrecords(newserverhello(nil), 1<<14),
+		0x14, 0x03, 0x03, 0x00, 0x01, 0x01))
+	var state modelx.TLSConnectionState
+	recorder.Fill(&state)
+	if state.JA3 != "771,4865-49199,0-10-11,29,0" || state.JA3Hash != Hash(state.JA3) {
+		t.Fatal("unexpected JA3")
+	}
+	if state.JA3S != "771,49199," || state.JA3SHash != Hash(state.JA3S) {
+		t.Fatal("unexpected JA3S")
+	}
+}
+
+func TestUnitRecorderNotTLS(t *testing.T) {
+	recorder := new(Recorder)
+	recorder.OnWrite([]byte("GET / HTTP/1.1\r\n\r\n"))
+	var state modelx.TLSConnectionState
+	recorder.Fill(&state)
+	if state.JA3 != "" || state.JA3S != "" {
+		t.Fatal("unexpected fingerprints")
+	}
+}
+
+func TestUnitSlot(t *testing.T) {
+	if ContextSlot(context.Background()) != nil {
+		t.Fatal("unexpected slot")
+	}
+	ctx, slot := WithSlot(context.Background())
+	if ContextSlot(ctx) != slot {
+		t.Fatal("unexpected slot")
+	}
+	used := slot.NewRecorder()
+	used.OnWrite(newrecords(newclienthello(), 1<<14))
+	slot.NewRecorder() // e.g. a Happy Eyeballs attempt that did not win
+	var state modelx.TLSConnectionState
+	slot.Fill(&state)
+	if state.JA3 == "" {
+		t.Fatal("the slot did not use the right recorder")
+	}
+}
+
+func TestUnitConnWithCryptoTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	recorder := new(Recorder)
+	tlsconn := tls.Client(&Conn{Conn: conn, Recorder: recorder}, &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	if err := tlsconn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	state := modelx.NewTLSConnectionState(tlsconn.ConnectionState())
+	recorder.Fill(&state)
+	if !strings.HasPrefix(state.JA3, "771,") || len(state.JA3Hash) != 32 {
+		t.Fatal("unexpected JA3")
+	}
+	cipher := "," + strconv.Itoa(int(state.CipherSuite)) + ","
+	if !strings.Contains(state.JA3S, cipher) || len(state.JA3SHash) != 32 {
+		t.Fatal("unexpected JA3S")
+	}
+}
@@ -0,0 +1,242 @@
+// Package cacheresolver contains a caching resolver
+package cacheresolver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/internal/dialid"
+	"github.com/ooni/probe-engine/netx/internal/transactionid"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// Resolver is a caching resolver. It caches the results of LookupHost,
+// including NXDOMAIN failures, honouring the TTLs of the DNS replies.
+// The other lookups are not cached. A cache hit still emits resolve
+// start and done events, where the Cached field is true. Use the
+// modelx.WithBypassDNSCache context to skip the cache.
+type Resolver struct {
+	// DefaultTTL is the TTL we use when we cannot see the DNS
+	// replies, e.g., with the system resolver. Default: 60 seconds.
+	DefaultTTL time.Duration
+
+	// NegativeTTL is the TTL we use for NXDOMAIN failures when the
+	// reply does not contain any SOA record. Default: 30 seconds.
+	NegativeTTL time.Duration
+
+	entries  map[string]*entry
+	mu       sync.Mutex
+	now      func() time.Time
+	resolver modelx.DNSResolver
+}
+
+type entry struct {
+	done    modelx.ResolveDoneEvent
+	expires time.Time
+}
+
+// New creates a new caching Resolver.
+func New(resolver modelx.DNSResolver) *Resolver {
+	return &Resolver{
+		DefaultTTL:  60 * time.Second,
+		NegativeTTL: 30 * time.Second,
+		entries:     make(map[string]*entry),
+		now:         time.Now,
+		resolver:    resolver,
+	}
+}
+
+// LookupAddr returns the name of the provided IP address
+func (r *Resolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.resolver.LookupAddr(ctx, addr)
+}
+
+// LookupCNAME returns the canonical name of a host
+func (r *Resolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	return r.resolver.LookupCNAME(ctx, host)
+}
+
+// LookupHost returns the IP addresses of a host
+func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	key := strings.ToLower(hostname)
+	if !modelx.ContextBypassDNSCache(ctx) {
+		if e := r.get(key); e != nil {
+			return r.emitCached(ctx, e)
+		}
+	}
+	ctx, recorder := withRecorder(ctx)
+	addrs, err := r.resolver.LookupHost(ctx, hostname)
+	if err == nil || err.Error() == modelx.FailureDNSNXDOMAINError {
+		r.put(key, recorder.entry(r, hostname, addrs, err))
+	}
+	return addrs, err
+}
+
+func (r *Resolver) get(key string) *entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, found := r.entries[key]
+	if found && !r.now().Before(e.expires) {
+		delete(r.entries, key)
+		return nil
+	}
+	return e
+}
+
+func (r *Resolver) put(key string, e *entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries[key] = e
+}
+
+func (r *Resolver) emitCached(ctx context.Context, e *entry) ([]string, error) {
+	dialID := dialid.ContextDialID(ctx)
+	txID := transactionid.ContextTransactionID(ctx)
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	root.Handler.OnMeasurement(modelx.Measurement{
+		ResolveStart: &modelx.ResolveStartEvent{
+			Cached:                 true,
+			DialID:                 dialID,
+			DurationSinceBeginning: time.Now().Sub(root.Beginning),
+			Hostname:               e.done.Hostname,
+			TransactionID:          txID,
+			TransportAddress:       e.done.TransportAddress,
+			TransportNetwork:       e.done.TransportNetwork,
+		},
+	})
+	done := e.done // make a copy
+	done.Cached = true
+	done.DialID = dialID
+	done.DurationSinceBeginning = time.Now().Sub(root.Beginning)
+	done.TransactionID = txID
+	root.Handler.OnMeasurement(modelx.Measurement{ResolveDone: &done})
+	return done.Addresses, done.Error
+}
+
+// LookupRaw sends a query of the given type for name and returns the
+// reply. Raw lookups are never cached.
+func (r *Resolver) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	reso, okay := r.resolver.(modelx.DNSRawResolver)
+	if !okay {
+		return nil, modelx.ErrDNSRawNotSupported
+	}
+	return reso.LookupRaw(ctx, name, qtype)
+}
+
+// LookupMX returns the MX records of a specific name
+func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return r.resolver.LookupMX(ctx, name)
+}
+
+// LookupNS returns the NS records of a specific name
+func (r *Resolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error) {
+	return r.resolver.LookupNS(ctx, name)
+}
+
+// recorder forwards all the events emitted by the child resolver and
+// records the information that we need to create a cache entry.
+type recorder struct {
+	done    *modelx.ResolveDoneEvent
+	handler modelx.Handler
+	mu      sync.Mutex
+	replies []*dns.Msg
+}
+
+// withRecorder returns a context whose measurement root uses a new
+// recorder as its handler. We only do that when the context already
+// contains a measurement root, because otherwise we would prevent the
+// child resolver from setting its own root. In such case we return
+// the original context and a nil recorder.
+func withRecorder(ctx context.Context) (context.Context, *recorder) {
+	root := modelx.ContextMeasurementRoot(ctx)
+	if root == nil {
+		return ctx, nil
+	}
+	rec := &recorder{handler: root.Handler}
+	child := *root
+	child.Handler = rec
+	return modelx.WithMeasurementRoot(ctx, &child), rec
+}
+
+func (rec *recorder) OnMeasurement(m modelx.Measurement) {
+	rec.mu.Lock()
+	if m.DNSReply != nil && m.DNSReply.Msg != nil {
+		rec.replies = append(rec.replies, m.DNSReply.Msg)
+	}
+	if m.ResolveDone != nil {
+		rec.done = m.ResolveDone
+	}
+	rec.mu.Unlock()
+	rec.handler.OnMeasurement(m)
+}
+
+// entry creates a cache entry. It uses the resolve done event emitted
+// by the child resolver, if any, and the smallest TTL in the replies.
+func (rec *recorder) entry(
+	r *Resolver, hostname string, addrs []string, err error,
+) *entry {
+	e := &entry{done: modelx.ResolveDoneEvent{
+		Addresses: addrs,
+		Error:     err,
+		Hostname:  hostname,
+	}}
+	var (
+		found bool
+		ttl   time.Duration
+	)
+	if rec != nil {
+		rec.mu.Lock()
+		if rec.done != nil {
+			e.done = *rec.done
+		}
+		ttl, found = minTTL(rec.replies, err != nil)
+		rec.mu.Unlock()
+	}
+	if !found {
+		ttl = r.DefaultTTL
+		if err != nil {
+			ttl = r.NegativeTTL
+		}
+	}
+	e.expires = r.now().Add(ttl)
+	return e
+}
+
+// minTTL returns the smallest TTL of the A and AAAA records in the
+// replies. For negative replies, it uses the SOA records, as mandated
+// by RFC2308 Sect. 5. The bool is false if there is no such record.
+func minTTL(replies []*dns.Msg, negative bool) (time.Duration, bool) {
+	var (
+		found bool
+		ttl   uint32
+	)
+	update := func(value uint32) {
+		if !found || value < ttl {
+			ttl, found = value, true
+		}
+	}
+	for _, reply := range replies {
+		if negative {
+			for _, rr := range reply.Ns {
+				if soa, ok := rr.(*dns.SOA); ok {
+					update(soa.Hdr.Ttl)
+					update(soa.Minttl)
+				}
+			}
+			continue
+		}
+		for _, rr := range reply.Answer {
+			switch rr.(type) {
+			case *dns.A, *dns.AAAA:
+				update(rr.Header().Ttl)
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Second, found
+}
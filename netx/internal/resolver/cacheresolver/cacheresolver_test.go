@@ -0,0 +1,254 @@
+package cacheresolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+type fakeresolver struct {
+	*net.Resolver
+	addrs []string
+	count int
+	err   error
+	reply *dns.Msg
+}
+
+func (r *fakeresolver) LookupHost(ctx context.Context, hostname string) ([]string, error) {
+	r.count++
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	if r.reply != nil {
+		root.Handler.OnMeasurement(modelx.Measurement{
+			DNSReply: &modelx.DNSReplyEvent{Msg: r.reply},
+		})
+	}
+	root.Handler.OnMeasurement(modelx.Measurement{
+		ResolveDone: &modelx.ResolveDoneEvent{
+			Addresses:        r.addrs,
+			Error:            r.err,
+			Hostname:         hostname,
+			TransportAddress: "8.8.8.8:53",
+			TransportNetwork: "udp",
+		},
+	})
+	return r.addrs, r.err
+}
+
+func newreply(ttl uint32) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.Answer = append(reply.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "dns.google.", Rrtype: dns.TypeA, Ttl: ttl},
+		A:   net.IPv4(8, 8, 8, 8),
+	})
+	return reply
+}
+
+func newcontext(handler modelx.Handler) context.Context {
+	return modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   handler,
+	})
+}
+
+func TestUnitLookupHostCached(t *testing.T) {
+	child := &fakeresolver{addrs: []string{"8.8.8.8"}, reply: newreply(10)}
+	client := New(child)
+	saver := new(handlers.SavingHandler)
+	ctx := newcontext(saver)
+	for i := 0; i < 2; i++ {
+		addrs, err := client.LookupHost(ctx, "dns.google")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || addrs[0] != "8.8.8.8" {
+			t.Fatal("unexpected addresses")
+		}
+	}
+	if child.count != 1 {
+		t.Fatal("the cache has not been used")
+	}
+	var start, done *modelx.ResolveDoneEvent
+	var cachedStart bool
+	for _, ev := range saver.Read() {
+		if ev.ResolveStart != nil && ev.ResolveStart.Cached {
+			cachedStart = ev.ResolveStart.TransportNetwork == "udp"
+		}
+		if ev.ResolveDone != nil {
+			start, done = done, ev.ResolveDone
+		}
+	}
+	if !cachedStart {
+		t.Fatal("missing cached resolve start event")
+	}
+	if start == nil || start.Cached || done == nil || !done.Cached {
+		t.Fatal("unexpected resolve done events")
+	}
+	if done.TransportNetwork != "udp" || done.Addresses[0] != "8.8.8.8" {
+		t.Fatal("unexpected cached resolve done event")
+	}
+}
+
+func TestUnitLookupHostExpired(t *testing.T) {
+	child := &fakeresolver{addrs: []string{"8.8.8.8"}, reply: newreply(10)}
+	client := New(child)
+	ctx := newcontext(handlers.NoHandler)
+	if _, err := client.LookupHost(ctx, "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	client.now = func() time.Time {
+		return time.Now().Add(11 * time.Second)
+	}
+	if _, err := client.LookupHost(ctx, "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	if child.count != 2 {
+		t.Fatal("the cache entry has not expired")
+	}
+}
+
+func TestUnitLookupHostBypass(t *testing.T) {
+	child := &fakeresolver{addrs: []string{"8.8.8.8"}, reply: newreply(10)}
+	client := New(child)
+	ctx := newcontext(handlers.NoHandler)
+	if _, err := client.LookupHost(ctx, "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	ctx = modelx.WithBypassDNSCache(ctx)
+	if _, err := client.LookupHost(ctx, "dns.google"); err != nil {
+		t.Fatal(err)
+	}
+	if child.count != 2 {
+		t.Fatal("the cache has not been bypassed")
+	}
+}
+
+func TestUnitLookupHostNegative(t *testing.T) {
+	reply := new(dns.Msg)
+	reply.Ns = append(reply.Ns, &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "google.", Rrtype: dns.TypeSOA, Ttl: 3600},
+		Minttl: 5,
+	})
+	child := &fakeresolver{
+		err:   errors.New(modelx.FailureDNSNXDOMAINError),
+		reply: reply,
+	}
+	client := New(child)
+	ctx := newcontext(handlers.NoHandler)
+	for i := 0; i < 2; i++ {
+		if _, err := client.LookupHost(ctx, "dns.googlex"); err == nil {
+			t.Fatal("expected an error here")
+		}
+	}
+	if child.count != 1 {
+		t.Fatal("the failure has not been cached")
+	}
+	client.now = func() time.Time {
+		return time.Now().Add(6 * time.Second)
+	}
+	if _, err := client.LookupHost(ctx, "dns.googlex"); err == nil {
+		t.Fatal("expected an error here")
+	}
+	if child.count != 2 {
+		t.Fatal("the negative TTL has not been honoured")
+	}
+}
+
+func TestUnitLookupHostOtherErrors(t *testing.T) {
+	child := &fakeresolver{err: errors.New(modelx.FailureGenericTimeoutError)}
+	client := New(child)
+	ctx := newcontext(handlers.NoHandler)
+	for i := 0; i < 2; i++ {
+		if _, err := client.LookupHost(ctx, "dns.google"); err == nil {
+			t.Fatal("expected an error here")
+		}
+	}
+	if child.count != 2 {
+		t.Fatal("the failure has been cached")
+	}
+}
+
+func TestUnitLookupHostNoMeasurementRoot(t *testing.T) {
+	child := &fakeresolver{addrs: []string{"8.8.8.8"}, reply: newreply(0)}
+	client := New(child)
+	for i := 0; i < 2; i++ {
+		if _, err := client.LookupHost(context.Background(), "dns.google"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// We cannot see the TTL of the reply, so we use the default TTL
+	if child.count != 1 {
+		t.Fatal("the cache has not been used")
+	}
+}
+
+func TestUnitLookupRawNotSupported(t *testing.T) {
+	client := New(new(net.Resolver))
+	reply, err := client.LookupRaw(context.Background(), "dns.google", dns.TypeTXT)
+	if !errors.Is(err, modelx.ErrDNSRawNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected a nil reply here")
+	}
+}
+
+func TestUnitMinTTL(t *testing.T) {
+	replies := []*dns.Msg{newreply(30), newreply(20)}
+	ttl, found := minTTL(replies, false)
+	if !found || ttl != 20*time.Second {
+		t.Fatal("unexpected TTL")
+	}
+	if _, found := minTTL(replies, true); found {
+		t.Fatal("unexpected SOA record")
+	}
+}
+
+func TestIntegrationLookupAddr(t *testing.T) {
+	client := New(new(net.Resolver))
+	names, err := client.LookupAddr(context.Background(), "8.8.8.8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names == nil {
+		t.Fatal("expected non-nil result here")
+	}
+}
+
+func TestIntegrationLookupCNAME(t *testing.T) {
+	client := New(new(net.Resolver))
+	cname, err := client.LookupCNAME(context.Background(), "www.ooni.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cname == "" {
+		t.Fatal("expected non-empty result here")
+	}
+}
+
+func TestIntegrationLookupMX(t *testing.T) {
+	client := New(new(net.Resolver))
+	records, err := client.LookupMX(context.Background(), "ooni.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records == nil {
+		t.Fatal("expected non-nil result here")
+	}
+}
+
+func TestIntegrationLookupNS(t *testing.T) {
+	client := New(new(net.Resolver))
+	records, err := client.LookupNS(context.Background(), "ooni.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records == nil {
+		t.Fatal("expected non-nil result here")
+	}
+}
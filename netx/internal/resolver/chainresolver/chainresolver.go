@@ -5,6 +5,7 @@ import (
 	"context"
 	"net"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
 
@@ -66,3 +67,25 @@ func (c *Resolver) LookupNS(ctx context.Context, name string) ([]*net.NS, error)
 	}
 	return records, err
 }
+
+// LookupRaw sends a query of the given type for name and returns the
+// reply. We only use the resolvers that are capable of raw lookups.
+func (c *Resolver) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	reply, err := lookupRaw(ctx, c.primary, name, qtype)
+	if err != nil {
+		reply, err = lookupRaw(ctx, c.secondary, name, qtype)
+	}
+	return reply, err
+}
+
+func lookupRaw(
+	ctx context.Context, r modelx.DNSResolver, name string, qtype uint16,
+) (*dns.Msg, error) {
+	reso, okay := r.(modelx.DNSRawResolver)
+	if !okay {
+		return nil, modelx.ErrDNSRawNotSupported
+	}
+	return reso.LookupRaw(ctx, name, qtype)
+}
@@ -2,10 +2,13 @@ package chainresolver
 
 import (
 	"context"
+	"errors"
 	"net"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/internal/resolver/brokenresolver"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 func TestLookupAddr(t *testing.T) {
@@ -62,3 +65,37 @@ func TestLookupNS(t *testing.T) {
 		t.Fatal("expect non nil return value here")
 	}
 }
+
+type fakerawresolver struct {
+	*net.Resolver
+	reply *dns.Msg
+}
+
+func (r *fakerawresolver) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	return r.reply, nil
+}
+
+func TestUnitLookupRaw(t *testing.T) {
+	reply := new(dns.Msg)
+	client := New(brokenresolver.New(), &fakerawresolver{reply: reply})
+	out, err := client.LookupRaw(context.Background(), "ooni.io", dns.TypeSOA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != reply {
+		t.Fatal("not the reply we expected")
+	}
+}
+
+func TestUnitLookupRawNotSupported(t *testing.T) {
+	client := New(brokenresolver.New(), new(net.Resolver))
+	out, err := client.LookupRaw(context.Background(), "ooni.io", dns.TypeSOA)
+	if !errors.Is(err, modelx.ErrDNSRawNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if out != nil {
+		t.Fatal("expected a nil reply here")
+	}
+}
@@ -0,0 +1,721 @@
+package ooniresolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// rootAnchors contains the DS records of the root zone KSKs, as
+// published by IANA at <https://data.iana.org/root-anchors/>.
+var rootAnchors = []*dns.DS{
+	&dns.DS{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     20326,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D",
+	},
+	&dns.DS{
+		Hdr:        dns.RR_Header{Name: ".", Rrtype: dns.TypeDS, Class: dns.ClassINET},
+		KeyTag:     38696,
+		Algorithm:  dns.RSASHA256,
+		DigestType: dns.SHA256,
+		Digest:     "683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16",
+	},
+}
+
+var (
+	errDNSSECNoDS           = errors.New("ooniresolver: no DS records for zone")
+	errDNSSECNoKey          = errors.New("ooniresolver: no trusted DNSKEY for zone")
+	errDNSSECBadSignature   = errors.New("ooniresolver: cannot verify RRSIG")
+	errDNSSECNoSignature    = errors.New("ooniresolver: missing RRSIG")
+	errDNSSECInvalidSigner  = errors.New("ooniresolver: invalid RRSIG signer")
+	errDNSSECInvalidAnchors = errors.New("ooniresolver: DNSKEY does not match trust anchors")
+)
+
+// validator validates DNS replies using DNSSEC. It uses the resolver to
+// fetch the DNSKEY and DS records required to build the chain of trust
+// up to the root trust anchors. A validator caches the keys that it has
+// already verified, hence it should only be used for a single reply.
+type validator struct {
+	anchors []*dns.DS
+	keys    map[string][]*dns.DNSKEY
+	lookup  func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+	now     func() time.Time
+}
+
+func newValidator(c *Resolver) *validator {
+	return &validator{
+		anchors: rootAnchors,
+		keys:    make(map[string][]*dns.DNSKEY),
+		lookup: func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+			return c.roundTripWithRetryEx(ctx, name, qtype, true, nil)
+		},
+		now: time.Now,
+	}
+}
+
+func (v *validator) validate(ctx context.Context, reply *dns.Msg) *modelx.DNSSECValidation {
+	return &modelx.DNSSECValidation{
+		AuthenticatedData: reply.AuthenticatedData,
+		Status:            v.status(ctx, reply),
+	}
+}
+
+func (v *validator) status(ctx context.Context, reply *dns.Msg) string {
+	rrsets := newRRSetList(reply.Answer)
+	if len(rrsets.keys) <= 0 {
+		return v.negativeStatus(ctx, reply)
+	}
+	return v.positiveStatus(ctx, reply, rrsets)
+}
+
+// positiveStatus returns the status of a reply with answers. Because anyone
+// could replay signed records of other names, we only consider the records
+// reachable from the question following CNAMEs. Additionally, we require
+// signed NSEC or NSEC3 records proving that there is no closer match for
+// wildcard expanded records (RFC4035 Sect. 5.3.4 and RFC5155 Sect. 8.8) and
+// that the name at which the chain ends, if any, has no answer.
+func (v *validator) positiveStatus(ctx context.Context, reply *dns.Msg, rrsets *rrsetList) string {
+	if len(reply.Question) != 1 {
+		return modelx.DNSSECStatusBogus
+	}
+	question := reply.Question[0]
+	chain, dangling := answerChain(rrsets, question)
+	if len(chain.keys) <= 0 {
+		return modelx.DNSSECStatusBogus
+	}
+	status := v.rrsetsStatus(ctx, chain)
+	expanded := wildcardExpansions(chain)
+	if status != modelx.DNSSECStatusSecure || (dangling == "" && len(expanded) <= 0) {
+		return status
+	}
+	proofs := newRRSetList(reply.Ns)
+	if len(proofs.keys) <= 0 || v.rrsetsStatus(ctx, proofs) != modelx.DNSSECStatusSecure {
+		return modelx.DNSSECStatusBogus
+	}
+	for name, labels := range expanded {
+		if !deniesCloserMatch(proofs, name, labels) {
+			return modelx.DNSSECStatusBogus
+		}
+	}
+	if dangling != "" && !deniesExistence(proofs, reply.Rcode, dns.Question{
+		Name: dangling, Qtype: question.Qtype, Qclass: question.Qclass,
+	}) {
+		return modelx.DNSSECStatusBogus
+	}
+	return status
+}
+
+// negativeStatus returns the status of a reply without answers. Because
+// anyone could replay the signed SOA of a zone, such a reply is secure only
+// if the authority section also contains signed NSEC or NSEC3 records that
+// prove that the queried name or type does not exist.
+func (v *validator) negativeStatus(ctx context.Context, reply *dns.Msg) string {
+	if len(reply.Question) != 1 {
+		return modelx.DNSSECStatusBogus
+	}
+	question := reply.Question[0]
+	rrsets := newRRSetList(reply.Ns)
+	if len(rrsets.keys) <= 0 {
+		return v.unsignedStatus(ctx, question.Name)
+	}
+	status := v.rrsetsStatus(ctx, rrsets)
+	if status == modelx.DNSSECStatusSecure && !deniesExistence(rrsets, reply.Rcode, question) {
+		return modelx.DNSSECStatusBogus
+	}
+	return status
+}
+
+func (v *validator) rrsetsStatus(ctx context.Context, rrsets *rrsetList) string {
+	status := modelx.DNSSECStatusSecure
+	for _, key := range rrsets.keys {
+		sigs := rrsets.sigs[key]
+		if len(sigs) <= 0 {
+			if v.unsignedStatus(ctx, key.name) == modelx.DNSSECStatusBogus {
+				return modelx.DNSSECStatusBogus
+			}
+			status = modelx.DNSSECStatusInsecure
+			continue
+		}
+		if v.verify(ctx, sigs, rrsets.rrsets[key]) != nil {
+			return modelx.DNSSECStatusBogus
+		}
+	}
+	return status
+}
+
+// verify returns nil if at least one of sigs is a valid signature
+// for rrset made with a key belonging to a chain of trust.
+func (v *validator) verify(ctx context.Context, sigs []*dns.RRSIG, rrset []dns.RR) error {
+	err := errDNSSECNoSignature
+	for _, sig := range sigs {
+		if !dns.IsSubDomain(sig.SignerName, sig.Hdr.Name) {
+			err = errDNSSECInvalidSigner
+			continue
+		}
+		if !sig.ValidityPeriod(v.now()) {
+			err = errDNSSECBadSignature
+			continue
+		}
+		var keys []*dns.DNSKEY
+		keys, err = v.zoneKeys(ctx, sig.SignerName)
+		if err != nil {
+			continue
+		}
+		if err = verifyWithKeys(sig, keys, rrset); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func verifyWithKeys(sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if sig.Verify(key, rrset) == nil {
+			return nil
+		}
+	}
+	return errDNSSECBadSignature
+}
+
+// zoneKeys returns the DNSKEYs of zone after having verified that
+// the DNSKEY RRset is signed by a key matching the DS records of
+// the zone, which have been validated using the parent zone.
+func (v *validator) zoneKeys(ctx context.Context, zone string) ([]*dns.DNSKEY, error) {
+	zone = canonicalName(zone)
+	if keys, found := v.keys[zone]; found {
+		return keys, nil
+	}
+	reply, err := v.lookup(ctx, zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	rrsets := newRRSetList(reply.Answer)
+	rrkey := rrsetKey{name: zone, rrtype: dns.TypeDNSKEY}
+	var keys []*dns.DNSKEY
+	for _, rr := range rrsets.rrsets[rrkey] {
+		if key, ok := rr.(*dns.DNSKEY); ok && (key.Flags&dns.ZONE) != 0 {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) <= 0 {
+		return nil, errDNSSECNoKey
+	}
+	dsset, err := v.delegationSigners(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if !matchesDS(key, dsset) {
+			continue
+		}
+		for _, sig := range rrsets.sigs[rrkey] {
+			if !sig.ValidityPeriod(v.now()) {
+				continue
+			}
+			if verifyWithKeys(sig, []*dns.DNSKEY{key}, rrsets.rrsets[rrkey]) == nil {
+				v.keys[zone] = keys
+				return keys, nil
+			}
+		}
+	}
+	if zone == "." {
+		return nil, errDNSSECInvalidAnchors
+	}
+	return nil, errDNSSECNoKey
+}
+
+// delegationSigners returns the validated DS records of zone.
+func (v *validator) delegationSigners(ctx context.Context, zone string) ([]*dns.DS, error) {
+	if zone == "." {
+		return v.anchors, nil
+	}
+	reply, err := v.lookup(ctx, zone, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	rrsets := newRRSetList(reply.Answer)
+	rrkey := rrsetKey{name: zone, rrtype: dns.TypeDS}
+	var dsset []*dns.DS
+	for _, rr := range rrsets.rrsets[rrkey] {
+		if ds, ok := rr.(*dns.DS); ok {
+			dsset = append(dsset, ds)
+		}
+	}
+	if len(dsset) <= 0 {
+		return nil, errDNSSECNoDS
+	}
+	// The DS RRset must be signed by an ancestor zone. Enforcing
+	// this also guarantees that the recursion terminates.
+	var sigs []*dns.RRSIG
+	for _, sig := range rrsets.sigs[rrkey] {
+		if dns.CountLabel(sig.SignerName) < dns.CountLabel(zone) {
+			sigs = append(sigs, sig)
+		}
+	}
+	if err := v.verify(ctx, sigs, rrsets.rrsets[rrkey]); err != nil {
+		return nil, err
+	}
+	return dsset, nil
+}
+
+func matchesDS(key *dns.DNSKEY, dsset []*dns.DS) bool {
+	for _, ds := range dsset {
+		if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+			continue
+		}
+		if computed := key.ToDS(ds.DigestType); computed != nil &&
+			strings.EqualFold(computed.Digest, ds.Digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsignedStatus returns the status of unsigned records owned by name. They
+// are insecure if we can prove that there is an unsigned delegation between
+// the root and name, otherwise they are bogus. To this end we walk up the
+// tree looking for a name having a NS but not a DS record.
+func (v *validator) unsignedStatus(ctx context.Context, name string) string {
+	for name = canonicalName(name); name != "."; name = parentName(name) {
+		reply, err := v.lookup(ctx, name, dns.TypeDS)
+		if err != nil {
+			continue
+		}
+		for _, rr := range reply.Answer {
+			if _, ok := rr.(*dns.DS); ok {
+				// There is a secure delegation for this name, thus the
+				// records below it should have been signed.
+				return modelx.DNSSECStatusBogus
+			}
+		}
+		if v.deniesDS(ctx, reply.Ns, name) {
+			return modelx.DNSSECStatusInsecure
+		}
+	}
+	return modelx.DNSSECStatusBogus
+}
+
+// deniesDS returns true if the authority section contains a valid
+// NSEC or NSEC3 record proving that name is a delegation without
+// DS records. With NSEC3 we also accept an opt-out record covering
+// name, which means the delegation, if any, is insecure.
+func (v *validator) deniesDS(ctx context.Context, authority []dns.RR, name string) bool {
+	rrsets := newRRSetList(authority)
+	for _, key := range rrsets.keys {
+		if key.rrtype != dns.TypeNSEC && key.rrtype != dns.TypeNSEC3 {
+			continue
+		}
+		if v.verify(ctx, rrsets.sigs[key], rrsets.rrsets[key]) != nil {
+			continue
+		}
+		for _, rr := range rrsets.rrsets[key] {
+			switch record := rr.(type) {
+			case *dns.NSEC:
+				if canonicalName(record.Hdr.Name) == name &&
+					isUnsignedDelegation(record.TypeBitMap) {
+					return true
+				}
+			case *dns.NSEC3:
+				if record.Match(name) && isUnsignedDelegation(record.TypeBitMap) {
+					return true
+				}
+				if record.Cover(name) && (record.Flags&nsec3OptOut) != 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// nsec3OptOut is the NSEC3 opt-out flag. See RFC5155 Sect. 3.1.2.1.
+const nsec3OptOut = 1
+
+func isUnsignedDelegation(bitmap []uint16) bool {
+	var hasNS, hasDS, hasSOA bool
+	for _, rrtype := range bitmap {
+		hasNS = hasNS || rrtype == dns.TypeNS
+		hasDS = hasDS || rrtype == dns.TypeDS
+		hasSOA = hasSOA || rrtype == dns.TypeSOA
+	}
+	return hasNS && !hasDS && !hasSOA
+}
+
+// deniesExistence returns true if the verified NSEC or NSEC3 records in
+// rrsets prove that the question has no answer, i.e., that the name does
+// not exist, for NXDOMAIN replies, or that the name does not have records
+// of the queried type, for NODATA replies. See RFC4035 Sect. 5.4 and
+// RFC5155 Sect. 8. The rrsets are assumed to have been verified already.
+func deniesExistence(rrsets *rrsetList, rcode int, question dns.Question) bool {
+	nsecs, nsec3s := proofRecords(rrsets)
+	name := canonicalName(question.Name)
+	switch rcode {
+	case dns.RcodeNameError:
+		return nsecDeniesName(nsecs, name) || nsec3DeniesName(nsec3s, name)
+	case dns.RcodeSuccess:
+		return nsecDeniesType(nsecs, name, question.Qtype) ||
+			nsec3DeniesType(nsec3s, name, question.Qtype)
+	default:
+		return false
+	}
+}
+
+// deniesCloserMatch returns true if the verified NSEC or NSEC3 records in
+// rrsets prove that there is no closer match than the wildcard used to
+// synthesize the records owned by name, whose RRSIG has the given labels.
+func deniesCloserMatch(rrsets *rrsetList, name string, labels int) bool {
+	nsecs, nsec3s := proofRecords(rrsets)
+	return nsecCoversAny(nsecs, name) ||
+		nsec3CoversAny(nsec3s, ancestorName(name, labels+1))
+}
+
+func proofRecords(rrsets *rrsetList) (nsecs []*dns.NSEC, nsec3s []*dns.NSEC3) {
+	for _, key := range rrsets.keys {
+		for _, rr := range rrsets.rrsets[key] {
+			switch record := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, record)
+			case *dns.NSEC3:
+				if record.Hash == dns.SHA1 { // the only defined algorithm
+					nsec3s = append(nsec3s, record)
+				}
+			}
+		}
+	}
+	return
+}
+
+// nsecDeniesName returns true if an NSEC proves that name does not exist and
+// another one (or the same) proves that there is no wildcard that could
+// have been expanded to name in its closest encloser.
+func nsecDeniesName(nsecs []*dns.NSEC, name string) bool {
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) && nsecCoversAny(nsecs, wildcardName(nsecClosestEncloser(nsec, name))) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsecDeniesType returns true if an NSEC proves that name exists but it
+// does not have records of type qtype. We also accept proofs that name is
+// an empty non-terminal or that the wildcard that could have been expanded
+// to name does not have records of type qtype.
+func nsecDeniesType(nsecs []*dns.NSEC, name string, qtype uint16) bool {
+	for _, nsec := range nsecs {
+		if canonicalName(nsec.Hdr.Name) == name {
+			return deniesType(nsec.TypeBitMap, name, qtype)
+		}
+	}
+	for _, nsec := range nsecs {
+		if !nsecCovers(nsec, name) {
+			continue
+		}
+		if dns.IsSubDomain(name, canonicalName(nsec.NextDomain)) {
+			return true // empty non-terminal
+		}
+		wildcard := wildcardName(nsecClosestEncloser(nsec, name))
+		for _, other := range nsecs {
+			if canonicalName(other.Hdr.Name) == wildcard {
+				return deniesType(other.TypeBitMap, name, qtype)
+			}
+		}
+	}
+	return false
+}
+
+func nsecCoversAny(nsecs []*dns.NSEC, name string) bool {
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nsecCovers returns true if name is strictly between the owner and
+// the next name of nsec in the canonical DNS names order.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner, next := canonicalName(nsec.Hdr.Name), canonicalName(nsec.NextDomain)
+	if compareNames(owner, next) < 0 {
+		return compareNames(owner, name) < 0 && compareNames(name, next) < 0
+	}
+	// This is the last NSEC of the zone, whose next name is the apex.
+	return dns.IsSubDomain(next, name) && compareNames(owner, name) < 0
+}
+
+// nsecClosestEncloser returns the closest encloser of name, which is
+// the longest ancestor that name shares with the owner or the next name
+// of the NSEC proving that name does not exist.
+func nsecClosestEncloser(nsec *dns.NSEC, name string) string {
+	owner := commonAncestor(name, canonicalName(nsec.Hdr.Name))
+	next := commonAncestor(name, canonicalName(nsec.NextDomain))
+	if dns.CountLabel(next) > dns.CountLabel(owner) {
+		return next
+	}
+	return owner
+}
+
+// nsec3DeniesName implements RFC5155 Sect. 8.4: there must be a closest
+// encloser proof for name and an NSEC3 covering the wildcard at the
+// closest encloser.
+func nsec3DeniesName(nsec3s []*dns.NSEC3, name string) bool {
+	encloser, nextCloser, _ := nsec3ClosestEncloser(nsec3s, name)
+	return nextCloser != "" && nsec3CoversAny(nsec3s, wildcardName(encloser))
+}
+
+// nsec3DeniesType implements RFC5155 Sect. 8.5, 8.6 and 8.7.
+func nsec3DeniesType(nsec3s []*dns.NSEC3, name string, qtype uint16) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			return deniesType(nsec3.TypeBitMap, name, qtype)
+		}
+	}
+	encloser, nextCloser, optOut := nsec3ClosestEncloser(nsec3s, name)
+	if nextCloser == "" {
+		return false
+	}
+	if qtype == dns.TypeDS && optOut {
+		return true // insecure delegation
+	}
+	wildcard := wildcardName(encloser)
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(wildcard) {
+			return deniesType(nsec3.TypeBitMap, name, qtype)
+		}
+	}
+	return false
+}
+
+// nsec3ClosestEncloser implements the closest encloser proof described
+// in RFC5155 Sect. 8.3. It returns the closest encloser, the next closer
+// name, which is empty if there is no proof, and whether the NSEC3 covering
+// the next closer name has the opt-out flag set.
+func nsec3ClosestEncloser(nsec3s []*dns.NSEC3, name string) (string, string, bool) {
+	for nextCloser := name; nextCloser != "."; nextCloser = parentName(nextCloser) {
+		encloser := parentName(nextCloser)
+		var matched bool
+		for _, nsec3 := range nsec3s {
+			// An NSEC3 from the parent side of a delegation or having
+			// a DNAME cannot be used to prove a closest encloser.
+			matched = matched || (nsec3.Match(encloser) &&
+				!hasType(nsec3.TypeBitMap, dns.TypeDNAME) &&
+				(!hasType(nsec3.TypeBitMap, dns.TypeNS) ||
+					hasType(nsec3.TypeBitMap, dns.TypeSOA)))
+		}
+		if !matched {
+			continue
+		}
+		for _, nsec3 := range nsec3s {
+			if nsec3.Cover(nextCloser) {
+				return encloser, nextCloser, (nsec3.Flags & nsec3OptOut) != 0
+			}
+		}
+		return "", "", false
+	}
+	return "", "", false
+}
+
+func nsec3CoversAny(nsec3s []*dns.NSEC3, name string) bool {
+	for _, nsec3 := range nsec3s {
+		if nsec3.Cover(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniesType returns true if the bitmap of an NSEC or NSEC3 owned by
+// name proves that name does not have records of type qtype. The NSEC
+// or NSEC3 at a delegation point comes from the parent zone, thus it
+// can only be used to prove that there are no DS records.
+func deniesType(bitmap []uint16, name string, qtype uint16) bool {
+	if hasType(bitmap, qtype) || hasType(bitmap, dns.TypeCNAME) {
+		return false
+	}
+	if hasType(bitmap, dns.TypeSOA) {
+		return qtype != dns.TypeDS || name == "."
+	}
+	return !hasType(bitmap, dns.TypeNS) || qtype == dns.TypeDS
+}
+
+func hasType(bitmap []uint16, rrtype uint16) bool {
+	for _, entry := range bitmap {
+		if entry == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+func wildcardName(name string) string {
+	if name == "." {
+		return "*."
+	}
+	return "*." + name
+}
+
+// ancestorName returns the ancestor of name, or name itself, having the
+// given number of labels. The name must have at least as many labels.
+func ancestorName(name string, count int) string {
+	labels := dns.Split(name)
+	if count <= 0 || count > len(labels) {
+		return "."
+	}
+	return name[labels[len(labels)-count]:]
+}
+
+// commonAncestor returns the longest name that is an ancestor of, or
+// equal to, both a and b, which must be canonical names.
+func commonAncestor(a, b string) string {
+	count := dns.CompareDomainName(a, b)
+	if count <= 0 {
+		return "."
+	}
+	labels := dns.Split(a)
+	return a[labels[len(labels)-count]:]
+}
+
+// compareNames compares the canonical names a and b using the canonical
+// DNS names order defined by RFC4034 Sect. 6.1.
+func compareNames(a, b string) int {
+	alabels, blabels := wireLabels(a), wireLabels(b)
+	for i, j := len(alabels)-1, len(blabels)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if r := bytes.Compare(alabels[i], blabels[j]); r != 0 {
+			return r
+		}
+	}
+	return len(alabels) - len(blabels)
+}
+
+// wireLabels returns the labels of name as octets, i.e., with escape
+// sequences such as "\001" replaced by the corresponding octet.
+func wireLabels(name string) (labels [][]byte) {
+	buf := make([]byte, 256)
+	off, err := dns.PackDomainName(name, buf, 0, nil, false)
+	if err != nil {
+		return
+	}
+	for buf = buf[:off]; len(buf) > 0 && int(buf[0]) < len(buf); {
+		labels = append(labels, buf[1:1+int(buf[0])])
+		buf = buf[1+int(buf[0]):]
+	}
+	return
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(dns.Fqdn(name))
+}
+
+func parentName(name string) string {
+	if off, end := dns.NextLabel(name, 0); !end {
+		return name[off:]
+	}
+	return "."
+}
+
+// maxCNAMEChain is the maximum number of CNAMEs we follow.
+const maxCNAMEChain = 16
+
+// answerChain returns the RRsets that answer question, i.e., the RRset of
+// the queried type owned by the queried name or by a name reached from it
+// following CNAMEs, along with such CNAMEs. It also returns the name at which
+// the chain ends, if such name has no records of the queried type.
+func answerChain(rrsets *rrsetList, question dns.Question) (*rrsetList, string) {
+	chain := newRRSetList(nil)
+	name := canonicalName(question.Name)
+	for i := 0; i < maxCNAMEChain; i++ {
+		key := rrsetKey{name: name, rrtype: question.Qtype}
+		if _, found := rrsets.rrsets[key]; found {
+			chain.add(rrsets, key)
+			return chain, ""
+		}
+		key = rrsetKey{name: name, rrtype: dns.TypeCNAME}
+		records := rrsets.rrsets[key]
+		if len(records) != 1 {
+			break
+		}
+		cname, ok := records[0].(*dns.CNAME)
+		if !ok {
+			break
+		}
+		chain.add(rrsets, key)
+		name = canonicalName(cname.Target)
+	}
+	return chain, name
+}
+
+// wildcardExpansions returns the owner names of the RRsets that have been
+// synthesized from a wildcard, i.e., whose RRSIGs have fewer labels than the
+// owner name (RFC4034 Sect. 3.1.3), mapped to the number of such labels.
+func wildcardExpansions(rrsets *rrsetList) map[string]int {
+	expanded := make(map[string]int)
+	for _, key := range rrsets.keys {
+		count := dns.CountLabel(key.name)
+		if strings.HasPrefix(key.name, "*.") {
+			count--
+		}
+		for _, sig := range rrsets.sigs[key] {
+			labels, found := expanded[key.name]
+			if int(sig.Labels) < count && (!found || int(sig.Labels) < labels) {
+				expanded[key.name] = int(sig.Labels)
+			}
+		}
+	}
+	return expanded
+}
+
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// rrsetList groups records into RRsets and their signatures.
+type rrsetList struct {
+	keys   []rrsetKey
+	rrsets map[rrsetKey][]dns.RR
+	sigs   map[rrsetKey][]*dns.RRSIG
+}
+
+func newRRSetList(records []dns.RR) *rrsetList {
+	l := &rrsetList{
+		rrsets: make(map[rrsetKey][]dns.RR),
+		sigs:   make(map[rrsetKey][]*dns.RRSIG),
+	}
+	for _, rr := range records {
+		name := canonicalName(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := rrsetKey{name: name, rrtype: sig.TypeCovered}
+			l.sigs[key] = append(l.sigs[key], sig)
+			continue
+		}
+		key := rrsetKey{name: name, rrtype: rr.Header().Rrtype}
+		if _, found := l.rrsets[key]; !found {
+			l.keys = append(l.keys, key)
+		}
+		l.rrsets[key] = append(l.rrsets[key], rr)
+	}
+	return l
+}
+
+// add adds the RRset of other having the given key, along with its
+// signatures, unless the list already contains it.
+func (l *rrsetList) add(other *rrsetList, key rrsetKey) {
+	if _, found := l.rrsets[key]; found {
+		return
+	}
+	l.keys = append(l.keys, key)
+	l.rrsets[key] = other.rrsets[key]
+	l.sigs[key] = other.sigs[key]
+}
@@ -0,0 +1,511 @@
+package ooniresolver
+
+import (
+	"context"
+	"crypto"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+type testzone struct {
+	key    *dns.DNSKEY
+	name   string
+	signer crypto.Signer
+}
+
+func newtestzone(t *testing.T, name string) *testzone {
+	key := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600,
+		},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testzone{key: key, name: name, signer: priv.(crypto.Signer)}
+}
+
+func (z *testzone) sign(t *testing.T, rrset ...dns.RR) []dns.RR {
+	sig := &dns.RRSIG{
+		Algorithm:  z.key.Algorithm,
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.name,
+	}
+	if err := sig.Sign(z.signer, rrset); err != nil {
+		t.Fatal(err)
+	}
+	return append(rrset, sig)
+}
+
+func newtestA(name string) *dns.A {
+	return &dns.A{
+		Hdr: dns.RR_Header{
+			Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300,
+		},
+		A: net.IPv4(8, 8, 8, 8),
+	}
+}
+
+func newtestCNAME(name, target string) *dns.CNAME {
+	return &dns.CNAME{
+		Hdr: dns.RR_Header{
+			Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300,
+		},
+		Target: target,
+	}
+}
+
+func newtestReply(name string, qtype uint16, answer ...[]dns.RR) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetQuestion(name, qtype)
+	reply.Response = true
+	for _, rrset := range answer {
+		reply.Answer = append(reply.Answer, rrset...)
+	}
+	return reply
+}
+
+// newtestWildcardA returns a signed A record synthesized for name from the
+// wildcard of the example. zone, as a server would include it in a reply.
+func newtestWildcardA(t *testing.T, example *testzone, name string) []dns.RR {
+	rrset := example.sign(t, newtestA("*.example."))
+	for _, rr := range rrset {
+		rr.Header().Name = name
+	}
+	return rrset
+}
+
+// newtestvalidator returns a validator for a universe where the root
+// zone and example. are signed, while insecure. is not signed.
+func newtestvalidator(t *testing.T) (*validator, *testzone) {
+	root := newtestzone(t, ".")
+	example := newtestzone(t, "example.")
+	ds := example.key.ToDS(dns.SHA256)
+	ds.Hdr = dns.RR_Header{
+		Name: "example.", Rrtype: dns.TypeDS, Class: dns.ClassINET, Ttl: 3600,
+	}
+	nsec := &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name: "insecure.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600,
+		},
+		NextDomain: "zzz.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}
+	replies := map[string]*dns.Msg{
+		".|DNSKEY":        &dns.Msg{Answer: root.sign(t, root.key)},
+		"example.|DNSKEY": &dns.Msg{Answer: example.sign(t, example.key)},
+		"example.|DS":     &dns.Msg{Answer: root.sign(t, ds)},
+		"insecure.|DS":    &dns.Msg{Ns: root.sign(t, nsec)},
+	}
+	anchor := root.key.ToDS(dns.SHA256)
+	return &validator{
+		anchors: []*dns.DS{anchor},
+		keys:    make(map[string][]*dns.DNSKEY),
+		lookup: func(ctx context.Context, name string, qtype uint16) (*dns.Msg, error) {
+			if reply, found := replies[name+"|"+dns.TypeToString[qtype]]; found {
+				return reply, nil
+			}
+			return new(dns.Msg), nil
+		},
+		now: time.Now,
+	}, example
+}
+
+func TestUnitValidatorSecure(t *testing.T) {
+	v, example := newtestvalidator(t)
+	reply := newtestReply("www.example.", dns.TypeA, example.sign(t, newtestA("www.example.")))
+	reply.AuthenticatedData = true
+	result := v.validate(context.Background(), reply)
+	if result.Status != modelx.DNSSECStatusSecure {
+		t.Fatal(result.Status)
+	}
+	if result.AuthenticatedData != true {
+		t.Fatal("AD bit not reported")
+	}
+}
+
+func TestUnitValidatorTampered(t *testing.T) {
+	v, example := newtestvalidator(t)
+	answer := example.sign(t, newtestA("www.example."))
+	answer[0].(*dns.A).A = net.IPv4(10, 0, 0, 1)
+	result := v.validate(context.Background(), newtestReply("www.example.", dns.TypeA, answer))
+	if result.Status != modelx.DNSSECStatusBogus {
+		t.Fatal(result.Status)
+	}
+}
+
+func TestUnitValidatorStripped(t *testing.T) {
+	v, _ := newtestvalidator(t)
+	reply := newtestReply("www.example.", dns.TypeA, []dns.RR{newtestA("www.example.")})
+	result := v.validate(context.Background(), reply)
+	if result.Status != modelx.DNSSECStatusBogus {
+		t.Fatal(result.Status)
+	}
+}
+
+func TestUnitValidatorInsecure(t *testing.T) {
+	v, _ := newtestvalidator(t)
+	reply := newtestReply("www.insecure.", dns.TypeA, []dns.RR{newtestA("www.insecure.")})
+	result := v.validate(context.Background(), reply)
+	if result.Status != modelx.DNSSECStatusInsecure {
+		t.Fatal(result.Status)
+	}
+}
+
+func TestUnitValidatorWrongAnchors(t *testing.T) {
+	v, example := newtestvalidator(t)
+	v.anchors = rootAnchors
+	reply := newtestReply("www.example.", dns.TypeA, example.sign(t, newtestA("www.example.")))
+	result := v.validate(context.Background(), reply)
+	if result.Status != modelx.DNSSECStatusBogus {
+		t.Fatal(result.Status)
+	}
+}
+
+func TestUnitValidatorExpiredSignature(t *testing.T) {
+	v, example := newtestvalidator(t)
+	v.now = func() time.Time {
+		return time.Now().Add(24 * time.Hour)
+	}
+	reply := newtestReply("www.example.", dns.TypeA, example.sign(t, newtestA("www.example.")))
+	result := v.validate(context.Background(), reply)
+	if result.Status != modelx.DNSSECStatusBogus {
+		t.Fatal(result.Status)
+	}
+}
+
+func TestUnitValidatorPositiveReplies(t *testing.T) {
+	v, example := newtestvalidator(t)
+	wildcardNSEC := example.sign(t, newtestNSEC("example.", "www.example.", dns.TypeSOA))
+	var cases = []struct {
+		name     string
+		reply    *dns.Msg
+		expected string
+	}{{
+		name: "replayed RRset of another name",
+		reply: newtestReply("www.example.", dns.TypeA,
+			example.sign(t, newtestA("other.example."))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "RRset of another type",
+		reply: newtestReply("www.example.", dns.TypeAAAA,
+			example.sign(t, newtestA("www.example."))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "CNAME chain",
+		reply: newtestReply("www.example.", dns.TypeA,
+			example.sign(t, newtestCNAME("www.example.", "other.example.")),
+			example.sign(t, newtestA("other.example."))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "CNAME chain with a tampered unrelated RRset",
+		reply: newtestReply("www.example.", dns.TypeA,
+			example.sign(t, newtestCNAME("www.example.", "other.example.")),
+			example.sign(t, newtestA("other.example.")),
+			[]dns.RR{newtestA("unrelated.example.")}),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "CNAME to a name without proof that it has no answer",
+		reply: newtestReply("www.example.", dns.TypeA,
+			example.sign(t, newtestCNAME("www.example.", "other.example."))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "wildcard answer without proof",
+		reply: newtestReply("foo.example.", dns.TypeA,
+			newtestWildcardA(t, example, "foo.example.")),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "wildcard answer with NSEC proof",
+		reply: func() *dns.Msg {
+			reply := newtestReply("foo.example.", dns.TypeA,
+				newtestWildcardA(t, example, "foo.example."))
+			reply.Ns = wildcardNSEC
+			return reply
+		}(),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "wildcard answer for an existing name",
+		reply: func() *dns.Msg {
+			reply := newtestReply("www.example.", dns.TypeA,
+				newtestWildcardA(t, example, "www.example."))
+			reply.Ns = wildcardNSEC
+			return reply
+		}(),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "wildcard answer with NSEC3 proof",
+		reply: func() *dns.Msg {
+			reply := newtestReply("foo.example.", dns.TypeA,
+				newtestWildcardA(t, example, "foo.example."))
+			reply.Ns = example.sign(t, newtestNSEC3(0))
+			return reply
+		}(),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "wildcard answer with unsigned proof",
+		reply: func() *dns.Msg {
+			reply := newtestReply("foo.example.", dns.TypeA,
+				newtestWildcardA(t, example, "foo.example."))
+			reply.Ns = []dns.RR{newtestNSEC("example.", "www.example.", dns.TypeSOA)}
+			return reply
+		}(),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name:     "answer without question",
+		reply:    &dns.Msg{Answer: example.sign(t, newtestA("www.example."))},
+		expected: modelx.DNSSECStatusBogus,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := v.validate(context.Background(), tc.reply)
+			if result.Status != tc.expected {
+				t.Fatal(result.Status)
+			}
+		})
+	}
+}
+
+func TestUnitParentName(t *testing.T) {
+	if parentName("www.example.") != "example." {
+		t.Fatal("unexpected parent of www.example.")
+	}
+	if parentName("example.") != "." {
+		t.Fatal("unexpected parent of example.")
+	}
+}
+
+type emptytransport struct {
+	queries []*dns.Msg
+}
+
+func (t *emptytransport) RoundTrip(
+	ctx context.Context, query []byte,
+) (reply []byte, err error) {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+	t.queries = append(t.queries, req)
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	return msg.Pack()
+}
+
+func (t *emptytransport) RequiresPadding() bool {
+	return false
+}
+
+func TestUnitLookupRawWithDNSSEC(t *testing.T) {
+	txp := new(emptytransport)
+	client := New(txp)
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning:        time.Now(),
+		DNSSECValidation: true,
+		Handler:          saver,
+	})
+	if _, err := client.LookupRaw(ctx, "www.example.", dns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range txp.queries {
+		opt := query.IsEdns0()
+		if opt == nil || !opt.Do() || !query.CheckingDisabled {
+			t.Fatal("query without DO or CD bit")
+		}
+	}
+	var validations []*modelx.DNSSECValidation
+	for _, ev := range saver.Read() {
+		if ev.DNSReply != nil && ev.DNSReply.DNSSEC != nil {
+			validations = append(validations, ev.DNSReply.DNSSEC)
+		}
+	}
+	// The DS queries sent by the validator are not validated
+	if len(validations) != 1 {
+		t.Fatal("unexpected number of validations")
+	}
+	// Unsigned reply and no proof that the zone is unsigned
+	if validations[0].Status != modelx.DNSSECStatusBogus {
+		t.Fatal(validations[0].Status)
+	}
+}
+
+func newtestSOA() *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 300,
+		},
+		Ns:     "ns.example.",
+		Mbox:   "hostmaster.example.",
+		Serial: 1,
+	}
+}
+
+func newtestNSEC(name, next string, types ...uint16) *dns.NSEC {
+	return &dns.NSEC{
+		Hdr: dns.RR_Header{
+			Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300,
+		},
+		NextDomain: next,
+		TypeBitMap: types,
+	}
+}
+
+// newtestNSEC3 returns the only NSEC3 of a zone containing just the
+// apex, which hence matches the apex and covers any other name.
+func newtestNSEC3(flags uint8) *dns.NSEC3 {
+	hash := dns.HashName("example.", dns.SHA1, 1, "")
+	return &dns.NSEC3{
+		Hdr: dns.RR_Header{
+			Name: hash + ".example.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300,
+		},
+		Hash:       dns.SHA1,
+		Flags:      flags,
+		Iterations: 1,
+		NextDomain: hash,
+		HashLength: 20,
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeDNSKEY},
+	}
+}
+
+func newtestNegativeReply(name string, qtype uint16, rcode int, authority ...[]dns.RR) *dns.Msg {
+	reply := new(dns.Msg)
+	reply.SetQuestion(name, qtype)
+	reply.Response = true
+	reply.Rcode = rcode
+	for _, rrset := range authority {
+		reply.Ns = append(reply.Ns, rrset...)
+	}
+	return reply
+}
+
+func TestUnitValidatorNegativeReplies(t *testing.T) {
+	v, example := newtestvalidator(t)
+	soa := example.sign(t, newtestSOA())
+	var cases = []struct {
+		name     string
+		reply    *dns.Msg
+		expected string
+	}{{
+		name: "NXDOMAIN with replayed SOA",
+		reply: newtestNegativeReply(
+			"www.example.", dns.TypeA, dns.RcodeNameError, soa),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NODATA with replayed SOA",
+		reply: newtestNegativeReply(
+			"www.example.", dns.TypeA, dns.RcodeSuccess, soa),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NXDOMAIN with NSEC",
+		reply: newtestNegativeReply(
+			"foo.example.", dns.TypeA, dns.RcodeNameError, soa,
+			example.sign(t, newtestNSEC("example.", "www.example.", dns.TypeSOA))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "NXDOMAIN with NSEC not covering the name",
+		reply: newtestNegativeReply(
+			"zzz.example.", dns.TypeA, dns.RcodeNameError, soa,
+			example.sign(t, newtestNSEC("example.", "www.example.", dns.TypeSOA))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NXDOMAIN with NSEC not covering the wildcard",
+		reply: newtestNegativeReply(
+			"foo.example.", dns.TypeA, dns.RcodeNameError, soa,
+			example.sign(t, newtestNSEC("bar.example.", "www.example.", dns.TypeA))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NODATA with NSEC",
+		reply: newtestNegativeReply(
+			"www.example.", dns.TypeAAAA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC("www.example.", "example.", dns.TypeA))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "NODATA with NSEC having the type",
+		reply: newtestNegativeReply(
+			"www.example.", dns.TypeA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC("www.example.", "example.", dns.TypeA))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NODATA with NSEC from the parent side of a delegation",
+		reply: newtestNegativeReply(
+			"sub.example.", dns.TypeA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC("sub.example.", "www.example.", dns.TypeNS))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NODATA for an empty non-terminal with NSEC",
+		reply: newtestNegativeReply(
+			"sub.example.", dns.TypeA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC("example.", "www.sub.example.", dns.TypeSOA))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "NXDOMAIN with NSEC3",
+		reply: newtestNegativeReply(
+			"www.example.", dns.TypeA, dns.RcodeNameError, soa,
+			example.sign(t, newtestNSEC3(0))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "NODATA with NSEC3",
+		reply: newtestNegativeReply(
+			"example.", dns.TypeAAAA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC3(0))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name: "NODATA with NSEC3 having the type",
+		reply: newtestNegativeReply(
+			"example.", dns.TypeSOA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC3(0))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NODATA with NSEC3 for a nonexisting name",
+		reply: newtestNegativeReply(
+			"www.example.", dns.TypeA, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC3(0))),
+		expected: modelx.DNSSECStatusBogus,
+	}, {
+		name: "NODATA for DS with opt-out NSEC3",
+		reply: newtestNegativeReply(
+			"sub.example.", dns.TypeDS, dns.RcodeSuccess, soa,
+			example.sign(t, newtestNSEC3(nsec3OptOut))),
+		expected: modelx.DNSSECStatusSecure,
+	}, {
+		name:     "NXDOMAIN without question",
+		reply:    &dns.Msg{Ns: example.sign(t, newtestNSEC3(0))},
+		expected: modelx.DNSSECStatusBogus,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := v.validate(context.Background(), tc.reply)
+			if result.Status != tc.expected {
+				t.Fatal(result.Status)
+			}
+		})
+	}
+}
+
+func TestUnitCompareNames(t *testing.T) {
+	// See RFC4034 Sect. 6.1
+	names := []string{
+		"example.", "a.example.", "yljkjljk.a.example.", "z.a.example.",
+		"zabc.a.example.", "z.example.", "\\001.z.example.", "*.z.example.",
+		"\\200.z.example.",
+	}
+	for i := 0; i < len(names)-1; i++ {
+		if compareNames(names[i], names[i+1]) >= 0 {
+			t.Fatal(names[i], "should sort before", names[i+1])
+		}
+	}
+	if compareNames("example.", "example.") != 0 {
+		t.Fatal("equal names should compare equal")
+	}
+}
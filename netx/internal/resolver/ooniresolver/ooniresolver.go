@@ -54,23 +54,32 @@ func (c *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, e
 	var reply *dns.Msg
 	reply, errA := c.roundTripWithRetry(ctx, hostname, dns.TypeA)
 	if errA == nil {
-		for _, answer := range reply.Answer {
-			if rra, ok := answer.(*dns.A); ok {
-				ip := rra.A
-				addrs = append(addrs, ip.String())
-			}
-		}
+		addrs = append(addrs, chainAddresses(reply, hostname, dns.TypeA)...)
 	}
 	reply, errAAAA := c.roundTripWithRetry(ctx, hostname, dns.TypeAAAA)
 	if errAAAA == nil {
-		for _, answer := range reply.Answer {
-			if rra, ok := answer.(*dns.AAAA); ok {
-				ip := rra.AAAA
-				addrs = append(addrs, ip.String())
+		addrs = append(addrs, chainAddresses(reply, hostname, dns.TypeAAAA)...)
+	}
+	return lookupHostResult(addrs, errA, errAAAA)
+}
+
+// chainAddresses returns the addresses of hostname contained in the reply,
+// ignoring records that we cannot reach from hostname following CNAMEs.
+func chainAddresses(reply *dns.Msg, hostname string, qtype uint16) (addrs []string) {
+	chain, _ := answerChain(newRRSetList(reply.Answer), dns.Question{
+		Name: hostname, Qtype: qtype, Qclass: dns.ClassINET,
+	})
+	for _, key := range chain.keys {
+		for _, answer := range chain.rrsets[key] {
+			switch rr := answer.(type) {
+			case *dns.A:
+				addrs = append(addrs, rr.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, rr.AAAA.String())
 			}
 		}
 	}
-	return lookupHostResult(addrs, errA, errAAAA)
+	return
 }
 
 func lookupHostResult(addrs []string, errA, errAAAA error) ([]string, error) {
@@ -98,6 +107,14 @@ func (c *Resolver) LookupNS(ctx context.Context, name string) (ns []*net.NS, err
 	return
 }
 
+// LookupRaw sends a query of the given type for name and returns
+// the reply, or an error if the server did not return success.
+func (c *Resolver) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	return c.roundTripWithRetry(ctx, name, qtype)
+}
+
 const (
 	// desiredBlockSize is the size that the padded query should be multiple of
 	desiredBlockSize = 128
@@ -109,14 +126,23 @@ const (
 	dnssecEnabled = true
 )
 
-func (c *Resolver) newQueryWithQuestion(q dns.Question, needspadding bool) (query *dns.Msg) {
+func (c *Resolver) newQueryWithQuestion(
+	q dns.Question, needspadding, dnssec bool,
+) (query *dns.Msg) {
 	query = new(dns.Msg)
 	query.Id = dns.Id()
 	query.RecursionDesired = true
 	query.Question = make([]dns.Question, 1)
 	query.Question[0] = q
-	if needspadding {
+	if needspadding || dnssec {
 		query.SetEdns0(maxResponseSize, dnssecEnabled)
+	}
+	if dnssec {
+		// We validate replies ourselves, therefore we ask the upstream
+		// resolver to also give us data that fails validation.
+		query.CheckingDisabled = true
+	}
+	if needspadding {
 		// Clients SHOULD pad queries to the closest multiple of
 		// 128 octets RFC8467#section-4.1. We inflate the query
 		// length by the size of the option (i.e. 4 octets). The
@@ -133,6 +159,20 @@ func (c *Resolver) newQueryWithQuestion(q dns.Question, needspadding bool) (quer
 
 func (c *Resolver) roundTripWithRetry(
 	ctx context.Context, hostname string, qtype uint16,
+) (*dns.Msg, error) {
+	var validate func(reply *dns.Msg) *modelx.DNSSECValidation
+	dnssec := modelx.ContextMeasurementRootOrDefault(ctx).DNSSECValidation
+	if dnssec {
+		validate = func(reply *dns.Msg) *modelx.DNSSECValidation {
+			return newValidator(c).validate(ctx, reply)
+		}
+	}
+	return c.roundTripWithRetryEx(ctx, hostname, qtype, dnssec, validate)
+}
+
+func (c *Resolver) roundTripWithRetryEx(
+	ctx context.Context, hostname string, qtype uint16, dnssec bool,
+	validate func(reply *dns.Msg) *modelx.DNSSECValidation,
 ) (*dns.Msg, error) {
 	var errorslist []error
 	for i := 0; i < 3; i++ {
@@ -140,7 +180,7 @@ func (c *Resolver) roundTripWithRetry(
 			Name:   dns.Fqdn(hostname),
 			Qtype:  qtype,
 			Qclass: dns.ClassINET,
-		}, c.Transport().RequiresPadding()))
+		}, c.Transport().RequiresPadding(), dnssec), validate)
 		if err == nil {
 			return reply, nil
 		}
@@ -162,7 +202,10 @@ func (c *Resolver) roundTripWithRetry(
 	return nil, errorslist[0]
 }
 
-func (c *Resolver) roundTrip(ctx context.Context, query *dns.Msg) (reply *dns.Msg, err error) {
+func (c *Resolver) roundTrip(
+	ctx context.Context, query *dns.Msg,
+	validate func(reply *dns.Msg) *modelx.DNSSECValidation,
+) (reply *dns.Msg, err error) {
 	return c.mockableRoundTrip(
 		ctx, query, func(msg *dns.Msg) ([]byte, error) {
 			return msg.Pack()
@@ -175,6 +218,7 @@ func (c *Resolver) roundTrip(ctx context.Context, query *dns.Msg) (reply *dns.Ms
 		func(msg *dns.Msg, data []byte) (err error) {
 			return msg.Unpack(data)
 		},
+		validate,
 	)
 }
 
@@ -184,6 +228,7 @@ func (c *Resolver) mockableRoundTrip(
 	pack func(msg *dns.Msg) ([]byte, error),
 	roundTrip func(t modelx.DNSRoundTripper, query []byte) (reply []byte, err error),
 	unpack func(msg *dns.Msg, data []byte) (err error),
+	validate func(reply *dns.Msg) *modelx.DNSSECValidation,
 ) (reply *dns.Msg, err error) {
 	var (
 		querydata []byte
@@ -211,10 +256,17 @@ func (c *Resolver) mockableRoundTrip(
 	if err != nil {
 		return
 	}
+	// Note that validating may send more queries, whose events will
+	// be emitted before the reply event of the current query.
+	var validation *modelx.DNSSECValidation
+	if validate != nil {
+		validation = validate(reply)
+	}
 	root.Handler.OnMeasurement(modelx.Measurement{
 		DNSReply: &modelx.DNSReplyEvent{
 			Data:                   replydata,
 			DialID:                 dialid.ContextDialID(ctx),
+			DNSSEC:                 validation,
 			DurationSinceBeginning: time.Now().Sub(root.Beginning),
 			Msg:                    reply,
 		},
@@ -224,12 +276,15 @@ func (c *Resolver) mockableRoundTrip(
 }
 
 func mapError(rcode int) error {
-	// TODO(bassosimone): map more errors to net.DNSError names
 	switch rcode {
 	case dns.RcodeSuccess:
 		return nil
 	case dns.RcodeNameError:
 		return errors.New("ooniresolver: no such host")
+	case dns.RcodeServerFailure:
+		return modelx.ErrDNSServfail
+	case dns.RcodeRefused:
+		return modelx.ErrDNSRefused
 	default:
 		return errors.New("ooniresolver: query failed")
 	}
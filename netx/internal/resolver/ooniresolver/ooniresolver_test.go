@@ -6,8 +6,10 @@ import (
 	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/internal/resolver/dnstransport/dnsovertcp"
 	"github.com/ooni/probe-engine/netx/internal/resolver/dnstransport/dnsoverudp"
 	"github.com/ooni/probe-engine/netx/modelx"
@@ -82,6 +84,73 @@ func (t *faketransport) RequiresPadding() bool {
 	return true
 }
 
+type txttransport struct{}
+
+func (t *txttransport) RoundTrip(
+	ctx context.Context, query []byte,
+) (reply []byte, err error) {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	msg.Answer = append(msg.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   req.Question[0].Name,
+			Rrtype: req.Question[0].Qtype,
+			Class:  dns.ClassINET,
+			Ttl:    300,
+		},
+		Txt: []string{"v=spf1 -all"},
+	})
+	return msg.Pack()
+}
+
+func (t *txttransport) RequiresPadding() bool {
+	return false
+}
+
+func TestUnitLookupRaw(t *testing.T) {
+	client := New(&txttransport{})
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	reply, err := client.LookupRaw(ctx, "ooni.io", dns.TypeTXT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) != 1 {
+		t.Fatal("unexpected number of answers")
+	}
+	txt, ok := reply.Answer[0].(*dns.TXT)
+	if !ok || txt.Txt[0] != "v=spf1 -all" {
+		t.Fatal("not the answer we expected")
+	}
+	var query, replyev bool
+	for _, ev := range saver.Read() {
+		query = query || (ev.DNSQuery != nil &&
+			ev.DNSQuery.Msg.Question[0].Qtype == dns.TypeTXT)
+		replyev = replyev || ev.DNSReply != nil
+	}
+	if !query || !replyev {
+		t.Fatal("missing DNS query or reply events")
+	}
+}
+
+func TestUnitLookupRawFailure(t *testing.T) {
+	client := New(&faketransport{})
+	reply, err := client.LookupRaw(context.Background(), "ooni.io", dns.TypeCAA)
+	if err == nil || err.Error() != "mocked error" {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected a nil reply here")
+	}
+}
+
 func TestLookupHostWithNonTimeoutError(t *testing.T) {
 	client := New(&faketransport{})
 	addrs, err := client.LookupHost(context.Background(), "www.google.com")
@@ -112,6 +181,56 @@ func TestLookupHost(t *testing.T) {
 	}
 }
 
+type chaintransport struct{}
+
+func (t *chaintransport) RoundTrip(
+	ctx context.Context, query []byte,
+) (reply []byte, err error) {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+	msg := new(dns.Msg)
+	msg.SetReply(req)
+	if req.Question[0].Qtype == dns.TypeA {
+		msg.Answer = append(msg.Answer, &dns.CNAME{
+			Hdr: dns.RR_Header{
+				Name: req.Question[0].Name, Rrtype: dns.TypeCNAME,
+				Class: dns.ClassINET, Ttl: 300,
+			},
+			Target: "target.example.",
+		}, &dns.A{
+			Hdr: dns.RR_Header{
+				Name: "target.example.", Rrtype: dns.TypeA,
+				Class: dns.ClassINET, Ttl: 300,
+			},
+			A: net.IPv4(10, 0, 0, 1),
+		}, &dns.A{
+			Hdr: dns.RR_Header{
+				Name: "unrelated.example.", Rrtype: dns.TypeA,
+				Class: dns.ClassINET, Ttl: 300,
+			},
+			A: net.IPv4(10, 0, 0, 2),
+		})
+	}
+	return msg.Pack()
+}
+
+func (t *chaintransport) RequiresPadding() bool {
+	return false
+}
+
+func TestUnitLookupHostIgnoresUnrelatedRecords(t *testing.T) {
+	client := New(&chaintransport{})
+	addrs, err := client.LookupHost(context.Background(), "www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+		t.Fatal("unexpected addresses", addrs)
+	}
+}
+
 func TestLookupNonexistent(t *testing.T) {
 	client := New(newtransport())
 	addrs, err := client.LookupHost(context.Background(), "nonexistent.ooni.io")
@@ -161,6 +280,7 @@ func TestRoundTripExPackFailure(t *testing.T) {
 		func(msg *dns.Msg, data []byte) (err error) {
 			return nil
 		},
+		nil,
 	)
 	if err == nil {
 		t.Fatal("expeced an error here")
@@ -180,6 +300,7 @@ func TestRoundTripExRoundTripFailure(t *testing.T) {
 		func(msg *dns.Msg, data []byte) (err error) {
 			return nil
 		},
+		nil,
 	)
 	if err == nil {
 		t.Fatal("expeced an error here")
@@ -199,6 +320,7 @@ func TestRoundTripExUnpackFailure(t *testing.T) {
 		func(msg *dns.Msg, data []byte) (err error) {
 			return errors.New("mocked error")
 		},
+		nil,
 	)
 	if err == nil {
 		t.Fatal("expeced an error here")
@@ -234,6 +356,12 @@ func TestUnitMapError(t *testing.T) {
 	) {
 		t.Fatal("unexpected return value")
 	}
+	if err := mapError(dns.RcodeServerFailure); err != modelx.ErrDNSServfail {
+		t.Fatal("unexpected return value")
+	}
+	if err := mapError(dns.RcodeRefused); err != modelx.ErrDNSRefused {
+		t.Fatal("unexpected return value")
+	}
 	if err := mapError(dns.RcodeBadName); !strings.HasSuffix(
 		err.Error(), "query failed",
 	) {
@@ -255,7 +383,7 @@ func TestUnitPadding(t *testing.T) {
 			Name:   dns.Fqdn(strings.Repeat("x.", domainlen)),
 			Qtype:  dns.TypeA,
 			Qclass: dns.ClassINET,
-		}, padding)
+		}, padding, false)
 		data, err := query.Pack()
 		if err != nil {
 			t.Fatal(err)
@@ -5,8 +5,10 @@ import (
 	"context"
 	"errors"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/atomicx"
 	"github.com/ooni/probe-engine/netx/internal/dialid"
 	"github.com/ooni/probe-engine/netx/internal/errwrapper"
@@ -73,6 +75,7 @@ func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, e
 			TransportNetwork:       network,
 		},
 	})
+	ctx, recorder := withDNSSECRecorder(ctx)
 	addrs, err := r.lookupHost(ctx, hostname)
 	containsBogons := errors.Is(err, modelx.ErrDNSBogon)
 	if containsBogons {
@@ -97,6 +100,7 @@ func (r *Resolver) LookupHost(ctx context.Context, hostname string) ([]string, e
 			Addresses:              addrs,
 			ContainsBogons:         containsBogons,
 			DialID:                 dialID,
+			DNSSEC:                 recorder.results(),
 			DurationSinceBeginning: time.Now().Sub(root.Beginning),
 			Error:                  err,
 			Hostname:               hostname,
@@ -130,6 +134,113 @@ func (r *Resolver) detectedBogon(
 	return addrs, modelx.ErrDNSBogon
 }
 
+// LookupRaw sends a query of the given type for name and returns
+// the reply. It fails with modelx.ErrDNSRawNotSupported if the
+// underlying resolver is not capable of performing raw lookups.
+func (r *Resolver) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	network, address := r.queryTransport()
+	dialID := dialid.ContextDialID(ctx)
+	txID := transactionid.ContextTransactionID(ctx)
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	root.Handler.OnMeasurement(modelx.Measurement{
+		ResolveStart: &modelx.ResolveStartEvent{
+			DialID:                 dialID,
+			DurationSinceBeginning: time.Now().Sub(root.Beginning),
+			Hostname:               name,
+			QueryType:              qtype,
+			TransactionID:          txID,
+			TransportAddress:       address,
+			TransportNetwork:       network,
+		},
+	})
+	ctx, recorder := withDNSSECRecorder(ctx)
+	reply, err := r.lookupRaw(ctx, name, qtype)
+	err = errwrapper.SafeErrWrapperBuilder{
+		DialID:        dialID,
+		Error:         err,
+		Operation:     "resolve",
+		TransactionID: txID,
+	}.MaybeBuild()
+	var answers []dns.RR
+	if reply != nil {
+		answers = reply.Answer
+	}
+	root.Handler.OnMeasurement(modelx.Measurement{
+		ResolveDone: &modelx.ResolveDoneEvent{
+			Answers:                answers,
+			DialID:                 dialID,
+			DNSSEC:                 recorder.results(),
+			DurationSinceBeginning: time.Now().Sub(root.Beginning),
+			Error:                  err,
+			Hostname:               name,
+			QueryType:              qtype,
+			TransactionID:          txID,
+			TransportAddress:       address,
+			TransportNetwork:       network,
+		},
+	})
+	return reply, err
+}
+
+func (r *Resolver) lookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	reso, okay := r.resolver.(modelx.DNSRawResolver)
+	if !okay {
+		return nil, modelx.ErrDNSRawNotSupported
+	}
+	return reso.LookupRaw(ctx, name, qtype)
+}
+
+// dnssecRecorder collects the DNSSEC validation results of the replies
+// received by the child resolver and forwards all events.
+type dnssecRecorder struct {
+	handler modelx.Handler
+	mu      sync.Mutex
+	res     map[uint16]*modelx.DNSSECValidation
+}
+
+// withDNSSECRecorder returns a context whose measurement root uses a new
+// dnssecRecorder as handler, if DNSSEC validation is enabled. Otherwise, it
+// returns the original context and a nil dnssecRecorder.
+func withDNSSECRecorder(ctx context.Context) (context.Context, *dnssecRecorder) {
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	if !root.DNSSECValidation {
+		return ctx, nil
+	}
+	recorder := &dnssecRecorder{
+		handler: root.Handler,
+		res:     make(map[uint16]*modelx.DNSSECValidation),
+	}
+	child := *root
+	child.Handler = recorder
+	return modelx.WithMeasurementRoot(ctx, &child), recorder
+}
+
+func (h *dnssecRecorder) OnMeasurement(m modelx.Measurement) {
+	if reply := m.DNSReply; reply != nil && reply.DNSSEC != nil &&
+		reply.Msg != nil && len(reply.Msg.Question) == 1 {
+		h.mu.Lock()
+		h.res[reply.Msg.Question[0].Qtype] = reply.DNSSEC
+		h.mu.Unlock()
+	}
+	h.handler.OnMeasurement(m)
+}
+
+func (h *dnssecRecorder) results() map[uint16]*modelx.DNSSECValidation {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.res) <= 0 {
+		return nil
+	}
+	return h.res
+}
+
 // LookupMX returns the MX records of a specific name
 func (r *Resolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
 	return r.resolver.LookupMX(ctx, name)
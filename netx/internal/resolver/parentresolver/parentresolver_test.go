@@ -2,11 +2,14 @@ package parentresolver
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/internal/resolver/systemresolver"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
@@ -130,6 +133,117 @@ func TestLookupHostBogonAsWarning(t *testing.T) {
 	}
 }
 
+type fakerawresolver struct {
+	*net.Resolver
+	err   error
+	reply *dns.Msg
+}
+
+func (r *fakerawresolver) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	root := modelx.ContextMeasurementRootOrDefault(ctx)
+	if root.DNSSECValidation {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+		root.Handler.OnMeasurement(modelx.Measurement{
+			DNSReply: &modelx.DNSReplyEvent{
+				DNSSEC: &modelx.DNSSECValidation{Status: modelx.DNSSECStatusSecure},
+				Msg:    msg,
+			},
+		})
+	}
+	return r.reply, r.err
+}
+
+func TestUnitLookupRaw(t *testing.T) {
+	reply := new(dns.Msg)
+	reply.Answer = append(reply.Answer, &dns.CAA{
+		Hdr: dns.RR_Header{Name: "ooni.io.", Rrtype: dns.TypeCAA},
+		Tag: "issue", Value: "letsencrypt.org",
+	})
+	client := New(&fakerawresolver{reply: reply})
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	out, err := client.LookupRaw(ctx, "ooni.io", dns.TypeCAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != reply {
+		t.Fatal("not the reply we expected")
+	}
+	var start, done bool
+	for _, ev := range saver.Read() {
+		if ev.ResolveStart != nil {
+			start = ev.ResolveStart.QueryType == dns.TypeCAA
+		}
+		if ev.ResolveDone != nil {
+			done = ev.ResolveDone.QueryType == dns.TypeCAA &&
+				len(ev.ResolveDone.Answers) == 1 && ev.ResolveDone.Error == nil
+		}
+	}
+	if !start || !done {
+		t.Fatal("missing or invalid resolve events")
+	}
+}
+
+func TestUnitLookupRawDNSSEC(t *testing.T) {
+	client := New(&fakerawresolver{reply: new(dns.Msg)})
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning:        time.Now(),
+		DNSSECValidation: true,
+		Handler:          saver,
+	})
+	if _, err := client.LookupRaw(ctx, "ooni.io.", dns.TypeTXT); err != nil {
+		t.Fatal(err)
+	}
+	var reply, done bool
+	for _, ev := range saver.Read() {
+		reply = reply || ev.DNSReply != nil
+		if ev.ResolveDone != nil {
+			validation := ev.ResolveDone.DNSSEC[dns.TypeTXT]
+			done = validation != nil && validation.Status == modelx.DNSSECStatusSecure
+		}
+	}
+	if !reply {
+		t.Fatal("the reply event has not been forwarded")
+	}
+	if !done {
+		t.Fatal("missing DNSSEC validation in resolve done event")
+	}
+}
+
+func TestUnitLookupRawNotSupported(t *testing.T) {
+	client := New(systemresolver.New(new(net.Resolver)))
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	reply, err := client.LookupRaw(ctx, "ooni.io", dns.TypeTXT)
+	if !errors.Is(err, modelx.ErrDNSRawNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	var wrapper *modelx.ErrWrapper
+	if !errors.As(err, &wrapper) || wrapper.Operation != "resolve" {
+		t.Fatal("the error has not been wrapped")
+	}
+	if reply != nil {
+		t.Fatal("expected a nil reply here")
+	}
+	var done bool
+	for _, ev := range saver.Read() {
+		done = done || (ev.ResolveDone != nil && ev.ResolveDone.Error != nil)
+	}
+	if !done {
+		t.Fatal("missing resolve done event")
+	}
+}
+
 func TestLookupMX(t *testing.T) {
 	client := New(new(net.Resolver))
 	records, err := client.LookupMX(context.Background(), "ooni.io")
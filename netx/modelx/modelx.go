@@ -52,6 +52,13 @@ type Measurement struct {
 	TLSHandshakeStart *TLSHandshakeStartEvent `json:",omitempty"`
 	TLSHandshakeDone  *TLSHandshakeDoneEvent  `json:",omitempty"`
 
+	// QUIC events
+	//
+	// Identified by the ConnID of the UDP socket used by QUIC, whose
+	// datagrams are reported as Read and Write events.
+	QUICHandshakeStart *QUICHandshakeStartEvent `json:",omitempty"`
+	QUICHandshakeDone  *QUICHandshakeDoneEvent  `json:",omitempty"`
+
 	// HTTP roundtrip events
 	//
 	// A round trip starts when we need a connection to send a request
@@ -86,6 +93,9 @@ type Measurement struct {
 }
 
 const (
+	// FailureConnectionAborted means ECONNABORTED.
+	FailureConnectionAborted = "connection_aborted"
+
 	// FailureConnectionRefused means ECONNREFUSED.
 	FailureConnectionRefused = "connection_refused"
 
@@ -98,12 +108,37 @@ const (
 	// FailureDNSNXDOMAINError means we got NXDOMAIN in DNS reply.
 	FailureDNSNXDOMAINError = "dns_nxdomain_error"
 
+	// FailureDNSRefused means we got REFUSED in DNS reply.
+	FailureDNSRefused = "dns_refused_error"
+
+	// FailureDNSServfail means we got SERVFAIL in DNS reply.
+	FailureDNSServfail = "dns_server_failure"
+
 	// FailureEOFError means we got unexpected EOF on connection.
 	FailureEOFError = "eof_error"
 
 	// FailureGenericTimeoutError means we got some timer has expired.
 	FailureGenericTimeoutError = "generic_timeout_error"
 
+	// FailureHostUnreachable means EHOSTUNREACH.
+	FailureHostUnreachable = "host_unreachable"
+
+	// FailureHTTP2ProtocolError means the peer violated HTTP/2.
+	FailureHTTP2ProtocolError = "http2_protocol_error"
+
+	// FailureNetworkUnreachable means ENETUNREACH.
+	FailureNetworkUnreachable = "network_unreachable"
+
+	// FailureSSLAlertPrefix is the prefix of the failures meaning that the
+	// peer sent us a TLS alert. The alert name follows, with underscores in
+	// place of spaces, e.g., `ssl_alert_handshake_failure`.
+	FailureSSLAlertPrefix = "ssl_alert_"
+
+	// FailureSSLFailedHandshake means the TLS handshake failed because
+	// we received a record that is not TLS, i.e., the peer, or someone
+	// in the middle, does not speak TLS.
+	FailureSSLFailedHandshake = "ssl_failed_handshake"
+
 	// FailureSSLInvalidHostname means we got certificate is not valid for SNI.
 	FailureSSLInvalidHostname = "ssl_invalid_hostname"
 
@@ -139,6 +174,7 @@ type ErrWrapper struct {
 	// - `resolve`: resolving a domain name failed
 	// - `connect`: connecting to an IP failed
 	// - `tls_handshake`: TLS handshaking failed
+	// - `quic_handshake`: QUIC handshaking failed
 	// - `http_round_trip`: other errors during round trip
 	//
 	// Because a network connection doesn't necessarily know
@@ -253,10 +289,40 @@ type DNSReplyEvent struct {
 	// the time configured as the "zero" time.
 	DurationSinceBeginning time.Duration
 
+	// DNSSEC contains the DNSSEC validation result. It is nil
+	// unless MeasurementRoot.DNSSECValidation is true.
+	DNSSEC *DNSSECValidation `json:",omitempty"`
+
 	// Msg is the received parsed message.
 	Msg *dns.Msg `json:"-"`
 }
 
+// DNSSEC validation statuses. See RFC4035 Sect. 4.3.
+const (
+	// DNSSECStatusSecure means we could build a chain of trust
+	// from the root trust anchor to the returned records.
+	DNSSECStatusSecure = "secure"
+
+	// DNSSECStatusInsecure means we could prove that the returned
+	// records belong to a zone that is not signed.
+	DNSSECStatusInsecure = "insecure"
+
+	// DNSSECStatusBogus means that we expected the returned records
+	// to be signed but we could not validate their signatures or, for
+	// replies without answers, that we could not validate the proof
+	// that the queried name or type does not exist.
+	DNSSECStatusBogus = "bogus"
+)
+
+// DNSSECValidation is the result of validating a DNS reply.
+type DNSSECValidation struct {
+	// AuthenticatedData is the value of the AD bit in the reply.
+	AuthenticatedData bool
+
+	// Status is one of the DNSSECStatus values.
+	Status string
+}
+
 // ExtensionEvent is emitted by a netx extension.
 type ExtensionEvent struct {
 	// DurationSinceBeginning is the number of nanoseconds since
@@ -502,6 +568,36 @@ type HTTPResponseDoneEvent struct {
 	TransactionID int64
 }
 
+// QUICHandshakeStartEvent is emitted when the QUIC handshake starts.
+type QUICHandshakeStartEvent struct {
+	// ConnID is the ID of the UDP socket used by QUIC.
+	ConnID int64
+
+	// DurationSinceBeginning is the number of nanoseconds since
+	// the time configured as the "zero" time.
+	DurationSinceBeginning time.Duration
+
+	// SNI is the SNI used by the TLS handshake within QUIC.
+	SNI string
+}
+
+// QUICHandshakeDoneEvent is emitted when the QUIC handshake returns.
+type QUICHandshakeDoneEvent struct {
+	// ConnectionState is the TLS connection state. Depending on the
+	// error type, some fields may have little meaning.
+	ConnectionState TLSConnectionState
+
+	// ConnID is the ID of the UDP socket used by QUIC.
+	ConnID int64
+
+	// DurationSinceBeginning is the number of nanoseconds since
+	// the time configured as the "zero" time.
+	DurationSinceBeginning time.Duration
+
+	// Error is the result of the QUIC handshake.
+	Error error
+}
+
 // ReadEvent is emitted when the READ/RECV syscall returns.
 type ReadEvent struct {
 	// ConnID is the identifier of this connection.
@@ -525,6 +621,9 @@ type ReadEvent struct {
 
 // ResolveStartEvent is emitted when we start resolving a domain name.
 type ResolveStartEvent struct {
+	// Cached indicates that the result will come from a cache.
+	Cached bool `json:",omitempty"`
+
 	// DialID is the identifier of the dial operation as
 	// part of which we're resolving this domain.
 	DialID int64
@@ -536,6 +635,10 @@ type ResolveStartEvent struct {
 	// Hostname is the domain name to resolve.
 	Hostname string
 
+	// QueryType is the DNS query type used by LookupRaw. It is
+	// zero when we are resolving a hostname with LookupHost.
+	QueryType uint16 `json:",omitempty"`
+
 	// TransactionID is the ID of the HTTP transaction that caused the
 	// current dial to run, or zero if there's no such transaction.
 	TransactionID int64 `json:",omitempty"`
@@ -555,6 +658,15 @@ type ResolveDoneEvent struct {
 	// Addresses is the list of returned addresses (empty on error).
 	Addresses []string
 
+	// Answers contains the answer records returned by LookupRaw. It
+	// is always empty when we are resolving using LookupHost.
+	Answers []dns.RR `json:",omitempty"`
+
+	// Cached indicates that the result comes from a cache, i.e., we did
+	// not send any query and the Addresses are the ones we got when we
+	// resolved the same hostname before.
+	Cached bool `json:",omitempty"`
+
 	// ContainsBogons indicates whether Addresses contains one
 	// or more IP addresses that classify as bogons.
 	ContainsBogons bool
@@ -563,6 +675,11 @@ type ResolveDoneEvent struct {
 	// part of which we're resolving this domain.
 	DialID int64
 
+	// DNSSEC maps the query type of each reply (e.g. dns.TypeA) to
+	// the corresponding DNSSEC validation result. It is empty unless
+	// MeasurementRoot.DNSSECValidation is true.
+	DNSSEC map[uint16]*DNSSECValidation `json:",omitempty"`
+
 	// DurationSinceBeginning is the number of nanoseconds since
 	// the time configured as the "zero" time.
 	DurationSinceBeginning time.Duration
@@ -573,6 +690,10 @@ type ResolveDoneEvent struct {
 	// Hostname is the domain name to resolve.
 	Hostname string
 
+	// QueryType is the DNS query type used by LookupRaw. It is
+	// zero when we are resolving a hostname with LookupHost.
+	QueryType uint16 `json:",omitempty"`
+
 	// TransactionID is the ID of the HTTP transaction that caused the
 	// current dial to run, or zero if there's no such transaction.
 	TransactionID int64 `json:",omitempty"`
@@ -592,9 +713,17 @@ type X509Certificate struct {
 	Data []byte
 }
 
-// TLSConnectionState contains the TLS connection state.
+// TLSConnectionState contains the TLS connection state. The JA3 and
+// JA3S fields contain the fingerprints of the ClientHello and of the
+// ServerHello, respectively, and the hash fields contain their MD5 hash,
+// as described at <https://github.com/salesforce/ja3>. They are empty
+// when we have not seen, or could not parse, the hello messages.
 type TLSConnectionState struct {
 	CipherSuite        uint16
+	JA3                string `json:",omitempty"`
+	JA3Hash            string `json:",omitempty"`
+	JA3S               string `json:",omitempty"`
+	JA3SHash           string `json:",omitempty"`
 	NegotiatedProtocol string
 	PeerCertificates   []X509Certificate
 	Version            uint16
@@ -622,6 +751,10 @@ func SimplifyCerts(in []*x509.Certificate) (out []X509Certificate) {
 
 // TLSHandshakeStartEvent is emitted when the TLS handshake starts.
 type TLSHandshakeStartEvent struct {
+	// ClientHello is the name of the parrot we're using for the
+	// ClientHello, or empty if we're using Go's crypto/tls.
+	ClientHello string `json:",omitempty"`
+
 	// ConnID is the ID of the connection that started the TLS
 	// handshake, or zero if we don't know it. Typically, it is
 	// zero for connections managed by the HTTP transport, for
@@ -717,6 +850,16 @@ type DNSResolver interface {
 	LookupNS(ctx context.Context, name string) ([]*net.NS, error)
 }
 
+// DNSRawResolver is a DNSResolver that can also send queries for
+// arbitrary record types (e.g. TXT, CAA, SOA) and return the reply.
+type DNSRawResolver interface {
+	DNSResolver
+
+	// LookupRaw sends a query of the given type for name and
+	// returns the corresponding DNS reply.
+	LookupRaw(ctx context.Context, name string, qtype uint16) (*dns.Msg, error)
+}
+
 // DNSRoundTripper represents an abstract DNS transport.
 type DNSRoundTripper interface {
 	// RoundTrip sends a DNS query and receives the reply.
@@ -749,6 +892,23 @@ type TLSDialer interface {
 // to tell this library to return an error when a bogon is found.
 var ErrDNSBogon = errors.New("dns: detected bogon address")
 
+// ErrDNSRefused indicates that the DNS server returned REFUSED.
+var ErrDNSRefused = errors.New("dns: query refused")
+
+// ErrDNSServfail indicates that the DNS server returned SERVFAIL.
+var ErrDNSServfail = errors.New("dns: server failure")
+
+// ErrDNSRawNotSupported indicates that the underlying resolver
+// cannot perform a LookupRaw (e.g. the system resolver).
+var ErrDNSRawNotSupported = errors.New("dns: raw lookups not supported")
+
+// DNS record types that the DNS library we use does not know. We
+// nonetheless allow to query for them using LookupRaw.
+const (
+	DNSTypeSVCB  uint16 = 64
+	DNSTypeHTTPS uint16 = 65
+)
+
 // MeasurementRoot is the measurement root.
 //
 // If you attach this to a context, we'll use it rather than using
@@ -768,6 +928,12 @@ type MeasurementRoot struct {
 	// field is the ErrDNSBogon variable in this package.
 	ErrDNSBogon error
 
+	// DNSSECValidation causes this library to set the DO bit in
+	// DNS queries and to validate the replies using DNSSEC. This only
+	// works with resolvers that are not the system resolver. The
+	// validation result is in DNSReplyEvent and ResolveDoneEvent.
+	DNSSECValidation bool
+
 	// Handler is the handler that will handle events.
 	Handler Handler
 
@@ -782,6 +948,15 @@ type MeasurementRoot struct {
 	// LookupHost allows to override the host lookup for all the request
 	// and dials that use this measurement root.
 	LookupHost func(ctx context.Context, hostname string) ([]string, error)
+
+	// DialContext allows to override how we connect to the resolved
+	// endpoints for all the requests and dials that use this measurement
+	// root. The dials still emit the ConnectEvent.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// observed indicates that Handler already forwards the events
+	// to the Observer configured in the context, if any.
+	observed bool
 }
 
 type measurementRootContextKey struct{}
@@ -815,14 +990,149 @@ func ContextMeasurementRootOrDefault(ctx context.Context) *MeasurementRoot {
 // is a nil pointer, like httptrace.WithClientTrace.
 //
 // Merging more than one root is not supported. Setting again
-// the root is just going to replace the original root.
+// the root is just going to replace the original root, except
+// that, if root does not override LookupHost or DialContext, we
+// install a copy of root inheriting them from the original root. This
+// allows to simulate the network for code creating its own root.
+//
+// If the context contains an Observer, we install a copy of
+// the root whose Handler also passes events to the Observer.
 func WithMeasurementRoot(
 	ctx context.Context, root *MeasurementRoot,
 ) context.Context {
 	if root == nil {
 		panic("nil measurement root")
 	}
+	if parent := ContextMeasurementRoot(ctx); parent != nil &&
+		((root.LookupHost == nil && parent.LookupHost != nil) ||
+			(root.DialContext == nil && parent.DialContext != nil)) {
+		child := *root
+		if child.LookupHost == nil {
+			child.LookupHost = parent.LookupHost
+		}
+		if child.DialContext == nil {
+			child.DialContext = parent.DialContext
+		}
+		root = &child
+	}
+	if observer := ContextObserver(ctx); observer != nil && !root.observed {
+		child := *root
+		child.Handler = &observingHandler{
+			beginning: root.Beginning,
+			handler:   root.Handler,
+			observer:  observer,
+		}
+		child.observed = true
+		root = &child
+	}
 	return context.WithValue(
 		ctx, measurementRootContextKey{}, root,
 	)
 }
+
+type bypassDNSCacheContextKey struct{}
+
+// WithBypassDNSCache returns a copy of the context telling caching
+// resolvers to not use cached results. Such resolvers will perform
+// the lookup again and will update the cache with its result.
+func WithBypassDNSCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassDNSCacheContextKey{}, true)
+}
+
+// ContextBypassDNSCache returns true if the context tells caching
+// resolvers to not use cached results.
+func ContextBypassDNSCache(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassDNSCacheContextKey{}).(bool)
+	return bypass
+}
+
+// CapturedPacket is an application payload that has been sent or
+// received by a connection created using netx.
+type CapturedPacket struct {
+	// ConnID is the identifier of the connection.
+	ConnID int64
+
+	// Data contains the payload. The PacketCapturer must copy it
+	// if it needs to retain it after CapturePacket returns.
+	Data []byte
+
+	// DurationSinceBeginning is the number of nanoseconds since
+	// the time configured as the "zero" time.
+	DurationSinceBeginning time.Duration
+
+	// LocalAddr is the local address of the connection.
+	LocalAddr net.Addr
+
+	// Network is the network of the connection (e.g. "tcp", "udp").
+	Network string
+
+	// RemoteAddr is the remote address of the connection.
+	RemoteAddr net.Addr
+
+	// Sent is true if we sent Data and false if we received it.
+	Sent bool
+}
+
+// PacketCapturer captures the application payloads sent and received
+// by the connections created using netx, e.g., to write a pcapng file.
+// The CapturePacket method may be called by concurrent goroutines.
+type PacketCapturer interface {
+	CapturePacket(packet CapturedPacket)
+}
+
+type packetCapturerContextKey struct{}
+
+// WithPacketCapturer returns a copy of the context telling the
+// dialers to pass to capturer the payloads sent and received by
+// the connections that they will create.
+func WithPacketCapturer(
+	ctx context.Context, capturer PacketCapturer,
+) context.Context {
+	return context.WithValue(ctx, packetCapturerContextKey{}, capturer)
+}
+
+// ContextPacketCapturer returns the PacketCapturer configured in
+// the context, if any, or nil otherwise.
+func ContextPacketCapturer(ctx context.Context) PacketCapturer {
+	capturer, _ := ctx.Value(packetCapturerContextKey{}).(PacketCapturer)
+	return capturer
+}
+
+// Observer observes the events emitted using any MeasurementRoot. Because
+// each root has its own "zero" time, we also pass to Observe the Beginning
+// of the root that emitted the event. The Observe method may be called by
+// concurrent goroutines and must not retain references to the event.
+type Observer interface {
+	Observe(beginning time.Time, m Measurement)
+}
+
+type observerContextKey struct{}
+
+// WithObserver returns a copy of the context where observer will receive
+// the events emitted using the measurement roots that are installed, using
+// WithMeasurementRoot, in contexts derived from the returned context. This
+// is useful to observe code that creates its own measurement root, such as
+// experiments. Configuring more than one observer is not supported.
+func WithObserver(ctx context.Context, observer Observer) context.Context {
+	return context.WithValue(ctx, observerContextKey{}, observer)
+}
+
+// ContextObserver returns the Observer configured in the context, if
+// any, or nil otherwise.
+func ContextObserver(ctx context.Context) Observer {
+	observer, _ := ctx.Value(observerContextKey{}).(Observer)
+	return observer
+}
+
+type observingHandler struct {
+	beginning time.Time
+	handler   Handler
+	observer  Observer
+}
+
+func (h *observingHandler) OnMeasurement(m Measurement) {
+	h.observer.Observe(h.beginning, m)
+	if h.handler != nil {
+		h.handler.OnMeasurement(m)
+	}
+}
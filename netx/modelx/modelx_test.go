@@ -4,7 +4,9 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"io"
 	"math"
+	"net"
 	"testing"
 	"time"
 )
@@ -82,3 +84,92 @@ func TestUnitComputeBodySnapSize(t *testing.T) {
 		t.Fatal("unexpected result")
 	}
 }
+
+func TestUnitBypassDNSCache(t *testing.T) {
+	ctx := context.Background()
+	if ContextBypassDNSCache(ctx) != false {
+		t.Fatal("unexpected value for ContextBypassDNSCache")
+	}
+	ctx = WithBypassDNSCache(ctx)
+	if ContextBypassDNSCache(ctx) != true {
+		t.Fatal("unexpected value for ContextBypassDNSCache")
+	}
+}
+
+type fakecapturer struct{}
+
+func (fakecapturer) CapturePacket(CapturedPacket) {}
+
+func TestUnitPacketCapturer(t *testing.T) {
+	ctx := context.Background()
+	if ContextPacketCapturer(ctx) != nil {
+		t.Fatal("unexpected value for ContextPacketCapturer")
+	}
+	ctx = WithPacketCapturer(ctx, fakecapturer{})
+	if ContextPacketCapturer(ctx) == nil {
+		t.Fatal("unexpected value for ContextPacketCapturer")
+	}
+}
+
+type savingobserver struct {
+	beginnings []time.Time
+}
+
+func (o *savingobserver) Observe(beginning time.Time, m Measurement) {
+	o.beginnings = append(o.beginnings, beginning)
+}
+
+func TestUnitObserver(t *testing.T) {
+	observer := new(savingobserver)
+	ctx := WithObserver(context.Background(), observer)
+	if ContextObserver(ctx) != observer {
+		t.Fatal("unexpected value for ContextObserver")
+	}
+	root := &MeasurementRoot{Beginning: time.Now(), Handler: &dummyHandler{}}
+	ctx = WithMeasurementRoot(ctx, root)
+	// Simulate code that replaces the root with a copy, like the
+	// resolvers that intercept the events of their children.
+	child := *ContextMeasurementRoot(ctx)
+	ctx = WithMeasurementRoot(ctx, &child)
+	ContextMeasurementRoot(ctx).Handler.OnMeasurement(Measurement{})
+	if len(observer.beginnings) != 1 {
+		t.Fatal("unexpected number of observed events")
+	}
+	if !observer.beginnings[0].Equal(root.Beginning) {
+		t.Fatal("unexpected beginning")
+	}
+	if root.Handler != ContextMeasurementRoot(ctx).Handler.(*observingHandler).handler {
+		t.Fatal("the original root has been modified")
+	}
+}
+
+func TestUnitMeasurementRootInheritsOverrides(t *testing.T) {
+	lookupHost := func(ctx context.Context, hostname string) ([]string, error) {
+		return []string{"10.0.0.1"}, nil
+	}
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return nil, io.EOF
+	}
+	ctx := WithMeasurementRoot(context.Background(), &MeasurementRoot{
+		Beginning:   time.Now(),
+		DialContext: dialContext,
+		Handler:     &dummyHandler{},
+		LookupHost:  lookupHost,
+	})
+	root := &MeasurementRoot{Beginning: time.Now(), Handler: &dummyHandler{}}
+	child := ContextMeasurementRoot(WithMeasurementRoot(ctx, root))
+	if child.LookupHost == nil || child.DialContext == nil {
+		t.Fatal("the child root did not inherit the overrides")
+	}
+	if root.LookupHost != nil || root.DialContext != nil {
+		t.Fatal("the original root has been modified")
+	}
+	if _, err := child.DialContext(ctx, "tcp", "10.0.0.1:80"); err != io.EOF {
+		t.Fatal("not the DialContext we expected")
+	}
+	// A root overriding both functions is used as is
+	root.LookupHost, root.DialContext = lookupHost, dialContext
+	if ContextMeasurementRoot(WithMeasurementRoot(ctx, root)) != root {
+		t.Fatal("unexpected ContextMeasurementRoot value")
+	}
+}
@@ -0,0 +1,514 @@
+// Package netxtest contains a simulated network for testing code
+// using netx without accessing the internet.
+//
+// A Network is driven by a table of rules telling it how to treat
+// specific domain names and IP addresses. It provides a dialer, a
+// DNS round tripper, and a resolver using such round tripper. Use
+// Network.Configure to make all the dials and host lookups using
+// a measurement root go through the simulated network. Because a
+// measurement root inherits these overrides from the root already
+// in the context, this works also with code, such as experiments,
+// that creates its own measurement root.
+//
+// This allows us to simulate censorship. For example:
+//
+//	network := &netxtest.Network{
+//	    Hosts: map[string][]string{"example.com": {"93.184.216.34"}},
+//	    Rules: []netxtest.Rule{{
+//	        Action: netxtest.ActionReset,
+//	        Host:   "93.184.216.34",
+//	    }},
+//	}
+//	root := &modelx.MeasurementRoot{Beginning: time.Now(), Handler: handler}
+//	network.Configure(root)
+//	ctx := modelx.WithMeasurementRoot(context.Background(), root)
+//
+// With this configuration, connecting to example.com succeeds but
+// the connection is reset as soon as we send any data.
+package netxtest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/atomicx"
+	"github.com/ooni/probe-engine/netx/internal/resolver/ooniresolver"
+	"github.com/ooni/probe-engine/netx/internal/resolver/parentresolver"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+// Action is what the simulated network does when a rule matches.
+type Action string
+
+const (
+	// ActionBlackhole drops all the packets. Dials and DNS round
+	// trips block until the context is done.
+	ActionBlackhole = Action("blackhole")
+
+	// ActionBogon causes the DNS to return BogonAddress.
+	ActionBogon = Action("bogon")
+
+	// ActionDelay delays dials and DNS round trips by Rule.Delay and
+	// then proceeds as if there were no rules.
+	ActionDelay = Action("delay")
+
+	// ActionForgedCertificate causes a middlebox to complete the TLS
+	// handshake using a certificate for the SNI signed by an authority
+	// that the client does not trust.
+	ActionForgedCertificate = Action("forged_certificate")
+
+	// ActionNXDOMAIN causes the DNS to return NXDOMAIN.
+	ActionNXDOMAIN = Action("nxdomain")
+
+	// ActionReset causes a middlebox to reset the connection after
+	// the client has sent Rule.ResetAfter bytes.
+	ActionReset = Action("reset")
+)
+
+// BogonAddress is the address returned by the DNS with ActionBogon.
+const BogonAddress = "10.10.34.35"
+
+// Rule tells the simulated network how to treat a host.
+type Rule struct {
+	// Action is the action to perform.
+	Action Action
+
+	// Delay is the delay used by ActionDelay.
+	Delay time.Duration
+
+	// Host is the host to which the rule applies. The DNS matches the
+	// domain name being queried, while the dialer matches the IP
+	// address or the endpoint (e.g. "1.1.1.1:443") being dialed. Use
+	// "*" to match any domain name and any IP address.
+	Host string
+
+	// ResetAfter is the number of bytes after which ActionReset
+	// resets the connection. Zero means that we reset the connection
+	// as soon as the client attempts to send or receive data.
+	ResetAfter int
+}
+
+// Network is a simulated network. The first rule matching a dial or a
+// DNS round trip determines its outcome. The actions that do not apply
+// to dials (e.g. ActionNXDOMAIN) and the actions that do not apply to the
+// DNS (e.g. ActionReset) are ignored when looking for matching rules.
+type Network struct {
+	// Dialer is the dialer used when no rule applies to a dial. If
+	// nil, we use a net.Dialer. So, you can map domain names to the
+	// addresses of local servers using Hosts and connect to them.
+	Dialer modelx.Dialer
+
+	// Hosts maps domain names to their IPv4 and IPv6 addresses. When
+	// no rule applies to a domain name, the DNS returns its addresses
+	// if the domain name is in Hosts, and NXDOMAIN otherwise.
+	Hosts map[string][]string
+
+	// Rules contains the rules.
+	Rules []Rule
+
+	authority     *x509.Certificate
+	authorityErr  error
+	authorityKey  *ecdsa.PrivateKey
+	authorityOnce sync.Once
+}
+
+var (
+	dialActions = []Action{
+		ActionBlackhole, ActionDelay, ActionForgedCertificate, ActionReset,
+	}
+	dnsActions = []Action{
+		ActionBlackhole, ActionBogon, ActionDelay, ActionNXDOMAIN,
+	}
+)
+
+func (n *Network) match(actions []Action, names ...string) *Rule {
+	for idx := range n.Rules {
+		rule := &n.Rules[idx]
+		if !containsAction(actions, rule.Action) {
+			continue
+		}
+		for _, name := range names {
+			if rule.Host == "*" || strings.EqualFold(rule.Host, name) {
+				return rule
+			}
+		}
+	}
+	return nil
+}
+
+func containsAction(actions []Action, action Action) bool {
+	for _, a := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Configure configures root such that all the host lookups and dials
+// using root go through the simulated network.
+func (n *Network) Configure(root *modelx.MeasurementRoot) {
+	root.DialContext = n.NewDialer().DialContext
+	root.LookupHost = n.NewResolver().LookupHost
+}
+
+// Dialer is a modelx.Dialer using the simulated network.
+type Dialer struct {
+	network *Network
+}
+
+// NewDialer creates a new Dialer.
+func (n *Network) NewDialer() *Dialer {
+	return &Dialer{network: n}
+}
+
+// Dial creates a TCP or UDP connection. See net.Dial docs.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+// DialContext is like Dial but with context.
+func (d *Dialer) DialContext(
+	ctx context.Context, network, address string,
+) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	rule := d.network.match(dialActions, host, address)
+	if rule == nil {
+		return d.dial(ctx, network, address)
+	}
+	switch rule.Action {
+	case ActionBlackhole:
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+	case ActionDelay:
+		if err := sleep(ctx, rule.Delay); err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+		return d.dial(ctx, network, address)
+	case ActionForgedCertificate:
+		conn, peer := newPipe(host, port)
+		go d.network.serveForgedCertificate(peer)
+		return conn, nil
+	default: // ActionReset
+		conn, peer := newPipe(host, port)
+		go io.Copy(ioutil.Discard, peer)
+		return &resetConn{Conn: conn, resetAfter: rule.ResetAfter}, nil
+	}
+}
+
+func (d *Dialer) dial(
+	ctx context.Context, network, address string,
+) (net.Conn, error) {
+	dialer := d.network.Dialer
+	if dialer == nil {
+		dialer = new(net.Dialer)
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// pipeConn is a net.Pipe end using TCP addresses, so that we can
+// compute the connection ID like we do for real connections.
+type pipeConn struct {
+	net.Conn
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+var nextPort = atomicx.NewInt64()
+
+// newPipe returns the client and the middlebox ends of a simulated
+// connection to the given host and port.
+func newPipe(host, port string) (net.Conn, net.Conn) {
+	client, peer := net.Pipe()
+	portnum, _ := strconv.Atoi(port)
+	local := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 32768 + int(nextPort.Add(1)%28232),
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP(host), Port: portnum}
+	return &pipeConn{Conn: client, localAddr: local, remoteAddr: remote},
+		&pipeConn{Conn: peer, localAddr: remote, remoteAddr: local}
+}
+
+// resetConn is a connection that a middlebox resets after the
+// client has sent more than resetAfter bytes.
+type resetConn struct {
+	net.Conn
+	mu         sync.Mutex
+	resetAfter int
+	sent       int
+}
+
+func (c *resetConn) reset() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sent >= c.resetAfter
+}
+
+func (c *resetConn) resetError(op string) error {
+	c.Conn.Close()
+	return &net.OpError{
+		Op:     op,
+		Net:    "tcp",
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    os.NewSyscallError(op, syscall.ECONNRESET),
+	}
+}
+
+// Read reads data from the connection.
+func (c *resetConn) Read(b []byte) (int, error) {
+	if c.reset() {
+		return 0, c.resetError("read")
+	}
+	n, err := c.Conn.Read(b)
+	if err != nil && c.reset() {
+		err = c.resetError("read")
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (c *resetConn) Write(b []byte) (int, error) {
+	if c.reset() {
+		return 0, c.resetError("write")
+	}
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	c.sent += n
+	c.mu.Unlock()
+	if c.reset() {
+		c.Conn.Close() // unblock pending reads
+	}
+	return n, err
+}
+
+// queuedWriteConn queues writes and performs them in the background, like
+// the kernel send buffer would do. Without it, the client and the middlebox
+// could deadlock on the unbuffered pipe, e.g., when the client rejects the
+// forged certificate and sends an alert while we are still writing.
+type queuedWriteConn struct {
+	net.Conn
+	queue chan []byte
+}
+
+func newQueuedWriteConn(conn net.Conn) *queuedWriteConn {
+	c := &queuedWriteConn{Conn: conn, queue: make(chan []byte, 64)}
+	go func() {
+		defer conn.Close()
+		for data := range c.queue {
+			conn.Write(data) // after an error, all writes fail quickly
+		}
+	}()
+	return c
+}
+
+// Write queues data for writing.
+func (c *queuedWriteConn) Write(b []byte) (int, error) {
+	c.queue <- append([]byte{}, b...)
+	return len(b), nil
+}
+
+// Close closes the connection after all the queued data has been written.
+func (c *queuedWriteConn) Close() error {
+	close(c.queue)
+	return nil
+}
+
+func (n *Network) serveForgedCertificate(conn net.Conn) {
+	qconn := newQueuedWriteConn(conn)
+	defer qconn.Close()
+	tlsconn := tls.Server(qconn, &tls.Config{
+		GetCertificate: n.forgeCertificate,
+	})
+	if err := tlsconn.Handshake(); err != nil {
+		return
+	}
+	io.Copy(ioutil.Discard, tlsconn)
+}
+
+func (n *Network) forgeCertificate(
+	hello *tls.ClientHelloInfo,
+) (*tls.Certificate, error) {
+	n.authorityOnce.Do(func() {
+		n.authority, n.authorityKey, n.authorityErr = newCertificate(
+			"netxtest forged authority", nil, nil)
+	})
+	if n.authorityErr != nil {
+		return nil, n.authorityErr
+	}
+	name := hello.ServerName
+	if name == "" {
+		name = "localhost"
+	}
+	cert, key, err := newCertificate(name, n.authority, n.authorityKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{cert.Raw},
+		Leaf:        cert,
+		PrivateKey:  key,
+	}, nil
+}
+
+// newCertificate creates a certificate for name signed by parent, or
+// a certificate authority, if parent is nil.
+func newCertificate(
+	name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		NotBefore:    time.Now().Add(-time.Hour),
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+	}
+	if parent == nil {
+		template.BasicConstraintsValid = true
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	data, err := x509.CreateCertificate(
+		rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// DNSRoundTripper is a modelx.DNSRoundTripper using the simulated
+// network to answer queries.
+type DNSRoundTripper struct {
+	network *Network
+}
+
+// NewDNSRoundTripper creates a new DNSRoundTripper.
+func (n *Network) NewDNSRoundTripper() *DNSRoundTripper {
+	return &DNSRoundTripper{network: n}
+}
+
+// ErrInvalidQuery indicates that the query is not a valid DNS query.
+var ErrInvalidQuery = errors.New("netxtest: invalid query")
+
+// RoundTrip sends a DNS query and receives the reply.
+func (t *DNSRoundTripper) RoundTrip(
+	ctx context.Context, query []byte,
+) ([]byte, error) {
+	msg := new(dns.Msg)
+	if err := msg.Unpack(query); err != nil || len(msg.Question) != 1 {
+		return nil, ErrInvalidQuery
+	}
+	question := msg.Question[0]
+	name := strings.TrimSuffix(question.Name, ".")
+	reply := new(dns.Msg)
+	reply.SetReply(msg)
+	reply.RecursionAvailable = true
+	addresses, found := t.network.Hosts[name]
+	rule := t.network.match(dnsActions, name)
+	if rule != nil {
+		switch rule.Action {
+		case ActionBlackhole:
+			<-ctx.Done()
+			return nil, ctx.Err()
+		case ActionBogon:
+			addresses, found = []string{BogonAddress}, true
+		case ActionDelay:
+			if err := sleep(ctx, rule.Delay); err != nil {
+				return nil, err
+			}
+		default: // ActionNXDOMAIN
+			found = false
+		}
+	}
+	if !found {
+		reply.Rcode = dns.RcodeNameError
+		return reply.Pack()
+	}
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		header := dns.RR_Header{
+			Class:  dns.ClassINET,
+			Name:   question.Name,
+			Rrtype: question.Qtype,
+			Ttl:    300,
+		}
+		if ipv4 := ip.To4(); ipv4 != nil && question.Qtype == dns.TypeA {
+			reply.Answer = append(reply.Answer, &dns.A{Hdr: header, A: ipv4})
+		} else if ipv4 == nil && question.Qtype == dns.TypeAAAA {
+			reply.Answer = append(reply.Answer, &dns.AAAA{Hdr: header, AAAA: ip})
+		}
+	}
+	return reply.Pack()
+}
+
+// RequiresPadding returns false because we are not DoH or DoT.
+func (t *DNSRoundTripper) RequiresPadding() bool {
+	return false
+}
+
+// NewResolver creates a new resolver using a DNSRoundTripper. The
+// resolver emits the same events of the resolvers created by netx
+// and performs bogon detection. Use it with netx.Dialer.SetResolver
+// or use its LookupHost with modelx.MeasurementRoot.LookupHost.
+func (n *Network) NewResolver() modelx.DNSResolver {
+	return parentresolver.New(ooniresolver.New(n.NewDNSRoundTripper()))
+}
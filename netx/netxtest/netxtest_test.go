@@ -0,0 +1,344 @@
+package netxtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/ooni/probe-engine/netx"
+	"github.com/ooni/probe-engine/netx/handlers"
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+func TestUnitDialerReset(t *testing.T) {
+	network := &Network{Rules: []Rule{{
+		Action: ActionReset, Host: "10.0.0.1", ResetAfter: 4,
+	}}}
+	conn, err := network.NewDialer().Dial("tcp", "10.0.0.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if conn.RemoteAddr().String() != "10.0.0.1:443" {
+		t.Fatal("unexpected remote address")
+	}
+	if _, err := conn.Write([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 128)); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("not the error we expected")
+	}
+	if _, err := conn.Write([]byte("abcd")); !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitDialerResetPendingRead(t *testing.T) {
+	network := &Network{Rules: []Rule{{
+		Action: ActionReset, Host: "10.0.0.1:80", ResetAfter: 1,
+	}}}
+	conn, err := network.NewDialer().Dial("tcp", "10.0.0.1:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	errch := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 128))
+		errch <- err
+	}()
+	if _, err := conn.Write([]byte("GET / HTTP/1.0\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errch; !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitDialerBlackhole(t *testing.T) {
+	network := &Network{Rules: []Rule{{Action: ActionBlackhole, Host: "*"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conn, err := network.NewDialer().DialContext(ctx, "tcp", "10.0.0.1:443")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("not the error we expected")
+	}
+	if conn != nil {
+		t.Fatal("expected nil conn here")
+	}
+}
+
+type faileddialer struct{}
+
+func (faileddialer) Dial(network, address string) (net.Conn, error) {
+	return nil, io.EOF
+}
+
+func (faileddialer) DialContext(
+	ctx context.Context, network, address string,
+) (net.Conn, error) {
+	return nil, io.EOF
+}
+
+func TestUnitDialerDelay(t *testing.T) {
+	network := &Network{
+		Dialer: faileddialer{},
+		Rules: []Rule{{
+			Action: ActionNXDOMAIN, Host: "10.0.0.1", // ignored by the dialer
+		}, {
+			Action: ActionDelay, Delay: 50 * time.Millisecond, Host: "10.0.0.1",
+		}},
+	}
+	start := time.Now()
+	if _, err := network.NewDialer().Dial("tcp", "10.0.0.1:443"); err != io.EOF {
+		t.Fatal("not the error we expected")
+	}
+	if time.Now().Sub(start) < 50*time.Millisecond {
+		t.Fatal("the dial has not been delayed")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := network.NewDialer().DialContext(ctx, "tcp", "10.0.0.1:443")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitDialerInvalidAddress(t *testing.T) {
+	if _, err := new(Network).NewDialer().Dial("tcp", "10.0.0.1"); err == nil {
+		t.Fatal("expected an error here")
+	}
+}
+
+func TestUnitDialerForgedCertificate(t *testing.T) {
+	network := &Network{Rules: []Rule{{
+		Action: ActionForgedCertificate, Host: "10.0.0.1",
+	}}}
+	conn, err := network.NewDialer().Dial("tcp", "10.0.0.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = tls.Client(conn, &tls.Config{ServerName: "example.com"}).Handshake()
+	var unknownAuthorityError x509.UnknownAuthorityError
+	if !errors.As(err, &unknownAuthorityError) {
+		t.Fatal("not the error we expected")
+	}
+	conn, err = network.NewDialer().Dial("tcp", "10.0.0.1:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tlsconn := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         "example.com",
+	})
+	if err := tlsconn.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	certs := tlsconn.ConnectionState().PeerCertificates
+	if len(certs) != 1 || certs[0].VerifyHostname("example.com") != nil {
+		t.Fatal("the certificate is not for the SNI")
+	}
+}
+
+func roundtrip(
+	t *testing.T, network *Network, name string, qtype uint16,
+) (*dns.Msg, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	data, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	data, err = network.NewDNSRoundTripper().RoundTrip(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(data); err != nil {
+		t.Fatal(err)
+	}
+	return reply, nil
+}
+
+func TestUnitDNSRoundTripper(t *testing.T) {
+	network := &Network{
+		Hosts: map[string][]string{
+			"example.com": {"93.184.216.34", "2606:2800:220:1:248:1893:25c8:1946"},
+			"example.org": {"93.184.216.34"},
+			"example.net": {"93.184.216.34"},
+		},
+		Rules: []Rule{{
+			Action: ActionReset, Host: "*", // ignored by the DNS
+		}, {
+			Action: ActionNXDOMAIN, Host: "example.org",
+		}, {
+			Action: ActionBogon, Host: "example.info",
+		}, {
+			Action: ActionDelay, Delay: 10 * time.Millisecond, Host: "example.net",
+		}, {
+			Action: ActionBlackhole, Host: "example.edu",
+		}},
+	}
+	if network.NewDNSRoundTripper().RequiresPadding() {
+		t.Fatal("we do not need padding")
+	}
+	reply, err := roundtrip(t, network, "example.com", dns.TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) != 1 || reply.Answer[0].(*dns.AAAA).AAAA.String() !=
+		"2606:2800:220:1:248:1893:25c8:1946" {
+		t.Fatal("unexpected AAAA answer")
+	}
+	reply, err = roundtrip(t, network, "example.org", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Rcode != dns.RcodeNameError {
+		t.Fatal("expected NXDOMAIN here")
+	}
+	reply, err = roundtrip(t, network, "example.info", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) != 1 || reply.Answer[0].(*dns.A).A.String() != BogonAddress {
+		t.Fatal("expected a bogon here")
+	}
+	reply, err = roundtrip(t, network, "example.net", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) != 1 {
+		t.Fatal("unexpected A answer")
+	}
+	reply, err = roundtrip(t, network, "www.example.com", dns.TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Rcode != dns.RcodeNameError {
+		t.Fatal("expected NXDOMAIN for unknown domains")
+	}
+	if _, err := roundtrip(t, network, "example.edu", dns.TypeA); err != context.DeadlineExceeded {
+		t.Fatal("not the error we expected")
+	}
+	_, err = network.NewDNSRoundTripper().RoundTrip(context.Background(), []byte{0})
+	if err != ErrInvalidQuery {
+		t.Fatal("not the error we expected")
+	}
+}
+
+func TestUnitResolver(t *testing.T) {
+	network := &Network{
+		Hosts: map[string][]string{"example.com": {"93.184.216.34"}},
+		Rules: []Rule{{Action: ActionBogon, Host: "example.org"}},
+	}
+	saver := new(handlers.SavingHandler)
+	ctx := modelx.WithMeasurementRoot(context.Background(), &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   saver,
+	})
+	resolver := network.NewResolver()
+	addrs, err := resolver.LookupHost(ctx, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "93.184.216.34" {
+		t.Fatal("unexpected addresses")
+	}
+	var found bool
+	for _, ev := range saver.Read() {
+		found = found || ev.ResolveDone != nil
+	}
+	if !found {
+		t.Fatal("the resolver did not emit events")
+	}
+	if _, err := resolver.LookupHost(ctx, "example.net"); err == nil ||
+		err.Error() != modelx.FailureDNSNXDOMAINError {
+		t.Fatal("not the error we expected")
+	}
+	addrs, err = resolver.LookupHost(ctx, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != BogonAddress {
+		t.Fatal("unexpected addresses")
+	}
+}
+
+func TestUnitConfigureWithHTTPClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello, world"))
+		}))
+	defer server.Close()
+	URL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := &Network{
+		Hosts: map[string][]string{
+			"www.example.com": {"127.0.0.1"},
+			"www.example.org": {"10.0.0.1"},
+		},
+		Rules: []Rule{{Action: ActionReset, Host: "10.0.0.1", ResetAfter: 1}},
+	}
+	root := &modelx.MeasurementRoot{
+		Beginning: time.Now(),
+		Handler:   handlers.NoHandler,
+	}
+	network.Configure(root)
+	// Simulate code creating its own root, like experiments do
+	ctx := modelx.WithMeasurementRoot(
+		modelx.WithMeasurementRoot(context.Background(), root),
+		&modelx.MeasurementRoot{Beginning: time.Now(), Handler: handlers.NoHandler},
+	)
+	client := netx.NewHTTPClientWithoutProxy()
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(
+		ctx, "GET", "http://www.example.com:"+URL.Port()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("unexpected status code")
+	}
+	req, err = http.NewRequestWithContext(ctx, "GET", "http://www.example.org/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.HTTPClient.Do(req)
+	if err == nil || !strings.HasSuffix(err.Error(), modelx.FailureConnectionReset) {
+		t.Fatal("not the error we expected")
+	}
+	if resp != nil {
+		t.Fatal("expected nil resp here")
+	}
+	req, err = http.NewRequestWithContext(ctx, "GET", "http://www.example.net/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.HTTPClient.Do(req)
+	if err == nil || !strings.HasSuffix(err.Error(), modelx.FailureDNSNXDOMAINError) {
+		t.Fatal("not the error we expected")
+	}
+}
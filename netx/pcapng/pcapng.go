@@ -0,0 +1,327 @@
+// Package pcapng writes the payloads captured by netx in pcapng format.
+//
+// We do not capture real packets. Rather, we synthesize IPv4/IPv6 and
+// TCP/UDP headers around the application payloads passed to us by the
+// connections, using their 5-tuple. For TCP, we also synthesize the
+// three way handshake when we see a connection for the first time and
+// we track sequence numbers, so that "Follow TCP stream" works as
+// intended in Wireshark. The timestamp of each packet is the time
+// elapsed since the MeasurementRoot.Beginning, hence the capture
+// starts at the Unix epoch. Each packet has a comment containing
+// the netx connection ID.
+//
+// See <https://github.com/pcapng/pcapng> for the format.
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+const (
+	blockTypeSHB = 0x0A0D0D0A
+	blockTypeIDB = 0x00000001
+	blockTypeEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	// linkTypeRaw means that packets begin with an IPv4/IPv6 header.
+	linkTypeRaw = 101
+
+	optionEndOfOpt = 0
+	optionComment  = 1
+
+	protoTCP = 6
+	protoUDP = 17
+
+	tcpFlagSYN = 0x02
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+
+	// maxSegmentSize is the maximum payload of a synthesized packet. We
+	// split larger payloads, which would not fit into an IPv4 packet.
+	maxSegmentSize = 1 << 15
+)
+
+// Writer writes the captured payloads in pcapng format. It implements
+// the modelx.PacketCapturer interface and is goroutine safe.
+type Writer struct {
+	err   error
+	flows map[string]*flow
+	mu    sync.Mutex
+	w     io.Writer
+}
+
+// flow contains the state of a TCP connection.
+type flow struct {
+	// localSeq is the next sequence number we send
+	localSeq uint32
+
+	// remoteSeq is the next sequence number we receive
+	remoteSeq uint32
+}
+
+// NewWriter creates a new Writer and writes the pcapng section header
+// and interface description blocks into w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{flows: make(map[string]*flow), w: w}
+	shb := new(bytes.Buffer)
+	writeUint32(shb, byteOrderMagic)
+	writeUint16(shb, 1)                  // major version
+	writeUint16(shb, 0)                  // minor version
+	writeUint64(shb, 0xFFFFFFFFFFFFFFFF) // section length is unspecified
+	if err := pw.writeBlock(blockTypeSHB, shb.Bytes()); err != nil {
+		return nil, err
+	}
+	idb := new(bytes.Buffer)
+	writeUint16(idb, linkTypeRaw)
+	writeUint16(idb, 0) // reserved
+	writeUint32(idb, 0) // no snapshot length
+	if err := pw.writeBlock(blockTypeIDB, idb.Bytes()); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Err returns the first error that occurred when writing.
+func (pw *Writer) Err() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+// CapturePacket writes the synthesized packets containing packet.Data. It
+// ignores the packets whose addresses are not IP addresses. After the
+// first write error, it does not write anything anymore.
+func (pw *Writer) CapturePacket(packet modelx.CapturedPacket) {
+	local, lport, lok := splitAddr(packet.LocalAddr)
+	remote, rport, rok := splitAddr(packet.RemoteAddr)
+	if !lok || !rok {
+		return
+	}
+	src, sport, dst, dport := local, lport, remote, rport
+	if !packet.Sent {
+		src, sport, dst, dport = remote, rport, local, lport
+	}
+	ts := uint64(packet.DurationSinceBeginning.Nanoseconds() / 1000)
+	comment := fmt.Sprintf("conn_id=%d", packet.ConnID)
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.err != nil {
+		return
+	}
+	if strings.HasPrefix(packet.Network, "udp") {
+		for _, chunk := range split(packet.Data) {
+			segment := udpSegment(src, dst, sport, dport, chunk)
+			pw.writePacket(ts, comment, ipPacket(src, dst, protoUDP, segment))
+		}
+		return
+	}
+	key := fmt.Sprintf("%s %s", packet.LocalAddr, packet.RemoteAddr)
+	f, found := pw.flows[key]
+	if !found {
+		f = pw.handshake(ts, comment, local, remote, lport, rport)
+		pw.flows[key] = f
+	}
+	for _, chunk := range split(packet.Data) {
+		seq, ack := &f.localSeq, f.remoteSeq
+		if !packet.Sent {
+			seq, ack = &f.remoteSeq, f.localSeq
+		}
+		segment := tcpSegment(
+			src, dst, sport, dport, *seq, ack, tcpFlagPSH|tcpFlagACK, chunk)
+		pw.writePacket(ts, comment, ipPacket(src, dst, protoTCP, segment))
+		*seq += uint32(len(chunk))
+	}
+}
+
+// handshake synthesizes the three way handshake of a TCP connection.
+func (pw *Writer) handshake(
+	ts uint64, comment string, local, remote net.IP, lport, rport uint16,
+) *flow {
+	f := &flow{localSeq: 1000, remoteSeq: 5000}
+	pw.writePacket(ts, comment, ipPacket(local, remote, protoTCP, tcpSegment(
+		local, remote, lport, rport, f.localSeq, 0, tcpFlagSYN, nil)))
+	f.localSeq++
+	pw.writePacket(ts, comment, ipPacket(remote, local, protoTCP, tcpSegment(
+		remote, local, rport, lport, f.remoteSeq, f.localSeq,
+		tcpFlagSYN|tcpFlagACK, nil)))
+	f.remoteSeq++
+	pw.writePacket(ts, comment, ipPacket(local, remote, protoTCP, tcpSegment(
+		local, remote, lport, rport, f.localSeq, f.remoteSeq, tcpFlagACK, nil)))
+	return f
+}
+
+// writePacket writes an enhanced packet block. The caller must hold
+// the mutex. The timestamp is in microseconds.
+func (pw *Writer) writePacket(ts uint64, comment string, packet []byte) {
+	epb := new(bytes.Buffer)
+	writeUint32(epb, 0) // interface ID
+	writeUint32(epb, uint32(ts>>32))
+	writeUint32(epb, uint32(ts))
+	writeUint32(epb, uint32(len(packet))) // captured length
+	writeUint32(epb, uint32(len(packet))) // original length
+	epb.Write(pad(packet))
+	writeUint16(epb, optionComment)
+	writeUint16(epb, uint16(len(comment)))
+	epb.Write(pad([]byte(comment)))
+	writeUint16(epb, optionEndOfOpt)
+	writeUint16(epb, 0)
+	pw.err = pw.writeBlock(blockTypeEPB, epb.Bytes())
+}
+
+func (pw *Writer) writeBlock(blockType uint32, body []byte) error {
+	block := new(bytes.Buffer)
+	length := uint32(len(body) + 12)
+	writeUint32(block, blockType)
+	writeUint32(block, length)
+	block.Write(body)
+	writeUint32(block, length)
+	_, err := pw.w.Write(block.Bytes())
+	return err
+}
+
+func splitAddr(addr net.Addr) (net.IP, uint16, bool) {
+	if addr == nil {
+		return nil, 0, false
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, false
+	}
+	portnum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	return ip, uint16(portnum), true
+}
+
+func split(data []byte) (chunks [][]byte) {
+	for len(data) > maxSegmentSize {
+		chunks = append(chunks, data[:maxSegmentSize])
+		data = data[maxSegmentSize:]
+	}
+	return append(chunks, data)
+}
+
+func ipPacket(src, dst net.IP, proto uint8, segment []byte) []byte {
+	packet := new(bytes.Buffer)
+	if src.To4() != nil && dst.To4() != nil {
+		header := make([]byte, 20)
+		header[0] = 0x45 // version 4, header length 20 bytes
+		binary.BigEndian.PutUint16(header[2:], uint16(len(header)+len(segment)))
+		header[8] = 64 // TTL
+		header[9] = proto
+		copy(header[12:16], src.To4())
+		copy(header[16:20], dst.To4())
+		binary.BigEndian.PutUint16(header[10:], checksum(0, header))
+		packet.Write(header)
+	} else {
+		header := make([]byte, 40)
+		header[0] = 0x60 // version 6
+		binary.BigEndian.PutUint16(header[4:], uint16(len(segment)))
+		header[6] = proto
+		header[7] = 64 // hop limit
+		copy(header[8:24], src.To16())
+		copy(header[24:40], dst.To16())
+		packet.Write(header)
+	}
+	packet.Write(segment)
+	return packet.Bytes()
+}
+
+func tcpSegment(
+	src, dst net.IP, sport, dport uint16, seq, ack uint32, flags uint8,
+	payload []byte,
+) []byte {
+	segment := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], sport)
+	binary.BigEndian.PutUint16(segment[2:], dport)
+	binary.BigEndian.PutUint32(segment[4:], seq)
+	binary.BigEndian.PutUint32(segment[8:], ack)
+	segment[12] = 5 << 4 // header length 20 bytes
+	segment[13] = flags
+	binary.BigEndian.PutUint16(segment[14:], 0xFFFF) // window
+	copy(segment[20:], payload)
+	sum := checksum(pseudoHeaderSum(src, dst, protoTCP, len(segment)), segment)
+	binary.BigEndian.PutUint16(segment[16:], sum)
+	return segment
+}
+
+func udpSegment(src, dst net.IP, sport, dport uint16, payload []byte) []byte {
+	segment := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(segment[0:], sport)
+	binary.BigEndian.PutUint16(segment[2:], dport)
+	binary.BigEndian.PutUint16(segment[4:], uint16(len(segment)))
+	copy(segment[8:], payload)
+	sum := checksum(pseudoHeaderSum(src, dst, protoUDP, len(segment)), segment)
+	if sum == 0 {
+		sum = 0xFFFF // zero means no checksum for UDP (RFC768)
+	}
+	binary.BigEndian.PutUint16(segment[6:], sum)
+	return segment
+}
+
+func pseudoHeaderSum(src, dst net.IP, proto uint8, length int) uint32 {
+	if src.To4() != nil && dst.To4() != nil {
+		src, dst = src.To4(), dst.To4()
+	} else {
+		src, dst = src.To16(), dst.To16()
+	}
+	sum := sum16(0, src)
+	sum = sum16(sum, dst)
+	return sum + uint32(proto) + uint32(length)
+}
+
+func sum16(sum uint32, data []byte) uint32 {
+	for len(data) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+	if len(data) > 0 {
+		sum += uint32(data[0]) << 8
+	}
+	return sum
+}
+
+// checksum computes the Internet checksum (RFC1071) of data, starting
+// from the partial sum initial (e.g. from a pseudo header).
+func checksum(initial uint32, data []byte) uint16 {
+	sum := sum16(initial, data)
+	for sum > 0xFFFF {
+		sum = (sum >> 16) + (sum & 0xFFFF)
+	}
+	return ^uint16(sum)
+}
+
+// pad pads data to 32 bits, as required by pcapng.
+func pad(data []byte) []byte {
+	if rem := len(data) % 4; rem != 0 {
+		data = append(data[:len(data):len(data)], make([]byte, 4-rem)...)
+	}
+	return data
+}
+
+func writeUint16(w *bytes.Buffer, v uint16) {
+	binary.Write(w, binary.LittleEndian, v)
+}
+
+func writeUint32(w *bytes.Buffer, v uint32) {
+	binary.Write(w, binary.LittleEndian, v)
+}
+
+func writeUint64(w *bytes.Buffer, v uint64) {
+	binary.Write(w, binary.LittleEndian, v)
+}
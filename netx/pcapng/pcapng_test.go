@@ -0,0 +1,206 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ooni/probe-engine/netx/modelx"
+)
+
+type block struct {
+	body      []byte
+	blockType uint32
+}
+
+func readblocks(t *testing.T, data []byte) (blocks []block) {
+	for len(data) > 0 {
+		if len(data) < 12 {
+			t.Fatal("truncated block")
+		}
+		blockType := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		if length%4 != 0 || int(length) > len(data) {
+			t.Fatal("invalid block length")
+		}
+		if binary.LittleEndian.Uint32(data[length-4:]) != length {
+			t.Fatal("trailing block length mismatch")
+		}
+		blocks = append(blocks, block{body: data[8 : length-4], blockType: blockType})
+		data = data[length:]
+	}
+	return
+}
+
+// readpacket returns the packet data of an enhanced packet block
+func readpacket(t *testing.T, b block) []byte {
+	if b.blockType != blockTypeEPB {
+		t.Fatal("not an enhanced packet block")
+	}
+	length := binary.LittleEndian.Uint32(b.body[12:])
+	return b.body[20 : 20+length]
+}
+
+func newpacket(network string, local, remote net.Addr, sent bool, data string) modelx.CapturedPacket {
+	return modelx.CapturedPacket{
+		ConnID:                 17,
+		Data:                   []byte(data),
+		DurationSinceBeginning: 1500 * time.Millisecond,
+		LocalAddr:              local,
+		Network:                network,
+		RemoteAddr:             remote,
+		Sent:                   sent,
+	}
+}
+
+func TestUnitWriterTCP(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer, err := NewWriter(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}
+	remote := &net.TCPAddr{IP: net.IPv4(93, 184, 216, 34), Port: 80}
+	writer.CapturePacket(newpacket("tcp", local, remote, true, "GET / HTTP/1.1\r\n\r\n"))
+	writer.CapturePacket(newpacket("tcp", local, remote, false, "HTTP/1.1 200 OK\r\n\r\n"))
+	if writer.Err() != nil {
+		t.Fatal(writer.Err())
+	}
+	blocks := readblocks(t, buffer.Bytes())
+	if len(blocks) != 2+3+2 {
+		t.Fatal("unexpected number of blocks")
+	}
+	if blocks[0].blockType != blockTypeSHB || blocks[1].blockType != blockTypeIDB {
+		t.Fatal("unexpected header blocks")
+	}
+	if binary.LittleEndian.Uint32(blocks[0].body) != byteOrderMagic {
+		t.Fatal("unexpected byte order magic")
+	}
+	syn := readpacket(t, blocks[2])
+	if syn[0]>>4 != 4 || syn[9] != protoTCP || syn[20+13] != tcpFlagSYN {
+		t.Fatal("the first packet is not a TCP SYN")
+	}
+	if checksum(0, syn[:20]) != 0 {
+		t.Fatal("invalid IPv4 header checksum")
+	}
+	request := readpacket(t, blocks[5])
+	if !bytes.Equal(request[12:16], local.IP.To4()) {
+		t.Fatal("unexpected source address")
+	}
+	if binary.BigEndian.Uint16(request[20:]) != 54321 {
+		t.Fatal("unexpected source port")
+	}
+	if checksum(pseudoHeaderSum(local.IP, remote.IP, protoTCP, len(request)-20), request[20:]) != 0 {
+		t.Fatal("invalid TCP checksum")
+	}
+	response := readpacket(t, blocks[6])
+	if !bytes.Equal(response[12:16], remote.IP.To4()) {
+		t.Fatal("unexpected source address")
+	}
+	if string(response[40:]) != "HTTP/1.1 200 OK\r\n\r\n" {
+		t.Fatal("unexpected payload")
+	}
+	// The ACK of the response must be equal to the sequence number
+	// following the request sent by the client.
+	requestSeq := binary.BigEndian.Uint32(request[24:])
+	responseAck := binary.BigEndian.Uint32(response[28:])
+	if responseAck != requestSeq+uint32(len(request)-40) {
+		t.Fatal("unexpected ACK number")
+	}
+	ts := uint64(binary.LittleEndian.Uint32(blocks[6].body[4:]))<<32 |
+		uint64(binary.LittleEndian.Uint32(blocks[6].body[8:]))
+	if ts != 1500000 {
+		t.Fatal("unexpected timestamp")
+	}
+	if !strings.Contains(string(blocks[6].body), "conn_id=17") {
+		t.Fatal("missing connection ID comment")
+	}
+}
+
+func TestUnitWriterUDPv6(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer, err := NewWriter(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 54321}
+	remote := &net.UDPAddr{IP: net.ParseIP("2001:4860:4860::8888"), Port: 53}
+	writer.CapturePacket(newpacket("udp6", local, remote, true, "query"))
+	blocks := readblocks(t, buffer.Bytes())
+	if len(blocks) != 3 {
+		t.Fatal("unexpected number of blocks")
+	}
+	packet := readpacket(t, blocks[2])
+	if packet[0]>>4 != 6 || packet[6] != protoUDP {
+		t.Fatal("not an IPv6 UDP packet")
+	}
+	if binary.BigEndian.Uint16(packet[42:]) != 53 {
+		t.Fatal("unexpected destination port")
+	}
+	if string(packet[48:]) != "query" {
+		t.Fatal("unexpected payload")
+	}
+}
+
+func TestUnitWriterLargePayload(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer, err := NewWriter(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 443}
+	payload := string(make([]byte, maxSegmentSize*2+1))
+	writer.CapturePacket(newpacket("tcp", local, remote, true, payload))
+	if blocks := readblocks(t, buffer.Bytes()); len(blocks) != 2+3+3 {
+		t.Fatal("unexpected number of blocks")
+	}
+}
+
+func TestUnitWriterNonIPAddresses(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	writer, err := NewWriter(buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	writer.CapturePacket(newpacket(
+		"tcp", local.LocalAddr(), remote.RemoteAddr(), true, "abc"))
+	if blocks := readblocks(t, buffer.Bytes()); len(blocks) != 2 {
+		t.Fatal("unexpected number of blocks")
+	}
+}
+
+type failingwriter struct {
+	count int
+}
+
+func (w *failingwriter) Write(b []byte) (int, error) {
+	if w.count <= 0 {
+		return 0, errors.New("mocked error")
+	}
+	w.count--
+	return len(b), nil
+}
+
+func TestUnitWriterFailure(t *testing.T) {
+	if _, err := NewWriter(new(failingwriter)); err == nil {
+		t.Fatal("expected an error here")
+	}
+	writer, err := NewWriter(&failingwriter{count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 54321}
+	remote := &net.UDPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 53}
+	writer.CapturePacket(newpacket("udp", local, remote, true, "query"))
+	if writer.Err() == nil {
+		t.Fatal("expected an error here")
+	}
+}
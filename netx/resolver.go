@@ -9,8 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/internal/resolver"
+	"github.com/ooni/probe-engine/netx/internal/resolver/cacheresolver"
 	"github.com/ooni/probe-engine/netx/internal/resolver/chainresolver"
 	"github.com/ooni/probe-engine/netx/modelx"
 )
@@ -98,6 +100,20 @@ func (r *resolverWrapper) LookupHost(ctx context.Context, hostname string) ([]st
 	return r.resolver.LookupHost(ctx, hostname)
 }
 
+// LookupRaw sends a query of the given type for name and returns the
+// reply. It fails with modelx.ErrDNSRawNotSupported if the underlying
+// resolver cannot perform raw lookups (e.g. the system resolver).
+func (r *resolverWrapper) LookupRaw(
+	ctx context.Context, name string, qtype uint16,
+) (*dns.Msg, error) {
+	reso, okay := r.resolver.(modelx.DNSRawResolver)
+	if !okay {
+		return nil, modelx.ErrDNSRawNotSupported
+	}
+	ctx = maybeWithMeasurementRoot(ctx, r.beginning, r.handler)
+	return reso.LookupRaw(ctx, name, qtype)
+}
+
 // LookupMX returns the MX records of a specific name
 func (r *resolverWrapper) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
 	ctx = maybeWithMeasurementRoot(ctx, r.beginning, r.handler)
@@ -147,7 +163,9 @@ func newResolver(
 	return nil, errors.New("resolver.New: unsupported network value")
 }
 
-// NewResolver creates a standalone Resolver
+// NewResolver creates a standalone Resolver. The returned resolver
+// also implements modelx.DNSRawResolver, however LookupRaw fails with
+// modelx.ErrDNSRawNotSupported when using the "system" resolver.
 func NewResolver(network, address string) (modelx.DNSResolver, error) {
 	return newResolver(time.Now(), handlers.NoHandler, network, address)
 }
@@ -157,3 +175,11 @@ func NewResolver(network, address string) (modelx.DNSResolver, error) {
 func ChainResolvers(primary, secondary modelx.DNSResolver) modelx.DNSResolver {
 	return chainresolver.New(primary, secondary)
 }
+
+// NewCachingResolver returns a resolver that caches the results of
+// r.LookupHost, honouring TTLs. Cache hits still emit the resolve
+// start and done events, with their Cached field set to true. Use
+// modelx.WithBypassDNSCache to ignore the cache for a lookup.
+func NewCachingResolver(r modelx.DNSResolver) modelx.DNSResolver {
+	return cacheresolver.New(r)
+}
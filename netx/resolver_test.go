@@ -2,12 +2,15 @@ package netx_test
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/ooni/probe-engine/netx"
 	"github.com/ooni/probe-engine/netx/handlers"
 	"github.com/ooni/probe-engine/netx/internal/resolver/brokenresolver"
+	"github.com/ooni/probe-engine/netx/modelx"
 )
 
 func TestIntegrationResolverLookupAddr(t *testing.T) {
@@ -179,6 +182,38 @@ func TestIntegrationResolverLookupNS(t *testing.T) {
 	}
 }
 
+func TestIntegrationResolverLookupRaw(t *testing.T) {
+	resolver, err := netx.NewResolver("udp", "1.1.1.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := resolver.(modelx.DNSRawResolver).LookupRaw(
+		context.Background(), "ooni.io", dns.TypeSOA,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) < 1 {
+		t.Fatal("unexpected result")
+	}
+}
+
+func TestUnitResolverLookupRawSystem(t *testing.T) {
+	resolver, err := netx.NewResolver("system", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := resolver.(modelx.DNSRawResolver).LookupRaw(
+		context.Background(), "ooni.io", dns.TypeSOA,
+	)
+	if !errors.Is(err, modelx.ErrDNSRawNotSupported) {
+		t.Fatal("not the error we expected")
+	}
+	if reply != nil {
+		t.Fatal("expected a nil reply here")
+	}
+}
+
 func TestUnitNewHTTPClientForDoH(t *testing.T) {
 	first := netx.NewHTTPClientForDoH(
 		time.Now(), handlers.NoHandler,
@@ -196,3 +231,22 @@ func TestUnitNewHTTPClientForDoH(t *testing.T) {
 		t.Fatal("expected to see different client here")
 	}
 }
+
+func TestIntegrationNewCachingResolver(t *testing.T) {
+	resolver, err := netx.NewResolver("system", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver = netx.NewCachingResolver(resolver)
+	first, err := resolver.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := resolver.LookupHost(context.Background(), "dns.google")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != len(second) {
+		t.Fatal("expected to see the same addresses")
+	}
+}